@@ -252,33 +252,47 @@ var imsApiApp = function() {
       
       imsApiClient.getProjects(config, imsApiApp.createCallback('result', projectsTableId))
     },
+
+    start: function(config) {
+      $('a.overlay').click(function(e) {
+        // only on left click
+        if (e.which != 1) return;
+        
+        $.fancybox([{
+          href: $(this).attr('href'),
+          padding: 0, // this disables the ugly padding
+          helpers: {
+            overlay: {
+              locked: false // this disables the scrolling to the top
+            }
+          }
+        }])   
+        // prevent hyperlink from activating
+        return false; 
+      })
+      
+      $('button.cancel').click(function(e) {
+        $.fancybox.close()
+      })
+      imsApiApp.initTables(config)
+    },
   }
 }()
 
 $(document).ready(function() {
   var apiAddr = prompt('api address:')
-  var authToken = prompt('auth token:')
-  var config = imsApiClient.Config(apiAddr, authToken)
-  
-  $('a.overlay').click(function(e) {
-    // only on left click
-    if (e.which != 1) return;
-    
-    $.fancybox([{
-      href: $(this).attr('href'),
-      padding: 0, // this disables the ugly padding
-      helpers: {
-        overlay: {
-          locked: false // this disables the scrolling to the top
-        }
-      }
-    }])   
-    // prevent hyperlink from activating
-    return false; 
-  })
+  var config = imsApiClient.Config(apiAddr, '', '')
   
-  $('button.cancel').click(function(e) {
-    $.fancybox.close()
+  // prefer an existing staff session, then offer to log in, then fall back to the static token
+  imsApiClient.getSession(config, function(r) {
+    if (!r.error) {
+      config.csrfToken = r.result[0].csrfToken
+    } else if (confirm('Log in with your staff account?')) {
+      window.location = imsApiClient.loginUrl(config)
+      return
+    } else {
+      config.authToken = prompt('auth token:')
+    }
+    imsApiApp.start(config)
   })
-  imsApiApp.initTables(config)
 });
\ No newline at end of file
@@ -1,10 +1,13 @@
 var imsApiClient = (function() {
   return {
-    Config: function(apiAddr, authToken)
+    // authToken : the static api token, or empty when logged in with a session cookie
+    // csrfToken : the session's csrf token, as returned by getSession
+    Config: function(apiAddr, authToken, csrfToken)
     {
       return {
         apiAddr: apiAddr,
         authToken: authToken,
+        csrfToken: csrfToken,
       }
     },  
       
@@ -117,6 +120,17 @@ var imsApiClient = (function() {
       this.sendXMLHttpRequest(config, 'DELETE', elems.join('/'), '', callback)
     },
 
+    getSession: function(config, callback)
+    {
+      var elems = [config.apiAddr, 'auth', 'session']
+      this.sendXMLHttpRequest(config, 'GET', elems.join('/'), '', callback)
+    },
+
+    loginUrl: function(config)
+    {
+      return [config.apiAddr, 'auth', 'login'].join('/')
+    },
+
     sendXMLHttpRequest: function(config, method, route, data, callback) {  
         if (typeof callback != 'function') {
           callback = function(r) {
@@ -128,6 +142,8 @@ var imsApiClient = (function() {
         
         // asyhnchronous request
         xhr.open(method, route, true)
+        // send the session cookie, if any
+        xhr.withCredentials = true
         
         xhr.onreadystatechange = function() {
           if(xhr.readyState === XMLHttpRequest.DONE) {
@@ -142,6 +158,10 @@ var imsApiClient = (function() {
         if (config.authToken && method != "GET") {
         	xhr.setRequestHeader('Auth-Token', config.authToken)
         }
+
+        if (config.csrfToken && method != "GET") {
+          xhr.setRequestHeader('X-CSRF-Token', config.csrfToken)
+        }
         
         // send the data
         xhr.send(data)
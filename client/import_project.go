@@ -452,6 +452,10 @@ func addPages(apiRoute, authToken, imageFolder string, projectId, releaseId uint
 			name = fn[0:len(fn)-len(ext)] + ".png"
 		} else if strings.EqualFold(ext, ".jpg") || strings.EqualFold(ext, ".jpeg") {
 			name = fn[0:len(fn)-len(ext)] + ".jpg"
+		} else if strings.EqualFold(ext, ".webp") {
+			name = fn[0:len(fn)-len(ext)] + ".webp"
+		} else if strings.EqualFold(ext, ".gif") {
+			name = fn[0:len(fn)-len(ext)] + ".gif"
 		} else {
 			continue
 		}
@@ -8,17 +8,41 @@ import (
 // Config contains information that the API service generally needs to run.
 // It includes the address (formatted like <ip>:<port>) to bind the HTTP server to,
 // as well as the path to the directory to which image files are to be written.
+// The Oidc* fields are optional and enable staff logins through an OpenID Connect identity provider.
+// OidcAllowedSubjects and OidcAllowedEmails list the staff who get a user record on their first login.
+// PublishChecks sets the level ("off", "warning" or "error") of the checks run before a release is published.
 type Config struct {
-	BindAddress    string `json:"bindAddress"`
-	ImageDirectory string `json:"imageDirectory"`
-	DbProtocol     string `json:"dbProtocol"`
-	DbAddress      string `json:"dbAddress"`
-	DbName         string `json:"dbName"`
-	DbUser         string `json:"dbUser"`
-	DbPassword     string `json:"dbPassword"`
-	AuthToken      string `json:"authToken"`
+	BindAddress         string            `json:"bindAddress"`
+	ImageDirectory      string            `json:"imageDirectory"`
+	DbProtocol          string            `json:"dbProtocol"`
+	DbAddress           string            `json:"dbAddress"`
+	DbName              string            `json:"dbName"`
+	DbUser              string            `json:"dbUser"`
+	DbPassword          string            `json:"dbPassword"`
+	AuthToken           string            `json:"authToken"`
+	AllowedOrigins      []string          `json:"allowedOrigins"`
+	OidcIssuer          string            `json:"oidcIssuer"`
+	OidcClientId        string            `json:"oidcClientId"`
+	OidcClientSecret    string            `json:"oidcClientSecret"`
+	OidcRedirectUrl     string            `json:"oidcRedirectUrl"`
+	OidcPostLoginUrl    string            `json:"oidcPostLoginUrl"`
+	OidcAllowedSubjects []string          `json:"oidcAllowedSubjects"`
+	OidcAllowedEmails   []string          `json:"oidcAllowedEmails"`
+	SessionLifetime     uint32            `json:"sessionLifetime"`
+	SearchMode          string            `json:"searchMode"`
+	PublicUrl           string            `json:"publicUrl"`
+	PageTypeMismatch    string            `json:"pageTypeMismatch"`
+	PublishChecks       map[string]string `json:"publishChecks"`
+	MinPageCount        uint32            `json:"minPageCount"`
 }
 
+// Defaults for optional configuration fields.
+const (
+	DefaultSessionLifetime  uint32 = 60
+	DefaultSearchMode              = "fulltext"
+	DefaultPageTypeMismatch        = "reject"
+)
+
 // MustLoad attempts to load a Config from a specified path and panics if it
 // cannot successfully read or decode the contents of the file at that path.
 func LoadConfig(path string) (*Config, error) {
@@ -30,5 +54,17 @@ func LoadConfig(path string) (*Config, error) {
 	decoder := json.NewDecoder(file)
 	config := Config{}
 	decodeErr := decoder.Decode(&config)
+	if len(config.AllowedOrigins) == 0 {
+		config.AllowedOrigins = []string{"*"}
+	}
+	if config.SessionLifetime == 0 {
+		config.SessionLifetime = DefaultSessionLifetime
+	}
+	if config.SearchMode == "" {
+		config.SearchMode = DefaultSearchMode
+	}
+	if config.PageTypeMismatch == "" {
+		config.PageTypeMismatch = DefaultPageTypeMismatch
+	}
 	return &config, decodeErr
 }
@@ -55,3 +55,29 @@ func (db DbHandle) Query(query string, args ...interface{}) (*sql.Rows, error) {
 func (db DbHandle) Exec(query string, args ...interface{}) (sql.Result, error) {
 	return db.inner.Exec(query, args...)
 }
+
+func (db DbHandle) Begin() (*sql.Tx, error) {
+	return db.inner.Begin()
+}
+
+// Transaction runs f with a handle whose statements are committed together if f succeeds and rolled back if it
+// fails. Handles which cannot begin a transaction, such as an open transaction, are passed to f as they are.
+func Transaction(db DB, f func(tx DB) error) error {
+	beginner, ok := db.(interface {
+		Begin() (*sql.Tx, error)
+	})
+	if !ok {
+		return f(db)
+	}
+
+	tx, err := beginner.Begin()
+	if err != nil {
+		return err
+	}
+	err = f(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"errors"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"ims-release/assert"
+	"testing"
+)
+
+func TestTransaction(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	// test statements are committed together
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO a").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO b").WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	err = Transaction(db, func(tx DB) error {
+		_, err := tx.Exec("INSERT INTO a")
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO b")
+		return err
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, mock.ExpectationsWereMet())
+
+	// test a failure rolls the transaction back
+	someErr := errors.New("some error")
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO a").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO b").WillReturnError(someErr)
+	mock.ExpectRollback()
+
+	err = Transaction(db, func(tx DB) error {
+		_, err := tx.Exec("INSERT INTO a")
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO b")
+		return err
+	})
+	assert.Equal(t, someErr, err)
+	assert.Equal(t, nil, mock.ExpectationsWereMet())
+
+	// test a failure to begin
+	mock.ExpectBegin().WillReturnError(someErr)
+	called := false
+	err = Transaction(db, func(tx DB) error {
+		called = true
+		return nil
+	})
+	assert.Equal(t, someErr, err)
+	assert.Equal(t, false, called)
+
+	// test handles without transactions run f directly
+	err = Transaction(nil, func(tx DB) error {
+		called = true
+		return nil
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, called)
+}
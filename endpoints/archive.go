@@ -0,0 +1,251 @@
+package endpoints
+
+import (
+	"ims-release/database"
+	"ims-release/models"
+	"ims-release/storage_provider"
+
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"log"
+	"strings"
+)
+
+// archivePage is a page of a release together with its image data.
+type archivePage struct {
+	models.Page
+	Data []byte
+}
+
+// archiveFormat describes one of the formats a release can be downloaded in. Archives of every format are kept in
+// storage after they are first generated.
+type archiveFormat struct {
+	Extension   string
+	ContentType string
+	build       func(p models.Project, r models.Release, pages []archivePage) ([]byte, error)
+}
+
+// archiveFormats lists the supported formats. The first one is generated as soon as a release is published.
+var archiveFormats = []archiveFormat{
+	{".zip", "application/zip", buildZipArchive},
+	{".cbz", "application/vnd.comicbook+zip", buildCbzArchive},
+	{".epub", "application/epub+zip", buildEpubArchive},
+	{".pdf", "application/pdf", buildPdfArchive},
+}
+
+// findArchiveFormat looks up the format of an archive by its file name.
+func findArchiveFormat(name string) (archiveFormat, bool) {
+	for _, f := range archiveFormats {
+		if strings.HasSuffix(name, f.Extension) {
+			return f, true
+		}
+	}
+	return archiveFormat{}, false
+}
+
+// archiveName is the file name of a release's archive in this format.
+func (f archiveFormat) archiveName(p models.Project, r models.Release) string {
+	return strings.TrimSuffix(mGenerateArchiveName(p, r), ".zip") + f.Extension
+}
+
+// releaseArchive produces the archive of a release in a format, using the cached copy if there is one. The archive
+// name contains the release version and published releases cannot change without being upversioned, so a cached
+// archive never goes stale. Cached archives are still removed once their release returns to draft, see
+// invalidateReleaseArchives.
+func releaseArchive(db database.DB, sp storage_provider.Binary, format archiveFormat, p models.Project, r models.Release) ([]byte, error) {
+	return cachedArchive(sp, format, p, r, func() ([]archivePage, error) {
+		return loadArchivePages(db, sp, p, r)
+	})
+}
+
+// cachedArchive produces the archive of a release in a format from the pages returned by load, using the cached copy
+// if there is one.
+func cachedArchive(sp storage_provider.Binary, format archiveFormat, p models.Project, r models.Release, load func() ([]archivePage, error)) ([]byte, error) {
+	key := mGenerateArchivePath(p, r, format.archiveName(p, r))
+	if sp.Exists(key) {
+		data, err := sp.Get(key)
+		if err == nil {
+			return data, nil
+		}
+		log.Println("[---] Archive cache error:", err)
+	}
+
+	pages, err := load()
+	if err != nil {
+		return nil, err
+	}
+	data, err := format.build(p, r, pages)
+	if err != nil {
+		return nil, err
+	}
+
+	// failing to cache only costs regenerating the archive next time
+	err = sp.Set(key, data)
+	if err != nil {
+		log.Println("[---] Archive cache error:", err)
+	}
+	return data, nil
+}
+
+// invalidateReleaseArchives removes the cached archives of a release in every format.
+func invalidateReleaseArchives(sp storage_provider.Binary, p models.Project, r models.Release) {
+	for _, format := range archiveFormats {
+		key := mGenerateArchivePath(p, r, format.archiveName(p, r))
+		if !sp.Exists(key) {
+			continue
+		}
+		err := sp.Unset(key)
+		if err != nil {
+			log.Println("[---] Archive cache error:", err)
+		}
+	}
+}
+
+// loadArchivePages fetches the image data of every page of a release, in page order.
+func loadArchivePages(db database.DB, sp storage_provider.Binary, p models.Project, r models.Release) ([]archivePage, error) {
+	pages, err := mListPages(db, r, models.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve list of pages: %v", err)
+	}
+
+	result := []archivePage{}
+	for _, page := range pages {
+		filePath := mGeneratePagePath(p, r, page.Name)
+		data, err := sp.Get(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve image data for %s: %v", filePath, err)
+		}
+		result = append(result, archivePage{page, data})
+	}
+	return result, nil
+}
+
+// writeZip stores the pages in a zip archive, followed by any extra files.
+func writeZip(pages []archivePage, extra map[string][]byte) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	z := zip.NewWriter(buffer)
+
+	add := func(name string, data []byte) error {
+		f, err := z.Create(name)
+		if err != nil {
+			return fmt.Errorf("failed to add %s to archive: %v", name, err)
+		}
+		_, err = f.Write(data)
+		if err != nil {
+			return fmt.Errorf("failed to add %s to archive: %v", name, err)
+		}
+		return nil
+	}
+
+	for _, page := range pages {
+		if err := add(page.Name, page.Data); err != nil {
+			return nil, err
+		}
+	}
+	for name, data := range extra {
+		if err := add(name, data); err != nil {
+			return nil, err
+		}
+	}
+
+	err := z.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed when finalizing archive: %v", err)
+	}
+	return buffer.Bytes(), nil
+}
+
+func buildZipArchive(p models.Project, r models.Release, pages []archivePage) ([]byte, error) {
+	return writeZip(pages, nil)
+}
+
+func buildCbzArchive(p models.Project, r models.Release, pages []archivePage) ([]byte, error) {
+	comicInfo, err := xml.MarshalIndent(newComicInfo(p, r, pages), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	comicInfo = append([]byte(xml.Header), comicInfo...)
+	return writeZip(pages, map[string][]byte{"ComicInfo.xml": comicInfo})
+}
+
+// ComicInfo is the metadata file read by comic library managers, following the ComicRack schema.
+type ComicInfo struct {
+	XMLName         xml.Name        `xml:"ComicInfo"`
+	Series          string          `xml:"Series"`
+	Number          string          `xml:"Number,omitempty"`
+	Volume          string          `xml:"Volume,omitempty"`
+	Summary         string          `xml:"Summary,omitempty"`
+	Year            int             `xml:"Year,omitempty"`
+	Month           int             `xml:"Month,omitempty"`
+	Day             int             `xml:"Day,omitempty"`
+	PageCount       int             `xml:"PageCount"`
+	Manga           string          `xml:"Manga"`
+	ScanInformation string          `xml:"ScanInformation,omitempty"`
+	Pages           []ComicPageInfo `xml:"Pages>Page"`
+}
+
+// ComicPageInfo describes a page of a ComicInfo. Dimensions are left out for images which cannot be decoded.
+type ComicPageInfo struct {
+	Image       int    `xml:"Image,attr"`
+	Type        string `xml:"Type,attr,omitempty"`
+	ImageSize   int    `xml:"ImageSize,attr"`
+	ImageWidth  int    `xml:"ImageWidth,attr,omitempty"`
+	ImageHeight int    `xml:"ImageHeight,attr,omitempty"`
+}
+
+// comicPageType maps the role of a page to the ComicInfo page type. Content pages have no type.
+func comicPageType(role string) string {
+	switch role {
+	case models.PRoleCoverStr:
+		return "FrontCover"
+	case models.PRoleRecruitmentStr:
+		return "Advertisement"
+	case models.PRoleCreditStr:
+		return "Other"
+	default:
+		return ""
+	}
+}
+
+func newComicInfo(p models.Project, r models.Release, pages []archivePage) ComicInfo {
+	volume, number := models.ParseReleaseIdentifier(r.Identifier)
+	info := ComicInfo{
+		Series:          p.Name,
+		Number:          number,
+		Volume:          volume,
+		Summary:         p.Description,
+		PageCount:       len(pages),
+		Manga:           "YesAndRightToLeft",
+		ScanInformation: r.Scanlator,
+		Pages:           []ComicPageInfo{},
+	}
+	if !r.ReleasedOn.IsZero() {
+		info.Year = r.ReleasedOn.Year()
+		info.Month = int(r.ReleasedOn.Month())
+		info.Day = r.ReleasedOn.Day()
+	}
+
+	// without a page marked as cover, the first page is taken as the cover
+	hasCover := false
+	for _, page := range pages {
+		if page.Role == models.PRoleCoverStr {
+			hasCover = true
+			break
+		}
+	}
+	for i, page := range pages {
+		pi := ComicPageInfo{Image: i, ImageSize: len(page.Data), Type: comicPageType(page.Role)}
+		if i == 0 && !hasCover {
+			pi.Type = "FrontCover"
+		}
+		if cfg, _, err := image.DecodeConfig(bytes.NewReader(page.Data)); err == nil {
+			pi.ImageWidth = cfg.Width
+			pi.ImageHeight = cfg.Height
+		}
+		info.Pages = append(info.Pages, pi)
+	}
+	return info
+}
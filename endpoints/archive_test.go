@@ -0,0 +1,217 @@
+package endpoints
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"github.com/gorilla/mux"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"ims-release/assert"
+	"ims-release/database"
+	"ims-release/models"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPngBenc = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAAAXNSR0IArs4c6QAAAARnQU1BAACxjwv8YQUAAAAJcEhZcwAADsQAAA7EAZUrDhsAAAANSURBVBhXY/j3/+9/AAnzA/pJMr8HAAAAAElFTkSuQmCC"
+
+// mockArchiveRelease sets up a released release with a png and a jpg page stored in sp.
+func mockArchiveRelease(t *testing.T, sp SpMap) {
+	mFindProject = func(db database.DB, id uint32) (models.Project, error) {
+		return models.Project{Id: id, Name: "Project (test)", Shorthand: "proj"}, nil
+	}
+	mFindRelease = func(db database.DB, p models.Project, id uint32) (models.Release, error) {
+		return models.Release{Id: id, ProjectID: p.Id, Identifier: "c13", Version: 1, Scanlator: "ims",
+			Status: "released", ReleasedOn: time.Date(2017, 3, 2, 10, 0, 0, 0, time.UTC)}, nil
+	}
+	mGenerateArchiveName = models.GenerateArchiveName
+	mGeneratePagePath = models.GeneratePagePath
+	mGenerateArchivePath = models.GenerateArchivePath
+	mListPages = func(db database.DB, release models.Release, opts models.ListOptions) ([]models.Page, error) {
+		return []models.Page{
+			{Name: "p1.png", MimeType: models.MimeTypePng},
+			{Name: "p2.jpg", MimeType: models.MimeTypeJpg},
+		}, nil
+	}
+
+	sp["12/70/p1.png"], _ = base64.StdEncoding.DecodeString(testPngBenc)
+	img := image.NewGray(image.Rect(0, 0, 3, 2))
+	img.Set(1, 1, color.White)
+	var jpg bytes.Buffer
+	jpeg.Encode(&jpg, img, nil)
+	sp["12/70/p2.jpg"] = jpg.Bytes()
+}
+
+func TestDownloadReleaseEpub(t *testing.T) {
+	sp := SpMap{}
+	mockArchiveRelease(t, sp)
+	router := mux.NewRouter()
+	registerHandlers(router, nil, sp, TMismatchReject, DefaultLintConfig())
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/projects/12/releases/70/download/proj - c13[1][ims].epub", nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/epub+zip", w.Header().Get("Content-Type"))
+
+	z, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "mimetype", z.File[0].Name)
+	assert.Equal(t, zip.Store, z.File[0].Method)
+	files := map[string]string{}
+	for _, f := range z.File {
+		rc, _ := f.Open()
+		data, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+	}
+	assert.Equal(t, "application/epub+zip", files["mimetype"])
+	assert.Equal(t, true, strings.Contains(files["META-INF/container.xml"], `full-path="OEBPS/content.opf"`))
+
+	opf := files["OEBPS/content.opf"]
+	assert.Equal(t, true, strings.Contains(opf, "<dc:title>Project (test) c13</dc:title>"))
+	assert.Equal(t, true, strings.Contains(opf, `<meta property="rendition:layout">pre-paginated</meta>`))
+	assert.Equal(t, true, strings.Contains(opf, `<meta refines="#series" property="group-position">13</meta>`))
+	assert.Equal(t, true, strings.Contains(opf, `href="images/p0001.png" media-type="image/png" properties="cover-image"`))
+	assert.Equal(t, 2, strings.Count(opf, "<itemref "))
+
+	assert.Equal(t, true, strings.Contains(files["OEBPS/pages/p0002.xhtml"], `content="width=3, height=2"`))
+	assert.Equal(t, true, strings.Contains(files["OEBPS/pages/p0002.xhtml"], `<img src="../images/p0002.jpg"`))
+	assert.Equal(t, string(sp["12/70/p2.jpg"]), files["OEBPS/images/p0002.jpg"])
+
+	// the generated book is cached, so the pages are not needed the second time around
+	assert.Equal(t, w.Body.String(), string(sp["archives/12/70/proj - c13[1][ims].epub"]))
+	mListPages = func(db database.DB, release models.Release, opts models.ListOptions) ([]models.Page, error) {
+		return []models.Page{}, errors.New("some error")
+	}
+	cached := w.Body.String()
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, cached, w.Body.String())
+}
+
+func TestDownloadReleasePdf(t *testing.T) {
+	sp := SpMap{}
+	mockArchiveRelease(t, sp)
+	router := mux.NewRouter()
+	registerHandlers(router, nil, sp, TMismatchReject, DefaultLintConfig())
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/projects/12/releases/70/download/proj - c13[1][ims].pdf", nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/pdf", w.Header().Get("Content-Type"))
+
+	doc := w.Body.String()
+	assert.Equal(t, true, strings.HasPrefix(doc, "%PDF-1.4\n"))
+	assert.Equal(t, true, strings.HasSuffix(doc, "%%EOF\n"))
+	assert.Equal(t, true, strings.Contains(doc, "/Type /Pages /Kids [6 0 R 9 0 R] /Count 2"))
+	assert.Equal(t, true, strings.Contains(doc, "/MediaBox [0 0 1 1]"))
+	assert.Equal(t, true, strings.Contains(doc, "/MediaBox [0 0 3 2]"))
+	assert.Equal(t, true, strings.Contains(doc, "/ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /DCTDecode"))
+	assert.Equal(t, true, strings.Contains(doc, "/Title (Project \\(test\\) c13)"))
+
+	// every cross-reference entry points at its object
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindStringSubmatch(doc)
+	xref, _ := strconv.Atoi(m[1])
+	assert.Equal(t, true, strings.HasPrefix(doc[xref:], "xref\n0 10\n"))
+	entries := strings.Split(doc[xref:], "\n")[3:12]
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(entry[:10])
+		assert.Equal(t, true, strings.HasPrefix(doc[offset:], strconv.Itoa(i+1)+" 0 obj\n"))
+	}
+	assert.Equal(t, true, sp.Exists("archives/12/70/proj - c13[1][ims].pdf"))
+
+	// releases without pages cannot be rendered
+	sp = SpMap{}
+	mListPages = func(db database.DB, release models.Release, opts models.ListOptions) ([]models.Page, error) {
+		return []models.Page{}, nil
+	}
+	router = mux.NewRouter()
+	registerHandlers(router, nil, sp, TMismatchReject, DefaultLintConfig())
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, 0, len(sp))
+}
+
+func TestComicInfoPageTypes(t *testing.T) {
+	pages := []archivePage{
+		{models.Page{Name: "p1.png", Role: models.PRoleContentStr}, nil},
+		{models.Page{Name: "p2.png", Role: models.PRoleCoverStr}, nil},
+		{models.Page{Name: "p3.png", Role: models.PRoleColourStr}, nil},
+		{models.Page{Name: "p4.png", Role: models.PRoleRecruitmentStr}, nil},
+	}
+	info := newComicInfo(models.Project{}, models.Release{}, pages)
+	types := []string{}
+	for _, pi := range info.Pages {
+		types = append(types, pi.Type)
+	}
+	assert.Equal(t, ",FrontCover,,Advertisement", strings.Join(types, ","))
+
+	// the first page is the cover unless another page is marked as one
+	pages[1].Role = models.PRoleCreditStr
+	info = newComicInfo(models.Project{}, models.Release{}, pages)
+	assert.Equal(t, "FrontCover", info.Pages[0].Type)
+	assert.Equal(t, "Other", info.Pages[1].Type)
+}
+
+func TestArchiveFormatsWebpGif(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 6))
+	img.Set(1, 1, color.White)
+	var webpData, gifData bytes.Buffer
+	encodeWebp(&webpData, img)
+	gif.Encode(&gifData, img, nil)
+	pages := []archivePage{
+		{models.Page{Name: "p1.webp", MimeType: models.MimeTypeWebp}, webpData.Bytes()},
+		{models.Page{Name: "p2.gif", MimeType: models.MimeTypeGif}, gifData.Bytes()},
+	}
+	p := models.Project{Id: 12, Name: "Project"}
+	r := models.Release{Id: 70, Identifier: "c13", Version: 1}
+
+	// pages are stored in zip archives as they are
+	data, err := buildZipArchive(p, r, pages)
+	assert.Equal(t, nil, err)
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(z.File))
+	for i, f := range z.File {
+		rc, err := f.Open()
+		assert.Equal(t, nil, err)
+		content, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		assert.Equal(t, pages[i].Name, f.Name)
+		assert.Equal(t, string(pages[i].Data), string(content))
+	}
+
+	data, err = buildEpubArchive(p, r, pages)
+	assert.Equal(t, nil, err)
+	z, err = zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	assert.Equal(t, nil, err)
+	for _, f := range z.File {
+		if f.Name != "OEBPS/content.opf" {
+			continue
+		}
+		rc, _ := f.Open()
+		opf, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		assert.Equal(t, true, strings.Contains(string(opf), `href="images/p0001.webp" media-type="image/webp"`))
+		assert.Equal(t, true, strings.Contains(string(opf), `href="images/p0002.gif" media-type="image/gif"`))
+	}
+
+	data, err = buildPdfArchive(p, r, pages)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, strings.Contains(string(data), "/MediaBox [0 0 4 6]"))
+	assert.Equal(t, 2, strings.Count(string(data), "/Filter /FlateDecode"))
+}
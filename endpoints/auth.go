@@ -0,0 +1,328 @@
+package endpoints
+
+import (
+	"ims-release/database"
+	"ims-release/models"
+	"ims-release/oidc"
+
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	mFindUser              = models.FindUser
+	mFindUserBySubject     = models.FindUserBySubject
+	mNewUser               = models.NewUser
+	mSaveUser              = models.SaveUser
+	mUpdateUser            = models.UpdateUser
+	mNewSession            = models.NewSession
+	mFindSession           = models.FindSession
+	mSaveSession           = models.SaveSession
+	mDeleteSession         = models.DeleteSession
+	mDeleteExpiredSessions = models.DeleteExpiredSessions
+)
+
+var (
+	ErrMsgLogin            = "Login failed. Please try again."
+	ErrRspLogin            = NewApiResponse(http.StatusUnauthorized, &ErrMsgLogin)
+	ErrMsgLoginUnavailable = "The identity provider is unavailable. Please try again later."
+	ErrRspLoginUnavailable = NewApiResponse(http.StatusBadGateway, &ErrMsgLoginUnavailable)
+	ErrMsgLoginForbidden   = "This account is not allowed to log in."
+	ErrRspLoginForbidden   = NewApiResponse(http.StatusForbidden, &ErrMsgLoginForbidden)
+)
+
+// Names of the cookies and headers used by the login flow.
+const (
+	SessionCookieName = "ims_session"
+	StateCookieName   = "ims_oidc_state"
+	CsrfHeaderName    = "X-CSRF-Token"
+
+	loginModeCookie = "cookie"
+	loginModeToken  = "token"
+	stateLifetime   = 600
+)
+
+// IdentityProvider is the part of an OpenID Connect provider used by the login endpoints.
+type IdentityProvider interface {
+	AuthCodeUrl(state string) (string, error)
+	Exchange(code string) (oidc.Identity, error)
+}
+
+// LoginConfig contains everything the login endpoints need to know about how sessions are handed out.
+// When PostLoginUrl is set, cookie logins redirect there instead of responding with JSON. Only identities with a
+// user record, or whose subject or verified email is listed in AllowedSubjects or AllowedEmails, may log in.
+type LoginConfig struct {
+	Provider        IdentityProvider
+	PostLoginUrl    string
+	SessionLifetime time.Duration
+	SecureCookies   bool
+	AllowedSubjects []string
+	AllowedEmails   []string
+}
+
+// allowed reports whether a user record may be created for an identity logging in for the first time.
+func (lc LoginConfig) allowed(identity oidc.Identity) bool {
+	for _, subject := range lc.AllowedSubjects {
+		if subject == identity.Subject {
+			return true
+		}
+	}
+	if identity.Email == "" || !identity.EmailVerified {
+		return false
+	}
+	for _, email := range lc.AllowedEmails {
+		if strings.EqualFold(email, identity.Email) {
+			return true
+		}
+	}
+	return false
+}
+
+type SessionResult struct {
+	Token     string      `json:"token,omitempty"`
+	CsrfToken string      `json:"csrfToken,omitempty"`
+	ExpiresAt time.Time   `json:"expiresAt"`
+	User      models.User `json:"user"`
+}
+
+type SessionResponse struct {
+	ApiResponse
+	Result []SessionResult `json:"result"`
+}
+
+func NewSessionResponse(a ApiResponse, r []SessionResult) SessionResponse {
+	return SessionResponse{ApiResponse: a, Result: r}
+}
+
+// RegisterAuthHandlers attaches the closures generated by each function defined below
+// to handle incoming requests to the appropriate endpoint using a subrouter with an
+// appropriate prefix, specified in main.
+func RegisterAuthHandlers(r *mux.Router, db database.DB, lc LoginConfig) {
+	root := "/auth"
+	sr := r.PathPrefix(root).Subrouter()
+	sr.HandleFunc("/login", login(lc)).Methods("GET")
+	sr.HandleFunc("/callback", loginCallback(db, lc)).Methods("GET")
+	sr.HandleFunc("/session", getSession(db)).Methods("GET")
+	sr.HandleFunc("/session", deleteSession(db, lc)).Methods("DELETE")
+}
+
+func generateState() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// sessionFromRequest finds the session identified by either the bearer token or the session cookie of a request.
+// The returned bool reports whether the cookie was used, in which case the caller must also check the CSRF token.
+func sessionFromRequest(db database.DB, r *http.Request) (models.Session, bool, error) {
+	authorization := r.Header.Get("Authorization")
+	if strings.HasPrefix(authorization, "Bearer ") {
+		session, err := mFindSession(db, strings.TrimPrefix(authorization, "Bearer "), time.Now())
+		return session, false, err
+	}
+
+	cookie, err := r.Cookie(SessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return models.Session{}, false, models.ErrNoSuchSession
+	}
+	session, err := mFindSession(db, cookie.Value, time.Now())
+	return session, true, err
+}
+
+func validCsrfToken(r *http.Request, s models.Session) bool {
+	token := r.Header.Get(CsrfHeaderName)
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.CsrfToken)) == 1
+}
+
+func (lc LoginConfig) sessionCookie(value string, expires time.Time, maxAge int) *http.Cookie {
+	// cross-site cookies are only accepted by browsers over https, so fall back to lax for local setups
+	sameSite := http.SameSiteLaxMode
+	if lc.SecureCookies {
+		sameSite = http.SameSiteNoneMode
+	}
+	return &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   lc.SecureCookies,
+		SameSite: sameSite,
+	}
+}
+
+// GET /auth/login
+// login redirects the user agent to the identity provider. The login mode ("cookie" or "token") is carried
+// through the state parameter, which is also stored in a short-lived cookie to be checked on callback.
+func login(lc LoginConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mode := r.URL.Query().Get("mode")
+		if mode == "" {
+			mode = loginModeCookie
+		}
+		if mode != loginModeCookie && mode != loginModeToken {
+			encodeHelper(w, NewSessionResponse(ErrRspBadRequest, []SessionResult{}))
+			return
+		}
+
+		nonce, err := generateState()
+		if err != nil {
+			log.Println("[---] State error:", err)
+			encodeHelper(w, NewSessionResponse(ErrRspUnexpected, []SessionResult{}))
+			return
+		}
+		state := nonce + "." + mode
+
+		authUrl, err := lc.Provider.AuthCodeUrl(state)
+		if err != nil {
+			log.Println("[---] Identity provider error:", err)
+			encodeHelper(w, NewSessionResponse(ErrRspLoginUnavailable, []SessionResult{}))
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     StateCookieName,
+			Value:    state,
+			Path:     "/auth",
+			MaxAge:   stateLifetime,
+			HttpOnly: true,
+			Secure:   lc.SecureCookies,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, authUrl, http.StatusFound)
+	}
+}
+
+// GET /auth/callback
+// loginCallback completes the login, mapping the identity to a user record and issuing a session. Staff are only
+// given a user record on their first login if the configuration allows their identity.
+func loginCallback(db database.DB, lc LoginConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		state := q.Get("state")
+		cookie, err := r.Cookie(StateCookieName)
+		if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookie.Value)) != 1 {
+			log.Println("[---] Login error: state mismatch")
+			encodeHelper(w, NewSessionResponse(ErrRspLogin, []SessionResult{}))
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: StateCookieName, Path: "/auth", MaxAge: -1})
+
+		if q.Get("error") != "" {
+			log.Println("[---] Login error:", q.Get("error"))
+			encodeHelper(w, NewSessionResponse(ErrRspLogin, []SessionResult{}))
+			return
+		}
+		mode := state[strings.LastIndex(state, ".")+1:]
+
+		identity, err := lc.Provider.Exchange(q.Get("code"))
+		if err != nil {
+			log.Println("[---] Login error:", err)
+			encodeHelper(w, NewSessionResponse(ErrRspLogin, []SessionResult{}))
+			return
+		}
+
+		tm := time.Now()
+		user, err := mFindUserBySubject(db, identity.Subject)
+		if err == models.ErrNoSuchUser {
+			if !lc.allowed(identity) {
+				log.Println("[---] Login error: no user for subject", identity.Subject)
+				encodeHelper(w, NewSessionResponse(ErrRspLoginForbidden, []SessionResult{}))
+				return
+			}
+			user, err = mSaveUser(db, mNewUser(identity.Subject, identity.Email, identity.Name, tm))
+		} else if err == nil && (user.Email != identity.Email || user.Name != identity.Name) {
+			user.Email = identity.Email
+			user.Name = identity.Name
+			user, err = mUpdateUser(db, user)
+		}
+		if err != nil {
+			log.Println("[---] User error:", err)
+			encodeHelper(w, NewSessionResponse(ErrRspUnexpected, []SessionResult{}))
+			return
+		}
+
+		session, token, err := mNewSession(user, tm, lc.SessionLifetime)
+		if err == nil {
+			session, err = mSaveSession(db, session)
+		}
+		if err != nil {
+			log.Println("[---] Session error:", err)
+			encodeHelper(w, NewSessionResponse(ErrRspUnexpected, []SessionResult{}))
+			return
+		}
+		log.Printf("[+++] User %d logged in\n", user.Id)
+
+		err = mDeleteExpiredSessions(db, tm)
+		if err != nil {
+			// this is for logging only - expired sessions are never accepted anyway
+			log.Println("[---] Session cleanup error:", err)
+		}
+
+		if mode == loginModeToken {
+			encodeHelper(w, NewSessionResponse(NoErr, []SessionResult{{Token: token, ExpiresAt: session.ExpiresAt, User: user}}))
+			return
+		}
+
+		http.SetCookie(w, lc.sessionCookie(token, session.ExpiresAt, int(lc.SessionLifetime.Seconds())))
+		if lc.PostLoginUrl != "" {
+			http.Redirect(w, r, lc.PostLoginUrl, http.StatusFound)
+			return
+		}
+		encodeHelper(w, NewSessionResponse(NoErr, []SessionResult{{CsrfToken: session.CsrfToken, ExpiresAt: session.ExpiresAt, User: user}}))
+	}
+}
+
+// GET /auth/session
+// getSession describes the current session, including the CSRF token to send with cookie authenticated requests.
+func getSession(db database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _, err := sessionFromRequest(db, r)
+		if err != nil {
+			log.Println("[---] Session error:", err)
+			encodeHelper(w, NewSessionResponse(ErrRspUnauthorized, []SessionResult{}))
+			return
+		}
+
+		user, err := mFindUser(db, session.UserID)
+		if err != nil {
+			log.Println("[---] User error:", err)
+			encodeHelper(w, NewSessionResponse(ErrRspUnauthorized, []SessionResult{}))
+			return
+		}
+		encodeHelper(w, NewSessionResponse(NoErr, []SessionResult{{CsrfToken: session.CsrfToken, ExpiresAt: session.ExpiresAt, User: user}}))
+	}
+}
+
+// DELETE /auth/session
+// deleteSession logs the current session out.
+func deleteSession(db database.DB, lc LoginConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _, err := sessionFromRequest(db, r)
+		if err != nil {
+			log.Println("[---] Session error:", err)
+			encodeHelper(w, NewSessionResponse(ErrRspUnauthorized, []SessionResult{}))
+			return
+		}
+
+		_, err = mDeleteSession(db, session)
+		if err != nil {
+			log.Println("[---] Delete error:", err)
+			encodeHelper(w, NewSessionResponse(ErrRspUnexpected, []SessionResult{}))
+			return
+		}
+		http.SetCookie(w, lc.sessionCookie("", time.Unix(0, 0), -1))
+		encodeHelper(w, NewSessionResponse(NoErr, []SessionResult{}))
+	}
+}
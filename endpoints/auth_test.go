@@ -0,0 +1,341 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"ims-release/assert"
+	"ims-release/database"
+	"ims-release/models"
+	"ims-release/oidc"
+	"ims-release/oidc/oidctest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func mockUserStore(t *testing.T) *models.Session {
+	saved := &models.Session{}
+	mFindUserBySubject = func(db database.DB, subject string) (models.User, error) {
+		assert.Equal(t, "fake-subject", subject)
+		return models.User{}, models.ErrNoSuchUser
+	}
+	mSaveUser = func(db database.DB, u models.User) (models.User, error) {
+		assert.Equal(t, "staff@example.com", u.Email)
+		u.Id = 9
+		return u, nil
+	}
+	mFindUser = func(db database.DB, id uint32) (models.User, error) {
+		assert.Equal(t, uint32(9), id)
+		return models.User{Id: id, Subject: "fake-subject"}, nil
+	}
+	mSaveSession = func(db database.DB, s models.Session) (models.Session, error) {
+		s.Id = 4
+		*saved = s
+		return s, nil
+	}
+	mFindSession = func(db database.DB, token string, tm time.Time) (models.Session, error) {
+		if saved.Id == 0 || models.HashSessionToken(token) != saved.TokenHash {
+			return models.Session{}, models.ErrNoSuchSession
+		}
+		return *saved, nil
+	}
+	mDeleteSession = func(db database.DB, s models.Session) (models.Session, error) {
+		assert.Equal(t, uint32(4), s.Id)
+		saved.Id = 0
+		return s, nil
+	}
+	mDeleteExpiredSessions = func(db database.DB, tm time.Time) error {
+		return nil
+	}
+	return saved
+}
+
+// login performs the redirect to the fake identity provider and back, returning the callback response.
+func loginRoundTrip(t *testing.T, router http.Handler, mode string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/auth/login?mode="+mode, nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusFound, w.Code)
+	stateCookie := w.Result().Cookies()[0]
+	assert.Equal(t, StateCookieName, stateCookie.Name)
+
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	rsp, err := client.Get(w.Header().Get("Location"))
+	assert.Equal(t, nil, err)
+	callback, _ := url.Parse(rsp.Header.Get("Location"))
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/auth/callback?"+callback.RawQuery, nil)
+	r.AddCookie(stateCookie)
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func TestLoginTokenMode(t *testing.T) {
+	idp := oidctest.NewServer("client", "secret")
+	defer idp.Close()
+	mockUserStore(t)
+
+	router := mux.NewRouter()
+	lc := LoginConfig{
+		Provider:        oidc.NewProvider(idp.URL, "client", "secret", "http://localhost/auth/callback"),
+		SessionLifetime: time.Hour,
+		AllowedEmails:   []string{"Staff@example.com"},
+	}
+	RegisterAuthHandlers(router, nil, lc)
+	handler := NewAuthenticationHandler("static", []string{"POST", "PUT", "DELETE"}, nil, router)
+
+	w := loginRoundTrip(t, handler, "token")
+	var resp SessionResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, 1, len(resp.Result))
+	assert.Equal(t, uint32(9), resp.Result[0].User.Id)
+	token := resp.Result[0].Token
+	assert.Equal(t, 64, len(token))
+
+	// the bearer token describes the session
+	w = httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/auth/session", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	handler.ServeHTTP(w, r)
+	json.NewDecoder(w.Body).Decode(&resp)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, uint32(9), resp.Result[0].User.Id)
+
+	// the bearer token is accepted for state changing requests without a csrf token
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("DELETE", "/auth/session", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// after logging out the token is no longer accepted
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("DELETE", "/auth/session", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestLoginCookieMode(t *testing.T) {
+	idp := oidctest.NewServer("client", "secret")
+	defer idp.Close()
+	mockUserStore(t)
+
+	router := mux.NewRouter()
+	lc := LoginConfig{
+		Provider:        oidc.NewProvider(idp.URL, "client", "secret", "http://localhost/auth/callback"),
+		PostLoginUrl:    "http://localhost/client.html",
+		SessionLifetime: time.Hour,
+		AllowedSubjects: []string{"fake-subject"},
+	}
+	RegisterAuthHandlers(router, nil, lc)
+	handler := NewAuthenticationHandler("static", []string{"POST", "PUT", "DELETE"}, nil, router)
+
+	w := loginRoundTrip(t, handler, "cookie")
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "http://localhost/client.html", w.Header().Get("Location"))
+	var sessionCookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == SessionCookieName {
+			sessionCookie = c
+		}
+	}
+	assert.NotEqual(t, (*http.Cookie)(nil), sessionCookie)
+	assert.Equal(t, true, sessionCookie.HttpOnly)
+
+	// fetch the csrf token
+	var resp SessionResponse
+	w = httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/auth/session", nil)
+	r.AddCookie(sessionCookie)
+	handler.ServeHTTP(w, r)
+	json.NewDecoder(w.Body).Decode(&resp)
+	assert.Equal(t, http.StatusOK, w.Code)
+	csrf := resp.Result[0].CsrfToken
+	assert.Equal(t, 64, len(csrf))
+
+	// the cookie alone is not enough for state changing requests
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("DELETE", "/auth/session", nil)
+	r.AddCookie(sessionCookie)
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("DELETE", "/auth/session", nil)
+	r.AddCookie(sessionCookie)
+	r.Header.Set(CsrfHeaderName, "wrong")
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("DELETE", "/auth/session", nil)
+	r.AddCookie(sessionCookie)
+	r.Header.Set(CsrfHeaderName, csrf)
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestLoginCallbackErrors(t *testing.T) {
+	idp := oidctest.NewServer("client", "secret")
+	defer idp.Close()
+	mockUserStore(t)
+
+	router := mux.NewRouter()
+	lc := LoginConfig{
+		Provider:        oidc.NewProvider(idp.URL, "client", "secret", "http://localhost/auth/callback"),
+		SessionLifetime: time.Hour,
+		AllowedEmails:   []string{"Staff@example.com"},
+	}
+	RegisterAuthHandlers(router, nil, lc)
+	var resp SessionResponse
+
+	// bad login mode
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/auth/login?mode=other", nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// missing state cookie
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/auth/callback?code=fake-code&state=abc.token", nil)
+	router.ServeHTTP(w, r)
+	json.NewDecoder(w.Body).Decode(&resp)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, ErrMsgLogin, resp.getError().Error())
+
+	// mismatched state
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/auth/callback?code=fake-code&state=abc.token", nil)
+	r.AddCookie(&http.Cookie{Name: StateCookieName, Value: "def.token"})
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// bad code
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/auth/callback?code=bad&state=abc.token", nil)
+	r.AddCookie(&http.Cookie{Name: StateCookieName, Value: "abc.token"})
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// user store error
+	mSaveUser = func(db database.DB, u models.User) (models.User, error) {
+		return u, errors.New("some error")
+	}
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/auth/callback?code=fake-code&state=abc.token", nil)
+	r.AddCookie(&http.Cookie{Name: StateCookieName, Value: "abc.token"})
+	router.ServeHTTP(w, r)
+	json.NewDecoder(w.Body).Decode(&resp)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, ErrMsgUnexpected, resp.getError().Error())
+
+	// existing users get their details refreshed
+	mFindUserBySubject = func(db database.DB, subject string) (models.User, error) {
+		return models.User{Id: 9, Subject: subject, Email: "old@example.com"}, nil
+	}
+	updated := false
+	mUpdateUser = func(db database.DB, u models.User) (models.User, error) {
+		assert.Equal(t, "staff@example.com", u.Email)
+		updated = true
+		return u, nil
+	}
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/auth/callback?code=fake-code&state=abc.token", nil)
+	r.AddCookie(&http.Cookie{Name: StateCookieName, Value: "abc.token"})
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, true, updated)
+}
+
+func TestLoginCallbackUnknownUser(t *testing.T) {
+	idp := oidctest.NewServer("client", "secret")
+	defer idp.Close()
+	mockUserStore(t)
+	mSaveUser = func(db database.DB, u models.User) (models.User, error) {
+		t.Error("unexpected user record")
+		return u, nil
+	}
+
+	router := mux.NewRouter()
+	lc := LoginConfig{
+		Provider:        oidc.NewProvider(idp.URL, "client", "secret", "http://localhost/auth/callback"),
+		SessionLifetime: time.Hour,
+		AllowedSubjects: []string{"other-subject"},
+		AllowedEmails:   []string{"other@example.com"},
+	}
+	RegisterAuthHandlers(router, nil, lc)
+
+	// identities which are not allowed get neither a user record nor a session
+	var resp SessionResponse
+	w := loginRoundTrip(t, router, "token")
+	json.NewDecoder(w.Body).Decode(&resp)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, ErrMsgLoginForbidden, resp.getError().Error())
+	assert.Equal(t, 0, len(resp.Result))
+
+	// allowed emails only count once the identity provider has verified them
+	lc.AllowedEmails = []string{"staff@example.com"}
+	idp.Identity["email_verified"] = false
+	router = mux.NewRouter()
+	RegisterAuthHandlers(router, nil, lc)
+	resp = SessionResponse{}
+	w = loginRoundTrip(t, router, "token")
+	json.NewDecoder(w.Body).Decode(&resp)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, 0, len(resp.Result))
+}
+
+func TestAuthenticationHandler(t *testing.T) {
+	mockUserStore(t)
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := NewAuthenticationHandler("static", []string{"POST", "PUT", "DELETE"}, nil, inner)
+
+	// unhandled methods pass through
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/projects", nil)
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// missing credentials
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/projects", nil)
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// static token
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/projects", nil)
+	r.Header.Set("Auth-Token", "static")
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// unknown bearer token
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/projects", nil)
+	r.Header.Set("Authorization", "Bearer unknown")
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// an empty static token lets nothing through
+	handler = NewAuthenticationHandler("", []string{"POST", "PUT", "DELETE"}, nil, inner)
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/projects", nil)
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/projects", nil)
+	r.Header.Set("Auth-Token", "")
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
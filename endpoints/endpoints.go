@@ -3,19 +3,33 @@ package endpoints
 import (
 	"ims-release/config"
 	"ims-release/database"
+	"ims-release/models"
+	"ims-release/oidc"
 	"ims-release/storage_provider"
 
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 )
 
+// ErrNoAuthentication is raised when neither a static token nor logins are configured, which would leave no way to
+// authenticate requests that change data.
+var ErrNoAuthentication = errors.New("Either authToken or oidcIssuer must be set.")
+
 func NewHttpHandler(cfg *config.Config) http.Handler {
+	if cfg.AuthToken == "" && cfg.OidcIssuer == "" {
+		panic(ErrNoAuthentication)
+	}
 	db, err := database.NewDbHandle(cfg)
 	if err != nil {
 		panic(err)
@@ -23,27 +37,63 @@ func NewHttpHandler(cfg *config.Config) http.Handler {
 	db.Migrate(os.Getenv("GOPATH") + "/src/ims-release/migrations")
 	router := mux.NewRouter()
 	sp := storage_provider.File{Root: cfg.ImageDirectory}
-	registerHandlers(router, db, &sp)
+	mismatch := NewTypeMismatchPolicy(cfg.PageTypeMismatch)
+	if mismatch == TMismatchUnknown {
+		panic(ErrInvalidTypeMismatchPolicy)
+	}
+	checks, err := NewLintConfig(cfg.PublishChecks, cfg.MinPageCount)
+	if err != nil {
+		panic(err)
+	}
+	registerHandlers(router, db, &sp, mismatch, checks)
+	go runReleaseScheduler(db, &sp, checks, ReleaseSchedulerInterval)
 
-	authHandler := NewAuthenticationHandler(cfg.AuthToken, []string{"POST", "PUT", "DELETE"}, router)
+	searchMode := models.NewSearchMode(cfg.SearchMode)
+	if searchMode == models.SModeUnknown {
+		panic(models.ErrInvalidSearchMode)
+	}
+	RegisterSearchHandlers(router, db, searchMode)
+	publicUrl := strings.TrimSuffix(cfg.PublicUrl, "/")
+	RegisterFeedHandlers(router, db, publicUrl)
+	RegisterOpdsHandlers(router, db, publicUrl)
+
+	if cfg.OidcIssuer != "" {
+		provider := oidc.NewProvider(cfg.OidcIssuer, cfg.OidcClientId, cfg.OidcClientSecret, cfg.OidcRedirectUrl)
+		RegisterAuthHandlers(router, db, LoginConfig{
+			Provider:        provider,
+			PostLoginUrl:    cfg.OidcPostLoginUrl,
+			SessionLifetime: time.Duration(cfg.SessionLifetime) * time.Minute,
+			SecureCookies:   strings.HasPrefix(cfg.OidcRedirectUrl, "https://"),
+			AllowedSubjects: cfg.OidcAllowedSubjects,
+			AllowedEmails:   cfg.OidcAllowedEmails,
+		})
+	}
+
+	authHandler := NewAuthenticationHandler(cfg.AuthToken, []string{"POST", "PUT", "PATCH", "DELETE"}, db, router)
 	corsHandler := handlers.CORS(
-		handlers.AllowedOrigins([]string{"*"}),
-		handlers.AllowedHeaders([]string{"Auth-Token"}),
-		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE"}))(authHandler)
+		handlers.AllowedOrigins(cfg.AllowedOrigins),
+		handlers.AllowedHeaders([]string{"Auth-Token", "Authorization", "Content-Type", CsrfHeaderName, PageNameHeader,
+			UploadOffsetHeader}),
+		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "PATCH", "DELETE"}),
+		handlers.ExposedHeaders([]string{UploadOffsetHeader, UploadLengthHeader}),
+		handlers.AllowCredentials())(authHandler)
 
 	handler := handlers.LoggingHandler(os.Stdout, corsHandler)
 
 	return handler
 }
 
+// AuthenticationHandler guards the handled methods. A request is let through if it carries the static
+// Auth-Token, a bearer session token, or a session cookie together with the session's CSRF token.
 type AuthenticationHandler struct {
 	AuthToken      string
 	HandledMethods []string
+	Db             database.DB
 	InnerHandler   http.Handler
 }
 
-func NewAuthenticationHandler(authToken string, handledMethods []string, innerHandler http.Handler) AuthenticationHandler {
-	return AuthenticationHandler{AuthToken: authToken, HandledMethods: handledMethods, InnerHandler: innerHandler}
+func NewAuthenticationHandler(authToken string, handledMethods []string, db database.DB, innerHandler http.Handler) AuthenticationHandler {
+	return AuthenticationHandler{AuthToken: authToken, HandledMethods: handledMethods, Db: db, InnerHandler: innerHandler}
 }
 
 func (h AuthenticationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -56,19 +106,29 @@ func (h AuthenticationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
-	if token != h.AuthToken && handledMethod {
-		encodeHelper(w, ErrRspUnauthorized)
-	} else {
+	// an empty static token disables it, rather than letting requests without one through
+	if (h.AuthToken != "" && token == h.AuthToken) || !handledMethod {
 		h.InnerHandler.ServeHTTP(w, r)
+		return
+	}
+
+	session, fromCookie, err := sessionFromRequest(h.Db, r)
+	if err != nil || (fromCookie && !validCsrfToken(r, session)) {
+		encodeHelper(w, ErrRspUnauthorized)
+		return
 	}
+	h.InnerHandler.ServeHTTP(w, r)
 }
 
-func registerHandlers(r *mux.Router, db database.DB, sp storage_provider.Binary) {
+func registerHandlers(r *mux.Router, db database.DB, sp storage_provider.Binary, mismatch TypeMismatchPolicy, checks LintConfig) {
 	r.StrictSlash(true)
 	RegisterProjectHandlers(r, db)
-	RegisterReleaseHandlers(r, db, sp)
-	RegisterPageHandlers(r, db, sp)
+	RegisterReleaseHandlers(r, db, sp, checks)
+	RegisterLintHandlers(r, db, sp, checks)
+	RegisterPageHandlers(r, db, sp, mismatch)
 	RegisterThumbnailHandlers(r, db, sp)
+	RegisterUploadHandlers(r, db, sp, mismatch)
+	RegisterVersionHandlers(r, db, sp)
 }
 
 var (
@@ -91,10 +151,21 @@ type ApiResponseIf interface {
 	getError() error
 }
 type ApiResponse struct {
-	Code  int     `json:"-"`
-	Error *string `json:"error"`
+	Code   int     `json:"-"`
+	Error  *string `json:"error"`
+	Paging *Paging `json:"paging,omitempty"`
+}
+
+// Paging describes which slice of a list a response contains. Total is the number of items across all pages.
+type Paging struct {
+	Total  uint32 `json:"total"`
+	Limit  uint32 `json:"limit"`
+	Offset uint32 `json:"offset"`
 }
 
+// MaxListLimit is the largest number of items a single list request may ask for.
+const MaxListLimit = 1000
+
 func NewApiResponse(code int, e *string) ApiResponse {
 	return ApiResponse{Code: code, Error: e}
 }
@@ -111,6 +182,100 @@ func (r ApiResponse) getError() error {
 	}
 }
 
+func (r ApiResponse) withPaging(total uint32, opts models.ListOptions) ApiResponse {
+	r.Paging = &Paging{Total: total, Limit: opts.Limit, Offset: opts.Offset}
+	return r
+}
+
+// parseListOptions reads the limit, offset, sort and order query parameters shared by all list endpoints.
+func parseListOptions(r *http.Request) (models.ListOptions, error) {
+	q := r.URL.Query()
+	opts := models.ListOptions{Sort: q.Get("sort")}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.ParseUint(v, 10, 32)
+		if err != nil || limit == 0 || limit > MaxListLimit {
+			return opts, ErrRspBadRequest.getError()
+		}
+		opts.Limit = uint32(limit)
+	}
+
+	if v := q.Get("offset"); v != "" {
+		offset, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return opts, ErrRspBadRequest.getError()
+		}
+		opts.Offset = uint32(offset)
+	}
+
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		opts.Desc = true
+	default:
+		return opts, ErrRspBadRequest.getError()
+	}
+	return opts, nil
+}
+
+// parseTimeParam reads an optional RFC 3339 timestamp from the query string.
+func parseTimeParam(r *http.Request, name string) (time.Time, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// isListParamError reports whether an error returned by a List* or Count* model function was caused by
+// bad request parameters rather than a failure.
+func isListParamError(err error) bool {
+	return err == models.ErrInvalidSortField || err == models.ErrInvalidProjectStatus || err == models.ErrInvalidReleaseStatus
+}
+
+// hashETag produces a strong entity tag for a response body.
+func hashETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header value matches the entity tag, using weak comparison.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified sets the ETag and Last-Modified validators of a response, and reports whether the conditional headers
+// of the request show the client's copy to be current. In that case a 304 response has already been written.
+// Either validator may be left empty.
+func notModified(w http.ResponseWriter, r *http.Request, etag string, modified time.Time) bool {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	// If-Modified-Since is only considered when If-None-Match is absent, as required by RFC 7232
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if etag == "" || !etagMatches(match, etag) {
+			return false
+		}
+	} else {
+		since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		if err != nil || modified.IsZero() || modified.Truncate(time.Second).After(since) {
+			return false
+		}
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
 func decodeHelper(r *http.Request, s interface{}) error {
 	decoder := json.NewDecoder(r.Body)
 	defer r.Body.Close()
@@ -124,7 +289,7 @@ func decodeHelper(r *http.Request, s interface{}) error {
 
 func encodeHelper(w http.ResponseWriter, s ApiResponseIf) {
 	encoder := json.NewEncoder(w)
-	w.WriteHeader(s.getCode())
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(s.getCode())
 	encoder.Encode(s)
 }
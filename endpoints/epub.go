@@ -0,0 +1,218 @@
+package endpoints
+
+import (
+	"ims-release/models"
+
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Size used for pages whose image dimensions cannot be determined.
+const (
+	defaultPageWidth  = 800
+	defaultPageHeight = 1200
+)
+
+// epubPage is a page of an EPUB, which wraps one image in its own XHTML document.
+type epubPage struct {
+	Id        string
+	Document  string
+	Image     string
+	MediaType string
+	Width     int
+	Height    int
+}
+
+type epubData struct {
+	Identifier string
+	Title      string
+	Series     string
+	Number     string
+	Scanlator  string
+	Modified   string
+	Pages      []epubPage
+}
+
+var epubTemplates = template.Must(template.New("epub").Funcs(template.FuncMap{"xml": xmlEscape}).Parse(`
+{{- define "container" -}}
+<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+{{end}}
+
+{{- define "opf" -}}
+<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="uid" prefix="rendition: http://www.idpf.org/vocab/rendition/#">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="uid">{{xml .Identifier}}</dc:identifier>
+    <dc:title>{{xml .Title}}</dc:title>
+    <dc:language>en</dc:language>
+    <dc:publisher>{{xml .Scanlator}}</dc:publisher>
+    <meta property="dcterms:modified">{{.Modified}}</meta>
+    <meta property="belongs-to-collection" id="series">{{xml .Series}}</meta>
+    {{- if .Number}}
+    <meta refines="#series" property="group-position">{{xml .Number}}</meta>
+    {{- end}}
+    <meta property="rendition:layout">pre-paginated</meta>
+    <meta property="rendition:orientation">portrait</meta>
+    <meta property="rendition:spread">none</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    {{- range $i, $p := .Pages}}
+    <item id="img-{{$p.Id}}" href="{{$p.Image}}" media-type="{{$p.MediaType}}"{{if eq $i 0}} properties="cover-image"{{end}}/>
+    <item id="{{$p.Id}}" href="{{$p.Document}}" media-type="application/xhtml+xml"/>
+    {{- end}}
+  </manifest>
+  <spine page-progression-direction="rtl">
+    {{- range .Pages}}
+    <itemref idref="{{.Id}}"/>
+    {{- end}}
+  </spine>
+</package>
+{{end}}
+
+{{- define "nav" -}}
+<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>{{xml .Title}}</title></head>
+<body>
+  <nav epub:type="toc">
+    <ol>
+      {{- with index .Pages 0}}
+      <li><a href="{{.Document}}">{{xml $.Title}}</a></li>
+      {{- end}}
+    </ol>
+  </nav>
+</body>
+</html>
+{{end}}
+
+{{- define "page" -}}
+<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head>
+  <title>{{.Id}}</title>
+  <meta name="viewport" content="width={{.Width}}, height={{.Height}}"/>
+  <style>html, body { margin: 0; padding: 0; } img { display: block; width: 100%; height: 100%; }</style>
+</head>
+<body><img src="../{{.Image}}" alt=""/></body>
+</html>
+{{end}}
+`))
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// imageSize reports the dimensions of an image, falling back to a default size when they cannot be determined.
+func imageSize(data []byte) (int, int) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || cfg.Width == 0 || cfg.Height == 0 {
+		return defaultPageWidth, defaultPageHeight
+	}
+	return cfg.Width, cfg.Height
+}
+
+// buildEpubArchive renders a release as a fixed-layout EPUB 3 book with one page per image.
+func buildEpubArchive(p models.Project, r models.Release, pages []archivePage) ([]byte, error) {
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("release %d has no pages", r.Id)
+	}
+
+	_, number := models.ParseReleaseIdentifier(r.Identifier)
+	data := epubData{
+		Identifier: fmt.Sprintf("urn:ims-release:%d:%d:%d", p.Id, r.Id, r.Version),
+		Title:      fmt.Sprintf("%s %s", p.Name, r.Identifier),
+		Series:     p.Name,
+		Number:     number,
+		Scanlator:  r.Scanlator,
+		Modified:   r.ReleasedOn.UTC().Format(time.RFC3339),
+	}
+	for i, page := range pages {
+		id := fmt.Sprintf("p%04d", i+1)
+		width, height := imageSize(page.Data)
+		data.Pages = append(data.Pages, epubPage{
+			Id:        id,
+			Document:  "pages/" + id + ".xhtml",
+			Image:     "images/" + id + strings.ToLower(path.Ext(page.Name)),
+			MediaType: page.MimeType.String(),
+			Width:     width,
+			Height:    height,
+		})
+	}
+
+	buffer := new(bytes.Buffer)
+	z := zip.NewWriter(buffer)
+
+	// the mimetype file must come first and be stored uncompressed
+	f, err := z.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err == nil {
+		_, err = f.Write([]byte("application/epub+zip"))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	add := func(name string, render func(b *bytes.Buffer) error) error {
+		var b bytes.Buffer
+		err := render(&b)
+		if err != nil {
+			return err
+		}
+		f, err := z.Create(name)
+		if err != nil {
+			return fmt.Errorf("failed to add %s to archive: %v", name, err)
+		}
+		_, err = f.Write(b.Bytes())
+		return err
+	}
+	execute := func(name string, v interface{}) func(b *bytes.Buffer) error {
+		return func(b *bytes.Buffer) error {
+			return epubTemplates.ExecuteTemplate(b, name, v)
+		}
+	}
+
+	if err = add("META-INF/container.xml", execute("container", data)); err != nil {
+		return nil, err
+	}
+	if err = add("OEBPS/content.opf", execute("opf", data)); err != nil {
+		return nil, err
+	}
+	if err = add("OEBPS/nav.xhtml", execute("nav", data)); err != nil {
+		return nil, err
+	}
+	for i, page := range data.Pages {
+		if err = add("OEBPS/"+page.Document, execute("page", page)); err != nil {
+			return nil, err
+		}
+		imageData := pages[i].Data
+		err = add("OEBPS/"+page.Image, func(b *bytes.Buffer) error {
+			_, err := b.Write(imageData)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = z.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed when finalizing archive: %v", err)
+	}
+	return buffer.Bytes(), nil
+}
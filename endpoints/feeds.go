@@ -0,0 +1,260 @@
+package endpoints
+
+import (
+	"ims-release/database"
+	"ims-release/models"
+
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Feed settings.
+const (
+	FeedLength = 50
+	FeedTitle  = "IMangaScans releases"
+	FeedAuthor = "IMangaScans"
+
+	atomContentType = "application/atom+xml; charset=utf-8"
+	rssContentType  = "application/rss+xml; charset=utf-8"
+	atomNamespace   = "http://www.w3.org/2005/Atom"
+)
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	Id      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Id        string       `xml:"id"`
+	Title     string       `xml:"title"`
+	Updated   string       `xml:"updated"`
+	Published string       `xml:"published,omitempty"`
+	Content   *atomContent `xml:"content,omitempty"`
+	Links     []atomLink   `xml:"link"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title   string  `xml:"title"`
+	Link    string  `xml:"link"`
+	Guid    rssGuid `xml:"guid"`
+	PubDate string  `xml:"pubDate"`
+}
+
+type rssGuid struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// RegisterFeedHandlers attaches the closures generated by each function defined below
+// to handle incoming requests to the appropriate endpoint. Links in the feeds are made
+// absolute using publicUrl, specified in main.
+func RegisterFeedHandlers(r *mux.Router, db database.DB, publicUrl string) {
+	r.HandleFunc("/feeds/releases.atom", releasesAtomFeed(db, publicUrl)).Methods("GET")
+	r.HandleFunc("/feeds/releases.rss", releasesRssFeed(db, publicUrl)).Methods("GET")
+	r.HandleFunc("/projects/{projectId:[0-9]+}/feed.atom", projectAtomFeed(db, publicUrl)).Methods("GET")
+}
+
+// baseUrl is the URL the API is reachable at. Without a configured public URL it is derived from the request.
+func baseUrl(r *http.Request, publicUrl string) string {
+	if publicUrl != "" {
+		return publicUrl
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func releaseDownloadUrl(base string, r models.ProjectRelease) string {
+	return fmt.Sprintf("%s/projects/%d/releases/%d/download/%s", base, r.ProjectID, r.Id, url.PathEscape(r.ArchiveName))
+}
+
+func releaseTitle(r models.ProjectRelease) string {
+	return fmt.Sprintf("%s %s v%d", r.ProjectName, r.Identifier, r.Version)
+}
+
+// feedUpdated is the time of the newest release, releases being ordered newest first.
+func feedUpdated(releases []models.ProjectRelease) time.Time {
+	if len(releases) == 0 {
+		return time.Time{}
+	}
+	return releases[0].ReleasedOn.UTC()
+}
+
+func newAtomFeed(base, self, title string, releases []models.ProjectRelease) atomFeed {
+	feed := atomFeed{
+		Xmlns:   atomNamespace,
+		Id:      base + self,
+		Title:   title,
+		Updated: feedUpdated(releases).Format(time.RFC3339),
+		Author:  atomAuthor{FeedAuthor},
+		Links:   []atomLink{{Rel: "self", Type: "application/atom+xml", Href: base + self}},
+		Entries: []atomEntry{},
+	}
+	for _, r := range releases {
+		link := releaseDownloadUrl(base, r)
+		released := r.ReleasedOn.UTC().Format(time.RFC3339)
+		feed.Entries = append(feed.Entries, atomEntry{
+			Id:        link,
+			Title:     releaseTitle(r),
+			Updated:   released,
+			Published: released,
+			Links:     []atomLink{{Rel: "enclosure", Type: "application/zip", Href: link}},
+		})
+	}
+	return feed
+}
+
+func newRssFeed(base, title string, releases []models.ProjectRelease) rssFeed {
+	channel := rssChannel{
+		Title:       title,
+		Link:        base,
+		Description: title,
+		Items:       []rssItem{},
+	}
+	if updated := feedUpdated(releases); !updated.IsZero() {
+		channel.LastBuildDate = updated.Format(time.RFC1123Z)
+	}
+	for _, r := range releases {
+		link := releaseDownloadUrl(base, r)
+		channel.Items = append(channel.Items, rssItem{
+			Title:   releaseTitle(r),
+			Link:    link,
+			Guid:    rssGuid{true, link},
+			PubDate: r.ReleasedOn.UTC().Format(time.RFC1123Z),
+		})
+	}
+	return rssFeed{Version: "2.0", Channel: channel}
+}
+
+// writeFeed serves an XML document, answering conditional requests with 304 when the feed has not changed.
+func writeFeed(w http.ResponseWriter, r *http.Request, contentType string, feed interface{}, modified time.Time) {
+	body, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		log.Println("[---] Feed error:", err)
+		encodeHelper(w, ErrRspUnexpected)
+		return
+	}
+	body = append([]byte(xml.Header), body...)
+
+	if notModified(w, r, hashETag(body), modified) {
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(body)
+}
+
+// latestReleases obtains the newest released releases of all projects.
+func latestReleases(db database.DB) ([]models.ProjectRelease, error) {
+	filter := models.ReleaseFilter{Status: models.RStatusReleasedStr}
+	opts := models.ListOptions{Limit: FeedLength, Sort: "releasedOn", Desc: true}
+	return mListAllReleases(db, filter, opts)
+}
+
+// GET /feeds/releases.atom
+// releasesAtomFeed produces an Atom feed of the newest releases of all projects.
+func releasesAtomFeed(db database.DB, publicUrl string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		releases, err := latestReleases(db)
+		if err != nil {
+			log.Println("[---] Listing error:", err)
+			encodeHelper(w, ErrRspListReleases)
+			return
+		}
+		base := baseUrl(r, publicUrl)
+		writeFeed(w, r, atomContentType, newAtomFeed(base, "/feeds/releases.atom", FeedTitle, releases), feedUpdated(releases))
+	}
+}
+
+// GET /feeds/releases.rss
+// releasesRssFeed produces an RSS feed of the newest releases of all projects.
+func releasesRssFeed(db database.DB, publicUrl string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		releases, err := latestReleases(db)
+		if err != nil {
+			log.Println("[---] Listing error:", err)
+			encodeHelper(w, ErrRspListReleases)
+			return
+		}
+		writeFeed(w, r, rssContentType, newRssFeed(baseUrl(r, publicUrl), FeedTitle, releases), feedUpdated(releases))
+	}
+}
+
+// GET /projects/{projectId}/feed.atom
+// projectAtomFeed produces an Atom feed of the newest releases of a project.
+func projectAtomFeed(db database.DB, publicUrl string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		project, err := fetchProjectUsingRequestArgs(db, w, r, true)
+		if err != nil {
+			log.Println("[---] Project fetch error:", err)
+			// response already set
+			return
+		}
+
+		filter := models.ReleaseFilter{Status: models.RStatusReleasedStr}
+		opts := models.ListOptions{Limit: FeedLength, Sort: "releasedOn", Desc: true}
+		list, err := mListReleases(db, project, filter, opts)
+		if err != nil {
+			log.Println("[---] Listing error:", err)
+			encodeHelper(w, ErrRspListReleases)
+			return
+		}
+
+		releases := []models.ProjectRelease{}
+		for _, release := range list {
+			releases = append(releases, models.ProjectRelease{
+				Release:          release,
+				ProjectName:      project.Name,
+				ProjectShorthand: project.Shorthand,
+				ArchiveName:      mGenerateArchiveName(project, release),
+			})
+		}
+
+		base := baseUrl(r, publicUrl)
+		self := fmt.Sprintf("/projects/%d/feed.atom", project.Id)
+		feed := newAtomFeed(base, self, project.Name+" releases", releases)
+		writeFeed(w, r, atomContentType, feed, feedUpdated(releases))
+	}
+}
@@ -0,0 +1,158 @@
+package endpoints
+
+import (
+	"encoding/xml"
+	"errors"
+	"github.com/gorilla/mux"
+	"ims-release/assert"
+	"ims-release/database"
+	"ims-release/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var feedReleases = []models.ProjectRelease{
+	{
+		Release:          models.Release{Id: 7, Identifier: "c12", Version: 2, Scanlator: "ims", ReleasedOn: time.Date(2017, 3, 2, 10, 0, 0, 0, time.UTC), ProjectID: 5},
+		ProjectName:      "Project",
+		ProjectShorthand: "proj",
+		ArchiveName:      "proj - c12[2][ims].zip",
+	},
+	{
+		Release:          models.Release{Id: 6, Identifier: "c11", Version: 1, Scanlator: "ims", ReleasedOn: time.Date(2017, 3, 1, 10, 0, 0, 0, time.UTC), ProjectID: 5},
+		ProjectName:      "Project",
+		ProjectShorthand: "proj",
+		ArchiveName:      "proj - c11[1][ims].zip",
+	},
+}
+
+func TestReleasesAtomFeed(t *testing.T) {
+	mListAllReleases = func(db database.DB, filter models.ReleaseFilter, opts models.ListOptions) ([]models.ProjectRelease, error) {
+		assert.Equal(t, models.ReleaseFilter{Status: models.RStatusReleasedStr}, filter)
+		assert.Equal(t, models.ListOptions{Limit: FeedLength, Sort: "releasedOn", Desc: true}, opts)
+		return feedReleases, nil
+	}
+	router := mux.NewRouter()
+	RegisterFeedHandlers(router, nil, "https://api.example.com")
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/feeds/releases.atom", nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, atomContentType, w.Header().Get("Content-Type"))
+	assert.Equal(t, "Thu, 02 Mar 2017 10:00:00 GMT", w.Header().Get("Last-Modified"))
+	etag := w.Header().Get("ETag")
+	assert.NotEqual(t, "", etag)
+
+	var feed atomFeed
+	err := xml.Unmarshal(w.Body.Bytes(), &feed)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, atomNamespace, feed.XMLName.Space)
+	assert.Equal(t, "https://api.example.com/feeds/releases.atom", feed.Id)
+	assert.Equal(t, "2017-03-02T10:00:00Z", feed.Updated)
+	assert.Equal(t, 2, len(feed.Entries))
+	assert.Equal(t, "Project c12 v2", feed.Entries[0].Title)
+	assert.Equal(t, "https://api.example.com/projects/5/releases/7/download/proj%20-%20c12%5B2%5D%5Bims%5D.zip", feed.Entries[0].Links[0].Href)
+
+	// unchanged feeds are not sent again
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/feeds/releases.atom", nil)
+	r.Header.Set("If-None-Match", etag)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, 0, w.Body.Len())
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/feeds/releases.atom", nil)
+	r.Header.Set("If-Modified-Since", "Thu, 02 Mar 2017 10:00:00 GMT")
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	// If-None-Match takes precedence over If-Modified-Since
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/feeds/releases.atom", nil)
+	r.Header.Set("If-None-Match", `"other"`)
+	r.Header.Set("If-Modified-Since", "Thu, 02 Mar 2017 10:00:00 GMT")
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/feeds/releases.atom", nil)
+	r.Header.Set("If-Modified-Since", "Wed, 01 Mar 2017 10:00:00 GMT")
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// listing error
+	mListAllReleases = func(db database.DB, filter models.ReleaseFilter, opts models.ListOptions) ([]models.ProjectRelease, error) {
+		return []models.ProjectRelease{}, errors.New("some error")
+	}
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/feeds/releases.atom", nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestReleasesRssFeed(t *testing.T) {
+	mListAllReleases = func(db database.DB, filter models.ReleaseFilter, opts models.ListOptions) ([]models.ProjectRelease, error) {
+		return feedReleases, nil
+	}
+	router := mux.NewRouter()
+	RegisterFeedHandlers(router, nil, "")
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://localhost:3000/feeds/releases.rss", nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, rssContentType, w.Header().Get("Content-Type"))
+
+	var feed rssFeed
+	err := xml.Unmarshal(w.Body.Bytes(), &feed)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "2.0", feed.Version)
+	assert.Equal(t, "Thu, 02 Mar 2017 10:00:00 +0000", feed.Channel.LastBuildDate)
+	assert.Equal(t, 2, len(feed.Channel.Items))
+	assert.Equal(t, "Project c11 v1", feed.Channel.Items[1].Title)
+	assert.Equal(t, "http://localhost:3000/projects/5/releases/6/download/proj%20-%20c11%5B1%5D%5Bims%5D.zip", feed.Channel.Items[1].Guid.Value)
+
+	w2 := httptest.NewRecorder()
+	r.Header.Set("If-None-Match", "W/"+w.Header().Get("ETag"))
+	router.ServeHTTP(w2, r)
+	assert.Equal(t, http.StatusNotModified, w2.Code)
+}
+
+func TestProjectAtomFeed(t *testing.T) {
+	mFindProject = func(db database.DB, id uint32) (models.Project, error) {
+		if id != 5 {
+			return models.Project{}, models.ErrNoSuchProject
+		}
+		return models.Project{Id: id, Name: "Project", Shorthand: "proj"}, nil
+	}
+	mListReleases = func(db database.DB, p models.Project, filter models.ReleaseFilter, opts models.ListOptions) ([]models.Release, error) {
+		assert.Equal(t, uint32(5), p.Id)
+		assert.Equal(t, models.ReleaseFilter{Status: models.RStatusReleasedStr}, filter)
+		return []models.Release{feedReleases[0].Release}, nil
+	}
+	mGenerateArchiveName = models.GenerateArchiveName
+	router := mux.NewRouter()
+	RegisterFeedHandlers(router, nil, "https://api.example.com")
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/projects/5/feed.atom", nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var feed atomFeed
+	err := xml.Unmarshal(w.Body.Bytes(), &feed)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "Project releases", feed.Title)
+	assert.Equal(t, "https://api.example.com/projects/5/feed.atom", feed.Id)
+	assert.Equal(t, 1, len(feed.Entries))
+	assert.Equal(t, "https://api.example.com/projects/5/releases/7/download/proj%20-%20c12%5B2%5D%5Bims%5D.zip", feed.Entries[0].Id)
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/projects/6/feed.atom", nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
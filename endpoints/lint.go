@@ -0,0 +1,293 @@
+package endpoints
+
+import (
+	"ims-release/database"
+	"ims-release/models"
+	"ims-release/storage_provider"
+
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"image"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// LintLevel tells what happens when a release fails a publish check. Failing a check of the error level prevents
+// the release from being published, while warnings are only reported.
+type LintLevel uint32
+
+const (
+	LLevelUnknown    LintLevel = 0
+	LLevelUnknownStr string    = "unknown"
+	LLevelOff        LintLevel = 1
+	LLevelOffStr     string    = "off"
+	LLevelWarning    LintLevel = 2
+	LLevelWarningStr string    = "warning"
+	LLevelError      LintLevel = 3
+	LLevelErrorStr   string    = "error"
+)
+
+func (l LintLevel) String() string {
+	switch l {
+	case LLevelOff:
+		return LLevelOffStr
+	case LLevelWarning:
+		return LLevelWarningStr
+	case LLevelError:
+		return LLevelErrorStr
+	default:
+		return LLevelUnknownStr
+	}
+}
+
+func NewLintLevel(val string) LintLevel {
+	switch val {
+	case LLevelOffStr:
+		return LLevelOff
+	case LLevelWarningStr:
+		return LLevelWarning
+	case LLevelErrorStr:
+		return LLevelError
+	default:
+		return LLevelUnknown
+	}
+}
+
+var (
+	ErrInvalidLintLevel = errors.New("Invalid publish check level.")
+	ErrUnknownLintRule  = errors.New("Unknown publish check.")
+)
+
+var (
+	ErrMsgTooFewPages      = "The release has fewer pages than required."
+	ErrRspTooFewPages      = NewApiResponse(http.StatusExpectationFailed, &ErrMsgTooFewPages)
+	ErrMsgPageDimensions   = "Some pages are not as wide as most of the pages or twice as wide."
+	ErrRspPageDimensions   = NewApiResponse(http.StatusExpectationFailed, &ErrMsgPageDimensions)
+	ErrMsgDuplicatePages   = "Some pages contain the same image."
+	ErrRspDuplicatePages   = NewApiResponse(http.StatusExpectationFailed, &ErrMsgDuplicatePages)
+	ErrMsgIdentifierFormat = "The identifier does not contain a chapter number."
+	ErrRspIdentifierFormat = NewApiResponse(http.StatusExpectationFailed, &ErrMsgIdentifierFormat)
+	ErrMsgLintRelease      = "Could not check the release. Please try again later."
+	ErrRspLintRelease      = NewApiResponse(http.StatusInternalServerError, &ErrMsgLintRelease)
+)
+
+// DefaultMinPageCount is the number of pages a release needs by default, a credit page and a content page.
+const DefaultMinPageCount uint32 = 2
+
+// Names of the publish checks, as used in the configuration and in lint results.
+const (
+	LintRuleCreditPage       = "creditPage"
+	LintRulePageCount        = "minPageCount"
+	LintRulePageDimensions   = "pageDimensions"
+	LintRuleDuplicatePages   = "duplicatePages"
+	LintRuleIdentifierFormat = "identifierFormat"
+)
+
+// lintRule is a single publish check. The check returns the ids of the offending pages, if any, and whether the
+// release passes.
+type lintRule struct {
+	Name  string
+	Level LintLevel
+	Rsp   ApiResponse
+	check func(cfg LintConfig, r models.Release, pages []archivePage) ([]uint32, bool)
+}
+
+// lintRules lists the publish checks in the order they are run, with their default levels.
+var lintRules = []lintRule{
+	{LintRuleCreditPage, LLevelError, ErrRspMustContainCreditPage, lintCreditPage},
+	{LintRulePageCount, LLevelWarning, ErrRspTooFewPages, lintPageCount},
+	{LintRulePageDimensions, LLevelWarning, ErrRspPageDimensions, lintPageDimensions},
+	{LintRuleDuplicatePages, LLevelWarning, ErrRspDuplicatePages, lintDuplicatePages},
+	{LintRuleIdentifierFormat, LLevelWarning, ErrRspIdentifierFormat, lintIdentifierFormat},
+}
+
+// LintConfig holds the level of every publish check, and the settings of the checks which have any.
+type LintConfig struct {
+	Levels       map[string]LintLevel
+	MinPageCount uint32
+}
+
+// NewLintConfig overrides the default levels of the publish checks with the given ones. A zero minPageCount
+// stands for DefaultMinPageCount.
+func NewLintConfig(levels map[string]string, minPageCount uint32) (LintConfig, error) {
+	cfg := DefaultLintConfig()
+	for name, val := range levels {
+		if _, ok := cfg.Levels[name]; !ok {
+			return cfg, ErrUnknownLintRule
+		}
+		level := NewLintLevel(val)
+		if level == LLevelUnknown {
+			return cfg, ErrInvalidLintLevel
+		}
+		cfg.Levels[name] = level
+	}
+	if minPageCount != 0 {
+		cfg.MinPageCount = minPageCount
+	}
+	return cfg, nil
+}
+
+// DefaultLintConfig only prevents releases without a credit page from being published.
+func DefaultLintConfig() LintConfig {
+	cfg := LintConfig{Levels: map[string]LintLevel{}, MinPageCount: DefaultMinPageCount}
+	for _, rule := range lintRules {
+		cfg.Levels[rule.Name] = rule.Level
+	}
+	return cfg
+}
+
+// LintResult describes a publish check which a release fails.
+type LintResult struct {
+	Rule    string   `json:"rule"`
+	Level   string   `json:"level"`
+	Message string   `json:"message"`
+	Pages   []uint32 `json:"pages,omitempty"`
+
+	rsp ApiResponse
+}
+
+type LintResponse struct {
+	ApiResponse
+	Result []LintResult `json:"result"`
+}
+
+func NewLintResponse(a ApiResponse, r []LintResult) LintResponse {
+	return LintResponse{ApiResponse: a, Result: r}
+}
+
+// lintRelease runs every enabled publish check on a release and its pages, given in page order.
+func lintRelease(cfg LintConfig, r models.Release, pages []archivePage) []LintResult {
+	results := []LintResult{}
+	for _, rule := range lintRules {
+		level := cfg.Levels[rule.Name]
+		if level == LLevelOff {
+			continue
+		}
+		ids, ok := rule.check(cfg, r, pages)
+		if !ok {
+			results = append(results, LintResult{rule.Name, level.String(), *rule.Rsp.Error, ids, rule.Rsp})
+		}
+	}
+	return results
+}
+
+// lintError returns the first result which prevents publishing, if any.
+func lintError(results []LintResult) (LintResult, bool) {
+	for _, result := range results {
+		if result.Level == LLevelErrorStr {
+			return result, true
+		}
+	}
+	return LintResult{}, false
+}
+
+// checkPublishable runs the publish checks on a release which is about to be published, and returns the response for
+// the first check set to the error level which it fails.
+func checkPublishable(db database.DB, sp storage_provider.Binary, cfg LintConfig, p models.Project, r models.Release) ApiResponse {
+	pages, err := loadArchivePages(db, sp, p, r)
+	if err != nil {
+		log.Println("[---] Lint error:", err)
+		return ErrRspUnexpected
+	}
+	failed, found := lintError(lintRelease(cfg, r, pages))
+	if found {
+		log.Println("[---] Publish check failed:", failed.Message)
+		return failed.rsp
+	}
+	return NoErr
+}
+
+func lintCreditPage(cfg LintConfig, r models.Release, pages []archivePage) ([]uint32, bool) {
+	for _, page := range pages {
+		if page.Role == models.PRoleCreditStr {
+			return nil, true
+		}
+	}
+	return nil, false
+}
+
+func lintPageCount(cfg LintConfig, r models.Release, pages []archivePage) ([]uint32, bool) {
+	return nil, uint32(len(pages)) >= cfg.MinPageCount
+}
+
+// lintPageDimensions compares the widths of the content pages. Pages twice as wide as most pages are spreads.
+func lintPageDimensions(cfg LintConfig, r models.Release, pages []archivePage) ([]uint32, bool) {
+	widths := map[uint32]int{}
+	counts := map[int]int{}
+	mostCommon := 0
+	for _, page := range pages {
+		if page.Role != models.PRoleContentStr {
+			continue
+		}
+		conf, _, err := image.DecodeConfig(bytes.NewReader(page.Data))
+		if err != nil {
+			// the image was checked when it was uploaded
+			continue
+		}
+		widths[page.Id] = conf.Width
+		counts[conf.Width]++
+		if counts[conf.Width] > counts[mostCommon] {
+			mostCommon = conf.Width
+		}
+	}
+
+	ids := []uint32{}
+	for _, page := range pages {
+		width, ok := widths[page.Id]
+		if ok && width != mostCommon && width != 2*mostCommon {
+			ids = append(ids, page.Id)
+		}
+	}
+	return ids, len(ids) == 0
+}
+
+// lintDuplicatePages looks for pages with identical image data. Every page after the first copy is reported.
+func lintDuplicatePages(cfg LintConfig, r models.Release, pages []archivePage) ([]uint32, bool) {
+	seen := map[[sha256.Size]byte]bool{}
+	ids := []uint32{}
+	for _, page := range pages {
+		sum := sha256.Sum256(page.Data)
+		if seen[sum] {
+			ids = append(ids, page.Id)
+		}
+		seen[sum] = true
+	}
+	return ids, len(ids) == 0
+}
+
+func lintIdentifierFormat(cfg LintConfig, r models.Release, pages []archivePage) ([]uint32, bool) {
+	_, number := models.ParseReleaseIdentifier(r.Identifier)
+	return nil, number != ""
+}
+
+// RegisterLintHandlers attaches the closures generated by each function defined below
+// to handle incoming requests to the appropriate endpoint using a subrouter with an
+// appropriate prefix, specified in main.
+func RegisterLintHandlers(r *mux.Router, db database.DB, sp storage_provider.Binary, cfg LintConfig) {
+	r.HandleFunc("/projects/{projectId:[0-9]+}/releases/{releaseId:[0-9]+}/lint", getReleaseLint(db, sp, cfg)).Methods("GET")
+}
+
+// GET /projects/{projectId}/releases/{releaseId}/lint
+// getReleaseLint runs the publish checks on a release and lists the ones it fails. Checks which are turned off are
+// skipped.
+func getReleaseLint(db database.DB, sp storage_provider.Binary, cfg LintConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		project, release, err := fetchReleaseUsingRequestArgs(db, w, r, true)
+		if err != nil {
+			log.Println("[---] Release fetch error:", err)
+			// response already set
+			return
+		}
+
+		pages, err := loadArchivePages(db, sp, project, release)
+		if err != nil {
+			log.Println("[---] Lint error:", err)
+			encodeHelper(w, NewLintResponse(ErrRspLintRelease, []LintResult{}))
+			return
+		}
+		encodeHelper(w, NewLintResponse(NoErr, lintRelease(cfg, release, pages)))
+	}
+}
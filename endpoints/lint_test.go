@@ -0,0 +1,186 @@
+package endpoints
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"image"
+	"image/png"
+	"ims-release/assert"
+	"ims-release/database"
+	"ims-release/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func testPngOfSize(width, height int) []byte {
+	var buffer bytes.Buffer
+	png.Encode(&buffer, image.NewRGBA(image.Rect(0, 0, width, height)))
+	return buffer.Bytes()
+}
+
+func TestLintLevel(t *testing.T) {
+	assert.Equal(t, LLevelOff, NewLintLevel("off"))
+	assert.Equal(t, LLevelWarning, NewLintLevel("warning"))
+	assert.Equal(t, LLevelError, NewLintLevel("error"))
+	assert.Equal(t, LLevelUnknown, NewLintLevel("bla"))
+	assert.Equal(t, "warning", LLevelWarning.String())
+	assert.Equal(t, "unknown", LintLevel(4).String())
+}
+
+func TestNewLintConfig(t *testing.T) {
+	cfg, err := NewLintConfig(nil, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, DefaultMinPageCount, cfg.MinPageCount)
+	assert.Equal(t, LLevelError, cfg.Levels[LintRuleCreditPage])
+	assert.Equal(t, LLevelWarning, cfg.Levels[LintRuleDuplicatePages])
+
+	cfg, err = NewLintConfig(map[string]string{LintRuleCreditPage: "off", LintRuleDuplicatePages: "error"}, 5)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint32(5), cfg.MinPageCount)
+	assert.Equal(t, LLevelOff, cfg.Levels[LintRuleCreditPage])
+	assert.Equal(t, LLevelError, cfg.Levels[LintRuleDuplicatePages])
+	assert.Equal(t, LLevelWarning, cfg.Levels[LintRulePageCount])
+
+	_, err = NewLintConfig(map[string]string{"bla": "off"}, 0)
+	assert.Equal(t, ErrUnknownLintRule, err)
+
+	_, err = NewLintConfig(map[string]string{LintRuleCreditPage: "bla"}, 0)
+	assert.Equal(t, ErrInvalidLintLevel, err)
+}
+
+func TestLintRelease(t *testing.T) {
+	cfg := DefaultLintConfig()
+	release := models.Release{Identifier: "c1"}
+	content := func(id uint32, data []byte) archivePage {
+		return archivePage{models.Page{Id: id, Role: models.PRoleContentStr}, data}
+	}
+	rules := func(results []LintResult) string {
+		names := []string{}
+		for _, result := range results {
+			names = append(names, result.Rule+":"+result.Level+":"+fmt.Sprint(result.Pages))
+		}
+		return strings.Join(names, ",")
+	}
+
+	// test a release which passes every check
+	pages := []archivePage{
+		content(1, testPngOfSize(4, 6)),
+		content(2, testPngOfSize(8, 6)),
+		content(3, testPngOfSize(4, 7)),
+		{models.Page{Id: 4, Role: models.PRoleCreditStr}, testPngOfSize(10, 3)},
+	}
+	assert.Equal(t, "", rules(lintRelease(cfg, release, pages)))
+
+	// test an empty release
+	results := lintRelease(cfg, models.Release{Identifier: "extra"}, nil)
+	assert.Equal(t, "creditPage:error:[],minPageCount:warning:[],identifierFormat:warning:[]", rules(results))
+	assert.Equal(t, ErrMsgMustContainCreditPage, results[0].Message)
+	failed, found := lintError(results)
+	assert.Equal(t, true, found)
+	assert.Equal(t, LintRuleCreditPage, failed.Rule)
+
+	// test odd widths and duplicate images
+	pages = append(pages, content(5, testPngOfSize(5, 6)), content(6, testPngOfSize(4, 6)))
+	results = lintRelease(cfg, release, pages)
+	assert.Equal(t, "pageDimensions:warning:[5],duplicatePages:warning:[6]", rules(results))
+	_, found = lintError(results)
+	assert.Equal(t, false, found)
+
+	// test checks turned off or raised to errors
+	cfg.Levels[LintRulePageDimensions] = LLevelOff
+	cfg.Levels[LintRuleDuplicatePages] = LLevelError
+	results = lintRelease(cfg, release, pages)
+	assert.Equal(t, "duplicatePages:error:[6]", rules(results))
+	failed, found = lintError(results)
+	assert.Equal(t, true, found)
+	assert.Equal(t, ErrMsgDuplicatePages, *failed.rsp.Error)
+}
+
+func TestGetReleaseLint(t *testing.T) {
+	mFindProject = func(db database.DB, id uint32) (models.Project, error) {
+		return models.Project{Id: id}, nil
+	}
+	mFindRelease = func(db database.DB, p models.Project, id uint32) (models.Release, error) {
+		return models.Release{Id: id, ProjectID: p.Id, Identifier: "c1", Status: "draft"}, nil
+	}
+	mListPages = func(db database.DB, release models.Release, opts models.ListOptions) ([]models.Page, error) {
+		return []models.Page{models.Page{Id: 1, Name: "p01.png", Role: models.PRoleContentStr}}, nil
+	}
+	mGeneratePagePath = models.GeneratePagePath
+
+	sp := SpMap{}
+	router := mux.NewRouter()
+	registerHandlers(router, nil, sp, TMismatchReject, DefaultLintConfig())
+	get := func() (*httptest.ResponseRecorder, LintResponse) {
+		var resp LintResponse
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/projects/12/releases/70/lint", nil)
+		router.ServeHTTP(w, r)
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w, resp
+	}
+
+	// test a page which cannot be read
+	w, resp := get()
+	assert.Equal(t, ErrMsgLintRelease, resp.getError().Error())
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	// test the failed checks are listed
+	sp["12/70/p01.png"] = testPngOfSize(4, 6)
+	w, resp = get()
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 2, len(resp.Result))
+	assert.Equal(t, LintRuleCreditPage, resp.Result[0].Rule)
+	assert.Equal(t, "error", resp.Result[0].Level)
+	assert.Equal(t, ErrMsgMustContainCreditPage, resp.Result[0].Message)
+	assert.Equal(t, LintRulePageCount, resp.Result[1].Rule)
+	assert.Equal(t, "warning", resp.Result[1].Level)
+}
+
+func TestUpdateReleaseLint(t *testing.T) {
+	mockReleaseVersions()
+	mFindProject = func(db database.DB, id uint32) (models.Project, error) {
+		return models.Project{Id: id}, nil
+	}
+	mFindRelease = func(db database.DB, p models.Project, id uint32) (models.Release, error) {
+		return models.Release{Id: id, ProjectID: p.Id, Identifier: "c1", Version: 1, Status: "draft"}, nil
+	}
+	mListPages = func(db database.DB, release models.Release, opts models.ListOptions) ([]models.Page, error) {
+		return []models.Page{models.Page{Id: 1, Name: "p01.png", Role: models.PRoleCreditStr}}, nil
+	}
+	mUpdateRelease = func(db database.DB, release models.Release) (models.Release, error) {
+		return release, nil
+	}
+	mGeneratePagePath = models.GeneratePagePath
+	mGenerateArchiveName = models.GenerateArchiveName
+	mGenerateArchivePath = models.GenerateArchivePath
+
+	sp := SpMap{"12/70/p01.png": testPngOfSize(4, 6)}
+	cfg, _ := NewLintConfig(map[string]string{LintRuleIdentifierFormat: "error"}, 0)
+	router := mux.NewRouter()
+	registerHandlers(router, nil, sp, TMismatchReject, cfg)
+	put := func(body string) (*httptest.ResponseRecorder, ReleaseResponse) {
+		var resp ReleaseResponse
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("PUT", "/projects/12/releases/70", strings.NewReader(body))
+		router.ServeHTTP(w, r)
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w, resp
+	}
+
+	// test the requested identifier is checked
+	w, resp := put(`{"identifier":"extra","version":2,"status":"released"}`)
+	assert.Equal(t, ErrMsgIdentifierFormat, resp.getError().Error())
+	assert.Equal(t, http.StatusExpectationFailed, w.Code)
+
+	// test warnings do not prevent publishing
+	w, resp = put(`{"identifier":"c1","version":2,"status":"released"}`)
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "released", resp.Result[0].Status)
+}
@@ -0,0 +1,159 @@
+package endpoints
+
+import (
+	"ims-release/database"
+	"ims-release/models"
+
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// OPDS link relations and types.
+const (
+	opdsNavigationType  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	opdsAcquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	opdsRelAcquisition  = "http://opds-spec.org/acquisition"
+	opdsRelImage        = "http://opds-spec.org/image"
+	opdsRelThumbnail    = "http://opds-spec.org/image/thumbnail"
+	opdsCatalogTitle    = "IMangaScans"
+)
+
+// RegisterOpdsHandlers attaches the closures generated by each function defined below
+// to handle incoming requests to the appropriate endpoint. Links in the catalog are made
+// absolute using publicUrl, specified in main.
+func RegisterOpdsHandlers(r *mux.Router, db database.DB, publicUrl string) {
+	r.HandleFunc("/opds", opdsRoot(db, publicUrl)).Methods("GET")
+	r.HandleFunc("/opds/projects/{projectId:[0-9]+}", opdsProject(db, publicUrl)).Methods("GET")
+}
+
+func opdsProjectUrl(base string, p models.Project) string {
+	return fmt.Sprintf("%s/opds/projects/%d", base, p.Id)
+}
+
+// opdsCatalogLinks are the links every feed of the catalog carries.
+func opdsCatalogLinks(base, self, selfType string) []atomLink {
+	return []atomLink{
+		{Rel: "self", Type: selfType, Href: self},
+		{Rel: "start", Type: opdsNavigationType, Href: base + "/opds"},
+	}
+}
+
+// GET /opds
+// opdsRoot produces the OPDS navigation feed listing every project.
+func opdsRoot(db database.DB, publicUrl string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		projects, err := mListProjects(db, models.ProjectFilter{}, models.ListOptions{Sort: "name"})
+		if err != nil {
+			log.Println("[---] Listing error:", err)
+			encodeHelper(w, ErrRspListProjects)
+			return
+		}
+
+		base := baseUrl(r, publicUrl)
+		feed := atomFeed{
+			Xmlns:   atomNamespace,
+			Id:      base + "/opds",
+			Title:   opdsCatalogTitle,
+			Author:  atomAuthor{FeedAuthor},
+			Links:   opdsCatalogLinks(base, base+"/opds", opdsNavigationType),
+			Entries: []atomEntry{},
+		}
+		var updated time.Time
+		for _, p := range projects {
+			if p.CreatedAt.After(updated) {
+				updated = p.CreatedAt
+			}
+			link := opdsProjectUrl(base, p)
+			feed.Entries = append(feed.Entries, atomEntry{
+				Id:      link,
+				Title:   p.Name,
+				Updated: p.CreatedAt.UTC().Format(time.RFC3339),
+				Content: &atomContent{Type: "text", Value: p.Description},
+				Links:   []atomLink{{Rel: "subsection", Type: opdsAcquisitionType, Href: link}},
+			})
+		}
+		feed.Updated = updated.UTC().Format(time.RFC3339)
+		writeFeed(w, r, opdsNavigationType, feed, time.Time{})
+	}
+}
+
+// GET /opds/projects/{projectId}
+// opdsProject produces the OPDS acquisition feed of a project's released releases, newest first. Each release can
+// be acquired in every archive format, and its cover page (or first page, without one) serves as its cover.
+func opdsProject(db database.DB, publicUrl string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		project, err := fetchProjectUsingRequestArgs(db, w, r, true)
+		if err != nil {
+			log.Println("[---] Project fetch error:", err)
+			// response already set
+			return
+		}
+
+		filter := models.ReleaseFilter{Status: models.RStatusReleasedStr}
+		releases, err := mListReleases(db, project, filter, models.ListOptions{Sort: "releasedOn", Desc: true})
+		if err != nil {
+			log.Println("[---] Listing error:", err)
+			encodeHelper(w, ErrRspListReleases)
+			return
+		}
+
+		base := baseUrl(r, publicUrl)
+		self := opdsProjectUrl(base, project)
+		feed := atomFeed{
+			Xmlns:   atomNamespace,
+			Id:      self,
+			Title:   project.Name,
+			Updated: project.CreatedAt.UTC().Format(time.RFC3339),
+			Author:  atomAuthor{FeedAuthor},
+			Links:   opdsCatalogLinks(base, self, opdsAcquisitionType),
+			Entries: []atomEntry{},
+		}
+		if len(releases) > 0 {
+			feed.Updated = releases[0].ReleasedOn.UTC().Format(time.RFC3339)
+		}
+
+		covers, err := mListReleaseCovers(db, releases)
+		if err != nil {
+			log.Println("[---] Listing error:", err)
+			encodeHelper(w, ErrRspListPages)
+			return
+		}
+
+		for _, release := range releases {
+			pr := models.ProjectRelease{
+				Release:          release,
+				ProjectName:      project.Name,
+				ProjectShorthand: project.Shorthand,
+				ArchiveName:      mGenerateArchiveName(project, release),
+			}
+			released := release.ReleasedOn.UTC().Format(time.RFC3339)
+			entry := atomEntry{
+				Id:        releaseDownloadUrl(base, pr),
+				Title:     releaseTitle(pr),
+				Updated:   released,
+				Published: released,
+				Links:     []atomLink{},
+			}
+			for _, format := range archiveFormats {
+				pr.ArchiveName = format.archiveName(project, release)
+				entry.Links = append(entry.Links, atomLink{Rel: opdsRelAcquisition, Type: format.ContentType, Href: releaseDownloadUrl(base, pr)})
+			}
+
+			if cover, ok := covers[release.Id]; ok {
+				releaseUrl := fmt.Sprintf("%s/projects/%d/releases/%d", base, project.Id, release.Id)
+				name := url.PathEscape(cover.Name)
+				mimeType := cover.MimeType.String()
+				entry.Links = append(entry.Links,
+					atomLink{Rel: opdsRelImage, Type: mimeType, Href: releaseUrl + "/pages/" + name},
+					atomLink{Rel: opdsRelThumbnail, Type: mimeType, Href: releaseUrl + "/thumbnails/" + name})
+			}
+			feed.Entries = append(feed.Entries, entry)
+		}
+		writeFeed(w, r, opdsAcquisitionType, feed, time.Time{})
+	}
+}
@@ -0,0 +1,109 @@
+package endpoints
+
+import (
+	"encoding/xml"
+	"errors"
+	"github.com/gorilla/mux"
+	"ims-release/assert"
+	"ims-release/database"
+	"ims-release/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOpdsRoot(t *testing.T) {
+	tm := time.Date(2017, 3, 1, 10, 0, 0, 0, time.UTC)
+	mListProjects = func(db database.DB, filter models.ProjectFilter, opts models.ListOptions) ([]models.Project, error) {
+		assert.Equal(t, models.ListOptions{Sort: "name"}, opts)
+		return []models.Project{
+			{Id: 5, Name: "Project", Description: "desc", CreatedAt: tm},
+			{Id: 6, Name: "Other", CreatedAt: tm.Add(time.Hour)},
+		}, nil
+	}
+	router := mux.NewRouter()
+	RegisterOpdsHandlers(router, nil, "https://api.example.com")
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/opds", nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, opdsNavigationType, w.Header().Get("Content-Type"))
+
+	var feed atomFeed
+	err := xml.Unmarshal(w.Body.Bytes(), &feed)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "2017-03-01T11:00:00Z", feed.Updated)
+	assert.Equal(t, "start", feed.Links[1].Rel)
+	assert.Equal(t, 2, len(feed.Entries))
+	assert.Equal(t, "Project", feed.Entries[0].Title)
+	assert.Equal(t, "desc", feed.Entries[0].Content.Value)
+	assert.Equal(t, atomLink{Rel: "subsection", Type: opdsAcquisitionType, Href: "https://api.example.com/opds/projects/5"}, feed.Entries[0].Links[0])
+
+	mListProjects = func(db database.DB, filter models.ProjectFilter, opts models.ListOptions) ([]models.Project, error) {
+		return []models.Project{}, errors.New("some error")
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestOpdsProject(t *testing.T) {
+	mFindProject = func(db database.DB, id uint32) (models.Project, error) {
+		return models.Project{Id: id, Name: "Project", Shorthand: "proj"}, nil
+	}
+	mListReleases = func(db database.DB, p models.Project, filter models.ReleaseFilter, opts models.ListOptions) ([]models.Release, error) {
+		assert.Equal(t, models.ReleaseFilter{Status: models.RStatusReleasedStr}, filter)
+		assert.Equal(t, models.ListOptions{Sort: "releasedOn", Desc: true}, opts)
+		return []models.Release{feedReleases[0].Release, feedReleases[1].Release}, nil
+	}
+	mListReleaseCovers = func(db database.DB, releases []models.Release) (map[uint32]models.Page, error) {
+		assert.Equal(t, 2, len(releases))
+		return map[uint32]models.Page{7: {Id: 1, Name: "01 cover.jpg", MimeType: models.MimeTypeJpg, ReleaseID: 7}}, nil
+	}
+	mGenerateArchiveName = models.GenerateArchiveName
+	router := mux.NewRouter()
+	RegisterOpdsHandlers(router, nil, "https://api.example.com")
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/opds/projects/5", nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, opdsAcquisitionType, w.Header().Get("Content-Type"))
+
+	var feed atomFeed
+	err := xml.Unmarshal(w.Body.Bytes(), &feed)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "Project", feed.Title)
+	assert.Equal(t, "2017-03-02T10:00:00Z", feed.Updated)
+	assert.Equal(t, 2, len(feed.Entries))
+
+	links := feed.Entries[0].Links
+	assert.Equal(t, 2+len(archiveFormats), len(links))
+	assert.Equal(t, atomLink{Rel: opdsRelAcquisition, Type: "application/zip",
+		Href: "https://api.example.com/projects/5/releases/7/download/proj%20-%20c12%5B2%5D%5Bims%5D.zip"}, links[0])
+	assert.Equal(t, atomLink{Rel: opdsRelAcquisition, Type: "application/vnd.comicbook+zip",
+		Href: "https://api.example.com/projects/5/releases/7/download/proj%20-%20c12%5B2%5D%5Bims%5D.cbz"}, links[1])
+	assert.Equal(t, atomLink{Rel: opdsRelImage, Type: "image/jpeg",
+		Href: "https://api.example.com/projects/5/releases/7/pages/01%20cover.jpg"}, links[4])
+	assert.Equal(t, atomLink{Rel: opdsRelThumbnail, Type: "image/jpeg",
+		Href: "https://api.example.com/projects/5/releases/7/thumbnails/01%20cover.jpg"}, links[5])
+
+	// releases without pages have no cover
+	assert.Equal(t, len(archiveFormats), len(feed.Entries[1].Links))
+
+	mListReleaseCovers = func(db database.DB, releases []models.Release) (map[uint32]models.Page, error) {
+		return map[uint32]models.Page{}, errors.New("some error")
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	mListReleases = func(db database.DB, p models.Project, filter models.ReleaseFilter, opts models.ListOptions) ([]models.Release, error) {
+		return []models.Release{}, errors.New("some error")
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
@@ -5,17 +5,27 @@ import (
 	"ims-release/models"
 	"ims-release/storage_provider"
 
+	"archive/zip"
 	"bytes"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"image"
+	"image/gif"
 	"image/jpeg"
 	"image/png"
+	"io"
+	"io/ioutil"
 	"log"
+	"mime"
 	"net/http"
+	"path"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+	"golang.org/x/image/webp"
 )
 
 var (
@@ -25,12 +35,13 @@ var (
 	mSavePage       = models.SavePage
 	mUpdatePage     = models.UpdatePage
 	mDeletePage     = models.DeletePage
+	mReorderPages   = models.ReorderPages
 )
 
 var (
 	ErrMsgListPages    = "Could not list pages. Please try again later."
 	ErrRspListPages    = NewApiResponse(http.StatusInternalServerError, &ErrMsgListPages)
-	ErrMsgWrongType    = "The uploaded image is neither a valid JPG/JPEG or PNG image."
+	ErrMsgWrongType    = "The uploaded image is not a valid JPG/JPEG, PNG, WebP or GIF image."
 	ErrRspWrongType    = NewApiResponse(http.StatusExpectationFailed, &ErrMsgWrongType)
 	ErrMsgBadImageData = "The supplied image data is not base64 encoded."
 	ErrRspBadImageData = NewApiResponse(http.StatusExpectationFailed, &ErrMsgBadImageData)
@@ -40,6 +51,77 @@ var (
 	ErrRspDeletePage   = NewApiResponse(http.StatusInternalServerError, &ErrMsgDeletePage)
 	ErrMsgMustBeDraft  = "Action only allowed when release is in draft state."
 	ErrRspMustBeDraft  = NewApiResponse(http.StatusExpectationFailed, &ErrMsgMustBeDraft)
+	ErrMsgTypeMismatch = "The type of the uploaded image does not match the extension of its name."
+	ErrRspTypeMismatch = NewApiResponse(http.StatusExpectationFailed, &ErrMsgTypeMismatch)
+	ErrMsgReadUpload   = "The uploaded data could not be read."
+	ErrRspReadUpload   = NewApiResponse(http.StatusBadRequest, &ErrMsgReadUpload)
+	ErrMsgNoPageFiles  = "The upload does not contain any files."
+	ErrRspNoPageFiles  = NewApiResponse(http.StatusExpectationFailed, &ErrMsgNoPageFiles)
+	ErrMsgNoPageName   = "The name of the page must be given in the path or in the " + PageNameHeader + " header."
+	ErrRspNoPageName   = NewApiResponse(http.StatusExpectationFailed, &ErrMsgNoPageName)
+	ErrMsgBadPageName  = "The name of the page must not contain path separators."
+	ErrRspBadPageName  = NewApiResponse(http.StatusExpectationFailed, &ErrMsgBadPageName)
+	ErrMsgOnePageFile  = "The upload must contain exactly one file."
+	ErrRspOnePageFile  = NewApiResponse(http.StatusExpectationFailed, &ErrMsgOnePageFile)
+	ErrMsgBadArchive   = "The uploaded archive is not a valid zip file."
+	ErrRspBadArchive   = NewApiResponse(http.StatusExpectationFailed, &ErrMsgBadArchive)
+	ErrMsgArchiveDirs  = "The uploaded archive must not contain any folders."
+	ErrRspArchiveDirs  = NewApiResponse(http.StatusExpectationFailed, &ErrMsgArchiveDirs)
+	ErrMsgDupPageName  = "The upload contains several pages with the same name."
+	ErrRspDupPageName  = NewApiResponse(http.StatusExpectationFailed, &ErrMsgDupPageName)
+	ErrMsgBadPageOrder = "The order must list every page of the release exactly once."
+	ErrRspBadPageOrder = NewApiResponse(http.StatusExpectationFailed, &ErrMsgBadPageOrder)
+	ErrMsgReorderPages = "Could not reorder the pages. Please try again later."
+	ErrRspReorderPages = NewApiResponse(http.StatusInternalServerError, &ErrMsgReorderPages)
+	ErrMsgUpdatePage   = "Could not update the requested page. Please try again later."
+	ErrRspUpdatePage   = NewApiResponse(http.StatusInternalServerError, &ErrMsgUpdatePage)
+	ErrMsgPageExists   = "A page with that name already exists."
+	ErrRspPageExists   = NewApiResponse(http.StatusExpectationFailed, &ErrMsgPageExists)
+	ErrMsgBadPageRole  = "The role must be one of content, credit, cover, recruitment or colour."
+	ErrRspBadPageRole  = NewApiResponse(http.StatusExpectationFailed, &ErrMsgBadPageRole)
+)
+
+// PageNameHeader carries the name of a page uploaded as a raw image body.
+const PageNameHeader = "X-Page-Name"
+
+// Size limits for uploaded pages. MaxPageSize applies to the image of every page, MaxPageRequestSize to the whole
+// body of a request uploading pages, and MaxArchivePagesSize to the combined size of the pages in an archive.
+const (
+	MaxPageSize         = 32 << 20
+	MaxPageRequestSize  = 256 << 20
+	MaxArchivePagesSize = 1 << 30
+)
+
+// TypeMismatchPolicy decides what happens to an uploaded page whose image type does not match the extension of
+// its name.
+type TypeMismatchPolicy uint32
+
+const (
+	TMismatchUnknown   TypeMismatchPolicy = 0
+	TMismatchReject    TypeMismatchPolicy = 1
+	TMismatchRejectStr string             = "reject"
+	TMismatchRename    TypeMismatchPolicy = 2
+	TMismatchRenameStr string             = "rename"
+)
+
+var ErrInvalidTypeMismatchPolicy = errors.New("Invalid page type mismatch policy.")
+
+func NewTypeMismatchPolicy(val string) TypeMismatchPolicy {
+	switch val {
+	case TMismatchRejectStr:
+		return TMismatchReject
+	case TMismatchRenameStr:
+		return TMismatchRename
+	default:
+		return TMismatchUnknown
+	}
+}
+
+// Cache-Control policies for page images. The pages of a draft may still be replaced, so caches have to revalidate
+// them on every use.
+const (
+	CacheControlDraft    = "no-cache"
+	CacheControlReleased = "public, max-age=86400"
 )
 
 type PageResponse struct {
@@ -54,17 +136,22 @@ func NewPageResponse(a ApiResponse, r []models.Page) PageResponse {
 // RegisterPageHandlers attaches the closures generated by each function defined below
 // to handle incoming requests to the appropriate endpoint using a subrouter with an
 // appropriate prefix, specified in main.
-func RegisterPageHandlers(r *mux.Router, db database.DB, sp storage_provider.Binary) {
+func RegisterPageHandlers(r *mux.Router, db database.DB, sp storage_provider.Binary, mismatch TypeMismatchPolicy) {
 	root := "/projects/{projectId:[0-9]+}/releases/{releaseId:[0-9]+}/pages"
 	sr := r.PathPrefix(root).Subrouter()
 	r.HandleFunc(root, listPages(db)).Methods("GET")
-	r.HandleFunc(root, createPage(db, sp)).Methods("POST")
+	r.HandleFunc(root, createPage(db, sp, mismatch)).Methods("POST")
+	sr.HandleFunc("/archive", uploadArchive(db, sp, mismatch)).Methods("POST")
+	sr.HandleFunc("/order", reorderPages(db)).Methods("PUT")
+	sr.HandleFunc("/{name}", createPage(db, sp, mismatch)).Methods("POST")
+	sr.HandleFunc("/{pageId:[0-9]+}", updatePage(db, sp, mismatch)).Methods("PUT")
+	sr.HandleFunc("/{pageId:[0-9]+}/role", setPageRole(db)).Methods("PUT")
 	sr.HandleFunc("/{pageId:[0-9]+}", deletePage(db, sp)).Methods("DELETE")
 	sr.HandleFunc("/{name}", getPage(db, sp)).Methods("GET")
 }
 
 // GET /projects/{projectId}/releases/{releaseId}/pages
-// listPages lists descriptive information about
+// listPages lists descriptive information about the pages of a release, sorted and paginated.
 func listPages(db database.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		_, release, err := fetchReleaseUsingRequestArgs(db, w, r, true)
@@ -73,14 +160,32 @@ func listPages(db database.DB) http.HandlerFunc {
 			// response already set
 			return
 		}
+		if release.Status == models.RStatusRetractedStr {
+			encodeHelper(w, NewPageResponse(ErrRspReleaseRetracted, []models.Page{}))
+			return
+		}
 
-		pages, err := mListPages(db, release)
+		opts, err := parseListOptions(r)
 		if err != nil {
+			encodeHelper(w, NewPageResponse(ErrRspBadRequest, []models.Page{}))
+			return
+		}
+
+		total, err := mCountPages(db, release)
+		pages := []models.Page{}
+		if err == nil {
+			pages, err = mListPages(db, release, opts)
+		}
+		if isListParamError(err) {
+			log.Println("[---] List error:", err)
+			encodeHelper(w, NewPageResponse(ErrRspBadRequest, []models.Page{}))
+			return
+		} else if err != nil {
 			log.Println("[---] List error:", err)
 			encodeHelper(w, NewPageResponse(ErrRspListPages, []models.Page{}))
 			return
 		}
-		encodeHelper(w, NewPageResponse(NoErr, pages))
+		encodeHelper(w, NewPageResponse(NoErr.withPaging(total, opts), pages))
 	}
 }
 
@@ -89,9 +194,25 @@ type PageCreateReq struct {
 	ImageData string `json:"data"`
 }
 
+// sniffMimeType determines the type of an image from its content.
+func sniffMimeType(data []byte) models.MimeType {
+	return models.NewMimeType(http.DetectContentType(data))
+}
+
+// renameForMimeType replaces the extension of a page name with the one used for a type. Names without a known
+// extension keep it and have the extension appended.
+func renameForMimeType(name string, mimeType models.MimeType) string {
+	ext := path.Ext(name)
+	if models.MimeTypeFromFilename(strings.ToLower(ext)) != models.MimeTypeUnknown {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return name + mimeType.Extension()
+}
+
 // POST /projects/{projectId}/releases/{releaseId}/pages
-// createPage inserts a new page into the DB and saves page data to a file.
-func createPage(db database.DB, sp storage_provider.Binary) http.HandlerFunc {
+// POST /projects/{projectId}/releases/{releaseId}/pages/{name}
+// createPage inserts new pages into the DB and saves page data to files.
+func createPage(db database.DB, sp storage_provider.Binary, mismatch TypeMismatchPolicy) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		project, release, err := fetchReleaseUsingRequestArgs(db, w, r, true)
 		if err != nil {
@@ -106,61 +227,496 @@ func createPage(db database.DB, sp storage_provider.Binary) http.HandlerFunc {
 			return
 		}
 
-		request := PageCreateReq{}
-		err = decodeHelper(r, &request)
-		if err != nil {
-			encodeHelper(w, NewPageResponse(ErrRspJsonDecode, []models.Page{}))
+		uploads, rsp := readPageUploads(w, r, true)
+		if rsp != NoErr {
+			encodeHelper(w, NewPageResponse(rsp, []models.Page{}))
 			return
 		}
 
-		imageData, err := base64.StdEncoding.DecodeString(request.ImageData)
+		// every image is checked before any of them is stored
+		now := time.Now()
+		pages := make([]models.Page, 0, len(uploads))
+		for _, upload := range uploads {
+			page, rsp := checkPageUpload(release, upload, mismatch, now)
+			if rsp != NoErr {
+				encodeHelper(w, NewPageResponse(rsp, []models.Page{}))
+				return
+			}
+			pages = append(pages, page)
+		}
+		rsp = checkPageNames(db, release, pages)
+		if rsp != NoErr {
+			encodeHelper(w, NewPageResponse(rsp, []models.Page{}))
+			return
+		}
+
+		for i := range pages {
+			pages[i], rsp = storePage(db, sp, project, release, pages[i], uploads[i].Data)
+			if rsp != NoErr {
+				// the pages stored so far are kept, and listed in the response
+				encodeHelper(w, NewPageResponse(rsp, pages[:i]))
+				return
+			}
+		}
+		encodeHelper(w, NewPageResponse(NoErr, pages))
+	}
+}
+
+// POST /projects/{projectId}/releases/{releaseId}/pages/archive
+// uploadArchive adds every page in a zip or cbz archive to a release. Either all pages are added or none are.
+func uploadArchive(db database.DB, sp storage_provider.Binary, mismatch TypeMismatchPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		project, release, err := fetchReleaseUsingRequestArgs(db, w, r, true)
 		if err != nil {
-			log.Println("[---] Image decode error:", err)
-			encodeHelper(w, NewPageResponse(ErrRspBadImageData, []models.Page{}))
+			log.Println("[---] Release fetch error:", err)
+			// response already set
 			return
 		}
-		log.Println("[+++] Successfully decoded image data")
 
-		page := mNewPage(release, request.Name, time.Now())
-		mimeType := page.MimeType
-		switch mimeType {
-		case models.MimeTypePng:
-			_, err = png.Decode(bytes.NewReader(imageData))
-		case models.MimeTypeJpg:
-			_, err = jpeg.Decode(bytes.NewReader(imageData))
-		case models.MimeTypeUnknown:
-			fallthrough
-		default:
-			err = errors.New("bad extension")
+		if release.Status != models.RStatusDraftStr {
+			log.Println("[---] Invalid state:", ErrMsgMustBeDraft)
+			encodeHelper(w, NewPageResponse(ErrRspMustBeDraft, []models.Page{}))
+			return
 		}
 
+		defer r.Body.Close()
+		data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MaxPageRequestSize))
 		if err != nil {
-			// The image is neither a valid JPG/JPEG nor a valid PNG image.
-			log.Printf("[---] Uploaded error: %v\n", err)
-			encodeHelper(w, NewPageResponse(ErrRspWrongType, []models.Page{}))
+			log.Println("[---] Read error:", err)
+			encodeHelper(w, NewPageResponse(readErrorResponse(err), []models.Page{}))
 			return
 		}
 
-		filePath := mGeneratePagePath(project, release, page.Name)
+		uploads, rsp := readArchive(data)
+		if rsp != NoErr {
+			encodeHelper(w, NewPageResponse(rsp, []models.Page{}))
+			return
+		}
 
-		log.Printf("[+++] Computed filename %s\n", filePath)
-		err = sp.Set(filePath, imageData)
+		pages, rsp := storeArchivePages(db, sp, mismatch, project, release, uploads)
+		encodeHelper(w, NewPageResponse(rsp, pages))
+	}
+}
+
+// storeArchivePages adds the pages of an archive to a release. Every page is checked before any of them is stored,
+// and the images are removed again unless every page is inserted.
+func storeArchivePages(db database.DB, sp storage_provider.Binary, mismatch TypeMismatchPolicy, project models.Project, release models.Release, uploads []pageUpload) ([]models.Page, ApiResponse) {
+	now := time.Now()
+	pages := make([]models.Page, 0, len(uploads))
+	for _, upload := range uploads {
+		page, rsp := checkPageUpload(release, upload, mismatch, now)
+		if rsp != NoErr {
+			log.Println("[---] Archive entry rejected:", upload.Name)
+			return []models.Page{}, rsp
+		}
+		pages = append(pages, page)
+	}
+	rsp := checkPageNames(db, release, pages)
+	if rsp != NoErr {
+		return []models.Page{}, rsp
+	}
+
+	stored := []string{}
+	removeStored := func() {
+		for _, key := range stored {
+			sp.Unset(key)
+		}
+	}
+	for i, page := range pages {
+		filePath := mGeneratePagePath(project, release, page.Name)
+		err := sp.Set(filePath, uploads[i].Data)
 		if err != nil {
 			log.Println("[---] Save error:", err)
-			encodeHelper(w, NewPageResponse(ErrRspCreatePage, []models.Page{}))
+			removeStored()
+			return []models.Page{}, ErrRspCreatePage
+		}
+		stored = append(stored, filePath)
+	}
+	log.Printf("[+++] Successfully saved %d images to disk\n", len(stored))
+
+	pages, err := insertPages(db, release, pages)
+	if err != nil {
+		log.Println("[---] Insert error:", err)
+		removeStored()
+		return []models.Page{}, ErrRspCreatePage
+	}
+
+	for i, page := range pages {
+		generateThumbnails(sp, project, release, page, uploads[i].Data)
+	}
+	return pages, NoErr
+}
+
+// insertPages inserts new pages into a release, all of them or none. The new pages are placed among the existing
+// ones in natural order of their names.
+func insertPages(db database.DB, release models.Release, pages []models.Page) ([]models.Page, error) {
+	err := database.Transaction(db, func(tx database.DB) error {
+		existing, err := mListPages(tx, release, models.ListOptions{})
+		if err != nil {
+			return err
+		}
+		for i := range pages {
+			pages[i], err = mSavePage(tx, pages[i])
+			if err != nil {
+				return err
+			}
+		}
+
+		order := placeNewPages(existing, pages)
+		ids := make([]uint32, len(order))
+		for i, page := range order {
+			ids[i] = page.Id
+		}
+		err = mReorderPages(tx, release, ids)
+		if err != nil {
+			return err
+		}
+		for i := range pages {
+			for _, page := range order {
+				if page.Id == pages[i].Id {
+					pages[i].SortIndex = page.SortIndex
+				}
+			}
+		}
+		return nil
+	})
+	return pages, err
+}
+
+// placeNewPages merges new pages into the order of the existing pages of a release. Each new page goes right after
+// the last existing page whose name sorts before it in natural order, so that an order set by hand is kept. The
+// pages are numbered in the resulting order.
+func placeNewPages(existing []models.Page, added []models.Page) []models.Page {
+	sorted := make([]models.Page, len(added))
+	copy(sorted, added)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return models.NaturalLess(sorted[i].Name, sorted[j].Name)
+	})
+
+	// after[i] holds the new pages following existing page i, with the pages going first at index 0
+	after := make([][]models.Page, len(existing)+1)
+	for _, page := range sorted {
+		position := 0
+		for i, other := range existing {
+			if models.NaturalLess(other.Name, page.Name) {
+				position = i + 1
+			}
+		}
+		after[position] = append(after[position], page)
+	}
+
+	order := make([]models.Page, 0, len(existing)+len(sorted))
+	order = append(order, after[0]...)
+	for i, page := range existing {
+		order = append(order, page)
+		order = append(order, after[i+1]...)
+	}
+
+	for i := range order {
+		order[i].SortIndex = uint32(i)
+	}
+	return order
+}
+
+// PageOrderReq lists the ids of all pages of a release in their new order.
+type PageOrderReq struct {
+	Ids []uint32 `json:"ids"`
+}
+
+// PUT /projects/{projectId}/releases/{releaseId}/pages/order
+// reorderPages sets the order in which the pages of a release are listed and archived.
+func reorderPages(db database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, release, err := fetchReleaseUsingRequestArgs(db, w, r, true)
+		if err != nil {
+			log.Println("[---] Release fetch error:", err)
+			// response already set
+			return
+		}
+
+		if release.Status != models.RStatusDraftStr {
+			log.Println("[---] Invalid state:", ErrMsgMustBeDraft)
+			encodeHelper(w, NewPageResponse(ErrRspMustBeDraft, []models.Page{}))
 			return
 		}
-		log.Println("[+++] Successfully saved image to disk")
 
-		page, err = mSavePage(db, page)
+		request := PageOrderReq{}
+		err = decodeHelper(r, &request)
 		if err != nil {
-			log.Println("[---] Insert error:", err)
-			encodeHelper(w, NewPageResponse(ErrRspCreatePage, []models.Page{}))
-			sp.Unset(filePath)
+			encodeHelper(w, NewPageResponse(ErrRspJsonDecode, []models.Page{}))
 			return
 		}
-		encodeHelper(w, NewPageResponse(NoErr, []models.Page{page}))
+
+		pages, err := mListPages(db, release, models.ListOptions{})
+		if err != nil {
+			log.Println("[---] List error:", err)
+			encodeHelper(w, NewPageResponse(ErrRspListPages, []models.Page{}))
+			return
+		}
+
+		byId := map[uint32]models.Page{}
+		for _, page := range pages {
+			byId[page.Id] = page
+		}
+		ordered := []models.Page{}
+		for i, id := range request.Ids {
+			page, ok := byId[id]
+			if !ok {
+				break
+			}
+			delete(byId, id)
+			page.SortIndex = uint32(i)
+			ordered = append(ordered, page)
+		}
+		if len(ordered) != len(request.Ids) || len(ordered) != len(pages) {
+			log.Println("[---] Invalid page order:", request.Ids)
+			encodeHelper(w, NewPageResponse(ErrRspBadPageOrder, []models.Page{}))
+			return
+		}
+
+		err = mReorderPages(db, release, request.Ids)
+		if err != nil {
+			log.Println("[---] Reorder error:", err)
+			encodeHelper(w, NewPageResponse(ErrRspReorderPages, []models.Page{}))
+			return
+		}
+		encodeHelper(w, NewPageResponse(NoErr, ordered))
+	}
+}
+
+// readArchive reads the images in an uploaded zip archive. A ComicInfo.xml file, as found in cbz archives, is
+// skipped. Archives containing folders are rejected, as are archives whose pages go past the size limits.
+func readArchive(data []byte) ([]pageUpload, ApiResponse) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		log.Println("[---] Archive error:", err)
+		return nil, ErrRspBadArchive
+	}
+
+	uploads := []pageUpload{}
+	var total uint64
+	for _, f := range archive.File {
+		if f.FileInfo().IsDir() || strings.ContainsAny(f.Name, "/\\") {
+			log.Println("[---] Archive error: folder", f.Name)
+			return nil, ErrRspArchiveDirs
+		}
+		if strings.EqualFold(f.Name, "ComicInfo.xml") {
+			continue
+		}
+		total += f.UncompressedSize64
+		if f.UncompressedSize64 > MaxPageSize || total > MaxArchivePagesSize {
+			log.Println("[---] Archive error: too large at", f.Name)
+			return nil, ErrRspUploadTooLarge
+		}
+		rc, err := f.Open()
+		if err != nil {
+			log.Println("[---] Archive error:", err)
+			return nil, ErrRspBadArchive
+		}
+		data, err := ioutil.ReadAll(io.LimitReader(rc, MaxPageSize+1))
+		rc.Close()
+		if err != nil {
+			log.Println("[---] Archive error:", err)
+			return nil, ErrRspBadArchive
+		}
+		if len(data) > MaxPageSize {
+			log.Println("[---] Archive error: too large at", f.Name)
+			return nil, ErrRspUploadTooLarge
+		}
+		uploads = append(uploads, pageUpload{f.Name, data})
+	}
+	if len(uploads) == 0 {
+		return nil, ErrRspNoPageFiles
+	}
+	return uploads, NoErr
+}
+
+// pageUpload is an image uploaded as a page, along with the name it was uploaded under.
+type pageUpload struct {
+	Name string
+	Data []byte
+}
+
+// readPageUploads reads the images sent in a page upload request. The body is either a JSON PageCreateReq, a
+// multipart/form-data form with one or more files, or a single raw image whose name is given in the path or in the
+// PageNameHeader header. A raw image without a name is only accepted if nameRequired is not set.
+func readPageUploads(w http.ResponseWriter, r *http.Request, nameRequired bool) ([]pageUpload, ApiResponse) {
+	r.Body = http.MaxBytesReader(w, r.Body, MaxPageRequestSize)
+	contentType := r.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if contentType == "" || mediaType == "application/json" {
+		request := PageCreateReq{}
+		err = decodeHelper(r, &request)
+		if err != nil {
+			return nil, ErrRspJsonDecode
+		}
+		imageData, err := base64.StdEncoding.DecodeString(request.ImageData)
+		if err != nil {
+			log.Println("[---] Image decode error:", err)
+			return nil, ErrRspBadImageData
+		}
+		if len(imageData) > MaxPageSize {
+			return nil, ErrRspUploadTooLarge
+		}
+		log.Println("[+++] Successfully decoded image data")
+		return []pageUpload{{request.Name, imageData}}, NoErr
 	}
+	defer r.Body.Close()
+
+	if mediaType == "multipart/form-data" {
+		reader, err := r.MultipartReader()
+		if err != nil {
+			log.Println("[---] Multipart error:", err)
+			return nil, ErrRspReadUpload
+		}
+		uploads := []pageUpload{}
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				log.Println("[---] Multipart error:", err)
+				return nil, readErrorResponse(err)
+			}
+			if part.FileName() == "" {
+				// not a file
+				continue
+			}
+			data, rsp := readPageData(part)
+			if rsp != NoErr {
+				return nil, rsp
+			}
+			uploads = append(uploads, pageUpload{part.FileName(), data})
+		}
+		if len(uploads) == 0 {
+			return nil, ErrRspNoPageFiles
+		}
+		return uploads, NoErr
+	}
+
+	name := mux.Vars(r)["name"]
+	if name == "" {
+		name = r.Header.Get(PageNameHeader)
+	}
+	if name == "" && nameRequired {
+		return nil, ErrRspNoPageName
+	}
+	data, rsp := readPageData(r.Body)
+	if rsp != NoErr {
+		return nil, rsp
+	}
+	return []pageUpload{{name, data}}, NoErr
+}
+
+// readPageData reads the image of an uploaded page, which may be at most MaxPageSize bytes long.
+func readPageData(rd io.Reader) ([]byte, ApiResponse) {
+	data, err := ioutil.ReadAll(io.LimitReader(rd, MaxPageSize+1))
+	if err != nil {
+		log.Println("[---] Read error:", err)
+		return nil, readErrorResponse(err)
+	}
+	if len(data) > MaxPageSize {
+		log.Println("[---] Read error: page larger than", MaxPageSize)
+		return nil, ErrRspUploadTooLarge
+	}
+	return data, NoErr
+}
+
+// readErrorResponse picks the response for an error reading an upload, which is ErrRspUploadTooLarge once the body
+// goes past the limit set with http.MaxBytesReader.
+func readErrorResponse(err error) ApiResponse {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return ErrRspUploadTooLarge
+	}
+	return ErrRspReadUpload
+}
+
+// checkPageNames ensures that new pages can be stored under their names, which have to be unique within the upload
+// and must not be taken by a page of the release already.
+func checkPageNames(db database.DB, release models.Release, pages []models.Page) ApiResponse {
+	existing, err := mListPages(db, release, models.ListOptions{})
+	if err != nil {
+		log.Println("[---] List error:", err)
+		return ErrRspListPages
+	}
+	taken := map[string]bool{}
+	for _, page := range existing {
+		taken[page.Name] = true
+	}
+
+	names := map[string]bool{}
+	for _, page := range pages {
+		if names[page.Name] {
+			log.Println("[---] Duplicate page name:", page.Name)
+			return ErrRspDupPageName
+		}
+		if taken[page.Name] {
+			log.Println("[---] Page exists:", page.Name)
+			return ErrRspPageExists
+		}
+		names[page.Name] = true
+	}
+	return NoErr
+}
+
+// checkPageUpload builds the page for an uploaded image. The type of the image is detected from its content and has
+// to match the extension of its name, unless the mismatch policy allows renaming it.
+func checkPageUpload(release models.Release, upload pageUpload, mismatch TypeMismatchPolicy, now time.Time) (models.Page, ApiResponse) {
+	page := mNewPage(release, upload.Name, now)
+	// the name becomes part of the storage key of the image
+	if strings.ContainsAny(page.Name, "/\\") || page.Name == "." || page.Name == ".." {
+		log.Println("[---] Uploaded error: invalid name", page.Name)
+		return page, ErrRspBadPageName
+	}
+	mimeType := sniffMimeType(upload.Data)
+	if mimeType == models.MimeTypeUnknown {
+		log.Println("[---] Uploaded error: unsupported image type", http.DetectContentType(upload.Data))
+		return page, ErrRspWrongType
+	}
+	if mimeType != page.MimeType {
+		if mismatch != TMismatchRename {
+			log.Printf("[---] Uploaded error: %s is a %s image\n", page.Name, mimeType)
+			return page, ErrRspTypeMismatch
+		}
+		name := renameForMimeType(page.Name, mimeType)
+		log.Printf("[+++] Renamed %s to %s to match its type\n", page.Name, name)
+		page.Name = name
+		page.MimeType = mimeType
+	}
+
+	_, err := decodePageImage(page.MimeType, upload.Data)
+	if err != nil {
+		// The image is not a valid image of the type given by its extension.
+		log.Printf("[---] Uploaded error: %v\n", err)
+		return page, ErrRspWrongType
+	}
+	return page, NoErr
+}
+
+// storePage saves the image of a checked page and inserts the page, then generates its thumbnails.
+func storePage(db database.DB, sp storage_provider.Binary, p models.Project, r models.Release, page models.Page, data []byte) (models.Page, ApiResponse) {
+	filePath := mGeneratePagePath(p, r, page.Name)
+
+	log.Printf("[+++] Computed filename %s\n", filePath)
+	err := sp.Set(filePath, data)
+	if err != nil {
+		log.Println("[---] Save error:", err)
+		return page, ErrRspCreatePage
+	}
+	log.Println("[+++] Successfully saved image to disk")
+
+	inserted, err := insertPages(db, r, []models.Page{page})
+	if err != nil {
+		log.Println("[---] Insert error:", err)
+		sp.Unset(filePath)
+		return page, ErrRspCreatePage
+	}
+	page = inserted[0]
+
+	generateThumbnails(sp, p, r, page, data)
+	return page, NoErr
 }
 
 func getPage(db database.DB, sp storage_provider.Binary) http.HandlerFunc {
@@ -171,6 +727,10 @@ func getPage(db database.DB, sp storage_provider.Binary) http.HandlerFunc {
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
+		if release.Status == models.RStatusRetractedStr {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
 
 		vars := mux.Vars(r)
 		page, err := mFindPageByName(db, release, vars["name"])
@@ -187,9 +747,13 @@ func getPage(db database.DB, sp storage_provider.Binary) http.HandlerFunc {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		w.WriteHeader(http.StatusOK)
-		w.Header().Set("Content-Type", page.MimeType.String())
-		w.Write(imageBytes)
+
+		contentType, data := negotiateImage(w, r, sp, path, page.MimeType.String(), imageBytes)
+		if pageNotModified(w, r, release, page, data) {
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		http.ServeContent(w, r, page.Name, page.CreatedAt, bytes.NewReader(data))
 	}
 }
 
@@ -220,6 +784,180 @@ func fetchPageUsingRequestArgs(db database.DB, w http.ResponseWriter, r *http.Re
 	return project, release, page, nil
 }
 
+// readPageUpdate reads the new name and image data of a page, sent in any of the formats accepted by
+// readPageUploads. Either may be left empty to keep the current one.
+func readPageUpdate(w http.ResponseWriter, r *http.Request) (string, []byte, ApiResponse) {
+	uploads, rsp := readPageUploads(w, r, false)
+	if rsp != NoErr {
+		return "", nil, rsp
+	}
+	if len(uploads) != 1 {
+		log.Println("[---] Page update error:", len(uploads), "files")
+		return "", nil, ErrRspOnePageFile
+	}
+	return uploads[0].Name, uploads[0].Data, NoErr
+}
+
+// PUT /projects/{projectId}/releases/{releaseId}/pages/{pageId}
+// updatePage renames a page, moving its stored image, and/or replaces its image. The page keeps its id and its
+// position. The new name and image are checked the same way as those of new pages.
+func updatePage(db database.DB, sp storage_provider.Binary, mismatch TypeMismatchPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		project, release, page, err := fetchPageUsingRequestArgs(db, w, r, true)
+		if err != nil {
+			log.Println("[---] Page fetch error:", err)
+			// response already set
+			return
+		}
+
+		if release.Status != models.RStatusDraftStr {
+			log.Println("[---] Invalid state:", ErrMsgMustBeDraft)
+			encodeHelper(w, NewPageResponse(ErrRspMustBeDraft, []models.Page{}))
+			return
+		}
+
+		name, data, rsp := readPageUpdate(w, r)
+		if rsp != NoErr {
+			encodeHelper(w, NewPageResponse(rsp, []models.Page{}))
+			return
+		}
+		if name == "" {
+			name = page.Name
+		}
+
+		oldPath := mGeneratePagePath(project, release, page.Name)
+		previous, err := sp.Get(oldPath)
+		if err != nil {
+			log.Println("[---] Read error:", err)
+			encodeHelper(w, NewPageResponse(ErrRspUpdatePage, []models.Page{}))
+			return
+		}
+		replaced := len(data) > 0
+		if !replaced {
+			data = previous
+		}
+
+		updated, rsp := checkPageUpload(release, pageUpload{name, data}, mismatch, page.CreatedAt)
+		if rsp != NoErr {
+			encodeHelper(w, NewPageResponse(rsp, []models.Page{}))
+			return
+		}
+		updated.Id = page.Id
+		updated.SortIndex = page.SortIndex
+		updated.Role = page.Role
+		if replaced {
+			// the modification time of the page is used for caching
+			updated.CreatedAt = time.Now()
+		}
+
+		renamed := updated.Name != page.Name
+		if !renamed && !replaced {
+			encodeHelper(w, NewPageResponse(NoErr, []models.Page{page}))
+			return
+		}
+		if renamed {
+			_, err = mFindPageByName(db, release, updated.Name)
+			if err == nil {
+				log.Println("[---] Page exists:", updated.Name)
+				encodeHelper(w, NewPageResponse(ErrRspPageExists, []models.Page{}))
+				return
+			} else if err != models.ErrNoSuchPage {
+				log.Println("[---] Page fetch error:", err)
+				encodeHelper(w, NewPageResponse(ErrRspUpdatePage, []models.Page{}))
+				return
+			}
+		}
+
+		newPath := mGeneratePagePath(project, release, updated.Name)
+		if renamed {
+			err = sp.Set(newPath, data)
+		} else {
+			// the stored image has to make room for the new one
+			err = sp.Unset(oldPath)
+			if err == nil {
+				err = sp.Set(newPath, data)
+				if err != nil {
+					sp.Set(oldPath, previous)
+				}
+			}
+		}
+		if err != nil {
+			log.Println("[---] Save error:", err)
+			encodeHelper(w, NewPageResponse(ErrRspUpdatePage, []models.Page{}))
+			return
+		}
+
+		updated, err = mUpdatePage(db, updated)
+		if err != nil {
+			log.Println("[---] Update error:", err)
+			sp.Unset(newPath)
+			if !renamed {
+				sp.Set(oldPath, previous)
+			}
+			encodeHelper(w, NewPageResponse(ErrRspUpdatePage, []models.Page{}))
+			return
+		}
+
+		if renamed {
+			err = sp.Unset(oldPath)
+			if err != nil {
+				// this is for logging only, like for deleted pages
+				log.Println("[---] Page delete error:", err)
+			}
+		}
+		deleteVariants(sp, oldPath)
+		deleteThumbnails(sp, project, release, page)
+		generateThumbnails(sp, project, release, updated, data)
+
+		encodeHelper(w, NewPageResponse(NoErr, []models.Page{updated}))
+	}
+}
+
+// PageRoleReq carries the new role of a page.
+type PageRoleReq struct {
+	Role string `json:"role"`
+}
+
+// PUT /projects/{projectId}/releases/{releaseId}/pages/{pageId}/role
+// setPageRole marks a page as content, credit, cover, recruitment or colour page.
+func setPageRole(db database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, release, page, err := fetchPageUsingRequestArgs(db, w, r, true)
+		if err != nil {
+			log.Println("[---] Page fetch error:", err)
+			// response already set
+			return
+		}
+
+		if release.Status != models.RStatusDraftStr {
+			log.Println("[---] Invalid state:", ErrMsgMustBeDraft)
+			encodeHelper(w, NewPageResponse(ErrRspMustBeDraft, []models.Page{}))
+			return
+		}
+
+		request := PageRoleReq{}
+		err = decodeHelper(r, &request)
+		if err != nil {
+			encodeHelper(w, NewPageResponse(ErrRspJsonDecode, []models.Page{}))
+			return
+		}
+		if models.NewPageRole(request.Role) == models.PRoleUnknown {
+			log.Println("[---] Invalid page role:", request.Role)
+			encodeHelper(w, NewPageResponse(ErrRspBadPageRole, []models.Page{}))
+			return
+		}
+
+		page.Role = request.Role
+		page, err = mUpdatePage(db, page)
+		if err != nil {
+			log.Println("[---] Update error:", err)
+			encodeHelper(w, NewPageResponse(ErrRspUpdatePage, []models.Page{}))
+			return
+		}
+		encodeHelper(w, NewPageResponse(NoErr, []models.Page{page}))
+	}
+}
+
 // DELETE /projects/{projectId}/releases/{releaseId}/pages/{pageId}
 // deletePage removes a page from the DB and deletes the file containing the image.
 func deletePage(db database.DB, sp storage_provider.Binary) http.HandlerFunc {
@@ -254,7 +992,41 @@ func deletePage(db database.DB, sp storage_provider.Binary) http.HandlerFunc {
 			// an issue
 			log.Println("[---] Page delete error:", err)
 		}
+		deleteVariants(sp, filePath)
+		deleteThumbnails(sp, project, release, page)
 
 		encodeHelper(w, NewPageResponse(NoErr, []models.Page{page}))
 	}
 }
+
+func pageCacheControl(release models.Release) string {
+	if release.Status == models.RStatusReleasedStr {
+		return CacheControlReleased
+	}
+	return CacheControlDraft
+}
+
+// pageNotModified sets the caching headers for an image derived from a page, and reports whether a 304 response
+// has been written instead. The validators also let http.ServeContent evaluate If-Range for range requests. They
+// follow the hash of the current image data and the page's CreatedAt, both of which change when updatePage replaces
+// the image.
+func pageNotModified(w http.ResponseWriter, r *http.Request, release models.Release, page models.Page, data []byte) bool {
+	w.Header().Set("Cache-Control", pageCacheControl(release))
+	return notModified(w, r, hashETag(data), page.CreatedAt)
+}
+
+// decodePageImage decodes the image data of a page. Only the first frame of an animated GIF is decoded.
+func decodePageImage(mimeType models.MimeType, data []byte) (image.Image, error) {
+	switch mimeType {
+	case models.MimeTypePng:
+		return png.Decode(bytes.NewReader(data))
+	case models.MimeTypeJpg:
+		return jpeg.Decode(bytes.NewReader(data))
+	case models.MimeTypeWebp:
+		return webp.Decode(bytes.NewReader(data))
+	case models.MimeTypeGif:
+		return gif.Decode(bytes.NewReader(data))
+	default:
+		return nil, errors.New("bad extension")
+	}
+}
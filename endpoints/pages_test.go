@@ -1,22 +1,34 @@
 package endpoints
 
 import (
+	"archive/zip"
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/gorilla/mux"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
 	"ims-release/assert"
 	"ims-release/database"
 	"ims-release/models"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestListPages(t *testing.T) {
 	router := mux.NewRouter()
-	registerHandlers(router, nil, nil)
+	registerHandlers(router, nil, nil, TMismatchReject, DefaultLintConfig())
 	var resp PageResponse
 
 	// test fetch release error
@@ -41,12 +53,30 @@ func TestListPages(t *testing.T) {
 	assert.Equal(t, http.StatusNotFound, w.Code)
 	assert.Equal(t, 0, len(resp.Result))
 
-	// test list pages error
+	// test count pages error
 	mFindRelease = func(db database.DB, p models.Project, id uint32) (models.Release, error) {
 		return models.Release{Id: id, ProjectID: p.Id}, nil
 	}
 
-	mListPages = func(db database.DB, release models.Release) ([]models.Page, error) {
+	mCountPages = func(db database.DB, release models.Release) (uint32, error) {
+		return 0, errors.New("some error")
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/projects/12/releases/70/pages", nil)
+	router.ServeHTTP(w, r)
+	decoder = json.NewDecoder(w.Body)
+	decoder.Decode(&resp)
+
+	assert.Equal(t, ErrMsgListPages, resp.getError().Error())
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	// test list pages error
+	mCountPages = func(db database.DB, release models.Release) (uint32, error) {
+		return 1, nil
+	}
+
+	mListPages = func(db database.DB, release models.Release, opts models.ListOptions) ([]models.Page, error) {
 		return []models.Page{}, errors.New("some error")
 	}
 
@@ -61,7 +91,7 @@ func TestListPages(t *testing.T) {
 	assert.Equal(t, 0, len(resp.Result))
 
 	// test success case
-	mListPages = func(db database.DB, release models.Release) ([]models.Page, error) {
+	mListPages = func(db database.DB, release models.Release, opts models.ListOptions) ([]models.Page, error) {
 		return []models.Page{models.Page{Id: uint32(71)}}, nil
 	}
 
@@ -75,6 +105,37 @@ func TestListPages(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Equal(t, 1, len(resp.Result))
 	assert.Equal(t, uint32(71), resp.Result[0].Id)
+	assert.Equal(t, uint32(1), resp.Paging.Total)
+
+	// test list options are passed through
+	mListPages = func(db database.DB, release models.Release, opts models.ListOptions) ([]models.Page, error) {
+		assert.Equal(t, models.ListOptions{Limit: 10, Offset: 20, Sort: "createdAt", Desc: true}, opts)
+		return []models.Page{}, nil
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/projects/12/releases/70/pages?limit=10&offset=20&sort=createdAt&order=desc", nil)
+	router.ServeHTTP(w, r)
+	resp = PageResponse{}
+	decoder = json.NewDecoder(w.Body)
+	decoder.Decode(&resp)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, Paging{Total: 1, Limit: 10, Offset: 20}, *resp.Paging)
+
+	// test bad list options
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/projects/12/releases/70/pages?limit=0", nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	mListPages = func(db database.DB, release models.Release, opts models.ListOptions) ([]models.Page, error) {
+		return []models.Page{}, models.ErrInvalidSortField
+	}
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/projects/12/releases/70/pages?sort=bogus", nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
 }
 
 type SpTest struct {
@@ -107,10 +168,79 @@ func (sp SpTest) Exists(key string) bool {
 	return sp.IsExists
 }
 
+// SpMap is an in-memory storage provider, for tests which use more than one key.
+type SpMap map[string][]byte
+
+func (sp SpMap) Set(key string, data []byte) error {
+	if sp.Exists(key) {
+		return errors.New("key " + key + " already exists")
+	}
+	sp[key] = data
+	return nil
+}
+
+func (sp SpMap) Get(key string) ([]byte, error) {
+	data, ok := sp[key]
+	if !ok {
+		return []byte{}, errors.New("key " + key + " does not exist")
+	}
+	return data, nil
+}
+
+func (sp SpMap) Unset(key string) error {
+	delete(sp, key)
+	return nil
+}
+
+func (sp SpMap) Exists(key string) bool {
+	_, ok := sp[key]
+	return ok
+}
+
+// mockPageOrder lets pages be inserted into releases which have no pages yet.
+func mockPageOrder() {
+	mListPages = func(db database.DB, release models.Release, opts models.ListOptions) ([]models.Page, error) {
+		return []models.Page{}, nil
+	}
+	mReorderPages = func(db database.DB, release models.Release, ids []uint32) error {
+		return nil
+	}
+}
+
+// multipartRanges reads the parts of a multipart/byteranges response, as "Content-Range: body" strings.
+func multipartRanges(t *testing.T, w *httptest.ResponseRecorder) []string {
+	mediaType, params, err := mime.ParseMediaType(w.Result().Header.Get("Content-Type"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+
+	parts := []string{}
+	mr := multipart.NewReader(w.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		assert.Equal(t, nil, err)
+		body, err := ioutil.ReadAll(part)
+		assert.Equal(t, nil, err)
+		parts = append(parts, part.Header.Get("Content-Range")+": "+string(body))
+	}
+	return parts
+}
+
+// spUnsetError is an in-memory storage provider which fails to delete anything.
+type spUnsetError struct {
+	SpMap
+}
+
+func (sp spUnsetError) Unset(key string) error {
+	return errors.New("delete error")
+}
+
 func TestCreate(t *testing.T) {
 	router := mux.NewRouter()
 	var sp SpTest
-	registerHandlers(router, nil, sp)
+	registerHandlers(router, nil, sp, TMismatchReject, DefaultLintConfig())
 	var resp PageResponse
 
 	// test fetch release error
@@ -205,7 +335,7 @@ func TestCreate(t *testing.T) {
 	decoder = json.NewDecoder(w.Body)
 	decoder.Decode(&resp)
 
-	assert.Equal(t, ErrMsgWrongType, resp.getError().Error())
+	assert.Equal(t, ErrMsgTypeMismatch, resp.getError().Error())
 	assert.Equal(t, http.StatusExpectationFailed, w.Code)
 	assert.Equal(t, 0, len(resp.Result))
 
@@ -215,7 +345,7 @@ func TestCreate(t *testing.T) {
 	decoder = json.NewDecoder(w.Body)
 	decoder.Decode(&resp)
 
-	assert.Equal(t, ErrMsgWrongType, resp.getError().Error())
+	assert.Equal(t, ErrMsgTypeMismatch, resp.getError().Error())
 	assert.Equal(t, http.StatusExpectationFailed, w.Code)
 	assert.Equal(t, 0, len(resp.Result))
 
@@ -230,6 +360,7 @@ func TestCreate(t *testing.T) {
 	assert.Equal(t, 0, len(resp.Result))
 
 	// test save to disk error
+	mockPageOrder()
 	const dataJpg = `{"name":"fileName.jpg", "data":"` + bencJpg + `"}`
 	const dataPng = `{"name":"fileName.png", "data":"` + bencPng + `"}`
 	sp.Error = errors.New("some error")
@@ -237,7 +368,7 @@ func TestCreate(t *testing.T) {
 	sp.ExpectedKey = "12/70/fileName.jpg"
 	sp.ExpectedBytesBenc = bencJpg
 	router = mux.NewRouter()
-	registerHandlers(router, nil, sp)
+	registerHandlers(router, nil, sp, TMismatchReject, DefaultLintConfig())
 
 	w = httptest.NewRecorder()
 	r, _ = http.NewRequest("POST", "/projects/12/releases/70/pages", strings.NewReader(dataJpg))
@@ -258,7 +389,7 @@ func TestCreate(t *testing.T) {
 	sp.ExpectedKey = "12/70/fileName.png"
 	sp.ExpectedBytesBenc = bencPng
 	router = mux.NewRouter()
-	registerHandlers(router, nil, sp)
+	registerHandlers(router, nil, sp, TMismatchReject, DefaultLintConfig())
 
 	w = httptest.NewRecorder()
 	r, _ = http.NewRequest("POST", "/projects/12/releases/70/pages", strings.NewReader(dataPng))
@@ -273,8 +404,13 @@ func TestCreate(t *testing.T) {
 	// test success
 	mSavePage = func(db database.DB, page models.Page) (models.Page, error) {
 		assert.Equal(t, uint32(70), page.ReleaseID)
-		return models.Page{Id: uint32(100)}, nil
+		page.Id = uint32(100)
+		return page, nil
 	}
+	mGenerateThumbnailPath = models.GenerateThumbnailPath
+	spMap := SpMap{}
+	router = mux.NewRouter()
+	registerHandlers(router, nil, spMap, TMismatchReject, DefaultLintConfig())
 
 	w = httptest.NewRecorder()
 	r, _ = http.NewRequest("POST", "/projects/12/releases/70/pages", strings.NewReader(dataPng))
@@ -286,11 +422,18 @@ func TestCreate(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Equal(t, 1, len(resp.Result))
 	assert.Equal(t, uint32(100), resp.Result[0].Id)
+	assert.Equal(t, bencPng, base64.StdEncoding.EncodeToString(spMap["12/70/fileName.png"]))
+
+	// thumbnails are generated in every size
+	assert.Equal(t, 1+len(thumbnailSizes), len(spMap))
+	for name := range thumbnailSizes {
+		assert.Equal(t, true, spMap.Exists("thumbnails/12/70/"+name+"/fileName.png"))
+	}
 }
 
 func TestGetPage(t *testing.T) {
 	router := mux.NewRouter()
-	registerHandlers(router, nil, nil)
+	registerHandlers(router, nil, nil, TMismatchReject, DefaultLintConfig())
 	var resp PageResponse
 
 	// test release not found
@@ -336,7 +479,7 @@ func TestGetPage(t *testing.T) {
 	const bencPng = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAAAXNSR0IArs4c6QAAAARnQU1BAACxjwv8YQUAAAAJcEhZcwAADsQAAA7EAZUrDhsAAAANSURBVBhXY/j3/+9/AAnzA/pJMr8HAAAAAElFTkSuQmCC"
 	sp.Bytes, _ = base64.StdEncoding.DecodeString(bencPng)
 	router = mux.NewRouter()
-	registerHandlers(router, nil, sp)
+	registerHandlers(router, nil, sp, TMismatchReject, DefaultLintConfig())
 
 	mFindPageByName = func(db database.DB, release models.Release, name string) (models.Page, error) {
 		assert.Equal(t, uint32(70), release.Id)
@@ -354,14 +497,891 @@ func TestGetPage(t *testing.T) {
 	// test success
 	sp.Error = nil
 	router = mux.NewRouter()
-	registerHandlers(router, nil, sp)
+	registerHandlers(router, nil, sp, TMismatchReject, DefaultLintConfig())
 	w = httptest.NewRecorder()
 	r, _ = http.NewRequest("GET", "/projects/12/releases/70/pages/thePage.png", nil)
 	router.ServeHTTP(w, r)
-	decoder = json.NewDecoder(w.Body)
-	decoder.Decode(&resp)
 	assert.Equal(t, http.StatusOK, w.Code)
-	assert.Equal(t, "image/png", w.Header()["Content-Type"][0])
+	assert.Equal(t, "image/png", w.Result().Header.Get("Content-Type"))
+	assert.Equal(t, CacheControlDraft, w.Result().Header.Get("Cache-Control"))
+	etag := hashETag(sp.Bytes)
+	assert.Equal(t, etag, w.Result().Header.Get("ETag"))
+	assert.Equal(t, bencPng, base64.StdEncoding.EncodeToString(w.Body.Bytes()))
+
+	// test not modified (etag)
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/projects/12/releases/70/pages/thePage.png", nil)
+	r.Header.Set("If-None-Match", etag)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, 0, w.Body.Len())
+
+	// test not modified (date) for a released release
+	createdAt := time.Date(2017, 3, 2, 10, 0, 0, 0, time.UTC)
+	mFindRelease = func(db database.DB, p models.Project, id uint32) (models.Release, error) {
+		return models.Release{Id: id, ProjectID: p.Id, Status: "released"}, nil
+	}
+	mFindPageByName = func(db database.DB, release models.Release, name string) (models.Page, error) {
+		return models.Page{Name: name, Id: uint32(100), ReleaseID: release.Id, CreatedAt: createdAt, MimeType: models.MimeTypeFromFilename(name)}, nil
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/projects/12/releases/70/pages/thePage.png", nil)
+	r.Header.Set("If-Modified-Since", createdAt.Format(http.TimeFormat))
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, CacheControlReleased, w.Result().Header.Get("Cache-Control"))
+	assert.Equal(t, createdAt.Format(http.TimeFormat), w.Result().Header.Get("Last-Modified"))
+
+	// test modified since
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/projects/12/releases/70/pages/thePage.png", nil)
+	r.Header.Set("If-Modified-Since", createdAt.Add(-time.Hour).Format(http.TimeFormat))
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// test a stale etag takes precedence over the date
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/projects/12/releases/70/pages/thePage.png", nil)
+	r.Header.Set("If-None-Match", `"stale"`)
+	r.Header.Set("If-Modified-Since", createdAt.Format(http.TimeFormat))
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "bytes", w.Result().Header.Get("Accept-Ranges"))
+
+	// test single range
+	data := string(sp.Bytes)
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/projects/12/releases/70/pages/thePage.png", nil)
+	r.Header.Set("Range", "bytes=1-3")
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "image/png", w.Result().Header.Get("Content-Type"))
+	assert.Equal(t, fmt.Sprintf("bytes 1-3/%d", len(data)), w.Result().Header.Get("Content-Range"))
+	assert.Equal(t, "PNG", w.Body.String())
+
+	// test multiple ranges
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/projects/12/releases/70/pages/thePage.png", nil)
+	r.Header.Set("Range", "bytes=1-3,-4")
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	parts := multipartRanges(t, w)
+	assert.Equal(t, 2, len(parts))
+	assert.Equal(t, fmt.Sprintf("bytes 1-3/%d: PNG", len(data)), parts[0])
+	assert.Equal(t, fmt.Sprintf("bytes %d-%d/%d: %s", len(data)-4, len(data)-1, len(data), data[len(data)-4:]), parts[1])
+
+	// test a range is ignored if the page changed since the client's copy
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/projects/12/releases/70/pages/thePage.png", nil)
+	r.Header.Set("Range", "bytes=1-3")
+	r.Header.Set("If-Range", `"stale"`)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, data, w.Body.String())
+}
+
+func TestCreateRenameMismatch(t *testing.T) {
+	mFindProject = func(db database.DB, id uint32) (models.Project, error) {
+		return models.Project{Id: id}, nil
+	}
+	mFindRelease = func(db database.DB, p models.Project, id uint32) (models.Release, error) {
+		return models.Release{Id: id, ProjectID: p.Id, Status: "draft"}, nil
+	}
+	mNewPage = models.NewPage
+	mGeneratePagePath = models.GeneratePagePath
+	mGenerateThumbnailPath = models.GenerateThumbnailPath
+	var saved models.Page
+	mockPageOrder()
+	mSavePage = func(db database.DB, page models.Page) (models.Page, error) {
+		saved = page
+		return page, nil
+	}
+
+	sp := SpMap{}
+	router := mux.NewRouter()
+	registerHandlers(router, nil, sp, TMismatchRename, DefaultLintConfig())
+	var resp PageResponse
+
+	// test a png named .jpg is renamed
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/projects/12/releases/70/pages", strings.NewReader(`{"name":"p01.jpg", "data":"`+testPngBenc+`"}`))
+	router.ServeHTTP(w, r)
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, "p01.png", resp.Result[0].Name)
+	assert.Equal(t, "p01.png", saved.Name)
+	assert.Equal(t, models.MimeTypePng, saved.MimeType)
+	assert.Equal(t, testPngBenc, base64.StdEncoding.EncodeToString(sp["12/70/p01.png"]))
+
+	// test a name without extension gets one
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/projects/12/releases/70/pages", strings.NewReader(`{"name":"p02", "data":"`+testPngBenc+`"}`))
+	router.ServeHTTP(w, r)
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, "p02.png", saved.Name)
+
+	// test data which only looks like an image is still rejected
+	png, _ := base64.StdEncoding.DecodeString(testPngBenc)
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/projects/12/releases/70/pages", strings.NewReader(`{"name":"p03.png", "data":"`+
+		base64.StdEncoding.EncodeToString(png[:20])+`"}`))
+	router.ServeHTTP(w, r)
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, ErrMsgWrongType, resp.getError().Error())
+	assert.Equal(t, http.StatusExpectationFailed, w.Code)
+	assert.Equal(t, false, sp.Exists("12/70/p03.png"))
+}
+
+func TestCreateMultipart(t *testing.T) {
+	mFindProject = func(db database.DB, id uint32) (models.Project, error) {
+		return models.Project{Id: id}, nil
+	}
+	mFindRelease = func(db database.DB, p models.Project, id uint32) (models.Release, error) {
+		return models.Release{Id: id, ProjectID: p.Id, Status: "draft"}, nil
+	}
+	mNewPage = models.NewPage
+	mGeneratePagePath = models.GeneratePagePath
+	mGenerateThumbnailPath = models.GenerateThumbnailPath
+	saved := []string{}
+	mockPageOrder()
+	mSavePage = func(db database.DB, page models.Page) (models.Page, error) {
+		saved = append(saved, page.Name)
+		return page, nil
+	}
+
+	sp := SpMap{}
+	router := mux.NewRouter()
+	registerHandlers(router, nil, sp, TMismatchReject, DefaultLintConfig())
+	var resp PageResponse
+	png, _ := base64.StdEncoding.DecodeString(testPngBenc)
+
+	form := func(files map[string][]byte) (*bytes.Buffer, string) {
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		mw.WriteField("comment", "not a file")
+		for name, data := range files {
+			fw, _ := mw.CreateFormFile("files", name)
+			fw.Write(data)
+		}
+		mw.Close()
+		return body, mw.FormDataContentType()
+	}
+
+	// test a form without files
+	body, contentType := form(map[string][]byte{})
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/projects/12/releases/70/pages", body)
+	r.Header.Set("Content-Type", contentType)
+	router.ServeHTTP(w, r)
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, ErrMsgNoPageFiles, resp.getError().Error())
+	assert.Equal(t, http.StatusExpectationFailed, w.Code)
+
+	// test a single bad file rejects the whole upload
+	body, contentType = form(map[string][]byte{"p01.png": png, "p02.png": png[:20]})
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/projects/12/releases/70/pages", body)
+	r.Header.Set("Content-Type", contentType)
+	router.ServeHTTP(w, r)
+	resp = PageResponse{}
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, ErrMsgWrongType, resp.getError().Error())
+	assert.Equal(t, http.StatusExpectationFailed, w.Code)
+	assert.Equal(t, 0, len(saved))
+	assert.Equal(t, false, sp.Exists("12/70/p01.png"))
+
+	// test a file over the size limit rejects the whole upload
+	body, contentType = form(map[string][]byte{"p01.png": png, "p02.png": make([]byte, MaxPageSize+1)})
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/projects/12/releases/70/pages", body)
+	r.Header.Set("Content-Type", contentType)
+	router.ServeHTTP(w, r)
+	resp = PageResponse{}
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, ErrMsgUploadTooLarge, resp.getError().Error())
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, 0, len(saved))
+
+	// test files with the same name reject the whole upload
+	body = &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	for i := 0; i < 2; i++ {
+		fw, _ := mw.CreateFormFile("files", "p01.png")
+		fw.Write(png)
+	}
+	mw.Close()
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/projects/12/releases/70/pages", body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	router.ServeHTTP(w, r)
+	resp = PageResponse{}
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, ErrMsgDupPageName, resp.getError().Error())
+	assert.Equal(t, http.StatusExpectationFailed, w.Code)
+	assert.Equal(t, 0, len(sp))
+
+	// test a file named like an existing page rejects the whole upload
+	mListPages = func(db database.DB, release models.Release, opts models.ListOptions) ([]models.Page, error) {
+		return []models.Page{models.Page{Name: "p02.png"}}, nil
+	}
+	body, contentType = form(map[string][]byte{"p01.png": png, "p02.png": png})
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/projects/12/releases/70/pages", body)
+	r.Header.Set("Content-Type", contentType)
+	router.ServeHTTP(w, r)
+	resp = PageResponse{}
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, ErrMsgPageExists, resp.getError().Error())
+	assert.Equal(t, http.StatusExpectationFailed, w.Code)
+	assert.Equal(t, 0, len(sp))
+	mockPageOrder()
+
+	// test several files are stored
+	body, contentType = form(map[string][]byte{"p01.png": png, "p02.png": png})
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/projects/12/releases/70/pages", body)
+	r.Header.Set("Content-Type", contentType)
+	router.ServeHTTP(w, r)
+	resp = PageResponse{}
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 2, len(resp.Result))
+	assert.Equal(t, 2, len(saved))
+	assert.Equal(t, string(png), string(sp["12/70/p01.png"]))
+	assert.Equal(t, string(png), string(sp["12/70/p02.png"]))
+	assert.Equal(t, true, sp.Exists(models.GenerateThumbnailPath(models.Project{Id: 12}, models.Release{Id: 70}, DefaultThumbnailSize, "p02.png")))
+}
+
+func TestCreateRaw(t *testing.T) {
+	mFindProject = func(db database.DB, id uint32) (models.Project, error) {
+		return models.Project{Id: id}, nil
+	}
+	mFindRelease = func(db database.DB, p models.Project, id uint32) (models.Release, error) {
+		return models.Release{Id: id, ProjectID: p.Id, Status: "draft"}, nil
+	}
+	mNewPage = models.NewPage
+	mGeneratePagePath = models.GeneratePagePath
+	mGenerateThumbnailPath = models.GenerateThumbnailPath
+	var saved models.Page
+	mockPageOrder()
+	mSavePage = func(db database.DB, page models.Page) (models.Page, error) {
+		saved = page
+		return page, nil
+	}
+
+	sp := SpMap{}
+	router := mux.NewRouter()
+	registerHandlers(router, nil, sp, TMismatchReject, DefaultLintConfig())
+	var resp PageResponse
+	png, _ := base64.StdEncoding.DecodeString(testPngBenc)
+
+	// test a missing name
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/projects/12/releases/70/pages", bytes.NewReader(png))
+	r.Header.Set("Content-Type", "image/png")
+	router.ServeHTTP(w, r)
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, ErrMsgNoPageName, resp.getError().Error())
+	assert.Equal(t, http.StatusExpectationFailed, w.Code)
+
+	// test the name in the path
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/projects/12/releases/70/pages/p01.png", bytes.NewReader(png))
+	r.Header.Set("Content-Type", "image/png")
+	router.ServeHTTP(w, r)
+	resp = PageResponse{}
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, "p01.png", saved.Name)
+	assert.Equal(t, string(png), string(sp["12/70/p01.png"]))
+
+	// test the name in the header
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/projects/12/releases/70/pages", bytes.NewReader(png))
+	r.Header.Set("Content-Type", "application/octet-stream")
+	r.Header.Set(PageNameHeader, "p02.png")
+	router.ServeHTTP(w, r)
+	resp = PageResponse{}
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, "p02.png", resp.Result[0].Name)
+	assert.Equal(t, string(png), string(sp["12/70/p02.png"]))
+
+	// test the mismatch policy applies to raw uploads too
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/projects/12/releases/70/pages/p03.jpg", bytes.NewReader(png))
+	r.Header.Set("Content-Type", "image/jpeg")
+	router.ServeHTTP(w, r)
+	resp = PageResponse{}
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, ErrMsgTypeMismatch, resp.getError().Error())
+	assert.Equal(t, false, sp.Exists("12/70/p03.jpg"))
+
+	// test an image over the size limit
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/projects/12/releases/70/pages/p04.png", bytes.NewReader(make([]byte, MaxPageSize+1)))
+	r.Header.Set("Content-Type", "image/png")
+	router.ServeHTTP(w, r)
+	resp = PageResponse{}
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, ErrMsgUploadTooLarge, resp.getError().Error())
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, false, sp.Exists("12/70/p04.png"))
+
+	// test names which would leave the release's folder
+	for _, name := range []string{"../71/p05.png", "..", "sub\\p05.png"} {
+		w = httptest.NewRecorder()
+		r, _ = http.NewRequest("POST", "/projects/12/releases/70/pages", bytes.NewReader(png))
+		r.Header.Set("Content-Type", "image/png")
+		r.Header.Set(PageNameHeader, name)
+		router.ServeHTTP(w, r)
+		resp = PageResponse{}
+		json.NewDecoder(w.Body).Decode(&resp)
+
+		assert.Equal(t, ErrMsgBadPageName, resp.getError().Error())
+		assert.Equal(t, http.StatusExpectationFailed, w.Code)
+	}
+	assert.Equal(t, false, sp.Exists("12/71/p05.png"))
+}
+
+func TestUploadArchive(t *testing.T) {
+	mFindProject = func(db database.DB, id uint32) (models.Project, error) {
+		return models.Project{Id: id}, nil
+	}
+	mFindRelease = func(db database.DB, p models.Project, id uint32) (models.Release, error) {
+		return models.Release{Id: id, ProjectID: p.Id, Status: "draft"}, nil
+	}
+	mNewPage = models.NewPage
+	mGeneratePagePath = models.GeneratePagePath
+	mGenerateThumbnailPath = models.GenerateThumbnailPath
+	saved := []string{}
+	mockPageOrder()
+	mSavePage = func(db database.DB, page models.Page) (models.Page, error) {
+		saved = append(saved, page.Name)
+		return page, nil
+	}
+
+	sp := SpMap{}
+	router := mux.NewRouter()
+	registerHandlers(router, nil, sp, TMismatchReject, DefaultLintConfig())
+	png, _ := base64.StdEncoding.DecodeString(testPngBenc)
+
+	zipOf := func(names ...string) *bytes.Buffer {
+		body := &bytes.Buffer{}
+		zw := zip.NewWriter(body)
+		for _, name := range names {
+			fw, _ := zw.Create(name)
+			if !strings.HasSuffix(name, "/") {
+				fw.Write(png)
+			}
+		}
+		zw.Close()
+		return body
+	}
+	upload := func(body io.Reader) (*httptest.ResponseRecorder, PageResponse) {
+		var resp PageResponse
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/projects/12/releases/70/pages/archive", body)
+		r.Header.Set("Content-Type", "application/zip")
+		router.ServeHTTP(w, r)
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w, resp
+	}
+
+	// test data which is not a zip archive
+	w, resp := upload(bytes.NewReader(png))
+	assert.Equal(t, ErrMsgBadArchive, resp.getError().Error())
+	assert.Equal(t, http.StatusExpectationFailed, w.Code)
+
+	// test folders are rejected
+	w, resp = upload(zipOf("p01.png", "extra/"))
+	assert.Equal(t, ErrMsgArchiveDirs, resp.getError().Error())
+	assert.Equal(t, http.StatusExpectationFailed, w.Code)
+
+	w, resp = upload(zipOf("p01.png", "extra/p02.png"))
+	assert.Equal(t, ErrMsgArchiveDirs, resp.getError().Error())
+
+	// test an archive with a bad page adds nothing
+	w, resp = upload(zipOf("p01.png", "p02.jpg"))
+	assert.Equal(t, ErrMsgTypeMismatch, resp.getError().Error())
+	assert.Equal(t, 0, len(saved))
+	assert.Equal(t, 0, len(sp))
+
+	// test an archive without pages
+	w, resp = upload(zipOf("ComicInfo.xml"))
+	assert.Equal(t, ErrMsgNoPageFiles, resp.getError().Error())
+
+	// test an entry over the size limit is rejected before it is read
+	body := &bytes.Buffer{}
+	zw := zip.NewWriter(body)
+	fw, _ := zw.CreateRaw(&zip.FileHeader{Name: "p01.png", Method: zip.Store, CompressedSize64: uint64(len(png)), UncompressedSize64: MaxPageSize + 1})
+	fw.Write(png)
+	zw.Close()
+	w, resp = upload(body)
+	assert.Equal(t, ErrMsgUploadTooLarge, resp.getError().Error())
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, 0, len(sp))
+
+	// test a large entry which compresses well is rejected too
+	body = &bytes.Buffer{}
+	zw = zip.NewWriter(body)
+	fw, _ = zw.Create("p01.png")
+	fw.Write(make([]byte, MaxPageSize+1))
+	zw.Close()
+	w, resp = upload(body)
+	assert.Equal(t, ErrMsgUploadTooLarge, resp.getError().Error())
+	assert.Equal(t, 0, len(sp))
+
+	// test a failed insert removes the stored images
+	mSavePage = func(db database.DB, page models.Page) (models.Page, error) {
+		if page.Name == "p02.png" {
+			return page, errors.New("some error")
+		}
+		return page, nil
+	}
+	w, resp = upload(zipOf("p01.png", "p02.png"))
+	assert.Equal(t, ErrMsgCreatePage, resp.getError().Error())
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, 0, len(sp))
+
+	// test a cbz archive is added
+	mSavePage = func(db database.DB, page models.Page) (models.Page, error) {
+		saved = append(saved, page.Name)
+		return page, nil
+	}
+	w, resp = upload(zipOf("p01.png", "ComicInfo.xml", "p02.png"))
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 2, len(resp.Result))
+	assert.Equal(t, "p01.png,p02.png", strings.Join(saved, ","))
+	assert.Equal(t, string(png), string(sp["12/70/p02.png"]))
+	assert.Equal(t, true, sp.Exists(models.GenerateThumbnailPath(models.Project{Id: 12}, models.Release{Id: 70}, DefaultThumbnailSize, "p01.png")))
+
+	// test pages which exist already are not replaced
+	saved = []string{}
+	w, resp = upload(zipOf("p03.png", "p01.png"))
+	assert.Equal(t, ErrMsgCreatePage, resp.getError().Error())
+	assert.Equal(t, false, sp.Exists("12/70/p03.png"))
+	assert.Equal(t, true, sp.Exists("12/70/p01.png"))
+	assert.Equal(t, 0, len(saved))
+}
+
+func TestPlaceNewPages(t *testing.T) {
+	names := func(pages []models.Page) string {
+		result := []string{}
+		for _, page := range pages {
+			result = append(result, fmt.Sprintf("%s:%d", page.Name, page.SortIndex))
+		}
+		return strings.Join(result, ",")
+	}
+
+	// test new pages are sorted naturally
+	added := []models.Page{{Name: "10.png"}, {Name: "2.png"}, {Name: "1.png"}}
+	assert.Equal(t, "1.png:0,2.png:1,10.png:2", names(placeNewPages([]models.Page{}, added)))
+	assert.Equal(t, "10.png", added[0].Name)
+
+	// test new pages are merged into existing ones
+	existing := []models.Page{{Name: "1.png"}, {Name: "3.png"}, {Name: "20.png"}}
+	added = []models.Page{{Name: "4.png"}, {Name: "2.png"}, {Name: "30.png"}}
+	assert.Equal(t, "1.png:0,2.png:1,3.png:2,4.png:3,20.png:4,30.png:5", names(placeNewPages(existing, added)))
+
+	// test an order set by hand is kept
+	existing = []models.Page{{Name: "credits.png"}, {Name: "1.png"}, {Name: "3.png"}}
+	added = []models.Page{{Name: "2.png"}}
+	assert.Equal(t, "credits.png:0,1.png:1,2.png:2,3.png:3", names(placeNewPages(existing, added)))
+}
+
+func TestCreateOrder(t *testing.T) {
+	mFindProject = func(db database.DB, id uint32) (models.Project, error) {
+		return models.Project{Id: id}, nil
+	}
+	mFindRelease = func(db database.DB, p models.Project, id uint32) (models.Release, error) {
+		return models.Release{Id: id, ProjectID: p.Id, Status: "draft"}, nil
+	}
+	mNewPage = models.NewPage
+	mGeneratePagePath = models.GeneratePagePath
+	mGenerateThumbnailPath = models.GenerateThumbnailPath
+	mListPages = func(db database.DB, release models.Release, opts models.ListOptions) ([]models.Page, error) {
+		return []models.Page{{Id: 1, Name: "1.png", SortIndex: 0}, {Id: 2, Name: "10.png", SortIndex: 1}}, nil
+	}
+	mSavePage = func(db database.DB, page models.Page) (models.Page, error) {
+		page.Id = 3
+		return page, nil
+	}
+	var reordered []uint32
+	mReorderPages = func(db database.DB, release models.Release, ids []uint32) error {
+		reordered = ids
+		return nil
+	}
+
+	sp := SpMap{}
+	router := mux.NewRouter()
+	registerHandlers(router, nil, sp, TMismatchReject, DefaultLintConfig())
+	var resp PageResponse
+
+	// test a new page is placed in natural order
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/projects/12/releases/70/pages", strings.NewReader(`{"name":"2.png", "data":"`+testPngBenc+`"}`))
+	router.ServeHTTP(w, r)
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, uint32(1), resp.Result[0].SortIndex)
+	assert.Equal(t, "[1 3 2]", fmt.Sprint(reordered))
+
+	// test a failed reorder adds nothing
+	mReorderPages = func(db database.DB, release models.Release, ids []uint32) error {
+		return errors.New("some error")
+	}
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/projects/12/releases/70/pages", strings.NewReader(`{"name":"3.png", "data":"`+testPngBenc+`"}`))
+	router.ServeHTTP(w, r)
+	resp = PageResponse{}
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, ErrMsgCreatePage, resp.getError().Error())
+	assert.Equal(t, false, sp.Exists("12/70/3.png"))
+}
+
+func TestReorderPages(t *testing.T) {
+	mFindProject = func(db database.DB, id uint32) (models.Project, error) {
+		return models.Project{Id: id}, nil
+	}
+	release := models.Release{Id: 70, ProjectID: 12, Status: "draft"}
+	mFindRelease = func(db database.DB, p models.Project, id uint32) (models.Release, error) {
+		return release, nil
+	}
+	mListPages = func(db database.DB, release models.Release, opts models.ListOptions) ([]models.Page, error) {
+		return []models.Page{{Id: 1, Name: "1.png", SortIndex: 0}, {Id: 2, Name: "2.png", SortIndex: 1},
+			{Id: 3, Name: "3.png", SortIndex: 2}}, nil
+	}
+	var reordered []uint32
+	mReorderPages = func(db database.DB, release models.Release, ids []uint32) error {
+		reordered = ids
+		return nil
+	}
+
+	router := mux.NewRouter()
+	registerHandlers(router, nil, nil, TMismatchReject, DefaultLintConfig())
+	put := func(body string) (*httptest.ResponseRecorder, PageResponse) {
+		var resp PageResponse
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("PUT", "/projects/12/releases/70/pages/order", strings.NewReader(body))
+		router.ServeHTTP(w, r)
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w, resp
+	}
+
+	// test orders which do not list every page once
+	for _, body := range []string{`{"ids":[3,1]}`, `{"ids":[3,1,2,4]}`, `{"ids":[3,1,1]}`, `{"ids":[3,1,4]}`} {
+		w, resp := put(body)
+		assert.Equal(t, ErrMsgBadPageOrder, resp.getError().Error())
+		assert.Equal(t, http.StatusExpectationFailed, w.Code)
+	}
+	assert.Equal(t, 0, len(reordered))
+
+	// test a decode error
+	w, resp := put(`{"ids":"3,1,2"}`)
+	assert.Equal(t, ErrMsgJsonDecode, resp.getError().Error())
+
+	// test reordering
+	w, resp = put(`{"ids":[3,1,2]}`)
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "[3 1 2]", fmt.Sprint(reordered))
+	assert.Equal(t, 3, len(resp.Result))
+	assert.Equal(t, "3.png", resp.Result[0].Name)
+	assert.Equal(t, uint32(0), resp.Result[0].SortIndex)
+	assert.Equal(t, uint32(2), resp.Result[2].SortIndex)
+
+	// test a reorder error
+	mReorderPages = func(db database.DB, release models.Release, ids []uint32) error {
+		return errors.New("some error")
+	}
+	w, resp = put(`{"ids":[3,1,2]}`)
+	assert.Equal(t, ErrMsgReorderPages, resp.getError().Error())
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	// test released releases cannot be reordered
+	release.Status = "released"
+	w, resp = put(`{"ids":[3,1,2]}`)
+	assert.Equal(t, ErrMsgMustBeDraft, resp.getError().Error())
+}
+
+func TestUpdatePage(t *testing.T) {
+	mFindProject = func(db database.DB, id uint32) (models.Project, error) {
+		return models.Project{Id: id}, nil
+	}
+	release := models.Release{Id: 70, ProjectID: 12, Status: "draft"}
+	mFindRelease = func(db database.DB, p models.Project, id uint32) (models.Release, error) {
+		return release, nil
+	}
+	mNewPage = models.NewPage
+	mGeneratePagePath = models.GeneratePagePath
+	mGenerateThumbnailPath = models.GenerateThumbnailPath
+	mGenerateVariantPath = models.GenerateVariantPath
+	tm := time.Now().Add(-time.Hour)
+	current := models.Page{Id: 5, Name: "p01.png", CreatedAt: tm, ReleaseID: 70, MimeType: models.MimeTypePng, SortIndex: 3, Role: models.PRoleCreditStr}
+	mFindPage = func(db database.DB, r models.Release, id uint32) (models.Page, error) {
+		assert.Equal(t, uint32(5), id)
+		return current, nil
+	}
+	mFindPageByName = func(db database.DB, r models.Release, name string) (models.Page, error) {
+		if name == "taken.png" {
+			return models.Page{Id: 6, Name: name}, nil
+		}
+		return models.Page{}, models.ErrNoSuchPage
+	}
+	mUpdatePage = func(db database.DB, page models.Page) (models.Page, error) {
+		current = page
+		return page, nil
+	}
+
+	original, _ := base64.StdEncoding.DecodeString(testPngBenc)
+	var buffer bytes.Buffer
+	jpeg.Encode(&buffer, image.NewRGBA(image.Rect(0, 0, 4, 4)), nil)
+	jpg := buffer.Bytes()
+	buffer = bytes.Buffer{}
+	png.Encode(&buffer, image.NewRGBA(image.Rect(0, 0, 2, 2)))
+	replacement := buffer.Bytes()
+
+	sp := SpMap{}
+	sp["12/70/p01.png"] = original
+	project := models.Project{Id: 12}
+	generateThumbnails(sp, project, release, current, original)
+	thumbnail := models.GenerateThumbnailPath(project, release, DefaultThumbnailSize, "p01.png")
+	sp[models.GenerateVariantPath("12/70/p01.png", ".webp")] = []byte("webp")
+
+	router := mux.NewRouter()
+	registerHandlers(router, nil, sp, TMismatchReject, DefaultLintConfig())
+	put := func(body io.Reader, contentType string) (*httptest.ResponseRecorder, PageResponse) {
+		var resp PageResponse
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("PUT", "/projects/12/releases/70/pages/5", body)
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		router.ServeHTTP(w, r)
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w, resp
+	}
+
+	// test renaming to a name which is taken
+	w, resp := put(strings.NewReader(`{"name":"taken.png"}`), "")
+	assert.Equal(t, ErrMsgPageExists, resp.getError().Error())
+	assert.Equal(t, http.StatusExpectationFailed, w.Code)
+
+	// test renaming to a name of another type
+	w, resp = put(strings.NewReader(`{"name":"p02.jpg"}`), "")
+	assert.Equal(t, ErrMsgTypeMismatch, resp.getError().Error())
+	assert.Equal(t, true, sp.Exists("12/70/p01.png"))
+
+	// test renaming moves the image and its thumbnails
+	w, resp = put(strings.NewReader(`{"name":"p02.png"}`), "application/json")
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, uint32(5), resp.Result[0].Id)
+	assert.Equal(t, "p02.png", resp.Result[0].Name)
+	assert.Equal(t, uint32(3), resp.Result[0].SortIndex)
+	assert.Equal(t, models.PRoleCreditStr, resp.Result[0].Role)
+	assert.Equal(t, true, tm.Equal(current.CreatedAt))
+	assert.Equal(t, false, sp.Exists("12/70/p01.png"))
+	assert.Equal(t, string(original), string(sp["12/70/p02.png"]))
+	assert.Equal(t, false, sp.Exists(thumbnail))
+	assert.Equal(t, false, sp.Exists(models.GenerateVariantPath("12/70/p01.png", ".webp")))
+	assert.Equal(t, true, sp.Exists(models.GenerateThumbnailPath(project, release, DefaultThumbnailSize, "p02.png")))
+
+	// test replacing the image with one of another type
+	w, resp = put(bytes.NewReader(jpg), "image/jpeg")
+	assert.Equal(t, ErrMsgTypeMismatch, resp.getError().Error())
+	assert.Equal(t, string(original), string(sp["12/70/p02.png"]))
+
+	// test a failed update keeps the old image
+	mUpdatePage = func(db database.DB, page models.Page) (models.Page, error) {
+		return page, errors.New("some error")
+	}
+	w, resp = put(bytes.NewReader(replacement), "image/png")
+	assert.Equal(t, ErrMsgUpdatePage, resp.getError().Error())
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, string(original), string(sp["12/70/p02.png"]))
+
+	// test replacing the image in place
+	mUpdatePage = func(db database.DB, page models.Page) (models.Page, error) {
+		current = page
+		return page, nil
+	}
+	oldThumbnail := string(sp[models.GenerateThumbnailPath(project, release, DefaultThumbnailSize, "p02.png")])
+	w, resp = put(bytes.NewReader(replacement), "image/png")
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, "p02.png", resp.Result[0].Name)
+	assert.Equal(t, true, current.CreatedAt.After(tm))
+	assert.Equal(t, string(replacement), string(sp["12/70/p02.png"]))
+	assert.NotEqual(t, oldThumbnail, string(sp[models.GenerateThumbnailPath(project, release, DefaultThumbnailSize, "p02.png")]))
+
+	// test renaming and replacing at once, with the name in the header
+	w = httptest.NewRecorder()
+	r, _ := http.NewRequest("PUT", "/projects/12/releases/70/pages/5", bytes.NewReader(original))
+	r.Header.Set("Content-Type", "image/png")
+	r.Header.Set(PageNameHeader, "p03.png")
+	router.ServeHTTP(w, r)
+	resp = PageResponse{}
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, "p03.png", current.Name)
+	assert.Equal(t, false, sp.Exists("12/70/p02.png"))
+	assert.Equal(t, string(original), string(sp["12/70/p03.png"]))
+
+	// test replacing the image with a form
+	form := func(files ...string) (*bytes.Buffer, string) {
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		for _, name := range files {
+			fw, _ := mw.CreateFormFile("files", name)
+			fw.Write(replacement)
+		}
+		mw.Close()
+		return body, mw.FormDataContentType()
+	}
+	w, resp = put(form("p04.png", "p05.png"))
+	assert.Equal(t, ErrMsgOnePageFile, resp.getError().Error())
+	assert.Equal(t, http.StatusExpectationFailed, w.Code)
+	assert.Equal(t, "p03.png", current.Name)
+
+	w, resp = put(form("p04.png"))
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, "p04.png", current.Name)
+	assert.Equal(t, string(replacement), string(sp["12/70/p04.png"]))
+	assert.Equal(t, false, sp.Exists("12/70/p03.png"))
+
+	// test a failed lookup of the new name
+	mFindPageByName = func(db database.DB, r models.Release, name string) (models.Page, error) {
+		return models.Page{}, errors.New("some error")
+	}
+	w, resp = put(strings.NewReader(`{"name":"p05.png"}`), "")
+	assert.Equal(t, ErrMsgUpdatePage, resp.getError().Error())
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "p04.png", current.Name)
+	assert.Equal(t, true, sp.Exists("12/70/p04.png"))
+
+	// test released releases cannot be changed
+	release.Status = "released"
+	w, resp = put(strings.NewReader(`{"name":"p06.png"}`), "")
+	assert.Equal(t, ErrMsgMustBeDraft, resp.getError().Error())
+	assert.Equal(t, "p04.png", current.Name)
+}
+
+func TestSetPageRole(t *testing.T) {
+	mFindProject = func(db database.DB, id uint32) (models.Project, error) {
+		return models.Project{Id: id}, nil
+	}
+	release := models.Release{Id: 70, ProjectID: 12, Status: "draft"}
+	mFindRelease = func(db database.DB, p models.Project, id uint32) (models.Release, error) {
+		return release, nil
+	}
+	current := models.Page{Id: 5, Name: "p01.png", ReleaseID: 70, MimeType: models.MimeTypePng, Role: models.PRoleContentStr}
+	mFindPage = func(db database.DB, r models.Release, id uint32) (models.Page, error) {
+		return current, nil
+	}
+	mUpdatePage = func(db database.DB, page models.Page) (models.Page, error) {
+		current = page
+		return page, nil
+	}
+
+	router := mux.NewRouter()
+	registerHandlers(router, nil, SpMap{}, TMismatchReject, DefaultLintConfig())
+	put := func(body string) (*httptest.ResponseRecorder, PageResponse) {
+		var resp PageResponse
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("PUT", "/projects/12/releases/70/pages/5/role", strings.NewReader(body))
+		router.ServeHTTP(w, r)
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w, resp
+	}
+
+	// test an unknown role
+	w, resp := put(`{"role":"bla"}`)
+	assert.Equal(t, ErrMsgBadPageRole, resp.getError().Error())
+	assert.Equal(t, http.StatusExpectationFailed, w.Code)
+	assert.Equal(t, models.PRoleContentStr, current.Role)
+
+	// test marking a credit page
+	w, resp = put(`{"role":"credit"}`)
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, models.PRoleCreditStr, resp.Result[0].Role)
+	assert.Equal(t, models.PRoleCreditStr, current.Role)
+
+	// test update error
+	mUpdatePage = func(db database.DB, page models.Page) (models.Page, error) {
+		return page, errors.New("some error")
+	}
+	w, resp = put(`{"role":"cover"}`)
+	assert.Equal(t, ErrMsgUpdatePage, resp.getError().Error())
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	// test released releases cannot be changed
+	release.Status = "released"
+	w, resp = put(`{"role":"cover"}`)
+	assert.Equal(t, ErrMsgMustBeDraft, resp.getError().Error())
+	assert.Equal(t, models.PRoleCreditStr, current.Role)
+}
+
+func TestRenameForMimeType(t *testing.T) {
+	assert.Equal(t, "p01.png", renameForMimeType("p01.jpg", models.MimeTypePng))
+	assert.Equal(t, "p01.jpg", renameForMimeType("p01.JPEG", models.MimeTypeJpg))
+	assert.Equal(t, "p01.webp", renameForMimeType("p01", models.MimeTypeWebp))
+	assert.Equal(t, "vol.1.gif", renameForMimeType("vol.1", models.MimeTypeGif))
+}
+
+func TestDecodePageImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	var pngData, jpgData, webpData, gifData bytes.Buffer
+	png.Encode(&pngData, img)
+	jpeg.Encode(&jpgData, img, nil)
+	encodeWebp(&webpData, img)
+	gif.Encode(&gifData, img, nil)
+	images := map[models.MimeType][]byte{
+		models.MimeTypePng:  pngData.Bytes(),
+		models.MimeTypeJpg:  jpgData.Bytes(),
+		models.MimeTypeWebp: webpData.Bytes(),
+		models.MimeTypeGif:  gifData.Bytes(),
+	}
+
+	for mimeType, data := range images {
+		assert.Equal(t, mimeType, sniffMimeType(data))
+		decoded, err := decodePageImage(mimeType, data)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 3, decoded.Bounds().Dx())
+
+		// the data has to match the type given by the extension
+		for otherType, otherData := range images {
+			if otherType != mimeType {
+				_, err = decodePageImage(mimeType, otherData)
+				assert.NotEqual(t, nil, err)
+			}
+		}
+	}
+
+	_, err := decodePageImage(models.MimeTypeUnknown, pngData.Bytes())
+	assert.NotEqual(t, nil, err)
 }
 
 func TestBadDeletePageRequest(t *testing.T) {
@@ -395,7 +1415,7 @@ func TestBadDeletePageRequest(t *testing.T) {
 
 func TestDeletePage(t *testing.T) {
 	router := mux.NewRouter()
-	registerHandlers(router, nil, nil)
+	registerHandlers(router, nil, nil, TMismatchReject, DefaultLintConfig())
 	var resp PageResponse
 
 	// test fetch release error
@@ -480,13 +1500,11 @@ func TestDeletePage(t *testing.T) {
 	assert.Equal(t, 0, len(resp.Result))
 
 	// test success
-	var sp SpTest
-	sp.Testing = t
-	sp.ExpectedKey = "12/70/somePage.png"
+	sp := spUnsetError{SpMap{"12/70/somePage.png": []byte{1}, "thumbnails/12/70/grid/somePage.png": []byte{2}}}
+	mGenerateThumbnailPath = models.GenerateThumbnailPath
 	// confirm that still succeeds even if page deletion fails (just exercises the code path)
-	sp.Error = errors.New("delete error")
 	router = mux.NewRouter()
-	registerHandlers(router, nil, sp)
+	registerHandlers(router, nil, sp, TMismatchReject, DefaultLintConfig())
 
 	mDeletePage = func(db database.DB, page models.Page) (models.Page, error) {
 		assert.Equal(t, uint32(100), page.Id)
@@ -504,4 +1522,23 @@ func TestDeletePage(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Equal(t, 1, len(resp.Result))
 	assert.Equal(t, uint32(100), resp.Result[0].Id)
+
+	// test the page image and its thumbnails are removed
+	mGenerateVariantPath = models.GenerateVariantPath
+	spMap := SpMap{
+		"12/70/somePage.png":                               []byte{1},
+		"thumbnails/12/70/grid/somePage.png":               []byte{2},
+		"variants/12/70/somePage.png.webp":                 []byte{3},
+		"variants/thumbnails/12/70/grid/somePage.png.webp": []byte{4},
+		"12/70/otherPage.png":                              []byte{5},
+	}
+	router = mux.NewRouter()
+	registerHandlers(router, nil, spMap, TMismatchReject, DefaultLintConfig())
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("DELETE", "/projects/12/releases/70/pages/100", nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, len(spMap))
+	assert.Equal(t, true, spMap.Exists("12/70/otherPage.png"))
 }
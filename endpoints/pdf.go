@@ -0,0 +1,155 @@
+package endpoints
+
+import (
+	"ims-release/models"
+
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+)
+
+// pdfWriter writes the objects of a PDF document, keeping track of their offsets for the cross-reference table.
+type pdfWriter struct {
+	buf     bytes.Buffer
+	offsets []int
+}
+
+// reserve allocates an object number for an object which will be written later.
+func (w *pdfWriter) reserve() int {
+	w.offsets = append(w.offsets, 0)
+	return len(w.offsets)
+}
+
+func (w *pdfWriter) writeObject(id int, dict string, stream []byte) {
+	w.offsets[id-1] = w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n%s\n", id, dict)
+	if stream != nil {
+		w.buf.WriteString("stream\n")
+		w.buf.Write(stream)
+		w.buf.WriteString("\nendstream\n")
+	}
+	w.buf.WriteString("endobj\n")
+}
+
+func (w *pdfWriter) finish(root, info int) []byte {
+	xref := w.buf.Len()
+	fmt.Fprintf(&w.buf, "xref\n0 %d\n0000000000 65535 f \n", len(w.offsets)+1)
+	for _, offset := range w.offsets {
+		fmt.Fprintf(&w.buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&w.buf, "trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n",
+		len(w.offsets)+1, root, info, xref)
+	return w.buf.Bytes()
+}
+
+// pdfString encodes a text string as a PDF literal string. Characters outside of ASCII are replaced, since the
+// standard fonts do not matter for document metadata but a valid encoding does.
+func pdfString(s string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, c := range s {
+		switch {
+		case c == '(' || c == ')' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(c)
+		case c < 32 || c > 126:
+			b.WriteByte('?')
+		default:
+			b.WriteRune(c)
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// pdfImage produces the image XObject dictionary and stream for a page. JPEG images are embedded as they are, while
+// anything else is decoded and stored as compressed RGB samples.
+func pdfImage(page archivePage) (string, []byte, int, int, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(page.Data))
+	if err != nil {
+		return "", nil, 0, 0, fmt.Errorf("failed to decode %s: %v", page.Name, err)
+	}
+
+	if format == "jpeg" && (cfg.ColorModel == color.YCbCrModel || cfg.ColorModel == color.GrayModel) {
+		colorSpace := "/DeviceRGB"
+		if cfg.ColorModel == color.GrayModel {
+			colorSpace = "/DeviceGray"
+		}
+		dict := fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8 /Filter /DCTDecode /Length %d >>",
+			cfg.Width, cfg.Height, colorSpace, len(page.Data))
+		return dict, page.Data, cfg.Width, cfg.Height, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(page.Data))
+	if err != nil {
+		return "", nil, 0, 0, fmt.Errorf("failed to decode %s: %v", page.Name, err)
+	}
+	bounds := img.Bounds()
+	// transparent areas end up white, as they would on paper
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Over)
+
+	var samples bytes.Buffer
+	zw := zlib.NewWriter(&samples)
+	row := make([]byte, 0, 3*bounds.Dx())
+	for y := 0; y < bounds.Dy(); y++ {
+		row = row[:0]
+		for x := 0; x < bounds.Dx(); x++ {
+			i := rgba.PixOffset(x, y)
+			row = append(row, rgba.Pix[i], rgba.Pix[i+1], rgba.Pix[i+2])
+		}
+		zw.Write(row)
+	}
+	err = zw.Close()
+	if err != nil {
+		return "", nil, 0, 0, err
+	}
+
+	dict := fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode /Length %d >>",
+		bounds.Dx(), bounds.Dy(), samples.Len())
+	return dict, samples.Bytes(), bounds.Dx(), bounds.Dy(), nil
+}
+
+// buildPdfArchive renders a release as a PDF document with one page per image, each page sized to its image.
+func buildPdfArchive(p models.Project, r models.Release, pages []archivePage) ([]byte, error) {
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("release %d has no pages", r.Id)
+	}
+
+	w := &pdfWriter{}
+	w.buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	catalog := w.reserve()
+	pageTree := w.reserve()
+	info := w.reserve()
+
+	kids := []string{}
+	for _, page := range pages {
+		dict, stream, width, height, err := pdfImage(page)
+		if err != nil {
+			return nil, err
+		}
+		imageId := w.reserve()
+		w.writeObject(imageId, dict, stream)
+
+		content := []byte(fmt.Sprintf("q %d 0 0 %d 0 0 cm /Im0 Do Q", width, height))
+		contentId := w.reserve()
+		w.writeObject(contentId, fmt.Sprintf("<< /Length %d >>", len(content)), content)
+
+		pageId := w.reserve()
+		w.writeObject(pageId, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %d %d] /Resources << /XObject << /Im0 %d 0 R >> >> /Contents %d 0 R >>",
+			pageTree, width, height, imageId, contentId), nil)
+		kids = append(kids, fmt.Sprintf("%d 0 R", pageId))
+	}
+
+	w.writeObject(pageTree, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids)), nil)
+	// manga is read right to left
+	w.writeObject(catalog, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R /ViewerPreferences << /Direction /R2L >> >>", pageTree), nil)
+	w.writeObject(info, fmt.Sprintf("<< /Title %s /Author %s /Producer (ims-release) /CreationDate (D:%s) >>",
+		pdfString(fmt.Sprintf("%s %s", p.Name, r.Identifier)), pdfString(r.Scanlator), r.ReleasedOn.UTC().Format("20060102150405Z")), nil)
+	return w.finish(catalog, info), nil
+}
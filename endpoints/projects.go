@@ -14,9 +14,11 @@ import (
 
 var (
 	mListProjects  = models.ListProjects
+	mCountProjects = models.CountProjects
 	mNewProject    = models.NewProject
 	mFindProject   = models.FindProject
 	mListReleases  = models.ListReleases
+	mCountReleases = models.CountReleases
 	mSaveProject   = models.SaveProject
 	mUpdateProject = models.UpdateProject
 	mDeleteProject = models.DeleteProject
@@ -56,16 +58,31 @@ func RegisterProjectHandlers(r *mux.Router, db database.DB) {
 }
 
 // GET /projects
-// listProjects produces a list of all projects.
+// listProjects produces a list of projects, optionally filtered by status, sorted and paginated.
 func listProjects(db database.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		projects, err := mListProjects(db)
+		opts, err := parseListOptions(r)
 		if err != nil {
+			encodeHelper(w, NewProjectResponse(ErrRspBadRequest, []models.Project{}))
+			return
+		}
+		filter := models.ProjectFilter{Status: r.URL.Query().Get("status")}
+
+		total, err := mCountProjects(db, filter)
+		projects := []models.Project{}
+		if err == nil {
+			projects, err = mListProjects(db, filter, opts)
+		}
+		if isListParamError(err) {
+			log.Println("[---] Listing error:", err)
+			encodeHelper(w, NewProjectResponse(ErrRspBadRequest, []models.Project{}))
+			return
+		} else if err != nil {
 			log.Println("[---] Listing error:", err)
 			encodeHelper(w, NewProjectResponse(ErrRspListProjects, []models.Project{}))
 			return
 		}
-		encodeHelper(w, NewProjectResponse(NoErr, projects))
+		encodeHelper(w, NewProjectResponse(NoErr.withPaging(total, opts), projects))
 	}
 }
 
@@ -176,14 +193,14 @@ func deleteProject(db database.DB) http.HandlerFunc {
 			return
 		}
 
-		releases, err := mListReleases(db, project)
+		releaseCount, err := mCountReleases(db, project, models.ReleaseFilter{})
 		if err != nil {
 			log.Println("[---] Delete error:", err)
 			encodeHelper(w, NewProjectResponse(ErrRspUnexpected, []models.Project{}))
 			return
 		}
 
-		if len(releases) > 0 {
+		if releaseCount > 0 {
 			log.Println("[---] Delete error: releases not empty")
 			encodeHelper(w, NewProjectResponse(ErrRspReleasesNotEmpty, []models.Project{}))
 			return
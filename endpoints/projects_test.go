@@ -15,7 +15,10 @@ import (
 )
 
 func TestListProjects(t *testing.T) {
-	mListProjects = func(db database.DB) ([]models.Project, error) {
+	mCountProjects = func(db database.DB, filter models.ProjectFilter) (uint32, error) {
+		return 0, nil
+	}
+	mListProjects = func(db database.DB, filter models.ProjectFilter, opts models.ListOptions) ([]models.Project, error) {
 		return []models.Project{}, nil
 	}
 
@@ -34,12 +37,12 @@ func TestListProjects(t *testing.T) {
 
 	projects := []models.Project{models.Project{
 		Id:        5,
-		CreatedAt: time.Now(),
+		CreatedAt: time.Now().UTC(),
 		Name:      "name",
 		Shorthand: "short",
 		Status:    "unknown",
 	}}
-	mListProjects = func(db database.DB) ([]models.Project, error) {
+	mListProjects = func(db database.DB, filter models.ProjectFilter, opts models.ListOptions) ([]models.Project, error) {
 		return projects, nil
 	}
 
@@ -53,7 +56,7 @@ func TestListProjects(t *testing.T) {
 	assert.Equal(t, projects[0], resp.Result[0])
 
 	expErr := errors.New("error")
-	mListProjects = func(db database.DB) ([]models.Project, error) {
+	mListProjects = func(db database.DB, filter models.ProjectFilter, opts models.ListOptions) ([]models.Project, error) {
 		return []models.Project{}, expErr
 	}
 
@@ -64,6 +67,42 @@ func TestListProjects(t *testing.T) {
 	assert.Equal(t, ErrRspListProjects.getError().Error(), resp.getError().Error())
 	assert.Equal(t, 0, len(resp.Result))
 	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	// test filters and list options are passed through
+	mCountProjects = func(db database.DB, filter models.ProjectFilter) (uint32, error) {
+		assert.Equal(t, "active", filter.Status)
+		return 12, nil
+	}
+	mListProjects = func(db database.DB, filter models.ProjectFilter, opts models.ListOptions) ([]models.Project, error) {
+		assert.Equal(t, "active", filter.Status)
+		assert.Equal(t, models.ListOptions{Limit: 2, Offset: 4, Sort: "name"}, opts)
+		return projects, nil
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/projects?status=active&limit=2&offset=4&sort=name&order=asc", nil)
+	listFn.ServeHTTP(w, r)
+	resp = ProjectResponse{}
+	decoder = json.NewDecoder(w.Body)
+	decoder.Decode(&resp)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, Paging{Total: 12, Limit: 2, Offset: 4}, *resp.Paging)
+
+	// test bad parameters
+	for _, query := range []string{"limit=abc", "limit=1001", "offset=-1", "order=up"} {
+		w = httptest.NewRecorder()
+		r, _ = http.NewRequest("GET", "/projects?"+query, nil)
+		listFn.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	}
+
+	mCountProjects = func(db database.DB, filter models.ProjectFilter) (uint32, error) {
+		return 0, models.ErrInvalidProjectStatus
+	}
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/projects?status=bogus", nil)
+	listFn.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
 }
 
 func TestCreateProject(t *testing.T) {
@@ -130,7 +169,7 @@ func TestGetProject(t *testing.T) {
 
 	// test not found
 	router := mux.NewRouter()
-	registerHandlers(router, nil, nil)
+	registerHandlers(router, nil, nil, TMismatchReject, DefaultLintConfig())
 	mFindProject = func(db database.DB, id uint32) (models.Project, error) {
 		assert.Equal(t, uint32(5), id)
 		return models.Project{}, errors.New("not found")
@@ -165,7 +204,7 @@ func TestGetProject(t *testing.T) {
 
 func TestUpdateProject(t *testing.T) {
 	router := mux.NewRouter()
-	registerHandlers(router, nil, nil)
+	registerHandlers(router, nil, nil, TMismatchReject, DefaultLintConfig())
 	const updateReq = `{"name":"Georgi is coolish","shorthand":"geocool","description":"yeah","status":"completed"}`
 	var resp ProjectResponse
 
@@ -239,7 +278,7 @@ func TestUpdateProject(t *testing.T) {
 
 func TestDeleteProject(t *testing.T) {
 	router := mux.NewRouter()
-	registerHandlers(router, nil, nil)
+	registerHandlers(router, nil, nil, TMismatchReject, DefaultLintConfig())
 	var resp ProjectResponse
 
 	// test not found
@@ -263,8 +302,8 @@ func TestDeleteProject(t *testing.T) {
 		return models.Project{Id: id}, nil
 	}
 
-	mListReleases = func(db database.DB, p models.Project) ([]models.Release, error) {
-		return []models.Release{}, errors.New("list releases error")
+	mCountReleases = func(db database.DB, p models.Project, filter models.ReleaseFilter) (uint32, error) {
+		return 0, errors.New("count releases error")
 	}
 
 	w = httptest.NewRecorder()
@@ -278,8 +317,8 @@ func TestDeleteProject(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, w.Code)
 
 	// test releases non-zero
-	mListReleases = func(db database.DB, p models.Project) ([]models.Release, error) {
-		return []models.Release{models.Release{}}, nil
+	mCountReleases = func(db database.DB, p models.Project, filter models.ReleaseFilter) (uint32, error) {
+		return 1, nil
 	}
 
 	w = httptest.NewRecorder()
@@ -293,8 +332,8 @@ func TestDeleteProject(t *testing.T) {
 	assert.Equal(t, http.StatusExpectationFailed, w.Code)
 
 	// test deletion error
-	mListReleases = func(db database.DB, p models.Project) ([]models.Release, error) {
-		return []models.Release{}, nil
+	mCountReleases = func(db database.DB, p models.Project, filter models.ReleaseFilter) (uint32, error) {
+		return 0, nil
 	}
 
 	mDeleteProject = func(db database.DB, p models.Project) (models.Project, error) {
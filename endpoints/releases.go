@@ -5,7 +5,6 @@ import (
 	"ims-release/models"
 	"ims-release/storage_provider"
 
-	"archive/zip"
 	"bytes"
 	"fmt"
 	"log"
@@ -16,14 +15,19 @@ import (
 )
 
 var (
+	mListAllReleases     = models.ListAllReleases
+	mCountAllReleases    = models.CountAllReleases
 	mNewRelease          = models.NewRelease
 	mFindRelease         = models.FindRelease
 	mSaveRelease         = models.SaveRelease
 	mUpdateRelease       = models.UpdateRelease
 	mDeleteRelease       = models.DeleteRelease
 	mListPages           = models.ListPages
+	mListReleaseCovers   = models.ListReleaseCovers
+	mCountPages          = models.CountPages
 	mGenerateArchiveName = models.GenerateArchiveName
 	mGeneratePagePath    = models.GeneratePagePath
+	mGenerateArchivePath = models.GenerateArchivePath
 )
 
 var (
@@ -38,9 +42,17 @@ var (
 	ErrMsgMustDraft             = "Modifying a published release is not allowed unless changing status to draft."
 	ErrRspMustDraft             = NewApiResponse(http.StatusExpectationFailed, &ErrMsgMustDraft)
 	ErrMsgMustContainCreditPage = "A release must contain a credit page before it can be published."
+	ErrMsgBadPublishAt          = "A scheduled release must have a publishAt time in the future."
+	ErrRspBadPublishAt          = NewApiResponse(http.StatusExpectationFailed, &ErrMsgBadPublishAt)
+	ErrMsgMustBeReleased        = "Only released releases can be retracted."
+	ErrRspMustBeReleased        = NewApiResponse(http.StatusExpectationFailed, &ErrMsgMustBeReleased)
+	ErrMsgReleaseRetracted      = "The release has been retracted."
+	ErrRspReleaseRetracted      = NewApiResponse(http.StatusGone, &ErrMsgReleaseRetracted)
 	ErrRspMustContainCreditPage = NewApiResponse(http.StatusExpectationFailed, &ErrMsgMustContainCreditPage)
 	ErrMsgReleaseUpdate         = "Could not update specified release. Please ensure the status and identifier are correct."
 	ErrRspReleaseUpdate         = NewApiResponse(http.StatusInternalServerError, &ErrMsgReleaseUpdate)
+	ErrMsgReleaseSnapshot       = "Could not record the release version, so the release was not published. Please try again later."
+	ErrRspReleaseSnapshot       = NewApiResponse(http.StatusInternalServerError, &ErrMsgReleaseSnapshot)
 	ErrMsgPagesNotEmpty         = "All pages must be deleted before deleting a release."
 	ErrRspPagesNotEmpty         = NewApiResponse(http.StatusExpectationFailed, &ErrMsgPagesNotEmpty)
 	ErrMsgReleaseDelete         = "Could not delete the release. Please check that the releaseId is correct or try again later."
@@ -56,22 +68,84 @@ func NewReleaseResponse(a ApiResponse, r []models.Release) ReleaseResponse {
 	return ReleaseResponse{ApiResponse: a, Result: r}
 }
 
+type ProjectReleaseResponse struct {
+	ApiResponse
+	Result []models.ProjectRelease `json:"result"`
+}
+
+func NewProjectReleaseResponse(a ApiResponse, r []models.ProjectRelease) ProjectReleaseResponse {
+	return ProjectReleaseResponse{ApiResponse: a, Result: r}
+}
+
 // RegisterReleaseHandlers attaches the closures generated by each function defined below
 // to handle incoming requests to the appropriate endpoint using a subrouter with an
 // appropriate prefix, specified in main.
-func RegisterReleaseHandlers(r *mux.Router, db database.DB, sp storage_provider.Binary) {
+func RegisterReleaseHandlers(r *mux.Router, db database.DB, sp storage_provider.Binary, checks LintConfig) {
 	root := "/projects/{projectId:[0-9]+}/releases"
 	sr := r.PathPrefix(root).Subrouter()
+	r.HandleFunc("/releases", listAllReleases(db)).Methods("GET")
 	r.HandleFunc(root, listReleases(db)).Methods("GET")
 	r.HandleFunc(root, createRelease(db)).Methods("POST")
 	sr.HandleFunc("/{releaseId:[0-9]+}", getRelease(db)).Methods("GET")
-	sr.HandleFunc("/{releaseId:[0-9]+}", updateRelease(db)).Methods("PUT")
-	sr.HandleFunc("/{releaseId:[0-9]+}", deleteRelease(db)).Methods("DELETE")
+	sr.HandleFunc("/{releaseId:[0-9]+}", updateRelease(db, sp, checks)).Methods("PUT")
+	sr.HandleFunc("/{releaseId:[0-9]+}", deleteRelease(db, sp)).Methods("DELETE")
 	sr.HandleFunc("/{releaseId:[0-9]+}/download/{name:.*}", downloadRelease(db, sp)).Methods("GET")
 }
 
+// parseReleaseFilter reads the status, releasedSince and releasedBefore query parameters. Retracted releases are only
+// listed when they are asked for by status.
+func parseReleaseFilter(r *http.Request) (models.ReleaseFilter, error) {
+	filter := models.ReleaseFilter{Status: r.URL.Query().Get("status")}
+	filter.ExcludeRetracted = filter.Status == ""
+	var err error
+	filter.ReleasedSince, err = parseTimeParam(r, "releasedSince")
+	if err == nil {
+		filter.ReleasedBefore, err = parseTimeParam(r, "releasedBefore")
+	}
+	return filter, err
+}
+
+// GET /releases
+// listAllReleases produces a list of the releases of all projects, newest first unless another order is requested,
+// optionally filtered by status and release date and paginated.
+func listAllReleases(db database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		opts, err := parseListOptions(r)
+		var filter models.ReleaseFilter
+		if err == nil {
+			filter, err = parseReleaseFilter(r)
+		}
+		if err != nil {
+			encodeHelper(w, NewProjectReleaseResponse(ErrRspBadRequest, []models.ProjectRelease{}))
+			return
+		}
+		if opts.Sort == "" && r.URL.Query().Get("order") == "" {
+			opts.Sort = "releasedOn"
+			opts.Desc = true
+		}
+
+		total, err := mCountAllReleases(db, filter)
+		releases := []models.ProjectRelease{}
+		if err == nil {
+			releases, err = mListAllReleases(db, filter, opts)
+		}
+		if isListParamError(err) {
+			log.Println("[---] Listing error:", err)
+			encodeHelper(w, NewProjectReleaseResponse(ErrRspBadRequest, []models.ProjectRelease{}))
+			return
+		} else if err != nil {
+			log.Println("[---] Listing error:", err)
+			encodeHelper(w, NewProjectReleaseResponse(ErrRspListReleases, []models.ProjectRelease{}))
+			return
+		}
+
+		encodeHelper(w, NewProjectReleaseResponse(NoErr.withPaging(total, opts), releases))
+	}
+}
+
 // GET /projects/{projectId}/releases
-// listReleases produces a list of all releases under a given project.
+// listReleases produces a list of releases under a given project, optionally filtered by status and release
+// date, sorted and paginated.
 func listReleases(db database.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		project, err := fetchProjectUsingRequestArgs(db, w, r, true)
@@ -81,14 +155,32 @@ func listReleases(db database.DB) http.HandlerFunc {
 			return
 		}
 
-		releases, err := mListReleases(db, project)
+		opts, err := parseListOptions(r)
+		var filter models.ReleaseFilter
+		if err == nil {
+			filter, err = parseReleaseFilter(r)
+		}
 		if err != nil {
+			encodeHelper(w, NewReleaseResponse(ErrRspBadRequest, []models.Release{}))
+			return
+		}
+
+		total, err := mCountReleases(db, project, filter)
+		releases := []models.Release{}
+		if err == nil {
+			releases, err = mListReleases(db, project, filter, opts)
+		}
+		if isListParamError(err) {
+			log.Println("[---] Listing error:", err)
+			encodeHelper(w, NewReleaseResponse(ErrRspBadRequest, []models.Release{}))
+			return
+		} else if err != nil {
 			log.Println("[---] Listing error:", err)
 			encodeHelper(w, NewReleaseResponse(ErrRspListReleases, []models.Release{}))
 			return
 		}
 
-		encodeHelper(w, NewReleaseResponse(NoErr, releases))
+		encodeHelper(w, NewReleaseResponse(NoErr.withPaging(total, opts), releases))
 	}
 }
 
@@ -151,7 +243,8 @@ func fetchReleaseUsingRequestArgs(db database.DB, w http.ResponseWriter, r *http
 	return project, release, nil
 }
 
-// getRelease obtains information about a specific release.
+// getRelease obtains information about a specific release. Retracted releases are reported as gone, but still returned
+// so that the reason can be shown.
 func getRelease(db database.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		_, release, err := fetchReleaseUsingRequestArgs(db, w, r, true)
@@ -160,15 +253,22 @@ func getRelease(db database.DB) http.HandlerFunc {
 			// response already set
 			return
 		}
+		if release.Status == models.RStatusRetractedStr {
+			encodeHelper(w, NewReleaseResponse(ErrRspReleaseRetracted, []models.Release{release}))
+			return
+		}
 		encodeHelper(w, NewReleaseResponse(NoErr, []models.Release{release}))
 	}
 }
 
 // PUT /projects/{projectId}/releases/{releaseId}
-// updateRelease updates the chapter, version, and status of a release.
-func updateRelease(db database.DB) http.HandlerFunc {
+// updateRelease updates the chapter, version, and status of a release. A release can only be published or scheduled
+// if it passes the publish checks set to the error level. Publishing a release generates its archive ahead of the
+// first download, and returning it to draft discards the generated archives. Returning a scheduled release to draft
+// cancels its publication, and scheduling it again moves it to the new publishAt time.
+func updateRelease(db database.DB, sp storage_provider.Binary, checks LintConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		_, release, err := fetchReleaseUsingRequestArgs(db, w, r, true)
+		project, release, err := fetchReleaseUsingRequestArgs(db, w, r, true)
 		if err != nil {
 			log.Println("[---] Release fetch error:", err)
 			// response already set
@@ -182,64 +282,109 @@ func updateRelease(db database.DB) http.HandlerFunc {
 			return
 		}
 
-		if release.Status == models.RStatusReleasedStr && request.Status != models.RStatusDraftStr {
+		// a published release can be retracted and reinstated, but other changes need it to be returned to draft
+		wasPublished := release.Status == models.RStatusReleasedStr || release.Status == models.RStatusRetractedStr
+		staysPublished := (request.Status == models.RStatusReleasedStr || request.Status == models.RStatusRetractedStr) &&
+			request.Identifier == release.Identifier && request.Version == release.Version
+		if wasPublished && request.Status != models.RStatusDraftStr && !staysPublished {
 			log.Println("[---] Update error:", ErrMsgMustDraft)
 			encodeHelper(w, NewReleaseResponse(ErrRspMustDraft, []models.Release{}))
 			return
 		}
 
+		if !wasPublished && request.Status == models.RStatusRetractedStr {
+			log.Println("[---] Update error:", ErrMsgMustBeReleased)
+			encodeHelper(w, NewReleaseResponse(ErrRspMustBeReleased, []models.Release{}))
+			return
+		}
+
 		if release.Version > request.Version {
 			log.Println("[---] Update error:", ErrMsgDownversioning)
 			encodeHelper(w, NewReleaseResponse(ErrRspDownversioning, []models.Release{}))
 			return
 		}
 
-		if release.Status != request.Status && release.Version == request.Version && request.Status == models.RStatusReleasedStr {
+		publishing := request.Status == models.RStatusReleasedStr || request.Status == models.RStatusScheduledStr
+		if release.Status == models.RStatusDraftStr && release.Version == request.Version && publishing {
 			log.Println("[---] Update error:", ErrMsgMustUpversion)
 			encodeHelper(w, NewReleaseResponse(ErrRspMustUpversion, []models.Release{}))
 			return
 		}
 
-		if release.Status != request.Status && request.Status == models.RStatusReleasedStr {
-			pages, err := mListPages(db, release)
-			if err != nil {
-				log.Println("[---] Update error:", err)
-				encodeHelper(w, NewReleaseResponse(ErrRspUnexpected, []models.Release{}))
-				return
-			}
-			creditPageFound := false
-			for _, page := range pages {
-				if len(page.Name) > 0 && page.Name[0] == '!' {
-					creditPageFound = true
-					break
-				}
-			}
-			if creditPageFound != true {
-				log.Println("[---] Update error:", ErrMsgMustContainCreditPage)
-				encodeHelper(w, NewReleaseResponse(ErrRspMustContainCreditPage, []models.Release{}))
+		now := time.Now()
+		if request.Status == models.RStatusScheduledStr && (request.PublishAt == nil || !request.PublishAt.After(now)) {
+			log.Println("[---] Update error:", ErrMsgBadPublishAt)
+			encodeHelper(w, NewReleaseResponse(ErrRspBadPublishAt, []models.Release{}))
+			return
+		}
+
+		if !wasPublished && publishing {
+			// the checks apply to the release as it is about to be published
+			published := release
+			published.Identifier = request.Identifier
+			rsp := checkPublishable(db, sp, checks, project, published)
+			if rsp != NoErr {
+				encodeHelper(w, NewReleaseResponse(rsp, []models.Release{}))
 				return
 			}
 		}
 
+		previous := release
 		release.Version = request.Version
 		release.Identifier = request.Identifier
 		release.Status = request.Status
-		release.ReleasedOn = time.Now()
+		if !wasPublished || !staysPublished {
+			// retracting and reinstating a release keeps its release date
+			release.ReleasedOn = now
+		}
+		release.PublishAt = nil
+		if release.Status == models.RStatusScheduledStr {
+			release.PublishAt = request.PublishAt
+		}
+		release.RetractReason = ""
+		if release.Status == models.RStatusRetractedStr {
+			release.RetractReason = request.RetractReason
+		}
 
-		release, err = mUpdateRelease(db, release)
-		if err != nil {
+		// a release is only published along with its release version
+		var snapshotErr error
+		err = database.Transaction(db, func(tx database.DB) error {
+			release, err = mUpdateRelease(tx, release)
+			if err != nil || wasPublished || release.Status != models.RStatusReleasedStr {
+				return err
+			}
+			snapshotErr = snapshotRelease(tx, sp, project, release)
+			return snapshotErr
+		})
+		if snapshotErr != nil {
+			log.Println("[---] Snapshot error:", snapshotErr)
+			encodeHelper(w, NewReleaseResponse(ErrRspReleaseSnapshot, []models.Release{}))
+			return
+		} else if err != nil {
 			log.Println("[---] Update error:", err)
 			encodeHelper(w, NewReleaseResponse(ErrRspReleaseUpdate, []models.Release{}))
 			return
 		}
+
+		if previous.Status == models.RStatusReleasedStr && release.Status != models.RStatusReleasedStr {
+			invalidateReleaseArchives(sp, project, previous)
+		}
+		if previous.Status != models.RStatusReleasedStr && release.Status == models.RStatusReleasedStr {
+			// the release is published either way, the archive will be generated on download instead
+			_, err = releaseArchive(db, sp, archiveFormats[0], project, release)
+			if err != nil {
+				log.Println("[---] Archive error:", err)
+			}
+		}
 		encodeHelper(w, NewReleaseResponse(NoErr, []models.Release{release}))
 	}
 }
 
 // DELETE /projects/{projectId}/releases/{releaseId}
 
-// deleteRelease deletes a release from the DB and also all associated pages.
-func deleteRelease(db database.DB) http.HandlerFunc {
+// deleteRelease deletes a release from the DB and also all associated pages. Uploads into the release are abandoned
+// and their staged chunks removed.
+func deleteRelease(db database.DB, sp storage_provider.Binary) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		_, release, err := fetchReleaseUsingRequestArgs(db, w, r, true)
 		if err != nil {
@@ -248,29 +393,43 @@ func deleteRelease(db database.DB) http.HandlerFunc {
 			return
 		}
 
-		pages, err := mListPages(db, release)
+		pageCount, err := mCountPages(db, release)
 		if err != nil {
 			log.Println("[---] Delete error:", err)
 			encodeHelper(w, NewReleaseResponse(ErrRspUnexpected, []models.Release{}))
 			return
 		}
 
-		if len(pages) > 0 {
+		if pageCount > 0 {
 			log.Println("[---] Delete error: pages not empty")
 			encodeHelper(w, NewReleaseResponse(ErrRspPagesNotEmpty, []models.Release{}))
 			return
 		}
 
+		uploads, err := mListReleaseUploads(db, release)
+		if err != nil {
+			log.Println("[---] Delete error:", err)
+			encodeHelper(w, NewReleaseResponse(ErrRspUnexpected, []models.Release{}))
+			return
+		}
+
 		release, err = mDeleteRelease(db, release)
 		if err != nil {
 			log.Println("[---] Delete error:", err)
 			encodeHelper(w, NewReleaseResponse(ErrRspReleaseDelete, []models.Release{}))
 			return
 		}
+		// the uploads were deleted along with the release, only their chunks are left
+		for _, u := range uploads {
+			removeUploadChunks(sp, u)
+		}
 		encodeHelper(w, NewReleaseResponse(NoErr, []models.Release{release}))
 	}
 }
 
+// GET /projects/{projectId}/releases/{releaseId}/download/{name}
+// downloadRelease produces an archive of a released release's pages. The format is chosen by the extension of the
+// name, which must otherwise match the release's archive name. Range and conditional requests are supported.
 func downloadRelease(db database.DB, sp storage_provider.Binary) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		project, release, err := fetchReleaseUsingRequestArgs(db, w, r, false)
@@ -283,59 +442,35 @@ func downloadRelease(db database.DB, sp storage_provider.Binary) http.HandlerFun
 		vars := mux.Vars(r)
 		archiveName := vars["name"]
 
+		if release.Status == models.RStatusRetractedStr {
+			log.Println("the requested release has been retracted")
+			w.WriteHeader(http.StatusGone)
+			return
+		}
 		if release.Status != models.RStatusReleasedStr {
 			log.Println("the requested release is not in released state")
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
 
-		archiveNameExpected := mGenerateArchiveName(project, release)
-		if archiveNameExpected != archiveName {
+		format, ok := findArchiveFormat(archiveName)
+		archiveNameExpected := format.archiveName(project, release)
+		if !ok || archiveNameExpected != archiveName {
 			log.Printf("requested archive name '%s' does not match expected '%s'\n", archiveName, archiveNameExpected)
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
 
-		pages, err := mListPages(db, release)
-		if err != nil {
-			log.Println("failed to retrieve list of pages")
-			w.WriteHeader(http.StatusNotFound)
-			return
-		}
-
-		buffer := new(bytes.Buffer)
-		z := zip.NewWriter(buffer)
-
-		for _, page := range pages {
-			filePath := mGeneratePagePath(project, release, page.Name)
-			imgData, err := sp.Get(filePath)
-			if err != nil {
-				log.Printf("failed to retrieve image data for %s\n", filePath)
-				w.WriteHeader(http.StatusNotFound)
-				return
-			}
-
-			f, err := z.Create(page.Name)
-			if err != nil {
-				log.Printf("failed to add image %s to archive\n", filePath)
-				w.WriteHeader(http.StatusNotFound)
-				return
-			}
-			_, err = f.Write(imgData)
-			if err != nil {
-				log.Printf("failed to add image %s to archive\n", filePath)
-				w.WriteHeader(http.StatusNotFound)
-				return
-			}
-		}
-		err = z.Close()
+		data, err := releaseArchive(db, sp, format, project, release)
 		if err != nil {
-			log.Printf("failed when finalizing archive\n")
+			log.Println(err)
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/zip")
-		w.Write(buffer.Bytes())
+		// the release cannot change while it is released, so its release date serves as the archive's modification time
+		w.Header().Set("Content-Type", format.ContentType)
+		w.Header().Set("ETag", hashETag(data))
+		http.ServeContent(w, r, archiveName, release.ReleasedOn, bytes.NewReader(data))
 	}
 }
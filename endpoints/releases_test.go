@@ -1,21 +1,28 @@
 package endpoints
 
 import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/xml"
 	"errors"
+	"fmt"
 	"github.com/gorilla/mux"
 	"ims-release/assert"
 	"ims-release/database"
 	"ims-release/models"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestListReleases(t *testing.T) {
 	router := mux.NewRouter()
-	registerHandlers(router, nil, nil)
+	registerHandlers(router, nil, nil, TMismatchReject, DefaultLintConfig())
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest("GET", "/projects/5/releases", nil)
 	var resp ReleaseResponse
@@ -39,7 +46,12 @@ func TestListReleases(t *testing.T) {
 		return models.Project{Id: id}, nil
 	}
 
-	mListReleases = func(db database.DB, p models.Project) ([]models.Release, error) {
+	mCountReleases = func(db database.DB, p models.Project, filter models.ReleaseFilter) (uint32, error) {
+		assert.Equal(t, uint32(5), p.Id)
+		return 1, nil
+	}
+
+	mListReleases = func(db database.DB, p models.Project, filter models.ReleaseFilter, opts models.ListOptions) ([]models.Release, error) {
 		assert.Equal(t, uint32(5), p.Id)
 		return []models.Release{}, errors.New("some error")
 	}
@@ -54,7 +66,7 @@ func TestListReleases(t *testing.T) {
 	assert.Equal(t, 0, len(resp.Result))
 
 	// test success case
-	mListReleases = func(db database.DB, p models.Project) ([]models.Release, error) {
+	mListReleases = func(db database.DB, p models.Project, filter models.ReleaseFilter, opts models.ListOptions) ([]models.Release, error) {
 		assert.Equal(t, uint32(5), p.Id)
 		return []models.Release{models.Release{Id: 6, ProjectID: p.Id}}, nil
 	}
@@ -69,11 +81,123 @@ func TestListReleases(t *testing.T) {
 	assert.Equal(t, 1, len(resp.Result))
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Equal(t, uint32(6), resp.Result[0].Id)
+	assert.Equal(t, uint32(1), resp.Paging.Total)
+
+	// test filters are passed through
+	since := time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC)
+	mListReleases = func(db database.DB, p models.Project, filter models.ReleaseFilter, opts models.ListOptions) ([]models.Release, error) {
+		assert.Equal(t, "released", filter.Status)
+		assert.Equal(t, true, since.Equal(filter.ReleasedSince))
+		assert.Equal(t, models.ListOptions{Limit: 5, Sort: "releasedOn", Desc: true}, opts)
+		return []models.Release{}, nil
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/projects/5/releases?status=released&releasedSince=2017-03-01T00:00:00Z&limit=5&sort=releasedOn&order=desc", nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// test bad filters
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/projects/5/releases?releasedSince=yesterday", nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	mCountReleases = func(db database.DB, p models.Project, filter models.ReleaseFilter) (uint32, error) {
+		return 0, models.ErrInvalidReleaseStatus
+	}
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/projects/5/releases?status=bogus", nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestListAllReleases(t *testing.T) {
+	router := mux.NewRouter()
+	registerHandlers(router, nil, nil, TMismatchReject, DefaultLintConfig())
+	var resp ProjectReleaseResponse
+
+	// test default order and success case
+	mCountAllReleases = func(db database.DB, filter models.ReleaseFilter) (uint32, error) {
+		return 30, nil
+	}
+	mListAllReleases = func(db database.DB, filter models.ReleaseFilter, opts models.ListOptions) ([]models.ProjectRelease, error) {
+		assert.Equal(t, models.ReleaseFilter{ExcludeRetracted: true}, filter)
+		assert.Equal(t, models.ListOptions{Limit: 10, Sort: "releasedOn", Desc: true}, opts)
+		release := models.Release{Id: 6, Identifier: "c12", Version: 1, Scanlator: "ims", ProjectID: 5}
+		return []models.ProjectRelease{{Release: release, ProjectName: "Project", ProjectShorthand: "proj", ArchiveName: "proj - c12[1][ims].zip"}}, nil
+	}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/releases?limit=10", nil)
+	router.ServeHTTP(w, r)
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, len(resp.Result))
+	assert.Equal(t, uint32(6), resp.Result[0].Id)
+	assert.Equal(t, "proj", resp.Result[0].ProjectShorthand)
+	assert.Equal(t, "proj - c12[1][ims].zip", resp.Result[0].ArchiveName)
+	assert.Equal(t, Paging{Total: 30, Limit: 10}, *resp.Paging)
+
+	// test filters and explicit order are passed through
+	since := time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC)
+	before := time.Date(2017, 4, 1, 0, 0, 0, 0, time.UTC)
+	mListAllReleases = func(db database.DB, filter models.ReleaseFilter, opts models.ListOptions) ([]models.ProjectRelease, error) {
+		assert.Equal(t, "released", filter.Status)
+		assert.Equal(t, true, since.Equal(filter.ReleasedSince))
+		assert.Equal(t, true, before.Equal(filter.ReleasedBefore))
+		assert.Equal(t, models.ListOptions{Sort: "identifier"}, opts)
+		return []models.ProjectRelease{}, nil
+	}
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/releases?status=released&releasedSince=2017-03-01T00:00:00Z&releasedBefore=2017-04-01T00:00:00Z&sort=identifier", nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	mListAllReleases = func(db database.DB, filter models.ReleaseFilter, opts models.ListOptions) ([]models.ProjectRelease, error) {
+		assert.Equal(t, models.ListOptions{}, opts)
+		return []models.ProjectRelease{}, nil
+	}
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/releases?order=asc", nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// test bad parameters
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/releases?releasedBefore=tomorrow", nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	mCountAllReleases = func(db database.DB, filter models.ReleaseFilter) (uint32, error) {
+		return 0, models.ErrInvalidReleaseStatus
+	}
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/releases?status=bogus", nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// test listing error
+	mCountAllReleases = func(db database.DB, filter models.ReleaseFilter) (uint32, error) {
+		return 0, nil
+	}
+	mListAllReleases = func(db database.DB, filter models.ReleaseFilter, opts models.ListOptions) ([]models.ProjectRelease, error) {
+		return []models.ProjectRelease{}, errors.New("some error")
+	}
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/releases", nil)
+	router.ServeHTTP(w, r)
+	resp = ProjectReleaseResponse{}
+	json.NewDecoder(w.Body).Decode(&resp)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, ErrMsgListReleases, resp.getError().Error())
+	assert.Equal(t, 0, len(resp.Result))
 }
 
 func TestCreateRelease(t *testing.T) {
 	router := mux.NewRouter()
-	registerHandlers(router, nil, nil)
+	registerHandlers(router, nil, nil, TMismatchReject, DefaultLintConfig())
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest("POST", "/projects/5/releases", nil)
 	var resp ReleaseResponse
@@ -170,7 +294,7 @@ func TestBadGetReleaseRequest(t *testing.T) {
 
 func TestGetRelease(t *testing.T) {
 	router := mux.NewRouter()
-	registerHandlers(router, nil, nil)
+	registerHandlers(router, nil, nil, TMismatchReject, DefaultLintConfig())
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest("GET", "/projects/5/releases/7", nil)
 	var resp ReleaseResponse
@@ -231,8 +355,11 @@ func TestGetRelease(t *testing.T) {
 }
 
 func TestUpdateRelease(t *testing.T) {
+	mockReleaseVersions()
 	router := mux.NewRouter()
-	registerHandlers(router, nil, nil)
+	sp := SpMap{"5/7/someName.png": {1}, "5/7/someOtherName.png": {2}, "5/7/!someOtherName.png": {2}, "5/7/creditPage.jpg": {3}}
+	mGeneratePagePath = models.GeneratePagePath
+	registerHandlers(router, nil, sp, TMismatchReject, DefaultLintConfig())
 	var resp ReleaseResponse
 
 	// test release not found
@@ -319,7 +446,7 @@ func TestUpdateRelease(t *testing.T) {
 	assert.Equal(t, 0, len(resp.Result))
 
 	// test credit page missing error (page fetch error)
-	mListPages = func(db database.DB, release models.Release) ([]models.Page, error) {
+	mListPages = func(db database.DB, release models.Release, opts models.ListOptions) ([]models.Page, error) {
 		return []models.Page{}, errors.New("some error")
 	}
 
@@ -372,10 +499,10 @@ func TestUpdateRelease(t *testing.T) {
 	assert.Equal(t, 1, len(resp.Result))
 	assert.Equal(t, "c1", resp.Result[0].Identifier)
 
-	// test credit page missing error
-	mListPages = func(db database.DB, release models.Release) ([]models.Page, error) {
+	// test credit page missing error, a leading "!" in the name no longer marks a credit page
+	mListPages = func(db database.DB, release models.Release, opts models.ListOptions) ([]models.Page, error) {
 		assert.Equal(t, uint32(7), release.Id)
-		return []models.Page{models.Page{Name: "someName.png"}, models.Page{Name: "someOtherName.png"}}, nil
+		return []models.Page{models.Page{Name: "someName.png"}, models.Page{Name: "!someOtherName.png", Role: models.PRoleContentStr}}, nil
 	}
 
 	w = httptest.NewRecorder()
@@ -389,8 +516,8 @@ func TestUpdateRelease(t *testing.T) {
 	assert.Equal(t, 0, len(resp.Result))
 
 	// test save error (published)
-	mListPages = func(db database.DB, release models.Release) ([]models.Page, error) {
-		return []models.Page{models.Page{Name: "someName.png"}, models.Page{Name: "someOtherName.png"}, models.Page{Name: "!creditPage.jpg"}}, nil
+	mListPages = func(db database.DB, release models.Release, opts models.ListOptions) ([]models.Page, error) {
+		return []models.Page{models.Page{Name: "someName.png"}, models.Page{Name: "someOtherName.png"}, models.Page{Name: "creditPage.jpg", Role: models.PRoleCreditStr}}, nil
 	}
 
 	mUpdateRelease = func(db database.DB, release models.Release) (models.Release, error) {
@@ -427,9 +554,269 @@ func TestUpdateRelease(t *testing.T) {
 	assert.Equal(t, "c1", resp.Result[0].Identifier)
 }
 
+func TestUpdateReleaseArchives(t *testing.T) {
+	mockReleaseVersions()
+	router := mux.NewRouter()
+	sp := SpMap{"12/70/credits.png": []byte{1, 2}}
+	registerHandlers(router, nil, sp, TMismatchReject, DefaultLintConfig())
+	var resp ReleaseResponse
+
+	mFindProject = func(db database.DB, id uint32) (models.Project, error) {
+		return models.Project{Id: id, Shorthand: "proj"}, nil
+	}
+	mFindRelease = func(db database.DB, p models.Project, id uint32) (models.Release, error) {
+		return models.Release{Id: id, ProjectID: p.Id, Identifier: "c1", Version: 1, Scanlator: "ims", Status: "draft"}, nil
+	}
+	mUpdateRelease = func(db database.DB, release models.Release) (models.Release, error) {
+		return release, nil
+	}
+	mListPages = func(db database.DB, release models.Release, opts models.ListOptions) ([]models.Page, error) {
+		return []models.Page{models.Page{Name: "credits.png", Role: models.PRoleCreditStr}}, nil
+	}
+	mGenerateArchiveName = models.GenerateArchiveName
+	mGeneratePagePath = models.GeneratePagePath
+	mGenerateArchivePath = models.GenerateArchivePath
+
+	// test publishing generates the zip archive
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("PUT", "/projects/12/releases/70", strings.NewReader(`{"identifier":"c1","version":2,"status":"released"}`))
+	router.ServeHTTP(w, r)
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 3, len(sp))
+	assert.Equal(t, true, sp.Exists("archives/12/70/proj - c1[2][ims].zip"))
+
+	// test returning to draft removes the archives of every format
+	sp["archives/12/70/proj - c1[2][ims].pdf"] = []byte{3}
+	mFindRelease = func(db database.DB, p models.Project, id uint32) (models.Release, error) {
+		return models.Release{Id: id, ProjectID: p.Id, Identifier: "c1", Version: 2, Scanlator: "ims", Status: "released"}, nil
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("PUT", "/projects/12/releases/70", strings.NewReader(`{"identifier":"c2","version":2,"status":"draft"}`))
+	router.ServeHTTP(w, r)
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 2, len(sp))
+	assert.Equal(t, true, sp.Exists("12/70/credits.png"))
+
+	// test a failure to generate the archive does not fail publishing
+	mFindRelease = func(db database.DB, p models.Project, id uint32) (models.Release, error) {
+		return models.Release{Id: id, ProjectID: p.Id, Identifier: "c1", Version: 2, Scanlator: "ims", Status: "draft"}, nil
+	}
+	listed := 0
+	mListPages = func(db database.DB, release models.Release, opts models.ListOptions) ([]models.Page, error) {
+		// the pages are listed for the publish checks, and then again for the snapshot and the archive
+		listed++
+		if listed > 2 {
+			return nil, errors.New("some error")
+		}
+		return []models.Page{models.Page{Name: "credits.png", Role: models.PRoleCreditStr}}, nil
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("PUT", "/projects/12/releases/70", strings.NewReader(`{"identifier":"c1","version":3,"status":"released"}`))
+	router.ServeHTTP(w, r)
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 2, len(sp))
+
+	// test a failure to record the release version fails publishing
+	mSaveReleaseVersion = func(db database.DB, v models.ReleaseVersion) (models.ReleaseVersion, error) {
+		return v, errors.New("some error")
+	}
+	listed = 0
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("PUT", "/projects/12/releases/70", strings.NewReader(`{"identifier":"c1","version":4,"status":"released"}`))
+	router.ServeHTTP(w, r)
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, ErrMsgReleaseSnapshot, resp.getError().Error())
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, 2, len(sp))
+}
+
+func TestScheduleRelease(t *testing.T) {
+	mockReleaseVersions()
+	router := mux.NewRouter()
+	sp := SpMap{"12/70/credits.png": []byte{1, 2}}
+	registerHandlers(router, nil, sp, TMismatchReject, DefaultLintConfig())
+
+	release := models.Release{Id: 70, ProjectID: 12, Identifier: "c1", Version: 1, Scanlator: "ims", Status: "draft"}
+	mFindProject = func(db database.DB, id uint32) (models.Project, error) {
+		return models.Project{Id: id, Shorthand: "proj"}, nil
+	}
+	mFindRelease = func(db database.DB, p models.Project, id uint32) (models.Release, error) {
+		return release, nil
+	}
+	mUpdateRelease = func(db database.DB, r models.Release) (models.Release, error) {
+		release = r
+		return r, nil
+	}
+	mListPages = func(db database.DB, release models.Release, opts models.ListOptions) ([]models.Page, error) {
+		return []models.Page{models.Page{Name: "credits.png", Role: models.PRoleCreditStr}}, nil
+	}
+	mGenerateArchiveName = models.GenerateArchiveName
+	mGeneratePagePath = models.GeneratePagePath
+	mGenerateArchivePath = models.GenerateArchivePath
+	put := func(body string) (*httptest.ResponseRecorder, ReleaseResponse) {
+		var resp ReleaseResponse
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("PUT", "/projects/12/releases/70", strings.NewReader(body))
+		router.ServeHTTP(w, r)
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w, resp
+	}
+	publishAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	later := publishAt.Add(time.Hour)
+
+	// test scheduling without a time or in the past
+	w, resp := put(`{"identifier":"c1","version":2,"status":"scheduled"}`)
+	assert.Equal(t, ErrMsgBadPublishAt, resp.getError().Error())
+	assert.Equal(t, http.StatusExpectationFailed, w.Code)
+
+	w, resp = put(`{"identifier":"c1","version":2,"status":"scheduled","publishAt":"2001-01-01T00:00:00Z"}`)
+	assert.Equal(t, ErrMsgBadPublishAt, resp.getError().Error())
+
+	// test scheduling requires upversioning and passing the publish checks
+	w, resp = put(`{"identifier":"c1","version":1,"status":"scheduled","publishAt":"` + publishAt.Format(time.RFC3339) + `"}`)
+	assert.Equal(t, ErrMsgMustUpversion, resp.getError().Error())
+
+	delete(sp, "12/70/credits.png")
+	w, resp = put(`{"identifier":"c1","version":2,"status":"scheduled","publishAt":"` + publishAt.Format(time.RFC3339) + `"}`)
+	assert.Equal(t, ErrMsgUnexpected, resp.getError().Error())
+	assert.Equal(t, "draft", release.Status)
+	sp["12/70/credits.png"] = []byte{1, 2}
+
+	// test scheduling
+	w, resp = put(`{"identifier":"c1","version":2,"status":"scheduled","publishAt":"` + publishAt.Format(time.RFC3339) + `"}`)
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "scheduled", release.Status)
+	assert.Equal(t, true, publishAt.Equal(*release.PublishAt))
+	assert.Equal(t, true, publishAt.Equal(*resp.Result[0].PublishAt))
+	assert.Equal(t, 1, len(sp))
+
+	// test rescheduling
+	w, resp = put(`{"identifier":"c1","version":2,"status":"scheduled","publishAt":"` + later.Format(time.RFC3339) + `"}`)
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, true, later.Equal(*release.PublishAt))
+
+	// test publishing right away does not need another upversion
+	w, resp = put(`{"identifier":"c1","version":2,"status":"released"}`)
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, "released", release.Status)
+	assert.Equal(t, true, release.PublishAt == nil)
+	assert.Equal(t, 3, len(sp))
+
+	// test cancelling
+	release.Status = "scheduled"
+	release.PublishAt = &later
+	w, resp = put(`{"identifier":"c1","version":2,"status":"draft"}`)
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, "draft", release.Status)
+	assert.Equal(t, true, release.PublishAt == nil)
+}
+
+func TestRetractRelease(t *testing.T) {
+	mockReleaseVersions()
+	router := mux.NewRouter()
+	sp := SpMap{"12/70/credits.png": []byte{1, 2}}
+	registerHandlers(router, nil, sp, TMismatchReject, DefaultLintConfig())
+
+	releasedOn := time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC)
+	release := models.Release{Id: 70, ProjectID: 12, Identifier: "c1", Version: 2, Scanlator: "ims", Status: "draft"}
+	mFindProject = func(db database.DB, id uint32) (models.Project, error) {
+		return models.Project{Id: id, Shorthand: "proj"}, nil
+	}
+	mFindRelease = func(db database.DB, p models.Project, id uint32) (models.Release, error) {
+		return release, nil
+	}
+	mUpdateRelease = func(db database.DB, r models.Release) (models.Release, error) {
+		release = r
+		return r, nil
+	}
+	mListPages = func(db database.DB, release models.Release, opts models.ListOptions) ([]models.Page, error) {
+		return []models.Page{models.Page{Name: "credits.png", Role: models.PRoleCreditStr}}, nil
+	}
+	mFindPageByName = func(db database.DB, release models.Release, name string) (models.Page, error) {
+		return models.Page{Name: name, Role: models.PRoleCreditStr}, nil
+	}
+	mGenerateArchiveName = models.GenerateArchiveName
+	mGeneratePagePath = models.GeneratePagePath
+	mGenerateArchivePath = models.GenerateArchivePath
+	put := func(body string) (*httptest.ResponseRecorder, ReleaseResponse) {
+		var resp ReleaseResponse
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("PUT", "/projects/12/releases/70", strings.NewReader(body))
+		router.ServeHTTP(w, r)
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w, resp
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/projects/12/releases/70"+path, nil)
+		router.ServeHTTP(w, r)
+		return w
+	}
+	archivePath := models.GenerateArchivePath(models.Project{Id: 12}, release, "proj - c1[2][ims].zip")
+
+	// test only released releases can be retracted
+	w, resp := put(`{"identifier":"c1","version":2,"status":"retracted"}`)
+	assert.Equal(t, ErrMsgMustBeReleased, resp.getError().Error())
+	assert.Equal(t, http.StatusExpectationFailed, w.Code)
+
+	// test retracting
+	release.Status = "released"
+	release.ReleasedOn = releasedOn
+	sp[archivePath] = []byte{3}
+	w, resp = put(`{"identifier":"c1","version":2,"status":"retracted","retractReason":"licensed"}`)
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "retracted", release.Status)
+	assert.Equal(t, "licensed", release.RetractReason)
+	assert.Equal(t, releasedOn, release.ReleasedOn)
+	assert.Equal(t, false, sp.Exists(archivePath))
+	assert.Equal(t, true, sp.Exists("12/70/credits.png"))
+
+	// test retracted releases are gone, but their reason is shown
+	w = get("")
+	resp = ReleaseResponse{}
+	json.NewDecoder(w.Body).Decode(&resp)
+	assert.Equal(t, ErrMsgReleaseRetracted, resp.getError().Error())
+	assert.Equal(t, http.StatusGone, w.Code)
+	assert.Equal(t, "licensed", resp.Result[0].RetractReason)
+	assert.Equal(t, http.StatusGone, get("/download/proj - c1[2][ims].zip").Code)
+	assert.Equal(t, http.StatusGone, get("/pages").Code)
+	assert.Equal(t, http.StatusGone, get("/pages/credits.png").Code)
+	assert.Equal(t, http.StatusGone, get("/thumbnails/credits.png").Code)
+
+	// test a retracted release cannot be changed without returning it to draft
+	w, resp = put(`{"identifier":"c1","version":3,"status":"released"}`)
+	assert.Equal(t, ErrMsgMustDraft, resp.getError().Error())
+	assert.Equal(t, "retracted", release.Status)
+
+	// test reinstating
+	w, resp = put(`{"identifier":"c1","version":2,"status":"released"}`)
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, "released", release.Status)
+	assert.Equal(t, "", release.RetractReason)
+	assert.Equal(t, releasedOn, release.ReleasedOn)
+	assert.Equal(t, true, sp.Exists(archivePath))
+	assert.Equal(t, http.StatusOK, get("").Code)
+}
+
 func TestDeleteRelease(t *testing.T) {
 	router := mux.NewRouter()
-	registerHandlers(router, nil, nil)
+	sp := SpMap{}
+	registerHandlers(router, nil, sp, TMismatchReject, DefaultLintConfig())
 	var resp ReleaseResponse
 
 	// test release not found
@@ -454,13 +841,13 @@ func TestDeleteRelease(t *testing.T) {
 	assert.Equal(t, http.StatusNotFound, w.Code)
 	assert.Equal(t, 0, len(resp.Result))
 
-	// test list pages error
+	// test count pages error
 	mFindRelease = func(db database.DB, p models.Project, id uint32) (models.Release, error) {
 		return models.Release{Id: id, ProjectID: p.Id, Version: uint32(2), Status: "draft"}, nil
 	}
 
-	mListPages = func(db database.DB, release models.Release) ([]models.Page, error) {
-		return []models.Page{}, errors.New("some error")
+	mCountPages = func(db database.DB, release models.Release) (uint32, error) {
+		return 0, errors.New("some error")
 	}
 
 	w = httptest.NewRecorder()
@@ -474,8 +861,8 @@ func TestDeleteRelease(t *testing.T) {
 	assert.Equal(t, 0, len(resp.Result))
 
 	// test pages not empty
-	mListPages = func(db database.DB, release models.Release) ([]models.Page, error) {
-		return []models.Page{models.Page{}}, nil
+	mCountPages = func(db database.DB, release models.Release) (uint32, error) {
+		return 1, nil
 	}
 
 	w = httptest.NewRecorder()
@@ -488,12 +875,32 @@ func TestDeleteRelease(t *testing.T) {
 	assert.Equal(t, http.StatusExpectationFailed, w.Code)
 	assert.Equal(t, 0, len(resp.Result))
 
-	// test delete error
-	mListPages = func(db database.DB, release models.Release) ([]models.Page, error) {
+	// test listing uploads error
+	mCountPages = func(db database.DB, release models.Release) (uint32, error) {
 		assert.Equal(t, uint32(7), release.Id)
-		return []models.Page{}, nil
+		return 0, nil
+	}
+	mListReleaseUploads = func(db database.DB, r models.Release) ([]models.Upload, error) {
+		return nil, errors.New("some error")
 	}
 
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("DELETE", "/projects/5/releases/7", nil)
+	router.ServeHTTP(w, r)
+	decoder = json.NewDecoder(w.Body)
+	decoder.Decode(&resp)
+
+	assert.Equal(t, ErrMsgUnexpected, resp.getError().Error())
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	// test delete error
+	uploads := mockUploads()
+	pending, _ := models.NewUpload(models.Release{Id: 7}, "p01.png", models.UploadKindPage, 10, time.Now(), time.Hour)
+	pending.Chunks = 1
+	uploads[pending.Token] = pending
+	chunk := models.GenerateUploadChunkPath(pending, 0)
+	sp[chunk] = []byte{1}
+
 	mDeleteRelease = func(db database.DB, release models.Release) (models.Release, error) {
 		return release, errors.New("some error")
 	}
@@ -507,6 +914,7 @@ func TestDeleteRelease(t *testing.T) {
 	assert.Equal(t, ErrMsgReleaseDelete, resp.getError().Error())
 	assert.Equal(t, http.StatusInternalServerError, w.Code)
 	assert.Equal(t, 0, len(resp.Result))
+	assert.Equal(t, true, sp.Exists(chunk))
 
 	// test success case
 	mDeleteRelease = func(db database.DB, release models.Release) (models.Release, error) {
@@ -524,11 +932,13 @@ func TestDeleteRelease(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Equal(t, 1, len(resp.Result))
 	assert.Equal(t, uint32(7), resp.Result[0].Id)
+	assert.Equal(t, false, sp.Exists(chunk))
 }
 
 func TestDownloadRelease(t *testing.T) {
 	router := mux.NewRouter()
-	registerHandlers(router, nil, nil)
+	sp := SpMap{}
+	registerHandlers(router, nil, sp, TMismatchReject, DefaultLintConfig())
 	var resp ReleaseResponse
 
 	// test no release found
@@ -581,7 +991,7 @@ func TestDownloadRelease(t *testing.T) {
 	assert.Equal(t, http.StatusNotFound, w.Code)
 
 	// test list pages error
-	mListPages = func(db database.DB, release models.Release) ([]models.Page, error) {
+	mListPages = func(db database.DB, release models.Release, opts models.ListOptions) ([]models.Page, error) {
 		return []models.Page{}, errors.New("some error")
 	}
 
@@ -593,17 +1003,11 @@ func TestDownloadRelease(t *testing.T) {
 	assert.Equal(t, http.StatusNotFound, w.Code)
 
 	// test data get error
-	mListPages = func(db database.DB, release models.Release) ([]models.Page, error) {
+	mListPages = func(db database.DB, release models.Release, opts models.ListOptions) ([]models.Page, error) {
 		return []models.Page{models.Page{Name: "p1.png"}}, nil
 	}
-
-	router = mux.NewRouter()
-	var sp SpTest
-	sp.Error = errors.New("some error")
-	sp.Testing = t
-	sp.ExpectedKey = "12/70/p1.png"
-	sp.Bytes = []byte{1, 2}
-	registerHandlers(router, nil, sp)
+	mGeneratePagePath = models.GeneratePagePath
+	mGenerateArchivePath = models.GenerateArchivePath
 
 	w = httptest.NewRecorder()
 	r, _ = http.NewRequest("GET", "/projects/12/releases/70/download/someOtherName.zip", nil)
@@ -611,17 +1015,121 @@ func TestDownloadRelease(t *testing.T) {
 	decoder = json.NewDecoder(w.Body)
 	decoder.Decode(&resp)
 	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, 0, len(sp))
 
 	// test success
-	sp.Error = nil
-	router = mux.NewRouter()
-	registerHandlers(router, nil, sp)
+	sp["12/70/p1.png"] = []byte{1, 2}
 
 	w = httptest.NewRecorder()
 	r, _ = http.NewRequest("GET", "/projects/12/releases/70/download/someOtherName.zip", nil)
 	router.ServeHTTP(w, r)
-	decoder = json.NewDecoder(w.Body)
-	decoder.Decode(&resp)
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Equal(t, "application/zip", w.Header()["Content-Type"][0])
+	assert.Equal(t, strconv.Itoa(w.Body.Len()), w.Header().Get("Content-Length"))
+	assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+	archive := w.Body.String()
+	assert.Equal(t, archive, string(sp["archives/12/70/someOtherName.zip"]))
+
+	// test the cached archive is served without listing the pages again
+	mListPages = func(db database.DB, release models.Release, opts models.ListOptions) ([]models.Page, error) {
+		return []models.Page{}, errors.New("some error")
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/projects/12/releases/70/download/someOtherName.zip", nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, archive, w.Body.String())
+
+	// test range request
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/projects/12/releases/70/download/someOtherName.zip", nil)
+	r.Header.Set("Range", "bytes=2-5")
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "4", w.Header().Get("Content-Length"))
+	assert.Equal(t, fmt.Sprintf("bytes 2-5/%d", len(archive)), w.Header().Get("Content-Range"))
+	assert.Equal(t, archive[2:6], w.Body.String())
+
+	// test multiple ranges
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/projects/12/releases/70/download/someOtherName.zip", nil)
+	r.Header.Set("Range", "bytes=0-1,4-5")
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	parts := multipartRanges(t, w)
+	assert.Equal(t, 2, len(parts))
+	assert.Equal(t, fmt.Sprintf("bytes 0-1/%d: %s", len(archive), archive[0:2]), parts[0])
+	assert.Equal(t, fmt.Sprintf("bytes 4-5/%d: %s", len(archive), archive[4:6]), parts[1])
+
+	// test resuming a download of the same archive
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/projects/12/releases/70/download/someOtherName.zip", nil)
+	r.Header.Set("Range", "bytes=10-")
+	r.Header.Set("If-Range", hashETag([]byte(archive)))
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, archive[10:], w.Body.String())
+
+	// test unsatisfiable range
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/projects/12/releases/70/download/someOtherName.zip", nil)
+	r.Header.Set("Range", fmt.Sprintf("bytes=%d-", len(archive)))
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, w.Code)
+}
+
+func TestDownloadReleaseCbz(t *testing.T) {
+	mFindProject = func(db database.DB, id uint32) (models.Project, error) {
+		return models.Project{Id: id, Name: "Project", Shorthand: "proj", Description: "desc"}, nil
+	}
+	releasedOn := time.Date(2017, 3, 2, 10, 0, 0, 0, time.UTC)
+	mFindRelease = func(db database.DB, p models.Project, id uint32) (models.Release, error) {
+		return models.Release{Id: id, ProjectID: p.Id, Identifier: "v2c13", Version: 1, Scanlator: "ims", Status: "released", ReleasedOn: releasedOn}, nil
+	}
+	mGenerateArchiveName = models.GenerateArchiveName
+	mGeneratePagePath = models.GeneratePagePath
+	mListPages = func(db database.DB, release models.Release, opts models.ListOptions) ([]models.Page, error) {
+		return []models.Page{models.Page{Name: "p1.png"}}, nil
+	}
+
+	mGenerateArchivePath = models.GenerateArchivePath
+	sp := SpMap{}
+	const bencPng = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAAAXNSR0IArs4c6QAAAARnQU1BAACxjwv8YQUAAAAJcEhZcwAADsQAAA7EAZUrDhsAAAANSURBVBhXY/j3/+9/AAnzA/pJMr8HAAAAAElFTkSuQmCC"
+	sp["12/70/p1.png"], _ = base64.StdEncoding.DecodeString(bencPng)
+	router := mux.NewRouter()
+	registerHandlers(router, nil, sp, TMismatchReject, DefaultLintConfig())
+
+	// the archive name must use the requested extension
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/projects/12/releases/70/download/proj - v2c13[1][ims].rar", nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/projects/12/releases/70/download/proj - v2c13[1][ims].cbz", nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/vnd.comicbook+zip", w.Header().Get("Content-Type"))
+
+	z, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(z.File))
+	assert.Equal(t, "p1.png", z.File[0].Name)
+	assert.Equal(t, "ComicInfo.xml", z.File[1].Name)
+
+	f, err := z.File[1].Open()
+	assert.Equal(t, nil, err)
+	defer f.Close()
+	var info ComicInfo
+	err = xml.NewDecoder(f).Decode(&info)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "Project", info.Series)
+	assert.Equal(t, "13", info.Number)
+	assert.Equal(t, "2", info.Volume)
+	assert.Equal(t, "desc", info.Summary)
+	assert.Equal(t, "ims", info.ScanInformation)
+	assert.Equal(t, 2017, info.Year)
+	assert.Equal(t, 1, info.PageCount)
+	assert.Equal(t, ComicPageInfo{Image: 0, Type: "FrontCover", ImageSize: len(sp["12/70/p1.png"]), ImageWidth: 1, ImageHeight: 1}, info.Pages[0])
 }
@@ -0,0 +1,89 @@
+package endpoints
+
+import (
+	"ims-release/database"
+	"ims-release/models"
+	"ims-release/storage_provider"
+
+	"log"
+	"net/http"
+	"time"
+)
+
+var (
+	mListDueReleases         = models.ListDueReleases
+	mPublishScheduledRelease = models.PublishScheduledRelease
+)
+
+// ReleaseSchedulerInterval is how often the scheduler looks for scheduled releases which are due.
+const ReleaseSchedulerInterval = time.Minute
+
+// runReleaseScheduler publishes scheduled releases once they are due. It never returns.
+func runReleaseScheduler(db database.DB, sp storage_provider.Binary, checks LintConfig, interval time.Duration) {
+	for tm := range time.Tick(interval) {
+		publishDueReleases(db, sp, checks, tm)
+	}
+}
+
+// publishDueReleases publishes the scheduled releases which are due at the given time, applying the same checks as
+// updateRelease. Releases which fail a check are returned to draft, while releases which cannot be checked or saved
+// are left scheduled and tried again on the next run. Releases which were changed while they were being checked, such
+// as by being cancelled, are left alone.
+func publishDueReleases(db database.DB, sp storage_provider.Binary, checks LintConfig, tm time.Time) {
+	releases, err := mListDueReleases(db, tm)
+	if err != nil {
+		log.Println("[---] Scheduler error:", err)
+		return
+	}
+
+	for _, release := range releases {
+		project, err := mFindProject(db, release.ProjectID)
+		if err != nil {
+			log.Println("[---] Scheduler error:", err)
+			continue
+		}
+
+		rsp := checkPublishable(db, sp, checks, project, release)
+		if rsp.Code >= http.StatusInternalServerError {
+			continue
+		}
+		release.Status = models.RStatusReleasedStr
+		if rsp != NoErr {
+			log.Println("[---] Scheduled release returned to draft:", release.Id)
+			release.Status = models.RStatusDraftStr
+		}
+		release.ReleasedOn = tm
+		release.PublishAt = nil
+
+		// without its release version, the release stays scheduled and is tried again later
+		var snapshotErr error
+		err = database.Transaction(db, func(tx database.DB) error {
+			release, err = mPublishScheduledRelease(tx, release, tm)
+			if err != nil || release.Status != models.RStatusReleasedStr {
+				return err
+			}
+			snapshotErr = snapshotRelease(tx, sp, project, release)
+			return snapshotErr
+		})
+		if err == models.ErrReleaseNotDue {
+			log.Println("[---] Scheduled release changed, skipped:", release.Id)
+			continue
+		} else if snapshotErr != nil {
+			log.Println("[---] Snapshot error:", snapshotErr)
+			continue
+		} else if err != nil {
+			log.Println("[---] Scheduler error:", err)
+			continue
+		}
+		if release.Status != models.RStatusReleasedStr {
+			continue
+		}
+
+		log.Println("[+++] Published scheduled release", release.Id)
+		// the archive will be generated on download instead
+		_, err = releaseArchive(db, sp, archiveFormats[0], project, release)
+		if err != nil {
+			log.Println("[---] Archive error:", err)
+		}
+	}
+}
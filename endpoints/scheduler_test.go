@@ -0,0 +1,114 @@
+package endpoints
+
+import (
+	"errors"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"ims-release/assert"
+	"ims-release/database"
+	"ims-release/models"
+	"testing"
+	"time"
+)
+
+func TestPublishDueReleases(t *testing.T) {
+	versions := mockReleaseVersions()
+	tm := time.Now()
+	publishAt := tm.Add(-time.Minute)
+	mListDueReleases = func(db database.DB, now time.Time) ([]models.Release, error) {
+		assert.Equal(t, tm, now)
+		return []models.Release{
+			models.Release{Id: 70, ProjectID: 12, Identifier: "c1", Version: 2, Scanlator: "ims", Status: "scheduled", PublishAt: &publishAt},
+			models.Release{Id: 71, ProjectID: 12, Identifier: "c2", Version: 2, Scanlator: "ims", Status: "scheduled", PublishAt: &publishAt},
+			models.Release{Id: 72, ProjectID: 13, Identifier: "c3", Version: 2, Scanlator: "ims", Status: "scheduled", PublishAt: &publishAt},
+		}, nil
+	}
+	mFindProject = func(db database.DB, id uint32) (models.Project, error) {
+		if id == 13 {
+			return models.Project{}, models.ErrNoSuchProject
+		}
+		return models.Project{Id: id, Shorthand: "proj"}, nil
+	}
+	mListPages = func(db database.DB, release models.Release, opts models.ListOptions) ([]models.Page, error) {
+		if release.Id == 71 {
+			return []models.Page{models.Page{Name: "p01.png", Role: models.PRoleContentStr}}, nil
+		}
+		return []models.Page{models.Page{Name: "p01.png", Role: models.PRoleCreditStr}}, nil
+	}
+	updated := map[uint32]models.Release{}
+	cancelled := map[uint32]bool{}
+	mPublishScheduledRelease = func(db database.DB, release models.Release, now time.Time) (models.Release, error) {
+		assert.Equal(t, tm, now)
+		if cancelled[release.Id] {
+			return release, models.ErrReleaseNotDue
+		}
+		updated[release.Id] = release
+		return release, nil
+	}
+	mGeneratePagePath = models.GeneratePagePath
+	mGenerateArchiveName = models.GenerateArchiveName
+	mGenerateArchivePath = models.GenerateArchivePath
+
+	sp := SpMap{"12/70/p01.png": {1}, "12/71/p01.png": {2}}
+	publishDueReleases(nil, sp, DefaultLintConfig(), tm)
+
+	// the first release is published, the second fails the checks and the third cannot be checked
+	assert.Equal(t, 2, len(updated))
+	assert.Equal(t, "released", updated[70].Status)
+	assert.Equal(t, tm, updated[70].ReleasedOn)
+	assert.Equal(t, true, updated[70].PublishAt == nil)
+	assert.Equal(t, true, sp.Exists(models.GenerateArchivePath(models.Project{Id: 12}, updated[70], "proj - c1[2][ims].zip")))
+	assert.Equal(t, 1, len(versions))
+	assert.Equal(t, tm, versions[2].ReleasedOn)
+	assert.Equal(t, "draft", updated[71].Status)
+	assert.Equal(t, true, updated[71].PublishAt == nil)
+
+	// test releases whose pages cannot be read are tried again later
+	updated = map[uint32]models.Release{}
+	delete(sp, "12/70/p01.png")
+	publishDueReleases(nil, sp, DefaultLintConfig(), tm)
+	assert.Equal(t, 1, len(updated))
+	assert.Equal(t, "draft", updated[71].Status)
+
+	// test a release cancelled while it is being checked is left alone
+	sp["12/70/p01.png"] = []byte{1}
+	delete(versions, 2)
+	archive := models.GenerateArchivePath(models.Project{Id: 12}, models.Release{Id: 70}, "proj - c1[2][ims].zip")
+	delete(sp, archive)
+	updated = map[uint32]models.Release{}
+	mListPages = func(db database.DB, release models.Release, opts models.ListOptions) ([]models.Page, error) {
+		cancelled[release.Id] = true
+		return []models.Page{models.Page{Name: "p01.png", Role: models.PRoleCreditStr}}, nil
+	}
+	publishDueReleases(nil, sp, DefaultLintConfig(), tm)
+	assert.Equal(t, 0, len(updated))
+	assert.Equal(t, 0, len(versions))
+	assert.Equal(t, false, sp.Exists(archive))
+
+	// test a release whose version cannot be recorded is rolled back and tried again later
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	defer db.Close()
+	cancelled = map[uint32]bool{}
+	mListPages = func(db database.DB, release models.Release, opts models.ListOptions) ([]models.Page, error) {
+		return []models.Page{models.Page{Name: "p01.png", Role: models.PRoleCreditStr}}, nil
+	}
+	mSaveReleaseVersion = func(db database.DB, v models.ReleaseVersion) (models.ReleaseVersion, error) {
+		return v, errors.New("some error")
+	}
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	publishDueReleases(db, sp, DefaultLintConfig(), tm)
+	assert.Equal(t, 0, len(versions))
+	assert.Equal(t, false, sp.Exists(archive))
+	assert.Equal(t, nil, mock.ExpectationsWereMet())
+
+	// test listing errors
+	mListDueReleases = func(db database.DB, now time.Time) ([]models.Release, error) {
+		return nil, errors.New("some error")
+	}
+	updated = map[uint32]models.Release{}
+	publishDueReleases(nil, sp, DefaultLintConfig(), tm)
+	assert.Equal(t, 0, len(updated))
+}
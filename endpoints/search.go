@@ -0,0 +1,74 @@
+package endpoints
+
+import (
+	"ims-release/database"
+	"ims-release/models"
+
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	mSearch = models.Search
+)
+
+var (
+	ErrMsgSearch = "Could not complete the search. Please try again later."
+	ErrRspSearch = NewApiResponse(http.StatusInternalServerError, &ErrMsgSearch)
+)
+
+// Limits on search requests.
+const (
+	DefaultSearchLimit   = 20
+	MaxSearchQueryLength = 255
+)
+
+type SearchResponse struct {
+	ApiResponse
+	Result []models.SearchResult `json:"result"`
+}
+
+func NewSearchResponse(a ApiResponse, r []models.SearchResult) SearchResponse {
+	return SearchResponse{ApiResponse: a, Result: r}
+}
+
+// RegisterSearchHandlers attaches the closures generated by each function defined below
+// to handle incoming requests to the appropriate endpoint. The search mode is taken from
+// the configuration, specified in main.
+func RegisterSearchHandlers(r *mux.Router, db database.DB, mode models.SearchMode) {
+	r.HandleFunc("/search", search(db, mode)).Methods("GET")
+}
+
+// GET /search
+// search finds the projects and releases matching the q parameter, best matches first.
+func search(db database.DB, mode models.SearchMode) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := strings.TrimSpace(r.URL.Query().Get("q"))
+		if q == "" || len(q) > MaxSearchQueryLength {
+			encodeHelper(w, NewSearchResponse(ErrRspBadRequest, []models.SearchResult{}))
+			return
+		}
+
+		var limit uint64 = DefaultSearchLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			var err error
+			limit, err = strconv.ParseUint(v, 10, 32)
+			if err != nil || limit == 0 || limit > MaxListLimit {
+				encodeHelper(w, NewSearchResponse(ErrRspBadRequest, []models.SearchResult{}))
+				return
+			}
+		}
+
+		results, err := mSearch(db, mode, q, uint32(limit))
+		if err != nil {
+			log.Println("[---] Search error:", err)
+			encodeHelper(w, NewSearchResponse(ErrRspSearch, []models.SearchResult{}))
+			return
+		}
+		encodeHelper(w, NewSearchResponse(NoErr, results))
+	}
+}
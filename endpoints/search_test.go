@@ -0,0 +1,74 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"ims-release/assert"
+	"ims-release/database"
+	"ims-release/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSearch(t *testing.T) {
+	results := []models.SearchResult{
+		{Type: models.SearchTypeProject, Score: 10, Project: models.Project{Id: 1, Shorthand: "robo"}},
+		{Type: models.SearchTypeRelease, Score: 5, Project: models.Project{Id: 1, Shorthand: "robo"},
+			Release: &models.Release{Id: 2, Identifier: "c12"}},
+	}
+	mSearch = func(db database.DB, mode models.SearchMode, q string, limit uint32) ([]models.SearchResult, error) {
+		assert.Equal(t, models.SModeLike, mode)
+		assert.Equal(t, "robo", q)
+		assert.Equal(t, uint32(DefaultSearchLimit), limit)
+		return results, nil
+	}
+
+	router := mux.NewRouter()
+	RegisterSearchHandlers(router, nil, models.SModeLike)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/search?q=+robo+", nil)
+	router.ServeHTTP(w, r)
+	var resp SearchResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, 2, len(resp.Result))
+	assert.Equal(t, models.SearchTypeProject, resp.Result[0].Type)
+	assert.Equal(t, (*models.Release)(nil), resp.Result[0].Release)
+	assert.Equal(t, models.SearchTypeRelease, resp.Result[1].Type)
+	assert.Equal(t, "c12", resp.Result[1].Release.Identifier)
+
+	// explicit limit
+	mSearch = func(db database.DB, mode models.SearchMode, q string, limit uint32) ([]models.SearchResult, error) {
+		assert.Equal(t, uint32(5), limit)
+		return results, nil
+	}
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/search?q=robo&limit=5", nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// bad parameters
+	for _, query := range []string{"", "?q=", "?q=+", "?q=robo&limit=0", "?q=robo&limit=x", "?q=robo&limit=1001"} {
+		w = httptest.NewRecorder()
+		r, _ = http.NewRequest("GET", "/search"+query, nil)
+		router.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	}
+
+	// search failure
+	mSearch = func(db database.DB, mode models.SearchMode, q string, limit uint32) ([]models.SearchResult, error) {
+		return []models.SearchResult{}, errors.New("error")
+	}
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/search?q=robo", nil)
+	router.ServeHTTP(w, r)
+	resp = SearchResponse{}
+	json.NewDecoder(w.Body).Decode(&resp)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, ErrMsgSearch, resp.getError().Error())
+	assert.Equal(t, 0, len(resp.Result))
+}
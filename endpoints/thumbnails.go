@@ -2,8 +2,10 @@ package endpoints
 
 import (
 	"bytes"
+	"errors"
 	"github.com/gorilla/mux"
 	"github.com/nfnt/resize"
+	"image/gif"
 	"image/jpeg"
 	"image/png"
 	"ims-release/database"
@@ -13,20 +15,139 @@ import (
 	"net/http"
 )
 
+var mGenerateThumbnailPath = models.GenerateThumbnailPath
+
+// thumbnailSize is the box a thumbnail has to fit in. The aspect ratio of the page is preserved.
+type thumbnailSize struct {
+	MaxWidth  uint
+	MaxHeight uint
+}
+
+// DefaultThumbnailSize is used when a request does not name a size.
+const DefaultThumbnailSize = "grid"
+
+// thumbnailSizes are the sizes thumbnails can be requested in. Every size is generated as soon as a page is uploaded.
+var thumbnailSizes = map[string]thumbnailSize{
+	"grid":    {300, 200},
+	"cover":   {400, 600},
+	"preview": {800, 1200},
+}
+
 func RegisterThumbnailHandlers(r *mux.Router, db database.DB, sp storage_provider.Binary) {
 	root := "/projects/{projectId:[0-9]+}/releases/{releaseId:[0-9]+}/thumbnails"
 	sr := r.PathPrefix(root).Subrouter()
 	sr.HandleFunc("/{name}", getThumbnail(db, sp)).Methods("GET")
 }
 
+// renderThumbnail scales a page image down to a thumbnail size, keeping its format. Animated GIFs are reduced to
+// their first frame.
+func renderThumbnail(page models.Page, data []byte, size thumbnailSize) ([]byte, error) {
+	image, err := decodePageImage(page.MimeType, data)
+	if err != nil {
+		return nil, err
+	}
+	image = resize.Thumbnail(size.MaxWidth, size.MaxHeight, image, resize.Bilinear)
+
+	buffer := bytes.NewBuffer([]byte{})
+	switch page.MimeType {
+	case models.MimeTypePng:
+		err = png.Encode(buffer, image)
+	case models.MimeTypeJpg:
+		err = jpeg.Encode(buffer, image, nil)
+	case models.MimeTypeWebp:
+		err = encodeWebp(buffer, image)
+	case models.MimeTypeGif:
+		err = gif.Encode(buffer, image, nil)
+	default:
+		err = errors.New("unsupported mime type " + page.MimeType.String())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// pageThumbnail produces a thumbnail of a page, generating and storing it if it has not been stored yet.
+func pageThumbnail(sp storage_provider.Binary, p models.Project, r models.Release, page models.Page, name string) ([]byte, error) {
+	key := mGenerateThumbnailPath(p, r, name, page.Name)
+	if sp.Exists(key) {
+		data, err := sp.Get(key)
+		if err == nil {
+			return data, nil
+		}
+		log.Println("[---] Thumbnail cache error:", err)
+	}
+
+	source, err := sp.Get(mGeneratePagePath(p, r, page.Name))
+	if err != nil {
+		return nil, err
+	}
+	data, err := renderThumbnail(page, source, thumbnailSizes[name])
+	if err != nil {
+		return nil, err
+	}
+
+	// failing to store the thumbnail only costs generating it again next time
+	err = sp.Set(key, data)
+	if err != nil {
+		log.Println("[---] Thumbnail cache error:", err)
+	}
+	return data, nil
+}
+
+// generateThumbnails stores the thumbnails of a newly uploaded page in every size.
+func generateThumbnails(sp storage_provider.Binary, p models.Project, r models.Release, page models.Page, data []byte) {
+	for name, size := range thumbnailSizes {
+		thumbnail, err := renderThumbnail(page, data, size)
+		if err == nil {
+			err = sp.Set(mGenerateThumbnailPath(p, r, name, page.Name), thumbnail)
+		}
+		if err != nil {
+			log.Println("[---] Thumbnail error:", err)
+		}
+	}
+}
+
+// deleteThumbnails removes the stored thumbnails of a page, so that they are not served for a page uploaded under
+// the same name later on.
+func deleteThumbnails(sp storage_provider.Binary, p models.Project, r models.Release, page models.Page) {
+	for name := range thumbnailSizes {
+		key := mGenerateThumbnailPath(p, r, name, page.Name)
+		deleteVariants(sp, key)
+		if !sp.Exists(key) {
+			continue
+		}
+		err := sp.Unset(key)
+		if err != nil {
+			log.Println("[---] Thumbnail delete error:", err)
+		}
+	}
+}
+
+// GET /projects/{projectId}/releases/{releaseId}/thumbnails/{name}?size={size}
+// getThumbnail serves a page scaled down to one of the thumbnail sizes.
 func getThumbnail(db database.DB, sp storage_provider.Binary) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("size")
+		if name == "" {
+			name = DefaultThumbnailSize
+		}
+		if _, ok := thumbnailSizes[name]; !ok {
+			log.Println("[---] Unknown thumbnail size:", name)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
 		project, release, err := fetchReleaseUsingRequestArgs(db, w, r, false)
 		if err != nil {
 			log.Println("[---] Release fetch error:", err)
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
+		if release.Status == models.RStatusRetractedStr {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
 
 		vars := mux.Vars(r)
 		page, err := mFindPageByName(db, release, vars["name"])
@@ -36,47 +157,19 @@ func getThumbnail(db database.DB, sp storage_provider.Binary) http.HandlerFunc {
 			return
 		}
 
-		path := mGeneratePagePath(project, release, page.Name)
-		imageBytes, err := sp.Get(path)
+		data, err := pageThumbnail(sp, project, release, page, name)
 		if err != nil {
 			log.Println("[---] error:", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		mimeType := page.MimeType
-		buffer := bytes.NewBuffer([]byte{})
-		const maxHeight = 300
-		const maxWidth = 200
-		switch mimeType {
-		case models.MimeTypePng:
-			image, err := png.Decode(bytes.NewReader(imageBytes))
-			if err != nil {
-				log.Println("[---] error:", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-			image = resize.Thumbnail(maxHeight, maxWidth, image, resize.Bilinear)
-			err = png.Encode(buffer, image)
-		case models.MimeTypeJpg:
-			image, err := jpeg.Decode(bytes.NewReader(imageBytes))
-			if err != nil {
-				log.Println("[---] error:", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-			image = resize.Thumbnail(maxHeight, maxWidth, image, resize.Bilinear)
-			err = jpeg.Encode(buffer, image, nil)
-		case models.MimeTypeUnknown:
-			fallthrough
-		default:
-			log.Println("[---] error:", err)
-			w.WriteHeader(http.StatusInternalServerError)
+		key := mGenerateThumbnailPath(project, release, name, page.Name)
+		contentType, data := negotiateImage(w, r, sp, key, page.MimeType.String(), data)
+		if pageNotModified(w, r, release, page, data) {
 			return
 		}
-
-		w.WriteHeader(http.StatusOK)
-		w.Header().Set("Content-Type", page.MimeType.String())
-		w.Write(buffer.Bytes())
+		w.Header().Set("Content-Type", contentType)
+		http.ServeContent(w, r, page.Name, page.CreatedAt, bytes.NewReader(data))
 	}
 }
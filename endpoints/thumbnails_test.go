@@ -0,0 +1,138 @@
+package endpoints
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"github.com/gorilla/mux"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"ims-release/assert"
+	"ims-release/database"
+	"ims-release/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetThumbnail(t *testing.T) {
+	mFindProject = func(db database.DB, id uint32) (models.Project, error) {
+		return models.Project{Id: id}, nil
+	}
+	mFindRelease = func(db database.DB, p models.Project, id uint32) (models.Release, error) {
+		return models.Release{Id: id, ProjectID: p.Id, Status: "released"}, nil
+	}
+	mGeneratePagePath = models.GeneratePagePath
+
+	sp := SpMap{}
+	router := mux.NewRouter()
+	registerHandlers(router, nil, sp, TMismatchReject, DefaultLintConfig())
+
+	// test page not found
+	mFindPageByName = func(db database.DB, release models.Release, name string) (models.Page, error) {
+		return models.Page{}, errors.New("some error")
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/projects/12/releases/70/thumbnails/thePage.png", nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// test page data not found
+	mFindPageByName = func(db database.DB, release models.Release, name string) (models.Page, error) {
+		assert.Equal(t, "thePage.png", name)
+		return models.Page{Name: name, Id: uint32(100), ReleaseID: release.Id, MimeType: models.MimeTypeFromFilename(name)}, nil
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/projects/12/releases/70/thumbnails/thePage.png", nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	// test unknown size
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/projects/12/releases/70/thumbnails/thePage.png?size=huge", nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// test success, the thumbnail is generated and stored
+	mGenerateThumbnailPath = models.GenerateThumbnailPath
+	sp["12/70/thePage.png"], _ = base64.StdEncoding.DecodeString(testPngBenc)
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/projects/12/releases/70/thumbnails/thePage.png", nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/png", w.Result().Header.Get("Content-Type"))
+	assert.Equal(t, CacheControlReleased, w.Result().Header.Get("Cache-Control"))
+	thumbnail := sp["thumbnails/12/70/grid/thePage.png"]
+	assert.Equal(t, string(thumbnail), w.Body.String())
+	etag := w.Result().Header.Get("ETag")
+	assert.Equal(t, hashETag(thumbnail), etag)
+
+	// test not modified
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/projects/12/releases/70/thumbnails/thePage.png", nil)
+	r.Header.Set("If-None-Match", "W/"+etag)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, 0, w.Body.Len())
+
+	// test the stored thumbnail is served without the page image
+	sp["thumbnails/12/70/cover/thePage.png"] = []byte("stored")
+	delete(sp, "12/70/thePage.png")
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/projects/12/releases/70/thumbnails/thePage.png?size=cover", nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "stored", w.Body.String())
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/projects/12/releases/70/thumbnails/thePage.png?size=preview", nil)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestRenderThumbnail(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 1000, 1500))
+	var buffer bytes.Buffer
+	jpeg.Encode(&buffer, img, nil)
+	page := models.Page{Name: "p1.jpg", MimeType: models.MimeTypeJpg}
+
+	for name, size := range map[string][2]int{"grid": {133, 200}, "cover": {400, 600}, "preview": {800, 1200}} {
+		data, err := renderThumbnail(page, buffer.Bytes(), thumbnailSizes[name])
+		assert.Equal(t, nil, err)
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "jpeg", format)
+		assert.Equal(t, size[0], cfg.Width)
+		assert.Equal(t, size[1], cfg.Height)
+	}
+
+	_, err := renderThumbnail(models.Page{Name: "p1.bmp"}, buffer.Bytes(), thumbnailSizes["grid"])
+	assert.NotEqual(t, nil, err)
+
+	// thumbnails keep the format of the page
+	rgba := image.NewRGBA(image.Rect(0, 0, 300, 450))
+	var webpData, gifData bytes.Buffer
+	encodeWebp(&webpData, rgba)
+	gif.Encode(&gifData, rgba, nil)
+	for _, page := range []struct {
+		models.Page
+		data   []byte
+		format string
+	}{
+		{models.Page{Name: "p1.webp", MimeType: models.MimeTypeWebp}, webpData.Bytes(), "webp"},
+		{models.Page{Name: "p1.gif", MimeType: models.MimeTypeGif}, gifData.Bytes(), "gif"},
+	} {
+		data, err := renderThumbnail(page.Page, page.data, thumbnailSizes["grid"])
+		assert.Equal(t, nil, err)
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, page.format, format)
+		assert.Equal(t, 133, cfg.Width)
+		assert.Equal(t, 200, cfg.Height)
+	}
+}
@@ -0,0 +1,369 @@
+package endpoints
+
+import (
+	"ims-release/database"
+	"ims-release/models"
+	"ims-release/storage_provider"
+
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	mNewUpload               = models.NewUpload
+	mFindUpload              = models.FindUpload
+	mListExpiredUploads      = models.ListExpiredUploads
+	mListReleaseUploads      = models.ListReleaseUploads
+	mSaveUpload              = models.SaveUpload
+	mAdvanceUpload           = models.AdvanceUpload
+	mRewindUpload            = models.RewindUpload
+	mDeleteUpload            = models.DeleteUpload
+	mGenerateUploadChunkPath = models.GenerateUploadChunkPath
+)
+
+var (
+	ErrMsgBadUpload        = "Invalid upload. The name must not be empty, the kind must be page or archive and the size must not be 0."
+	ErrRspBadUpload        = NewApiResponse(http.StatusExpectationFailed, &ErrMsgBadUpload)
+	ErrMsgUploadTooLarge   = "The upload is too large."
+	ErrRspUploadTooLarge   = NewApiResponse(http.StatusRequestEntityTooLarge, &ErrMsgUploadTooLarge)
+	ErrMsgCreateUpload     = "Could not save the upload. Please try again later."
+	ErrRspCreateUpload     = NewApiResponse(http.StatusInternalServerError, &ErrMsgCreateUpload)
+	ErrMsgUploadOffset     = "The offset of the chunk does not match the offset of the upload."
+	ErrRspUploadOffset     = NewApiResponse(http.StatusConflict, &ErrMsgUploadOffset)
+	ErrMsgUploadIncomplete = "The upload has not received all of its data yet."
+	ErrRspUploadIncomplete = NewApiResponse(http.StatusExpectationFailed, &ErrMsgUploadIncomplete)
+)
+
+// Headers of the chunked upload protocol. They follow the naming used by tus.
+const (
+	UploadOffsetHeader = "Upload-Offset"
+	UploadLengthHeader = "Upload-Length"
+)
+
+// UploadLifetime is how long an upload is kept after its last chunk was received.
+const UploadLifetime = 24 * time.Hour
+
+// MaxUploadSize is the largest upload which can be started. Uploads of single pages are limited to MaxPageSize, like
+// pages uploaded directly.
+const MaxUploadSize = 1 << 30
+
+// uploadSizeLimit returns the largest upload of the given kind which is accepted.
+func uploadSizeLimit(kind string) uint64 {
+	if kind == models.UploadKindPage {
+		return MaxPageSize
+	}
+	return MaxUploadSize
+}
+
+type UploadResponse struct {
+	ApiResponse
+	Result []models.Upload `json:"result"`
+}
+
+func NewUploadResponse(a ApiResponse, r []models.Upload) UploadResponse {
+	return UploadResponse{ApiResponse: a, Result: r}
+}
+
+// RegisterUploadHandlers attaches the handlers of resumable uploads. An upload is started with the name, kind and
+// size of the data, which is then sent in chunks, and finished once all of it was received. Finishing an upload
+// adds its pages the same way createPage and uploadArchive do.
+func RegisterUploadHandlers(r *mux.Router, db database.DB, sp storage_provider.Binary, mismatch TypeMismatchPolicy) {
+	root := "/projects/{projectId:[0-9]+}/releases/{releaseId:[0-9]+}/uploads"
+	sr := r.PathPrefix(root).Subrouter()
+	r.HandleFunc(root, createUpload(db, sp)).Methods("POST")
+	sr.HandleFunc("/{uploadId:[0-9a-f]+}", getUpload(db)).Methods("GET", "HEAD")
+	sr.HandleFunc("/{uploadId:[0-9a-f]+}", appendUpload(db, sp)).Methods("PATCH")
+	sr.HandleFunc("/{uploadId:[0-9a-f]+}", deleteUpload(db, sp)).Methods("DELETE")
+	sr.HandleFunc("/{uploadId:[0-9a-f]+}/finish", finishUpload(db, sp, mismatch)).Methods("POST")
+}
+
+// fetchUploadUsingRequestArgs looks up the upload named in the request, writing the response if it does not exist.
+func fetchUploadUsingRequestArgs(db database.DB, w http.ResponseWriter, r *http.Request, draftOnly bool) (models.Project, models.Release, models.Upload, error) {
+	project, release, err := fetchReleaseUsingRequestArgs(db, w, r, true)
+	if err != nil {
+		return project, release, models.Upload{}, err
+	}
+
+	if draftOnly && release.Status != models.RStatusDraftStr {
+		encodeHelper(w, NewUploadResponse(ErrRspMustBeDraft, []models.Upload{}))
+		return project, release, models.Upload{}, ErrRspMustBeDraft.getError()
+	}
+
+	vars := mux.Vars(r)
+	upload, err := mFindUpload(db, release, vars["uploadId"], time.Now())
+	if err == models.ErrNoSuchUpload {
+		encodeHelper(w, NewUploadResponse(ErrRspNotFound, []models.Upload{}))
+		return project, release, upload, err
+	} else if err != nil {
+		encodeHelper(w, NewUploadResponse(ErrRspUnexpected, []models.Upload{}))
+		return project, release, upload, err
+	}
+	return project, release, upload, nil
+}
+
+// removeUpload deletes an upload along with its staged chunks.
+func removeUpload(db database.DB, sp storage_provider.Binary, u models.Upload) error {
+	removeUploadChunks(sp, u)
+	_, err := mDeleteUpload(db, u)
+	return err
+}
+
+// removeUploadChunks removes the staged chunks of an upload from storage. Failures are only logged.
+func removeUploadChunks(sp storage_provider.Binary, u models.Upload) {
+	for i := uint32(0); i < u.Chunks; i++ {
+		key := mGenerateUploadChunkPath(u, i)
+		if !sp.Exists(key) {
+			continue
+		}
+		err := sp.Unset(key)
+		if err != nil {
+			log.Println("[---] Chunk delete error:", err)
+		}
+	}
+}
+
+// setUploadHeaders reports the progress of an upload in the tus headers.
+func setUploadHeaders(w http.ResponseWriter, u models.Upload) {
+	w.Header().Set(UploadOffsetHeader, strconv.FormatUint(u.Offset, 10))
+	w.Header().Set(UploadLengthHeader, strconv.FormatUint(u.Size, 10))
+}
+
+// POST /projects/{projectId}/releases/{releaseId}/uploads
+// createUpload starts an upload. Uploads which have expired are removed along the way.
+func createUpload(db database.DB, sp storage_provider.Binary) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, release, err := fetchReleaseUsingRequestArgs(db, w, r, true)
+		if err != nil {
+			log.Println("[---] Release fetch error:", err)
+			// response already set
+			return
+		}
+
+		if release.Status != models.RStatusDraftStr {
+			log.Println("[---] Invalid state:", ErrMsgMustBeDraft)
+			encodeHelper(w, NewUploadResponse(ErrRspMustBeDraft, []models.Upload{}))
+			return
+		}
+
+		request := models.Upload{}
+		err = decodeHelper(r, &request)
+		if err != nil {
+			encodeHelper(w, NewUploadResponse(ErrRspJsonDecode, []models.Upload{}))
+			return
+		}
+		if request.Size > uploadSizeLimit(request.Kind) {
+			encodeHelper(w, NewUploadResponse(ErrRspUploadTooLarge, []models.Upload{}))
+			return
+		}
+
+		tm := time.Now()
+		upload, err := mNewUpload(release, request.Name, request.Kind, request.Size, tm, UploadLifetime)
+		if err == nil {
+			err = upload.Validate()
+			if err != nil {
+				log.Println("[---] Upload error:", err)
+				encodeHelper(w, NewUploadResponse(ErrRspBadUpload, []models.Upload{}))
+				return
+			}
+			upload, err = mSaveUpload(db, upload)
+		}
+		if err != nil {
+			log.Println("[---] Upload error:", err)
+			encodeHelper(w, NewUploadResponse(ErrRspCreateUpload, []models.Upload{}))
+			return
+		}
+
+		expired, err := mListExpiredUploads(db, tm)
+		for _, u := range expired {
+			if err == nil {
+				err = removeUpload(db, sp, u)
+			}
+		}
+		if err != nil {
+			// expired uploads are never accepted anyway, only their chunks are left behind
+			log.Println("[---] Upload cleanup error:", err)
+		}
+
+		setUploadHeaders(w, upload)
+		encodeHelper(w, NewUploadResponse(NoErr, []models.Upload{upload}))
+	}
+}
+
+// GET /projects/{projectId}/releases/{releaseId}/uploads/{uploadId}
+// getUpload reports how much of an upload has been received.
+func getUpload(db database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, _, upload, err := fetchUploadUsingRequestArgs(db, w, r, false)
+		if err != nil {
+			log.Println("[---] Upload fetch error:", err)
+			// response already set
+			return
+		}
+
+		setUploadHeaders(w, upload)
+		encodeHelper(w, NewUploadResponse(NoErr, []models.Upload{upload}))
+	}
+}
+
+// PATCH /projects/{projectId}/releases/{releaseId}/uploads/{uploadId}
+// appendUpload stages the next chunk of an upload. The Upload-Offset header has to match the data received so far,
+// so that a chunk whose response was lost is not appended twice.
+func appendUpload(db database.DB, sp storage_provider.Binary) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, _, upload, err := fetchUploadUsingRequestArgs(db, w, r, true)
+		if err != nil {
+			log.Println("[---] Upload fetch error:", err)
+			// response already set
+			return
+		}
+
+		setUploadHeaders(w, upload)
+		offset, err := strconv.ParseUint(r.Header.Get(UploadOffsetHeader), 10, 64)
+		if err != nil {
+			encodeHelper(w, NewUploadResponse(ErrRspBadRequest, []models.Upload{}))
+			return
+		}
+		if offset != upload.Offset {
+			log.Printf("[---] Chunk at %d, upload at %d\n", offset, upload.Offset)
+			encodeHelper(w, NewUploadResponse(ErrRspUploadOffset, []models.Upload{upload}))
+			return
+		}
+
+		defer r.Body.Close()
+		remaining := upload.Size - upload.Offset
+		data, err := ioutil.ReadAll(io.LimitReader(r.Body, int64(remaining)+1))
+		if err != nil {
+			log.Println("[---] Read error:", err)
+			encodeHelper(w, NewUploadResponse(ErrRspReadUpload, []models.Upload{upload}))
+			return
+		}
+		if uint64(len(data)) > remaining {
+			encodeHelper(w, NewUploadResponse(ErrRspUploadTooLarge, []models.Upload{upload}))
+			return
+		}
+		if len(data) == 0 {
+			encodeHelper(w, NewUploadResponse(ErrRspBadRequest, []models.Upload{upload}))
+			return
+		}
+
+		// The chunk is claimed before it is stored, so that of several requests sending the same chunk only one
+		// stores it.
+		claimed, err := mAdvanceUpload(db, upload, uint64(len(data)), time.Now().Add(UploadLifetime))
+		if err != nil {
+			log.Println("[---] Upload error:", err)
+			if err == models.ErrUploadOffsetStale {
+				encodeHelper(w, NewUploadResponse(ErrRspUploadOffset, []models.Upload{upload}))
+			} else {
+				encodeHelper(w, NewUploadResponse(ErrRspCreateUpload, []models.Upload{upload}))
+			}
+			return
+		}
+
+		key := mGenerateUploadChunkPath(upload, upload.Chunks)
+		err = sp.Set(key, data)
+		if err != nil {
+			log.Println("[---] Chunk save error:", err)
+			_, err = mRewindUpload(db, claimed, uint64(len(data)))
+			if err != nil {
+				// the upload cannot be finished anymore and expires on its own
+				log.Println("[---] Upload error:", err)
+			}
+			encodeHelper(w, NewUploadResponse(ErrRspCreateUpload, []models.Upload{upload}))
+			return
+		}
+		upload = claimed
+
+		setUploadHeaders(w, upload)
+		encodeHelper(w, NewUploadResponse(NoErr, []models.Upload{upload}))
+	}
+}
+
+// DELETE /projects/{projectId}/releases/{releaseId}/uploads/{uploadId}
+// deleteUpload abandons an upload.
+func deleteUpload(db database.DB, sp storage_provider.Binary) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, _, upload, err := fetchUploadUsingRequestArgs(db, w, r, false)
+		if err != nil {
+			log.Println("[---] Upload fetch error:", err)
+			// response already set
+			return
+		}
+
+		err = removeUpload(db, sp, upload)
+		if err != nil {
+			log.Println("[---] Upload delete error:", err)
+			encodeHelper(w, NewUploadResponse(ErrRspUnexpected, []models.Upload{}))
+			return
+		}
+		encodeHelper(w, NewUploadResponse(NoErr, []models.Upload{upload}))
+	}
+}
+
+// POST /projects/{projectId}/releases/{releaseId}/uploads/{uploadId}/finish
+// finishUpload adds the pages of a completely received upload. The upload is removed afterwards, unless adding the
+// pages failed on the server's side and may be retried.
+func finishUpload(db database.DB, sp storage_provider.Binary, mismatch TypeMismatchPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		project, release, upload, err := fetchUploadUsingRequestArgs(db, w, r, true)
+		if err != nil {
+			log.Println("[---] Upload fetch error:", err)
+			// response already set
+			return
+		}
+
+		if upload.Offset != upload.Size {
+			setUploadHeaders(w, upload)
+			encodeHelper(w, NewPageResponse(ErrRspUploadIncomplete, []models.Page{}))
+			return
+		}
+
+		var buffer bytes.Buffer
+		for i := uint32(0); i < upload.Chunks; i++ {
+			chunk, err := sp.Get(mGenerateUploadChunkPath(upload, i))
+			if err != nil {
+				log.Println("[---] Chunk read error:", err)
+				encodeHelper(w, NewPageResponse(ErrRspCreatePage, []models.Page{}))
+				return
+			}
+			buffer.Write(chunk)
+		}
+
+		pages := []models.Page{}
+		rsp := NoErr
+		if uint64(buffer.Len()) > uploadSizeLimit(upload.Kind) {
+			log.Println("[---] Upload too large:", buffer.Len())
+			rsp = ErrRspUploadTooLarge
+		} else if upload.Kind == models.UploadKindArchive {
+			var uploads []pageUpload
+			uploads, rsp = readArchive(buffer.Bytes())
+			if rsp == NoErr {
+				pages, rsp = storeArchivePages(db, sp, mismatch, project, release, uploads)
+			}
+		} else {
+			var page models.Page
+			page, rsp = checkPageUpload(release, pageUpload{upload.Name, buffer.Bytes()}, mismatch, time.Now())
+			if rsp == NoErr {
+				page, rsp = storePage(db, sp, project, release, page, buffer.Bytes())
+			}
+			if rsp == NoErr {
+				pages = append(pages, page)
+			}
+		}
+
+		if rsp.Code < http.StatusInternalServerError {
+			err = removeUpload(db, sp, upload)
+			if err != nil {
+				// the upload expires on its own
+				log.Println("[---] Upload delete error:", err)
+			}
+		}
+		encodeHelper(w, NewPageResponse(rsp, pages))
+	}
+}
@@ -0,0 +1,386 @@
+package endpoints
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"ims-release/assert"
+	"ims-release/database"
+	"ims-release/models"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mockUploads keeps uploads in memory in place of the uploads table.
+func mockUploads() map[string]models.Upload {
+	uploads := map[string]models.Upload{}
+	mNewUpload = models.NewUpload
+	mGenerateUploadChunkPath = models.GenerateUploadChunkPath
+	mSaveUpload = func(db database.DB, u models.Upload) (models.Upload, error) {
+		u.Id = uint32(len(uploads) + 1)
+		uploads[u.Token] = u
+		return u, nil
+	}
+	mFindUpload = func(db database.DB, r models.Release, token string, tm time.Time) (models.Upload, error) {
+		u, ok := uploads[token]
+		if !ok || u.ReleaseID != r.Id || !u.ExpiresAt.After(tm) {
+			return models.Upload{}, models.ErrNoSuchUpload
+		}
+		return u, nil
+	}
+	mListReleaseUploads = func(db database.DB, r models.Release) ([]models.Upload, error) {
+		list := []models.Upload{}
+		for _, u := range uploads {
+			if u.ReleaseID == r.Id {
+				list = append(list, u)
+			}
+		}
+		return list, nil
+	}
+	mListExpiredUploads = func(db database.DB, tm time.Time) ([]models.Upload, error) {
+		expired := []models.Upload{}
+		for _, u := range uploads {
+			if !u.ExpiresAt.After(tm) {
+				expired = append(expired, u)
+			}
+		}
+		return expired, nil
+	}
+	mAdvanceUpload = func(db database.DB, u models.Upload, length uint64, expiresAt time.Time) (models.Upload, error) {
+		if uploads[u.Token].Offset != u.Offset {
+			return u, models.ErrUploadOffsetStale
+		}
+		u.Offset += length
+		u.Chunks++
+		u.ExpiresAt = expiresAt
+		uploads[u.Token] = u
+		return u, nil
+	}
+	mRewindUpload = func(db database.DB, u models.Upload, length uint64) (models.Upload, error) {
+		if uploads[u.Token].Offset != u.Offset {
+			return u, models.ErrUploadOffsetStale
+		}
+		u.Offset -= length
+		u.Chunks--
+		uploads[u.Token] = u
+		return u, nil
+	}
+	mDeleteUpload = func(db database.DB, u models.Upload) (models.Upload, error) {
+		delete(uploads, u.Token)
+		return u, nil
+	}
+	return uploads
+}
+
+func TestUpload(t *testing.T) {
+	mFindProject = func(db database.DB, id uint32) (models.Project, error) {
+		return models.Project{Id: id}, nil
+	}
+	mFindRelease = func(db database.DB, p models.Project, id uint32) (models.Release, error) {
+		return models.Release{Id: id, ProjectID: p.Id, Status: "draft"}, nil
+	}
+	mNewPage = models.NewPage
+	mGeneratePagePath = models.GeneratePagePath
+	mGenerateThumbnailPath = models.GenerateThumbnailPath
+	saved := []string{}
+	mockPageOrder()
+	mSavePage = func(db database.DB, page models.Page) (models.Page, error) {
+		saved = append(saved, page.Name)
+		return page, nil
+	}
+	uploads := mockUploads()
+
+	sp := SpMap{}
+	router := mux.NewRouter()
+	registerHandlers(router, nil, sp, TMismatchReject, DefaultLintConfig())
+	png, _ := base64.StdEncoding.DecodeString(testPngBenc)
+	const root = "/projects/12/releases/70/uploads"
+
+	// test an invalid upload
+	var resp UploadResponse
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", root, strings.NewReader(`{"name":"p01.png","kind":"release","size":10}`))
+	router.ServeHTTP(w, r)
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, ErrMsgBadUpload, resp.getError().Error())
+	assert.Equal(t, http.StatusExpectationFailed, w.Code)
+	assert.Equal(t, 0, len(uploads))
+
+	// test a page larger than pages may be
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", root, strings.NewReader(`{"name":"p01.png","kind":"page","size":`+strconv.Itoa(MaxPageSize+1)+`}`))
+	router.ServeHTTP(w, r)
+	resp = UploadResponse{}
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, ErrMsgUploadTooLarge, resp.getError().Error())
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, 0, len(uploads))
+
+	// test starting an upload
+	body := `{"name":"p01.png","kind":"page","size":` + strconv.Itoa(len(png)) + `}`
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", root, strings.NewReader(body))
+	router.ServeHTTP(w, r)
+	resp = UploadResponse{}
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, 1, len(resp.Result))
+	assert.Equal(t, "0", w.Result().Header.Get(UploadOffsetHeader))
+	assert.Equal(t, strconv.Itoa(len(png)), w.Result().Header.Get(UploadLengthHeader))
+	token := resp.Result[0].Token
+	assert.Equal(t, 64, len(token))
+
+	patch := func(offset int, data []byte) (*httptest.ResponseRecorder, UploadResponse) {
+		var resp UploadResponse
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("PATCH", root+"/"+token, bytes.NewReader(data))
+		r.Header.Set(UploadOffsetHeader, strconv.Itoa(offset))
+		router.ServeHTTP(w, r)
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w, resp
+	}
+	finish := func() (*httptest.ResponseRecorder, PageResponse) {
+		var resp PageResponse
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", root+"/"+token+"/finish", nil)
+		router.ServeHTTP(w, r)
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w, resp
+	}
+
+	// test the first chunk
+	w, resp = patch(0, png[:40])
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, uint64(40), resp.Result[0].Offset)
+	assert.Equal(t, "40", w.Result().Header.Get(UploadOffsetHeader))
+
+	// test a chunk sent again is rejected
+	w, resp = patch(0, png[:40])
+	assert.Equal(t, ErrMsgUploadOffset, resp.getError().Error())
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "40", w.Result().Header.Get(UploadOffsetHeader))
+
+	// test a chunk past the size is rejected
+	w, resp = patch(40, append(png[40:], 0))
+	assert.Equal(t, ErrMsgUploadTooLarge, resp.getError().Error())
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// test a request which lost the race for a chunk leaves the stored chunk alone
+	find := mFindUpload
+	mFindUpload = func(db database.DB, r models.Release, token string, tm time.Time) (models.Upload, error) {
+		u, err := find(db, r, token, tm)
+		u.Offset = 0
+		u.Chunks = 0
+		return u, err
+	}
+	w, resp = patch(0, png[:30])
+	mFindUpload = find
+	assert.Equal(t, ErrMsgUploadOffset, resp.getError().Error())
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, string(png[:40]), string(sp["uploads/"+token+"/0"]))
+
+	// test a chunk which cannot be stored is taken back
+	sp["uploads/"+token+"/1"] = []byte{9}
+	w, resp = patch(40, png[40:50])
+	assert.Equal(t, ErrMsgCreateUpload, resp.getError().Error())
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, uint64(40), uploads[token].Offset)
+	assert.Equal(t, uint32(1), uploads[token].Chunks)
+	assert.Equal(t, string([]byte{9}), string(sp["uploads/"+token+"/1"]))
+	delete(sp, "uploads/"+token+"/1")
+
+	// test progress is reported
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", root+"/"+token, nil)
+	router.ServeHTTP(w, r)
+	resp = UploadResponse{}
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, uint64(40), resp.Result[0].Offset)
+	assert.Equal(t, "40", w.Result().Header.Get(UploadOffsetHeader))
+
+	// test finishing an incomplete upload
+	w, presp := finish()
+	assert.Equal(t, ErrMsgUploadIncomplete, presp.getError().Error())
+	assert.Equal(t, 0, len(saved))
+
+	// test the last chunk and finishing
+	w, resp = patch(40, png[40:])
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, uint64(len(png)), resp.Result[0].Offset)
+
+	w, presp = finish()
+	assert.Equal(t, nil, presp.getError())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "p01.png", presp.Result[0].Name)
+	assert.Equal(t, "p01.png", strings.Join(saved, ","))
+	assert.Equal(t, string(png), string(sp["12/70/p01.png"]))
+	assert.Equal(t, 0, len(uploads))
+	assert.Equal(t, false, sp.Exists("uploads/"+token+"/0"))
+
+	// test the upload is gone
+	w, presp = finish()
+	assert.Equal(t, ErrMsgNotFound, presp.getError().Error())
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestUploadArchiveInChunks(t *testing.T) {
+	mFindProject = func(db database.DB, id uint32) (models.Project, error) {
+		return models.Project{Id: id}, nil
+	}
+	mFindRelease = func(db database.DB, p models.Project, id uint32) (models.Release, error) {
+		return models.Release{Id: id, ProjectID: p.Id, Status: "draft"}, nil
+	}
+	mNewPage = models.NewPage
+	mGeneratePagePath = models.GeneratePagePath
+	mGenerateThumbnailPath = models.GenerateThumbnailPath
+	mockPageOrder()
+	mSavePage = func(db database.DB, page models.Page) (models.Page, error) {
+		return page, nil
+	}
+	uploads := mockUploads()
+
+	sp := SpMap{}
+	router := mux.NewRouter()
+	registerHandlers(router, nil, sp, TMismatchReject, DefaultLintConfig())
+	png, _ := base64.StdEncoding.DecodeString(testPngBenc)
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for _, name := range []string{"p01.png", "p02.png"} {
+		fw, _ := zw.Create(name)
+		fw.Write(png)
+	}
+	zw.Close()
+	data := archive.Bytes()
+
+	u, _ := models.NewUpload(models.Release{Id: 70}, "ch1.cbz", models.UploadKindArchive, uint64(len(data)), time.Now(), time.Hour)
+	uploads[u.Token] = u
+	root := "/projects/12/releases/70/uploads/" + u.Token
+
+	for offset := 0; offset < len(data); offset += 100 {
+		end := offset + 100
+		if end > len(data) {
+			end = len(data)
+		}
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("PATCH", root, bytes.NewReader(data[offset:end]))
+		r.Header.Set(UploadOffsetHeader, strconv.Itoa(offset))
+		router.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	var resp PageResponse
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", root+"/finish", nil)
+	router.ServeHTTP(w, r)
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, 2, len(resp.Result))
+	assert.Equal(t, string(png), string(sp["12/70/p02.png"]))
+	assert.Equal(t, 0, len(uploads))
+	assert.Equal(t, false, sp.Exists(models.GenerateUploadChunkPath(u, 0)))
+}
+
+func TestFinishUploadTooLarge(t *testing.T) {
+	mFindProject = func(db database.DB, id uint32) (models.Project, error) {
+		return models.Project{Id: id}, nil
+	}
+	mFindRelease = func(db database.DB, p models.Project, id uint32) (models.Release, error) {
+		return models.Release{Id: id, ProjectID: p.Id, Status: "draft"}, nil
+	}
+	saved := []string{}
+	mSavePage = func(db database.DB, page models.Page) (models.Page, error) {
+		saved = append(saved, page.Name)
+		return page, nil
+	}
+	uploads := mockUploads()
+
+	sp := SpMap{}
+	router := mux.NewRouter()
+	registerHandlers(router, nil, sp, TMismatchReject, DefaultLintConfig())
+
+	// a page upload whose data ends up larger than a page, as for one started before the limit
+	u, _ := models.NewUpload(models.Release{Id: 70}, "p01.png", models.UploadKindPage, MaxPageSize+1, time.Now(), time.Hour)
+	u.Offset = u.Size
+	u.Chunks = 1
+	uploads[u.Token] = u
+	sp[models.GenerateUploadChunkPath(u, 0)] = make([]byte, MaxPageSize+1)
+
+	var resp PageResponse
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/projects/12/releases/70/uploads/"+u.Token+"/finish", nil)
+	router.ServeHTTP(w, r)
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, ErrMsgUploadTooLarge, resp.getError().Error())
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, 0, len(saved))
+	assert.Equal(t, 0, len(uploads))
+	assert.Equal(t, 0, len(sp))
+}
+
+func TestUploadExpiry(t *testing.T) {
+	mFindProject = func(db database.DB, id uint32) (models.Project, error) {
+		return models.Project{Id: id}, nil
+	}
+	mFindRelease = func(db database.DB, p models.Project, id uint32) (models.Release, error) {
+		return models.Release{Id: id, ProjectID: p.Id, Status: "draft"}, nil
+	}
+	uploads := mockUploads()
+
+	sp := SpMap{}
+	router := mux.NewRouter()
+	registerHandlers(router, nil, sp, TMismatchReject, DefaultLintConfig())
+
+	tm := time.Now()
+	old, _ := models.NewUpload(models.Release{Id: 70}, "p01.png", models.UploadKindPage, 10, tm.Add(-2*UploadLifetime), UploadLifetime)
+	old.Chunks = 1
+	uploads[old.Token] = old
+	sp[models.GenerateUploadChunkPath(old, 0)] = []byte("chunk")
+
+	// test an expired upload is not found
+	var resp UploadResponse
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/projects/12/releases/70/uploads/"+old.Token, nil)
+	router.ServeHTTP(w, r)
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, ErrMsgNotFound, resp.getError().Error())
+
+	// test expired uploads are removed when an upload is started
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/projects/12/releases/70/uploads", strings.NewReader(`{"name":"p02.png","kind":"page","size":10}`))
+	router.ServeHTTP(w, r)
+	resp = UploadResponse{}
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, 1, len(uploads))
+	assert.Equal(t, 0, len(sp))
+
+	// test starting an upload fails when it cannot be saved
+	mSaveUpload = func(db database.DB, u models.Upload) (models.Upload, error) {
+		return u, errors.New("some error")
+	}
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/projects/12/releases/70/uploads", strings.NewReader(`{"name":"p02.png","kind":"page","size":10}`))
+	router.ServeHTTP(w, r)
+	resp = UploadResponse{}
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	assert.Equal(t, ErrMsgCreateUpload, resp.getError().Error())
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
@@ -0,0 +1,127 @@
+package endpoints
+
+import (
+	"ims-release/models"
+	"ims-release/storage_provider"
+
+	"bytes"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/HugoSmits86/nativewebp"
+)
+
+var mGenerateVariantPath = models.GenerateVariantPath
+
+// imageVariant is a format page images and thumbnails are converted to for clients which accept it. AVIF is left
+// out until there is a pure Go encoder for it.
+type imageVariant struct {
+	ContentType string
+	Extension   string
+	encode      func(w io.Writer, img image.Image) error
+}
+
+var imageVariants = []imageVariant{
+	{"image/webp", ".webp", encodeWebp},
+}
+
+func encodeWebp(w io.Writer, img image.Image) error {
+	return nativewebp.Encode(w, img, nil)
+}
+
+// acceptsType reports whether the Accept header of a request lists a content type. Wildcards are not taken into
+// account, since clients which accept anything do not necessarily support the newer formats.
+func acceptsType(r *http.Request, contentType string) bool {
+	for _, header := range r.Header["Accept"] {
+		for _, mediaRange := range strings.Split(header, ",") {
+			params := strings.Split(mediaRange, ";")
+			if !strings.EqualFold(strings.TrimSpace(params[0]), contentType) {
+				continue
+			}
+			for _, param := range params[1:] {
+				param = strings.TrimSpace(param)
+				if !strings.HasPrefix(param, "q=") {
+					continue
+				}
+				q, err := strconv.ParseFloat(param[2:], 64)
+				if err == nil && q == 0 {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// variantData produces an image in another format, converting and storing it if it has not been stored yet.
+func variantData(sp storage_provider.Binary, key string, data []byte, v imageVariant) ([]byte, error) {
+	variantKey := mGenerateVariantPath(key, v.Extension)
+	if sp.Exists(variantKey) {
+		variant, err := sp.Get(variantKey)
+		if err == nil {
+			return variant, nil
+		}
+		log.Println("[---] Variant cache error:", err)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var buffer bytes.Buffer
+	err = v.encode(&buffer, img)
+	if err != nil {
+		return nil, err
+	}
+
+	// failing to store the variant only costs converting the image again next time
+	err = sp.Set(variantKey, buffer.Bytes())
+	if err != nil {
+		log.Println("[---] Variant cache error:", err)
+	}
+	return buffer.Bytes(), nil
+}
+
+// negotiateImage picks the representation of an image stored under key to serve, based on the Accept header of the
+// request. A variant is only served if it is smaller than the original, which lossless WebP often is not for
+// photographic JPEG images. GIF images are always served as they are, since converting them would drop any
+// animation.
+func negotiateImage(w http.ResponseWriter, r *http.Request, sp storage_provider.Binary, key string, contentType string, data []byte) (string, []byte) {
+	w.Header().Add("Vary", "Accept")
+	if contentType == models.MimeTypeGifStr {
+		return contentType, data
+	}
+	for _, v := range imageVariants {
+		if v.ContentType == contentType || !acceptsType(r, v.ContentType) {
+			continue
+		}
+		variant, err := variantData(sp, key, data, v)
+		if err != nil {
+			log.Println("[---] Variant error:", err)
+			continue
+		}
+		if len(variant) < len(data) {
+			return v.ContentType, variant
+		}
+	}
+	return contentType, data
+}
+
+// deleteVariants removes the stored variants of an image stored under key.
+func deleteVariants(sp storage_provider.Binary, key string) {
+	for _, v := range imageVariants {
+		variantKey := mGenerateVariantPath(key, v.Extension)
+		if !sp.Exists(variantKey) {
+			continue
+		}
+		err := sp.Unset(variantKey)
+		if err != nil {
+			log.Println("[---] Variant delete error:", err)
+		}
+	}
+}
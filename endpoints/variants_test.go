@@ -0,0 +1,120 @@
+package endpoints
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/gorilla/mux"
+	"image"
+	"image/jpeg"
+	"ims-release/assert"
+	"ims-release/database"
+	"ims-release/models"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAcceptsType(t *testing.T) {
+	for accept, expected := range map[string]bool{
+		"":                                   false,
+		"*/*":                                false,
+		"image/*":                            false,
+		"image/webp":                         true,
+		"image/avif,image/webp,*/*;q=0.8":    true,
+		"image/png, IMAGE/WEBP;q=0.5":        true,
+		"image/webp;q=0, */*":                false,
+		"image/webp; q=0.000, image/png":     false,
+		"image/webpx, image/png;q=1, text/*": false,
+	} {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept", accept)
+		assert.Equal(t, expected, acceptsType(r, "image/webp"))
+	}
+}
+
+func TestNegotiateImage(t *testing.T) {
+	mGenerateVariantPath = models.GenerateVariantPath
+	sp := SpMap{}
+	png, _ := base64.StdEncoding.DecodeString(testPngBenc)
+
+	// test the original is served without an Accept header
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	contentType, data := negotiateImage(w, r, sp, "12/70/p1.png", "image/png", png)
+	assert.Equal(t, "image/png", contentType)
+	assert.Equal(t, string(png), string(data))
+	assert.Equal(t, "Accept", w.Header().Get("Vary"))
+	assert.Equal(t, 0, len(sp))
+
+	// test the variant is generated and stored
+	r.Header.Set("Accept", "image/webp,*/*")
+	contentType, data = negotiateImage(w, r, sp, "12/70/p1.png", "image/png", png)
+	assert.Equal(t, "image/webp", contentType)
+	assert.Equal(t, string(sp["variants/12/70/p1.png.webp"]), string(data))
+	assert.Equal(t, "RIFF", string(data[:4]))
+	assert.Equal(t, "WEBP", string(data[8:12]))
+
+	// test the stored variant is used
+	sp["variants/12/70/p1.png.webp"] = []byte("stored")
+	_, data = negotiateImage(w, r, sp, "12/70/p1.png", "image/png", png)
+	assert.Equal(t, "stored", string(data))
+
+	// test the original is served if the variant is not smaller
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	var buffer bytes.Buffer
+	jpeg.Encode(&buffer, img, &jpeg.Options{Quality: 10})
+
+	contentType, data = negotiateImage(w, r, sp, "12/70/p2.jpg", "image/jpeg", buffer.Bytes())
+	assert.Equal(t, "image/jpeg", contentType)
+	assert.Equal(t, buffer.String(), string(data))
+	assert.Equal(t, true, sp.Exists("variants/12/70/p2.jpg.webp"))
+
+	// test undecodable images are served as they are
+	contentType, data = negotiateImage(w, r, sp, "12/70/p3.png", "image/png", []byte{1, 2})
+	assert.Equal(t, "image/png", contentType)
+	assert.Equal(t, 2, len(data))
+}
+
+func TestGetPageWebp(t *testing.T) {
+	mFindProject = func(db database.DB, id uint32) (models.Project, error) {
+		return models.Project{Id: id}, nil
+	}
+	mFindRelease = func(db database.DB, p models.Project, id uint32) (models.Release, error) {
+		return models.Release{Id: id, ProjectID: p.Id, Status: "released"}, nil
+	}
+	mFindPageByName = func(db database.DB, release models.Release, name string) (models.Page, error) {
+		return models.Page{Name: name, Id: uint32(100), ReleaseID: release.Id, MimeType: models.MimeTypeFromFilename(name)}, nil
+	}
+	mGeneratePagePath = models.GeneratePagePath
+	mGenerateThumbnailPath = models.GenerateThumbnailPath
+	mGenerateVariantPath = models.GenerateVariantPath
+
+	sp := SpMap{}
+	sp["12/70/thePage.png"], _ = base64.StdEncoding.DecodeString(testPngBenc)
+	router := mux.NewRouter()
+	registerHandlers(router, nil, sp, TMismatchReject, DefaultLintConfig())
+
+	for _, path := range []string{"/projects/12/releases/70/pages/thePage.png", "/projects/12/releases/70/thumbnails/thePage.png"} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		r.Header.Set("Accept", "image/webp,*/*")
+		router.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "image/webp", w.Result().Header.Get("Content-Type"))
+		assert.Equal(t, "Accept", w.Result().Header.Get("Vary"))
+		etag := w.Result().Header.Get("ETag")
+		assert.Equal(t, hashETag(w.Body.Bytes()), etag)
+
+		// the original has a different entity tag
+		w = httptest.NewRecorder()
+		r, _ = http.NewRequest("GET", path, nil)
+		r.Header.Set("If-None-Match", etag)
+		router.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "image/png", w.Result().Header.Get("Content-Type"))
+	}
+	assert.Equal(t, true, sp.Exists("variants/12/70/thePage.png.webp"))
+	assert.Equal(t, true, sp.Exists("variants/thumbnails/12/70/grid/thePage.png.webp"))
+}
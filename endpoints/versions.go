@@ -0,0 +1,276 @@
+package endpoints
+
+import (
+	"ims-release/database"
+	"ims-release/models"
+	"ims-release/storage_provider"
+
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	mListReleaseVersions     = models.ListReleaseVersions
+	mFindReleaseVersion      = models.FindReleaseVersion
+	mSaveReleaseVersion      = models.SaveReleaseVersion
+	mGenerateVersionPagePath = models.GenerateVersionPagePath
+)
+
+var (
+	ErrMsgListVersions = "Could not obtain a list of versions. Please try again later."
+	ErrRspListVersions = NewApiResponse(http.StatusInternalServerError, &ErrMsgListVersions)
+)
+
+type ReleaseVersionResponse struct {
+	ApiResponse
+	Result []models.ReleaseVersion `json:"result"`
+}
+
+func NewReleaseVersionResponse(a ApiResponse, r []models.ReleaseVersion) ReleaseVersionResponse {
+	return ReleaseVersionResponse{ApiResponse: a, Result: r}
+}
+
+type VersionDiffResponse struct {
+	ApiResponse
+	Result []models.VersionPageChange `json:"result"`
+}
+
+func NewVersionDiffResponse(a ApiResponse, r []models.VersionPageChange) VersionDiffResponse {
+	return VersionDiffResponse{ApiResponse: a, Result: r}
+}
+
+// RegisterVersionHandlers attaches the closures generated by each function defined below
+// to handle incoming requests to the appropriate endpoint using a subrouter with an
+// appropriate prefix, specified in main.
+func RegisterVersionHandlers(r *mux.Router, db database.DB, sp storage_provider.Binary) {
+	root := "/projects/{projectId:[0-9]+}/releases/{releaseId:[0-9]+}/versions"
+	sr := r.PathPrefix(root).Subrouter()
+	r.HandleFunc(root, listReleaseVersions(db)).Methods("GET")
+	sr.HandleFunc("/{version:[0-9]+}", getReleaseVersion(db)).Methods("GET")
+	sr.HandleFunc("/{version:[0-9]+}/diff", diffReleaseVersions(db)).Methods("GET")
+	sr.HandleFunc("/{version:[0-9]+}/download/{name}", downloadReleaseVersion(db, sp)).Methods("GET")
+}
+
+// snapshotRelease records a release which has just been published as a release version. The image data of every
+// page is copied to storage under its hash, so that the version outlives later changes to the release's pages.
+func snapshotRelease(db database.DB, sp storage_provider.Binary, p models.Project, r models.Release) error {
+	pages, err := loadArchivePages(db, sp, p, r)
+	if err != nil {
+		return err
+	}
+
+	versionPages := []models.VersionPage{}
+	for _, page := range pages {
+		sum := sha256.Sum256(page.Data)
+		hash := hex.EncodeToString(sum[:])
+		key := mGenerateVersionPagePath(p, r, hash)
+		if !sp.Exists(key) {
+			err = sp.Set(key, page.Data)
+			if err != nil {
+				return fmt.Errorf("failed to store image data for %s: %v", key, err)
+			}
+		}
+		versionPages = append(versionPages, models.VersionPage{
+			Name:      page.Name,
+			MimeType:  page.MimeType,
+			Role:      page.Role,
+			SortIndex: page.SortIndex,
+			Hash:      hash,
+		})
+	}
+
+	_, err = mSaveReleaseVersion(db, models.NewReleaseVersion(r, versionPages))
+	return err
+}
+
+// loadVersionPages fetches the image data of every page of a release version, in page order.
+func loadVersionPages(sp storage_provider.Binary, p models.Project, r models.Release, v models.ReleaseVersion) ([]archivePage, error) {
+	result := []archivePage{}
+	for _, page := range v.Pages {
+		key := mGenerateVersionPagePath(p, r, page.Hash)
+		data, err := sp.Get(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve image data for %s: %v", key, err)
+		}
+		result = append(result, archivePage{models.Page{
+			Name:      page.Name,
+			ReleaseID: r.Id,
+			MimeType:  page.MimeType,
+			SortIndex: page.SortIndex,
+			Role:      page.Role,
+		}, data})
+	}
+	return result, nil
+}
+
+// fetchVersionUsingRequestArgs looks up the release version named by the request, along with its release and project.
+// Callers have to check whether the release has been retracted.
+func fetchVersionUsingRequestArgs(db database.DB, w http.ResponseWriter, r *http.Request, writeResponse bool) (models.Project, models.Release, models.ReleaseVersion, error) {
+	project, release, err := fetchReleaseUsingRequestArgs(db, w, r, writeResponse)
+	if err != nil {
+		return project, release, models.ReleaseVersion{}, err
+	}
+
+	version, err := strconv.ParseUint(mux.Vars(r)["version"], 10, 32)
+	if err != nil {
+		if writeResponse {
+			encodeHelper(w, NewReleaseVersionResponse(ErrRspBadRequest, []models.ReleaseVersion{}))
+		}
+		return project, release, models.ReleaseVersion{}, err
+	}
+
+	v, err := mFindReleaseVersion(db, release, uint32(version))
+	if err != nil {
+		if writeResponse {
+			encodeHelper(w, NewReleaseVersionResponse(ErrRspNotFound, []models.ReleaseVersion{}))
+		}
+		return project, release, models.ReleaseVersion{}, err
+	}
+	return project, release, v, nil
+}
+
+// GET /projects/{projectId}/releases/{releaseId}/versions
+// listReleaseVersions lists the published versions of a release, oldest first, without their pages.
+func listReleaseVersions(db database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, release, err := fetchReleaseUsingRequestArgs(db, w, r, true)
+		if err != nil {
+			log.Println("[---] Release fetch error:", err)
+			// response already set
+			return
+		}
+		if release.Status == models.RStatusRetractedStr {
+			encodeHelper(w, NewReleaseVersionResponse(ErrRspReleaseRetracted, []models.ReleaseVersion{}))
+			return
+		}
+
+		versions, err := mListReleaseVersions(db, release)
+		if err != nil {
+			log.Println("[---] Version list error:", err)
+			encodeHelper(w, NewReleaseVersionResponse(ErrRspListVersions, []models.ReleaseVersion{}))
+			return
+		}
+		encodeHelper(w, NewReleaseVersionResponse(NoErr, versions))
+	}
+}
+
+// GET /projects/{projectId}/releases/{releaseId}/versions/{version}
+// getReleaseVersion obtains a version of a release along with its pages.
+func getReleaseVersion(db database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, release, v, err := fetchVersionUsingRequestArgs(db, w, r, true)
+		if err != nil {
+			log.Println("[---] Version fetch error:", err)
+			// response already set
+			return
+		}
+		if release.Status == models.RStatusRetractedStr {
+			encodeHelper(w, NewReleaseVersionResponse(ErrRspReleaseRetracted, []models.ReleaseVersion{}))
+			return
+		}
+		encodeHelper(w, NewReleaseVersionResponse(NoErr, []models.ReleaseVersion{v}))
+	}
+}
+
+// GET /projects/{projectId}/releases/{releaseId}/versions/{version}/diff?base={base}
+// diffReleaseVersions lists the pages which changed from the base version to a version of a release. Without a base,
+// the version is compared to the one published before it, or to an empty release if it is the first one.
+func diffReleaseVersions(db database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, release, target, err := fetchVersionUsingRequestArgs(db, w, r, true)
+		if err != nil {
+			log.Println("[---] Version fetch error:", err)
+			// response already set
+			return
+		}
+		if release.Status == models.RStatusRetractedStr {
+			encodeHelper(w, NewVersionDiffResponse(ErrRspReleaseRetracted, []models.VersionPageChange{}))
+			return
+		}
+
+		base := models.ReleaseVersion{}
+		if v := r.URL.Query().Get("base"); v != "" {
+			version, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				encodeHelper(w, NewVersionDiffResponse(ErrRspBadRequest, []models.VersionPageChange{}))
+				return
+			}
+			base, err = mFindReleaseVersion(db, release, uint32(version))
+			if err != nil {
+				log.Println("[---] Version fetch error:", err)
+				encodeHelper(w, NewVersionDiffResponse(ErrRspNotFound, []models.VersionPageChange{}))
+				return
+			}
+		} else {
+			versions, err := mListReleaseVersions(db, release)
+			if err != nil {
+				log.Println("[---] Version list error:", err)
+				encodeHelper(w, NewVersionDiffResponse(ErrRspListVersions, []models.VersionPageChange{}))
+				return
+			}
+			for _, v := range versions {
+				if v.Version < target.Version && v.Version > base.Version {
+					base = v
+				}
+			}
+			if base.Version != 0 {
+				base, err = mFindReleaseVersion(db, release, base.Version)
+				if err != nil {
+					log.Println("[---] Version fetch error:", err)
+					encodeHelper(w, NewVersionDiffResponse(ErrRspUnexpected, []models.VersionPageChange{}))
+					return
+				}
+			}
+		}
+		encodeHelper(w, NewVersionDiffResponse(NoErr, models.DiffReleaseVersions(base, target)))
+	}
+}
+
+// GET /projects/{projectId}/releases/{releaseId}/versions/{version}/download/{name}
+// downloadReleaseVersion produces an archive of a version of a release, as it was when the version was published.
+// Names and formats work as for downloadRelease, with the version's identifier and version number.
+func downloadReleaseVersion(db database.DB, sp storage_provider.Binary) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		project, release, v, err := fetchVersionUsingRequestArgs(db, w, r, false)
+		if err != nil {
+			log.Println("[---] Version fetch error:", err)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if release.Status == models.RStatusRetractedStr {
+			log.Println("the requested release has been retracted")
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+
+		archiveName := mux.Vars(r)["name"]
+		published := v.Release(release)
+		format, ok := findArchiveFormat(archiveName)
+		archiveNameExpected := format.archiveName(project, published)
+		if !ok || archiveNameExpected != archiveName {
+			log.Printf("requested archive name '%s' does not match expected '%s'\n", archiveName, archiveNameExpected)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		data, err := cachedArchive(sp, format, project, published, func() ([]archivePage, error) {
+			return loadVersionPages(sp, project, release, v)
+		})
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", format.ContentType)
+		w.Header().Set("ETag", hashETag(data))
+		http.ServeContent(w, r, archiveName, v.ReleasedOn, bytes.NewReader(data))
+	}
+}
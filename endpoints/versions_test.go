@@ -0,0 +1,206 @@
+package endpoints
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"ims-release/assert"
+	"ims-release/database"
+	"ims-release/models"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// mockReleaseVersions keeps release versions in memory in place of the release versions tables, keyed by version.
+func mockReleaseVersions() map[uint32]models.ReleaseVersion {
+	versions := map[uint32]models.ReleaseVersion{}
+	mGenerateVersionPagePath = models.GenerateVersionPagePath
+	mSaveReleaseVersion = func(db database.DB, v models.ReleaseVersion) (models.ReleaseVersion, error) {
+		if _, ok := versions[v.Version]; ok {
+			return v, errors.New("duplicate version")
+		}
+		v.Id = uint32(len(versions) + 1)
+		versions[v.Version] = v
+		return v, nil
+	}
+	mFindReleaseVersion = func(db database.DB, r models.Release, version uint32) (models.ReleaseVersion, error) {
+		v, ok := versions[version]
+		if !ok || v.ReleaseID != r.Id {
+			return models.ReleaseVersion{}, models.ErrNoSuchReleaseVersion
+		}
+		return v, nil
+	}
+	mListReleaseVersions = func(db database.DB, r models.Release) ([]models.ReleaseVersion, error) {
+		list := []models.ReleaseVersion{}
+		for _, v := range versions {
+			if v.ReleaseID == r.Id {
+				v.Pages = nil
+				list = append(list, v)
+			}
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+		return list, nil
+	}
+	return versions
+}
+
+func TestReleaseVersions(t *testing.T) {
+	router := mux.NewRouter()
+	sp := SpMap{"12/70/p01.png": {1}, "12/70/p02.png": {2}}
+	registerHandlers(router, nil, sp, TMismatchReject, DefaultLintConfig())
+
+	release := models.Release{Id: 70, ProjectID: 12, Identifier: "c1", Version: 1, Scanlator: "ims", Status: "draft"}
+	pages := []models.Page{
+		models.Page{Name: "p01.png", Role: models.PRoleCreditStr, MimeType: models.MimeTypePng},
+		models.Page{Name: "p02.png", Role: models.PRoleContentStr, MimeType: models.MimeTypePng, SortIndex: 1},
+	}
+	mFindProject = func(db database.DB, id uint32) (models.Project, error) {
+		return models.Project{Id: id, Shorthand: "proj"}, nil
+	}
+	mFindRelease = func(db database.DB, p models.Project, id uint32) (models.Release, error) {
+		return release, nil
+	}
+	mUpdateRelease = func(db database.DB, r models.Release) (models.Release, error) {
+		release = r
+		return r, nil
+	}
+	mListPages = func(db database.DB, release models.Release, opts models.ListOptions) ([]models.Page, error) {
+		return pages, nil
+	}
+	mGenerateArchiveName = models.GenerateArchiveName
+	mGeneratePagePath = models.GeneratePagePath
+	mGenerateArchivePath = models.GenerateArchivePath
+	versions := mockReleaseVersions()
+
+	put := func(body string) {
+		var resp ReleaseResponse
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("PUT", "/projects/12/releases/70", strings.NewReader(body))
+		router.ServeHTTP(w, r)
+		json.NewDecoder(w.Body).Decode(&resp)
+		assert.Equal(t, nil, resp.getError())
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/projects/12/releases/70/versions"+path, nil)
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	// test publishing snapshots the pages
+	put(`{"identifier":"c1","version":2,"status":"released"}`)
+	assert.Equal(t, 1, len(versions))
+	v2 := versions[2]
+	assert.Equal(t, "c1", v2.Identifier)
+	assert.Equal(t, release.ReleasedOn, v2.ReleasedOn)
+	assert.Equal(t, 2, len(v2.Pages))
+	sum := sha256.Sum256([]byte{1})
+	assert.Equal(t, models.VersionPage{Name: "p01.png", MimeType: models.MimeTypePng, Role: "credit", Hash: hex.EncodeToString(sum[:])}, v2.Pages[0])
+	assert.Equal(t, true, sp.Exists(models.GenerateVersionPagePath(models.Project{Id: 12}, release, v2.Pages[1].Hash)))
+
+	// test retracting and reinstating does not snapshot again
+	put(`{"identifier":"c1","version":2,"status":"retracted"}`)
+	assert.Equal(t, http.StatusGone, get("").Code)
+	assert.Equal(t, http.StatusGone, get("/2/download/proj - c1[2][ims].zip").Code)
+	put(`{"identifier":"c1","version":2,"status":"released"}`)
+	assert.Equal(t, 1, len(versions))
+
+	// test the next version, with a page replaced and one added
+	put(`{"identifier":"c1","version":2,"status":"draft"}`)
+	sp["12/70/p02.png"] = []byte{4}
+	sp["12/70/p03.png"] = []byte{5}
+	pages = append(pages, models.Page{Name: "p03.png", Role: models.PRoleContentStr, MimeType: models.MimeTypePng, SortIndex: 2})
+	put(`{"identifier":"c1.5","version":3,"status":"released"}`)
+	assert.Equal(t, 2, len(versions))
+
+	// test listing versions
+	var resp ReleaseVersionResponse
+	w := get("")
+	json.NewDecoder(w.Body).Decode(&resp)
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, 2, len(resp.Result))
+	assert.Equal(t, uint32(2), resp.Result[0].Version)
+	assert.Equal(t, 0, len(resp.Result[0].Pages))
+	assert.Equal(t, "c1.5", resp.Result[1].Identifier)
+
+	// test getting a version
+	resp = ReleaseVersionResponse{}
+	w = get("/2")
+	json.NewDecoder(w.Body).Decode(&resp)
+	assert.Equal(t, nil, resp.getError())
+	assert.Equal(t, 2, len(resp.Result[0].Pages))
+	assert.Equal(t, "p02.png", resp.Result[0].Pages[1].Name)
+
+	resp = ReleaseVersionResponse{}
+	w = get("/4")
+	json.NewDecoder(w.Body).Decode(&resp)
+	assert.Equal(t, ErrMsgNotFound, resp.getError().Error())
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// test diffing against the previous version, a given version and no version
+	diff := func(path string) string {
+		var resp VersionDiffResponse
+		w := get(path)
+		json.NewDecoder(w.Body).Decode(&resp)
+		changes := []string{}
+		for _, c := range resp.Result {
+			changes = append(changes, c.Change+":"+c.Name)
+		}
+		return strings.Join(changes, ",")
+	}
+	assert.Equal(t, "changed:p02.png,added:p03.png", diff("/3/diff"))
+	assert.Equal(t, "removed:p03.png,changed:p02.png", diff("/2/diff?base=3"))
+	assert.Equal(t, "added:p01.png,added:p02.png", diff("/2/diff"))
+	assert.Equal(t, http.StatusNotFound, get("/3/diff?base=1").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/3/diff?base=x").Code)
+
+	// test downloading the old version after its pages have changed
+	w = get("/2/download/proj - c1[2][ims].zip")
+	assert.Equal(t, http.StatusOK, w.Code)
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(zr.File))
+	f, _ := zr.File[1].Open()
+	var data bytes.Buffer
+	data.ReadFrom(f)
+	assert.Equal(t, string([]byte{2}), data.String())
+
+	// test the archive name has to match the version
+	assert.Equal(t, http.StatusNotFound, get("/2/download/proj - c1.5[3][ims].zip").Code)
+	assert.Equal(t, http.StatusOK, get("/3/download/proj - c1.5[3][ims].cbz").Code)
+}
+
+func TestSnapshotReleaseErrors(t *testing.T) {
+	release := models.Release{Id: 70, Identifier: "c1", Version: 2}
+	project := models.Project{Id: 12}
+	mListPages = func(db database.DB, release models.Release, opts models.ListOptions) ([]models.Page, error) {
+		return []models.Page{models.Page{Name: "p01.png"}}, nil
+	}
+	mGeneratePagePath = models.GeneratePagePath
+	versions := mockReleaseVersions()
+
+	// test pages which cannot be read
+	sp := SpMap{}
+	assert.NotEqual(t, nil, snapshotRelease(nil, sp, project, release))
+	assert.Equal(t, 0, len(versions))
+
+	// test a version which cannot be saved
+	sp["12/70/p01.png"] = []byte{1}
+	versions[2] = models.ReleaseVersion{}
+	assert.NotEqual(t, nil, snapshotRelease(nil, sp, project, release))
+
+	// test a missing page of a version
+	delete(versions, 2)
+	assert.Equal(t, nil, snapshotRelease(nil, sp, project, release))
+	v := versions[2]
+	v.Pages[0].Hash = "bla"
+	_, err := loadVersionPages(sp, project, release, v)
+	assert.NotEqual(t, nil, err)
+}
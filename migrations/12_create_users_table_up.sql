@@ -0,0 +1,8 @@
+CREATE TABLE `users` (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `subject` VARBINARY(255) NOT NULL UNIQUE,
+  `email` TEXT,
+  `name` TEXT,
+  `created_at` TIMESTAMP NOT NULL,
+PRIMARY KEY(`id`))
+ENGINE=InnoDB DEFAULT CHARSET=utf8;
\ No newline at end of file
@@ -0,0 +1,10 @@
+CREATE TABLE `sessions` (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `token_hash` VARBINARY(64) NOT NULL UNIQUE,
+  `csrf_token` VARBINARY(64) NOT NULL,
+  `user_id` INT UNSIGNED NOT NULL,
+  `created_at` TIMESTAMP NOT NULL,
+  `expires_at` DATETIME NOT NULL,
+FOREIGN KEY(`user_id`) REFERENCES `users`(`id`),
+PRIMARY KEY(`id`))
+ENGINE=InnoDB DEFAULT CHARSET=utf8;
\ No newline at end of file
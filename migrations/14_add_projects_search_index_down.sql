@@ -0,0 +1 @@
+ALTER TABLE `projects` DROP INDEX `search`;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE `projects` ADD FULLTEXT INDEX `search` (`name`, `description`);
\ No newline at end of file
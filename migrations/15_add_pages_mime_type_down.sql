@@ -0,0 +1 @@
+ALTER TABLE `pages` DROP COLUMN `mime_type`;
\ No newline at end of file
@@ -0,0 +1,8 @@
+ALTER TABLE `pages` ADD COLUMN `mime_type` INT UNSIGNED NOT NULL DEFAULT 0;
+UPDATE `pages` SET `mime_type` = CASE
+  WHEN `name` LIKE '%.png' THEN 1
+  WHEN `name` LIKE '%.jpg' OR `name` LIKE '%.jpeg' THEN 2
+  WHEN `name` LIKE '%.webp' THEN 3
+  WHEN `name` LIKE '%.gif' THEN 4
+  ELSE 0
+END;
\ No newline at end of file
@@ -0,0 +1,14 @@
+CREATE TABLE `uploads` (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `token` VARBINARY(64) NOT NULL UNIQUE,
+  `release_id` INT UNSIGNED NOT NULL,
+  `name` VARCHAR(255) NOT NULL,
+  `kind` VARCHAR(16) NOT NULL,
+  `size` BIGINT UNSIGNED NOT NULL,
+  `offset` BIGINT UNSIGNED NOT NULL DEFAULT 0,
+  `chunks` INT UNSIGNED NOT NULL DEFAULT 0,
+  `created_at` TIMESTAMP NOT NULL,
+  `expires_at` DATETIME NOT NULL,
+FOREIGN KEY(`release_id`) REFERENCES `releases`(`id`),
+PRIMARY KEY(`id`))
+ENGINE=InnoDB DEFAULT CHARSET=utf8;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE `pages` DROP COLUMN `sort_index`;
\ No newline at end of file
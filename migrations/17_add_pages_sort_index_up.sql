@@ -0,0 +1,7 @@
+ALTER TABLE `pages` ADD COLUMN `sort_index` INT UNSIGNED NOT NULL DEFAULT 0;
+UPDATE `pages` JOIN (
+  SELECT a.`id`, COUNT(b.`id`) AS `position` FROM `pages` a
+  JOIN `pages` b ON b.`release_id` = a.`release_id` AND b.`name` < a.`name`
+  GROUP BY a.`id`
+) AS `ranked` ON `ranked`.`id` = `pages`.`id`
+SET `pages`.`sort_index` = `ranked`.`position`;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE `pages` DROP COLUMN `role`;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE `pages` ADD COLUMN `role` INT UNSIGNED NOT NULL DEFAULT 1;
+UPDATE `pages` SET `role` = 2 WHERE `name` LIKE '!%';
\ No newline at end of file
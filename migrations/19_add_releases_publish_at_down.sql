@@ -0,0 +1 @@
+ALTER TABLE `releases` DROP COLUMN `publish_at`;
\ No newline at end of file
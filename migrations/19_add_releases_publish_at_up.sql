@@ -0,0 +1 @@
+ALTER TABLE `releases` ADD COLUMN `publish_at` TIMESTAMP NULL DEFAULT NULL;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE `releases` DROP COLUMN `retract_reason`;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE `releases` ADD COLUMN `retract_reason` VARCHAR(255) NOT NULL DEFAULT '';
\ No newline at end of file
@@ -0,0 +1,2 @@
+DROP TABLE `release_version_pages`;
+DROP TABLE `release_versions`;
\ No newline at end of file
@@ -0,0 +1,22 @@
+CREATE TABLE `release_versions` (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `release_id` INT UNSIGNED NOT NULL,
+  `identifier` VARBINARY(10) NOT NULL,
+  `version` INT UNSIGNED NOT NULL,
+  `released_on` TIMESTAMP NOT NULL,
+FOREIGN KEY(`release_id`) REFERENCES `releases`(`id`) ON DELETE CASCADE,
+UNIQUE `version` (`release_id`, `version`),
+PRIMARY KEY(`id`))
+ENGINE=InnoDB DEFAULT CHARSET=utf8;
+CREATE TABLE `release_version_pages` (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `version_id` INT UNSIGNED NOT NULL,
+  `name` VARBINARY(255) NOT NULL,
+  `mime_type` INT UNSIGNED NOT NULL,
+  `role` INT UNSIGNED NOT NULL,
+  `sort_index` INT UNSIGNED NOT NULL,
+  `hash` BINARY(64) NOT NULL,
+FOREIGN KEY(`version_id`) REFERENCES `release_versions`(`id`) ON DELETE CASCADE,
+UNIQUE `path` (`version_id`, `name`),
+PRIMARY KEY(`id`))
+ENGINE=InnoDB DEFAULT CHARSET=utf8;
\ No newline at end of file
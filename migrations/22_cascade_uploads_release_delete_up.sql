@@ -0,0 +1,2 @@
+ALTER TABLE `uploads` DROP FOREIGN KEY `uploads_ibfk_1`;
+ALTER TABLE `uploads` ADD CONSTRAINT `uploads_ibfk_1` FOREIGN KEY (`release_id`) REFERENCES `releases` (`id`) ON DELETE CASCADE;
\ No newline at end of file
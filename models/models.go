@@ -10,6 +10,7 @@ var (
 	ErrCouldNotGetID         error = errors.New("Could not get ID of last created row.")
 	ErrOperationNotSupported error = errors.New("Operation not supported.")
 	ErrFieldTooLong                = errors.New("A field value is too long.")
+	ErrInvalidSortField            = errors.New("Invalid sort field.")
 )
 
 // Model should be implemented by all model types to provide functionality for data validation and persistence.
@@ -19,3 +20,50 @@ type Model interface {
 	Update(*sql.DB) error
 	Delete(*sql.DB) error
 }
+
+// ListOptions controls the order and the slice of rows returned by the List* functions. Sort is the JSON name of
+// the field to sort on, or empty for the model's default order. A Limit of 0 means that all rows are returned.
+type ListOptions struct {
+	Limit  uint32
+	Offset uint32
+	Sort   string
+	Desc   bool
+}
+
+// noLimit is the largest row count MySQL accepts, used when only an offset is requested.
+const noLimit = "18446744073709551615"
+
+// orderAndLimit produces the ORDER BY and LIMIT clauses for a query, along with their arguments. The id column
+// breaks ties so that paging through rows with equal sort values is stable.
+func (o ListOptions) orderAndLimit(sortable map[string]string, defaultSort, idColumn string) (string, []interface{}, error) {
+	sort := o.Sort
+	if sort == "" {
+		sort = defaultSort
+	}
+	column, ok := sortable[sort]
+	if !ok {
+		return "", nil, ErrInvalidSortField
+	}
+
+	dir := " ASC"
+	if o.Desc {
+		dir = " DESC"
+	}
+	clause := " ORDER BY " + column + dir
+	if column != idColumn {
+		clause += ", " + idColumn + dir
+	}
+
+	args := []interface{}{}
+	if o.Limit > 0 {
+		clause += " LIMIT ?"
+		args = append(args, o.Limit)
+	} else if o.Offset > 0 {
+		clause += " LIMIT " + noLimit
+	}
+	if o.Offset > 0 {
+		clause += " OFFSET ?"
+		args = append(args, o.Offset)
+	}
+	return clause, args, nil
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"ims-release/assert"
+	"testing"
+)
+
+func TestOrderAndLimit(t *testing.T) {
+	sortable := map[string]string{"id": "`id`", "name": "`name`"}
+
+	clause, args, err := ListOptions{}.orderAndLimit(sortable, "name", "`id`")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, " ORDER BY `name` ASC, `id` ASC", clause)
+	assert.Equal(t, 0, len(args))
+
+	clause, args, err = ListOptions{Sort: "id", Desc: true, Limit: 10}.orderAndLimit(sortable, "name", "`id`")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, " ORDER BY `id` DESC LIMIT ?", clause)
+	assert.Equal(t, 1, len(args))
+	assert.Equal(t, uint32(10), args[0])
+
+	clause, args, err = ListOptions{Limit: 10, Offset: 30}.orderAndLimit(sortable, "name", "`id`")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, " ORDER BY `name` ASC, `id` ASC LIMIT ? OFFSET ?", clause)
+	assert.Equal(t, 2, len(args))
+	assert.Equal(t, uint32(30), args[1])
+
+	clause, args, err = ListOptions{Offset: 30}.orderAndLimit(sortable, "name", "`id`")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, " ORDER BY `name` ASC, `id` ASC LIMIT "+noLimit+" OFFSET ?", clause)
+	assert.Equal(t, 1, len(args))
+
+	_, _, err = ListOptions{Sort: "bogus"}.orderAndLimit(sortable, "name", "`id`")
+	assert.Equal(t, ErrInvalidSortField, err)
+}
@@ -16,6 +16,61 @@ type Page struct {
 	CreatedAt time.Time `json:"createdAt"`
 	ReleaseID uint32    `json:"-"`
 	MimeType  MimeType  `json:"-"`
+	SortIndex uint32    `json:"sortIndex"`
+	Role      string    `json:"role"`
+}
+
+type PageRole int
+
+// PageRole pseudo-enum values. The role tells what a page is for; a release can only be published once it has a
+// credit page.
+const (
+	PRoleUnknown        PageRole = 0
+	PRoleUnknownStr     string   = "unknown"
+	PRoleContent        PageRole = 1
+	PRoleContentStr     string   = "content"
+	PRoleCredit         PageRole = 2
+	PRoleCreditStr      string   = "credit"
+	PRoleCover          PageRole = 3
+	PRoleCoverStr       string   = "cover"
+	PRoleRecruitment    PageRole = 4
+	PRoleRecruitmentStr string   = "recruitment"
+	PRoleColour         PageRole = 5
+	PRoleColourStr      string   = "colour"
+)
+
+func (r PageRole) String() string {
+	switch r {
+	case PRoleContent:
+		return PRoleContentStr
+	case PRoleCredit:
+		return PRoleCreditStr
+	case PRoleCover:
+		return PRoleCoverStr
+	case PRoleRecruitment:
+		return PRoleRecruitmentStr
+	case PRoleColour:
+		return PRoleColourStr
+	default:
+		return PRoleUnknownStr
+	}
+}
+
+func NewPageRole(val string) PageRole {
+	switch val {
+	case PRoleContentStr:
+		return PRoleContent
+	case PRoleCreditStr:
+		return PRoleCredit
+	case PRoleCoverStr:
+		return PRoleCover
+	case PRoleRecruitmentStr:
+		return PRoleRecruitment
+	case PRoleColourStr:
+		return PRoleColour
+	default:
+		return PRoleUnknown
+	}
 }
 
 type MimeType uint32
@@ -27,6 +82,10 @@ const (
 	MimeTypePngStr     string   = "image/png"
 	MimeTypeJpg        MimeType = 2
 	MimeTypeJpgStr     string   = "image/jpeg"
+	MimeTypeWebp       MimeType = 3
+	MimeTypeWebpStr    string   = "image/webp"
+	MimeTypeGif        MimeType = 4
+	MimeTypeGifStr     string   = "image/gif"
 )
 
 func (t MimeType) String() string {
@@ -35,6 +94,10 @@ func (t MimeType) String() string {
 		return MimeTypePngStr
 	case MimeTypeJpg:
 		return MimeTypeJpgStr
+	case MimeTypeWebp:
+		return MimeTypeWebpStr
+	case MimeTypeGif:
+		return MimeTypeGifStr
 	default:
 		return MimeTypeUnknownStr
 	}
@@ -46,16 +109,40 @@ func NewMimeType(val string) MimeType {
 		return MimeTypePng
 	case MimeTypeJpgStr:
 		return MimeTypeJpg
+	case MimeTypeWebpStr:
+		return MimeTypeWebp
+	case MimeTypeGifStr:
+		return MimeTypeGif
 	default:
 		return MimeTypeUnknown
 	}
 }
 
+// Extension is the file name extension conventionally used for the type.
+func (t MimeType) Extension() string {
+	switch t {
+	case MimeTypePng:
+		return ".png"
+	case MimeTypeJpg:
+		return ".jpg"
+	case MimeTypeWebp:
+		return ".webp"
+	case MimeTypeGif:
+		return ".gif"
+	default:
+		return ""
+	}
+}
+
 func MimeTypeFromFilename(filename string) MimeType {
 	if strings.HasSuffix(filename, ".png") {
 		return MimeTypePng
-	} else if strings.HasSuffix(filename, ".jpg") {
+	} else if strings.HasSuffix(filename, ".jpg") || strings.HasSuffix(filename, ".jpeg") {
 		return MimeTypeJpg
+	} else if strings.HasSuffix(filename, ".webp") {
+		return MimeTypeWebp
+	} else if strings.HasSuffix(filename, ".gif") {
+		return MimeTypeGif
 	}
 	return MimeTypeUnknown
 }
@@ -66,6 +153,7 @@ var (
 	ErrPageNameEmpty           = errors.New("Page name is empty.")
 	ErrPageNameTooLong         = errors.New("Page name is too long.")
 	ErrPageUnsupportedMimeType = errors.New("Unsupported mime type.")
+	ErrInvalidPageRole         = errors.New("Invalid page role.")
 )
 
 // Database queries for operations on Pages.
@@ -75,6 +163,9 @@ const (
 	PGc_name       string = "`name`"
 	PGc_created_at string = "`created_at`"
 	PGc_release_id string = "`release_id`"
+	PGc_mime_type  string = "`mime_type`"
+	PGc_sort_index string = "`sort_index`"
+	PGc_role       string = "`role`"
 
 	PGmax_len_name = 255
 )
@@ -88,36 +179,40 @@ func NewPage(release Release, name string, tm time.Time) Page {
 		CreatedAt: tm,
 		ReleaseID: release.Id,
 		MimeType:  MimeTypeFromFilename(name),
+		Role:      PRoleContentStr,
 	}
 }
 
 // FindPage attempts to lookup a page by ID.
 func FindPage(db database.DB, release Release, pageId uint32) (Page, error) {
 	p := Page{ReleaseID: release.Id, Id: pageId}
-	const query = "SELECT " + PGc_name + ", " + PGc_created_at +
-		" FROM " + t_pages + " WHERE " + PGc_id + " = ? AND " + PGc_release_id + " = ?"
+	var role PageRole
+	const query = "SELECT " + PGc_name + ", " + PGc_created_at + ", " + PGc_mime_type + ", " + PGc_sort_index +
+		", " + PGc_role + " FROM " + t_pages + " WHERE " + PGc_id + " = ? AND " + PGc_release_id + " = ?"
 	row := db.QueryRow(query, pageId, release.Id)
-	err := row.Scan(&p.Name, &p.CreatedAt)
+	err := row.Scan(&p.Name, &p.CreatedAt, &p.MimeType, &p.SortIndex, &role)
 	if err == database.ErrNoRows {
 		return Page{}, ErrNoSuchPage
 	} else if err != nil {
 		return Page{}, err
 	}
-	p.MimeType = MimeTypeFromFilename(p.Name)
+	p.Role = role.String()
 	return p, nil
 }
 
 func FindPageByName(db database.DB, release Release, name string) (Page, error) {
-	p := Page{ReleaseID: release.Id, Name: name, MimeType: MimeTypeFromFilename(name)}
-	const query = "SELECT " + PGc_id + ", " + PGc_created_at +
-		" FROM " + t_pages + " WHERE " + PGc_release_id + " = ? AND " + PGc_name + " = ?"
+	p := Page{ReleaseID: release.Id, Name: name}
+	var role PageRole
+	const query = "SELECT " + PGc_id + ", " + PGc_created_at + ", " + PGc_mime_type + ", " + PGc_sort_index +
+		", " + PGc_role + " FROM " + t_pages + " WHERE " + PGc_release_id + " = ? AND " + PGc_name + " = ?"
 	row := db.QueryRow(query, release.Id, name)
-	err := row.Scan(&p.Id, &p.CreatedAt)
+	err := row.Scan(&p.Id, &p.CreatedAt, &p.MimeType, &p.SortIndex, &role)
 	if err == database.ErrNoRows {
 		return Page{}, ErrNoSuchPage
 	} else if err != nil {
 		return Page{}, err
 	}
+	p.Role = role.String()
 	return p, nil
 }
 
@@ -125,32 +220,106 @@ func GeneratePagePath(p Project, r Release, name string) string {
 	return fmt.Sprintf("%d/%d/%s", p.Id, r.Id, name)
 }
 
-// ListPages attempts to obtain a list of all pages
-func ListPages(db database.DB, release Release) ([]Page, error) {
+// GenerateThumbnailPath produces the storage key under which a thumbnail of a page is kept, for one of the
+// thumbnail sizes.
+func GenerateThumbnailPath(p Project, r Release, size string, name string) string {
+	return fmt.Sprintf("thumbnails/%d/%d/%s/%s", p.Id, r.Id, size, name)
+}
+
+// GenerateVariantPath produces the storage key under which an image stored under key is kept in another format.
+func GenerateVariantPath(key string, extension string) string {
+	return "variants/" + key + extension
+}
+
+var pageSortColumns = map[string]string{
+	"id":        PGc_id,
+	"name":      PGc_name,
+	"createdAt": PGc_created_at,
+	"sortIndex": PGc_sort_index,
+}
+
+// ListPages attempts to obtain a list of the pages of a release, in page order unless asked otherwise.
+func ListPages(db database.DB, release Release, opts ListOptions) ([]Page, error) {
 	pages := []Page{}
 
-	const query = "SELECT " + PGc_id + ", " + PGc_name + ", " + PGc_created_at +
-		" FROM " + t_pages + " WHERE " + PGc_release_id + " = ?" +
-		" ORDER BY " + PGc_name + " ASC"
+	order, orderArgs, err := opts.orderAndLimit(pageSortColumns, "sortIndex", PGc_id)
+	if err != nil {
+		return pages, err
+	}
+
+	query := "SELECT " + PGc_id + ", " + PGc_name + ", " + PGc_created_at + ", " + PGc_mime_type + ", " +
+		PGc_sort_index + ", " + PGc_role + " FROM " + t_pages + " WHERE " + PGc_release_id + " = ?" + order
 
-	rows, err := db.Query(query, release.Id)
+	rows, err := db.Query(query, append([]interface{}{release.Id}, orderArgs...)...)
 	if err != nil {
 		return []Page{}, err
 	}
 	defer rows.Close()
 	for rows.Next() {
 		p := Page{ReleaseID: release.Id}
-		err = rows.Scan(&p.Id, &p.Name, &p.CreatedAt)
+		var role PageRole
+		err = rows.Scan(&p.Id, &p.Name, &p.CreatedAt, &p.MimeType, &p.SortIndex, &role)
 		if err != nil {
 			return pages, err
 		}
-		p.MimeType = MimeTypeFromFilename(p.Name)
+		p.Role = role.String()
 		pages = append(pages, p)
 	}
 	err = rows.Err()
 	return pages, err
 }
 
+// ListReleaseCovers finds the cover of each of the given releases in a single query, keyed by release id. The
+// page with the cover role is preferred; without one, the first page in sort order is taken. Releases without
+// pages have no entry.
+func ListReleaseCovers(db database.DB, releases []Release) (map[uint32]Page, error) {
+	covers := map[uint32]Page{}
+	if len(releases) == 0 {
+		return covers, nil
+	}
+
+	args := []interface{}{}
+	for _, r := range releases {
+		args = append(args, r.Id)
+	}
+	args = append(args, PRoleCover)
+
+	query := "SELECT p." + PGc_id + ", p." + PGc_name + ", p." + PGc_created_at + ", p." + PGc_mime_type + ", p." +
+		PGc_sort_index + ", p." + PGc_role + ", p." + PGc_release_id + " FROM " + t_pages + " p WHERE p." +
+		PGc_release_id + " IN (?" + strings.Repeat(", ?", len(releases)-1) + ") AND (p." + PGc_role + " = ? OR p." +
+		PGc_sort_index + " = (SELECT MIN(" + PGc_sort_index + ") FROM " + t_pages + " WHERE " + PGc_release_id +
+		" = p." + PGc_release_id + ")) ORDER BY p." + PGc_sort_index + " ASC, p." + PGc_id + " ASC"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return map[uint32]Page{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p Page
+		var role PageRole
+		err = rows.Scan(&p.Id, &p.Name, &p.CreatedAt, &p.MimeType, &p.SortIndex, &role, &p.ReleaseID)
+		if err != nil {
+			return covers, err
+		}
+		p.Role = role.String()
+		if current, ok := covers[p.ReleaseID]; ok && (current.Role == PRoleCoverStr || role != PRoleCover) {
+			continue
+		}
+		covers[p.ReleaseID] = p
+	}
+	err = rows.Err()
+	return covers, err
+}
+
+// CountPages counts the pages of a release.
+func CountPages(db database.DB, release Release) (uint32, error) {
+	var count uint32
+	const query = "SELECT COUNT(*) FROM " + t_pages + " WHERE " + PGc_release_id + " = ?"
+	err := db.QueryRow(query, release.Id).Scan(&count)
+	return count, err
+}
+
 // Validate currently doesn't perform any integrity checks.
 func (p *Page) Validate() error {
 	if len(p.Name) == 0 {
@@ -159,9 +328,12 @@ func (p *Page) Validate() error {
 	if len(p.Name) > PGmax_len_name {
 		return ErrPageNameTooLong
 	}
-	if MimeTypeUnknown == MimeTypeFromFilename(p.Name) {
+	if MimeTypeUnknown == MimeTypeFromFilename(p.Name) || MimeTypeUnknown == p.MimeType {
 		return ErrPageUnsupportedMimeType
 	}
+	if NewPageRole(p.Role) == PRoleUnknown {
+		return ErrInvalidPageRole
+	}
 	return nil
 }
 
@@ -174,9 +346,10 @@ func SavePage(db database.DB, p Page) (Page, error) {
 	// TODO - Make sure to save image data to disk before saving the Page.
 
 	const query = "INSERT INTO " + t_pages + " (" +
-		PGc_name + ", " + PGc_created_at + ", " + PGc_release_id + ") VALUES (?, ?, ?)"
+		PGc_name + ", " + PGc_created_at + ", " + PGc_release_id + ", " + PGc_mime_type + ", " + PGc_sort_index +
+		", " + PGc_role + ") VALUES (?, ?, ?, ?, ?, ?)"
 
-	res, err := db.Exec(query, p.Name, p.CreatedAt, p.ReleaseID)
+	res, err := db.Exec(query, p.Name, p.CreatedAt, p.ReleaseID, p.MimeType, p.SortIndex, NewPageRole(p.Role))
 	if err != nil {
 		return p, err
 	}
@@ -188,9 +361,82 @@ func SavePage(db database.DB, p Page) (Page, error) {
 	return p, nil
 }
 
+// ReorderPages sets the order of the pages of a release. The page with the first id comes first.
+func ReorderPages(db database.DB, release Release, ids []uint32) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	query := "UPDATE " + t_pages + " SET " + PGc_sort_index + " = CASE " + PGc_id
+	args := []interface{}{}
+	for i, id := range ids {
+		query += " WHEN ? THEN ?"
+		args = append(args, id, uint32(i))
+	}
+	query += " END WHERE " + PGc_release_id + " = ? AND " + PGc_id + " IN (?" + strings.Repeat(", ?", len(ids)-1) + ")"
+	args = append(args, release.Id)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	_, err := db.Exec(query, args...)
+	return err
+}
+
+// NaturalLess reports whether page name a sorts before b in natural order, where runs of digits are compared by
+// their numeric value, so that 2.png comes before 10.png.
+func NaturalLess(a, b string) bool {
+	for len(a) > 0 && len(b) > 0 {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			numA, restA := splitDigits(a)
+			numB, restB := splitDigits(b)
+			trimmedA := strings.TrimLeft(numA, "0")
+			trimmedB := strings.TrimLeft(numB, "0")
+			if len(trimmedA) != len(trimmedB) {
+				return len(trimmedA) < len(trimmedB)
+			}
+			if trimmedA != trimmedB {
+				return trimmedA < trimmedB
+			}
+			if numA != numB {
+				// fewer leading zeros first
+				return len(numA) < len(numB)
+			}
+			a, b = restA, restB
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// splitDigits splits a string after its leading run of digits.
+func splitDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
 // Update modifies all of the fields of a Page in place with whatever is currently in the struct.
 func UpdatePage(db database.DB, p Page) (Page, error) {
-	return p, ErrOperationNotSupported
+	validErr := p.Validate()
+	if validErr != nil {
+		return p, validErr
+	}
+	const query = "UPDATE " + t_pages + " SET " +
+		PGc_name + " = ?, " + PGc_created_at + " = ?, " + PGc_mime_type + " = ?, " + PGc_sort_index + " = ?, " +
+		PGc_role + " = ? WHERE " + PGc_id + " = ? AND " + PGc_release_id + " = ? LIMIT 1"
+	_, err := db.Exec(query, p.Name, p.CreatedAt, p.MimeType, p.SortIndex, NewPageRole(p.Role), p.Id, p.ReleaseID)
+	return p, err
 }
 
 // Delete removes the Page from the database and deletes the page image from disk.
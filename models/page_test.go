@@ -13,23 +13,42 @@ func TestMimeType(t *testing.T) {
 	tUnknown := NewMimeType("bla")
 	tPng := NewMimeType("image/png")
 	tJpg := NewMimeType("image/jpeg")
+	tWebp := NewMimeType("image/webp")
+	tGif := NewMimeType("image/gif")
 
 	assert.Equal(t, "image/png", tPng.String())
 	assert.Equal(t, "image/jpeg", tJpg.String())
+	assert.Equal(t, "image/webp", tWebp.String())
+	assert.Equal(t, "image/gif", tGif.String())
 	assert.Equal(t, "application/octet-stream", tUnknown.String())
 
 	assert.Equal(t, MimeType(1), tPng)
 	assert.Equal(t, MimeType(2), tJpg)
+	assert.Equal(t, MimeType(3), tWebp)
+	assert.Equal(t, MimeType(4), tGif)
 	assert.Equal(t, MimeType(0), tUnknown)
 
 	assert.Equal(t, tPng, MimeTypeFromFilename("bla.png"))
 	assert.Equal(t, tJpg, MimeTypeFromFilename("bla.jpg"))
+	assert.Equal(t, tJpg, MimeTypeFromFilename("bla.jpeg"))
+	assert.Equal(t, tWebp, MimeTypeFromFilename("bla.webp"))
+	assert.Equal(t, tGif, MimeTypeFromFilename("bla.gif"))
 	assert.Equal(t, tUnknown, MimeTypeFromFilename("bla.bmp"))
 
 	tUnknown = MimeType(6)
 	assert.Equal(t, "application/octet-stream", tUnknown.String())
 }
 
+func TestPageRole(t *testing.T) {
+	roles := []string{PRoleContentStr, PRoleCreditStr, PRoleCoverStr, PRoleRecruitmentStr, PRoleColourStr}
+	for i, role := range roles {
+		assert.Equal(t, PageRole(i+1), NewPageRole(role))
+		assert.Equal(t, role, PageRole(i+1).String())
+	}
+	assert.Equal(t, PRoleUnknown, NewPageRole("bla"))
+	assert.Equal(t, PRoleUnknownStr, PageRole(6).String())
+}
+
 func TestNewPage(t *testing.T) {
 	r := Release{Id: 5}
 	tm := time.Now()
@@ -40,6 +59,7 @@ func TestNewPage(t *testing.T) {
 	assert.Equal(t, "file.png", p.Name)
 	assert.Equal(t, MimeTypePng, p.MimeType)
 	assert.Equal(t, tm, p.CreatedAt)
+	assert.Equal(t, PRoleContentStr, p.Role)
 }
 
 func TestFindPage(t *testing.T) {
@@ -51,13 +71,13 @@ func TestFindPage(t *testing.T) {
 	r := Release{Id: 5}
 	const id uint32 = 7
 	const name = "pg001.png"
-	const query = "SELECT (`[a-z_]+`, ){1}`[a-z_]+` FROM `pages` WHERE `id` = \\? AND `release_id` = \\?"
-	cols := []string{"name", "created_at"}
+	const query = "SELECT (`[a-z_]+`, ){4}`[a-z_]+` FROM `pages` WHERE `id` = \\? AND `release_id` = \\?"
+	cols := []string{"name", "created_at", "mime_type", "sort_index", "role"}
 
 	rows := sqlmock.NewRows(cols)
 	rows2 := sqlmock.NewRows(cols)
 	tm := time.Now()
-	rows2.AddRow(name, tm)
+	rows2.AddRow(name, tm, MimeTypePng, 4, PRoleCredit)
 
 	// case of no rows
 	mock.ExpectQuery(query).WithArgs(id, r.Id).WillReturnRows(rows)
@@ -81,6 +101,8 @@ func TestFindPage(t *testing.T) {
 	assert.Equal(t, r.Id, page.ReleaseID)
 	assert.Equal(t, id, page.Id)
 	assert.Equal(t, tm, page.CreatedAt)
+	assert.Equal(t, uint32(4), page.SortIndex)
+	assert.Equal(t, PRoleCreditStr, page.Role)
 
 	_, err = FindPage(db, r, id)
 	assert.Equal(t, expErr, err)
@@ -98,13 +120,13 @@ func TestFindPageByName(t *testing.T) {
 	r := Release{Id: 5}
 	const id uint32 = 7
 	const name = "pg001.png"
-	const query = "SELECT (`[a-z_]+`, ){1}`[a-z_]+` FROM `pages` WHERE `release_id` = \\? AND `name` = \\?"
-	cols := []string{"id", "created_at"}
+	const query = "SELECT (`[a-z_]+`, ){4}`[a-z_]+` FROM `pages` WHERE `release_id` = \\? AND `name` = \\?"
+	cols := []string{"id", "created_at", "mime_type", "sort_index", "role"}
 
 	rows := sqlmock.NewRows(cols)
 	rows2 := sqlmock.NewRows(cols)
 	tm := time.Now()
-	rows2.AddRow(id, tm)
+	rows2.AddRow(id, tm, MimeTypePng, 4, PRoleCredit)
 
 	// case of no rows
 	mock.ExpectQuery(query).WithArgs(r.Id, name).WillReturnRows(rows)
@@ -128,6 +150,8 @@ func TestFindPageByName(t *testing.T) {
 	assert.Equal(t, r.Id, page.ReleaseID)
 	assert.Equal(t, id, page.Id)
 	assert.Equal(t, tm, page.CreatedAt)
+	assert.Equal(t, uint32(4), page.SortIndex)
+	assert.Equal(t, PRoleCreditStr, page.Role)
 
 	_, err = FindPageByName(db, r, name)
 	assert.Equal(t, expErr, err)
@@ -143,71 +167,83 @@ func TestGeneratePagePath(t *testing.T) {
 	assert.Equal(t, "5/3/img001.jpg", path)
 }
 
+func TestGenerateThumbnailPath(t *testing.T) {
+	p := Project{Id: 5}
+	r := Release{Id: 3}
+	path := GenerateThumbnailPath(p, r, "grid", "img001.jpg")
+	assert.Equal(t, "thumbnails/5/3/grid/img001.jpg", path)
+}
+
+func TestGenerateVariantPath(t *testing.T) {
+	assert.Equal(t, "variants/5/3/img001.jpg.webp", GenerateVariantPath("5/3/img001.jpg", ".webp"))
+}
+
 func TestListPages(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	assert.Equal(t, nil, err)
 	defer db.Close()
 
-	const query string = "SELECT (`[a-z_]+`, ){2}`[a-z_]+` FROM `pages`"
+	const query string = "SELECT (`[a-z_]+`, ){5}`[a-z_]+` FROM `pages` WHERE `release_id` = \\? ORDER BY `sort_index` ASC, `id` ASC"
 	r := Release{Id: 9}
 
 	tm := time.Now()
-	pg1 := Page{Id: 1, Name: "somepage.jpg", CreatedAt: tm, MimeType: MimeTypeJpg, ReleaseID: r.Id}
-	pg2 := Page{Id: 3, Name: "somepage.png", CreatedAt: tm, MimeType: MimeTypePng, ReleaseID: r.Id}
+	pg1 := Page{Id: 1, Name: "somepage.jpg", CreatedAt: tm, MimeType: MimeTypeJpg, ReleaseID: r.Id, SortIndex: 0, Role: PRoleContentStr}
+	// the stored type is used even where it does not match the name
+	pg2 := Page{Id: 3, Name: "somepage.png", CreatedAt: tm, MimeType: MimeTypeWebp, ReleaseID: r.Id, SortIndex: 1, Role: PRoleCoverStr}
 
 	// error case
 	expErr := errors.New("error")
 	mock.ExpectQuery(query).WithArgs(r.Id).WillReturnError(expErr)
 
 	// no results case
-	cols := []string{"id", "name", "created_at"}
+	cols := []string{"id", "name", "created_at", "mime_type", "sort_index", "role"}
 	rows := sqlmock.NewRows(cols)
 	mock.ExpectQuery(query).WithArgs(r.Id).WillReturnRows(rows)
 
 	// some results case
 	rows2 := sqlmock.NewRows(cols)
-	rows2.AddRow(pg1.Id, pg1.Name, pg1.CreatedAt)
-	rows2.AddRow(pg2.Id, pg2.Name, pg2.CreatedAt)
+	rows2.AddRow(pg1.Id, pg1.Name, pg1.CreatedAt, pg1.MimeType, pg1.SortIndex, NewPageRole(pg1.Role))
+	rows2.AddRow(pg2.Id, pg2.Name, pg2.CreatedAt, pg2.MimeType, pg2.SortIndex, NewPageRole(pg2.Role))
 	mock.ExpectQuery(query).WithArgs(r.Id).WillReturnRows(rows2)
 
 	// some results with error case
 	rows3 := sqlmock.NewRows(cols)
-	rows3.AddRow(pg1.Id, pg1.Name, pg1.CreatedAt)
-	rows3.AddRow(pg2.Id, pg2.Name, pg2.CreatedAt)
+	rows3.AddRow(pg1.Id, pg1.Name, pg1.CreatedAt, pg1.MimeType, pg1.SortIndex, NewPageRole(pg1.Role))
+	rows3.AddRow(pg2.Id, pg2.Name, pg2.CreatedAt, pg2.MimeType, pg2.SortIndex, NewPageRole(pg2.Role))
 	expErr2 := errors.New("row error")
 	rows3.RowError(1, expErr2)
 	mock.ExpectQuery(query).WithArgs(r.Id).WillReturnRows(rows3)
 
 	// some results with scan error case
 	rows4 := sqlmock.NewRows(cols)
-	rows4.AddRow(pg1.Id, pg1.Name, pg1.CreatedAt)
-	rows4.AddRow(pg2.Id, pg2.Name, "malformed time")
+	rows4.AddRow(pg1.Id, pg1.Name, pg1.CreatedAt, pg1.MimeType, pg1.SortIndex, NewPageRole(pg1.Role))
+	rows4.AddRow(pg2.Id, pg2.Name, "malformed time", pg2.MimeType, pg2.SortIndex, NewPageRole(pg2.Role))
 	mock.ExpectQuery(query).WithArgs(r.Id).WillReturnRows(rows4)
 
 	// tests the error case
-	_, err = ListPages(db, r)
+	_, err = ListPages(db, r, ListOptions{})
 	assert.Equal(t, expErr, err)
 
 	// tests the no results case
-	pages, err := ListPages(db, r)
+	pages, err := ListPages(db, r, ListOptions{})
 	assert.Equal(t, nil, err)
 	assert.Equal(t, 0, len(pages))
 
 	// tests the some results case
-	pages, err = ListPages(db, r)
+	pages, err = ListPages(db, r, ListOptions{})
 	assert.Equal(t, nil, err)
 	assert.Equal(t, 2, len(pages))
 	assert.Equal(t, pg1, pages[0])
 	assert.Equal(t, pg2, pages[1])
 
 	// tests some results with error case
-	pages, err = ListPages(db, r)
+	pages, err = ListPages(db, r, ListOptions{})
 	assert.Equal(t, expErr2, err)
 	assert.Equal(t, 1, len(pages))
 	assert.Equal(t, pg1, pages[0])
 
 	// tests some results with scan error case
-	pages, err = ListPages(db, r)
+	pages, err = ListPages(db, r, ListOptions{})
 	assert.NotEqual(t, nil, err)
 	assert.Equal(t, 1, len(pages))
 	assert.Equal(t, pg1, pages[0])
@@ -216,6 +252,56 @@ func TestListPages(t *testing.T) {
 	assert.Equal(t, nil, err)
 }
 
+func TestListReleaseCovers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	const query string = "SELECT (p.`[a-z_]+`, ){6}p.`[a-z_]+` FROM `pages` p WHERE p.`release_id` IN \\(\\?, \\?\\) " +
+		"AND \\(p.`role` = \\? OR p.`sort_index` = \\(SELECT MIN\\(`sort_index`\\) FROM `pages` WHERE `release_id` = p.`release_id`\\)\\) " +
+		"ORDER BY p.`sort_index` ASC, p.`id` ASC"
+	releases := []Release{{Id: 9}, {Id: 10}}
+
+	tm := time.Now()
+	first := Page{Id: 1, Name: "01.jpg", CreatedAt: tm, MimeType: MimeTypeJpg, ReleaseID: 9, SortIndex: 0, Role: PRoleContentStr}
+	cover := Page{Id: 5, Name: "cover.png", CreatedAt: tm, MimeType: MimeTypePng, ReleaseID: 9, SortIndex: 4, Role: PRoleCoverStr}
+	other := Page{Id: 7, Name: "01.jpg", CreatedAt: tm, MimeType: MimeTypeJpg, ReleaseID: 10, SortIndex: 0, Role: PRoleContentStr}
+
+	// no releases case doesn't query
+	covers, err := ListReleaseCovers(db, []Release{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(covers))
+
+	// error case
+	expErr := errors.New("error")
+	mock.ExpectQuery(query).WithArgs(9, 10, PRoleCover).WillReturnError(expErr)
+	_, err = ListReleaseCovers(db, releases)
+	assert.Equal(t, expErr, err)
+
+	// the cover role is preferred over the first page, which is taken otherwise
+	cols := []string{"id", "name", "created_at", "mime_type", "sort_index", "role", "release_id"}
+	rows := sqlmock.NewRows(cols)
+	for _, p := range []Page{first, other, cover} {
+		rows.AddRow(p.Id, p.Name, p.CreatedAt, p.MimeType, p.SortIndex, NewPageRole(p.Role), p.ReleaseID)
+	}
+	mock.ExpectQuery(query).WithArgs(9, 10, PRoleCover).WillReturnRows(rows)
+	covers, err = ListReleaseCovers(db, releases)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(covers))
+	assert.Equal(t, cover, covers[9])
+	assert.Equal(t, other, covers[10])
+
+	// scan error case
+	rows2 := sqlmock.NewRows(cols)
+	rows2.AddRow(first.Id, first.Name, "malformed time", first.MimeType, first.SortIndex, NewPageRole(first.Role), first.ReleaseID)
+	mock.ExpectQuery(query).WithArgs(9, 10, PRoleCover).WillReturnRows(rows2)
+	_, err = ListReleaseCovers(db, releases)
+	assert.NotEqual(t, nil, err)
+
+	err = mock.ExpectationsWereMet()
+	assert.Equal(t, nil, err)
+}
+
 func TestValidatePage(t *testing.T) {
 	p := Page{}
 	err := p.Validate()
@@ -227,6 +313,14 @@ func TestValidatePage(t *testing.T) {
 
 	p.Name = "test.png"
 	err = p.Validate()
+	assert.Equal(t, ErrPageUnsupportedMimeType, err)
+
+	p.MimeType = MimeTypePng
+	err = p.Validate()
+	assert.Equal(t, ErrInvalidPageRole, err)
+
+	p.Role = PRoleCreditStr
+	err = p.Validate()
 	assert.Equal(t, nil, err)
 
 	p.Name = strings.Repeat("a", 256)
@@ -248,15 +342,15 @@ func TestSavePage(t *testing.T) {
 
 	// success case
 	p := NewPage(Release{Id: 5}, "img.png", time.Now())
-	mock.ExpectExec(query).WithArgs(p.Name, p.CreatedAt, p.ReleaseID).WillReturnResult(sqlmock.NewResult(id, 1))
+	mock.ExpectExec(query).WithArgs(p.Name, p.CreatedAt, p.ReleaseID, p.MimeType, p.SortIndex, PRoleContent).WillReturnResult(sqlmock.NewResult(id, 1))
 
 	// error case
 	expErr := errors.New("error")
-	mock.ExpectExec(query).WithArgs(p.Name, p.CreatedAt, p.ReleaseID).WillReturnError(expErr)
+	mock.ExpectExec(query).WithArgs(p.Name, p.CreatedAt, p.ReleaseID, p.MimeType, p.SortIndex, PRoleContent).WillReturnError(expErr)
 
 	// error result case
 	expErr2 := errors.New("error2")
-	mock.ExpectExec(query).WithArgs(p.Name, p.CreatedAt, p.ReleaseID).WillReturnResult(sqlmock.NewErrorResult(expErr2))
+	mock.ExpectExec(query).WithArgs(p.Name, p.CreatedAt, p.ReleaseID, p.MimeType, p.SortIndex, PRoleContent).WillReturnResult(sqlmock.NewErrorResult(expErr2))
 
 	// tests success case
 	p, err = SavePage(db, p)
@@ -280,14 +374,70 @@ func TestSavePage(t *testing.T) {
 	assert.Equal(t, nil, err)
 }
 
+func TestReorderPages(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	r := Release{Id: 5}
+	const query string = "UPDATE `pages` SET `sort_index` = CASE `id` WHEN \\? THEN \\? WHEN \\? THEN \\? WHEN \\? THEN \\? END " +
+		"WHERE `release_id` = \\? AND `id` IN \\(\\?, \\?, \\?\\)"
+	mock.ExpectExec(query).WithArgs(3, 0, 1, 1, 2, 2, r.Id, 3, 1, 2).WillReturnResult(sqlmock.NewResult(0, 3))
+	expErr := errors.New("error")
+	mock.ExpectExec(query).WithArgs(3, 0, 1, 1, 2, 2, r.Id, 3, 1, 2).WillReturnError(expErr)
+
+	err = ReorderPages(db, r, []uint32{3, 1, 2})
+	assert.Equal(t, nil, err)
+
+	err = ReorderPages(db, r, []uint32{3, 1, 2})
+	assert.Equal(t, expErr, err)
+
+	// nothing to reorder
+	err = ReorderPages(db, r, []uint32{})
+	assert.Equal(t, nil, err)
+
+	err = mock.ExpectationsWereMet()
+	assert.Equal(t, nil, err)
+}
+
+func TestNaturalLess(t *testing.T) {
+	ordered := []string{"", "1.png", "2.png", "02.png", "10.png", "p1.png", "p1a.png", "p2.png", "p10.png", "p10b.png",
+		"page.png"}
+	for i := range ordered {
+		for j := range ordered {
+			assert.Equal(t, i < j, NaturalLess(ordered[i], ordered[j]))
+		}
+	}
+}
+
 func TestUpdatePage(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	assert.Equal(t, nil, err)
 	defer db.Close()
 
-	p := Page{}
+	const query string = "UPDATE `pages` SET `name` = \\?, `created_at` = \\?, `mime_type` = \\?, `sort_index` = \\?, `role` = \\? WHERE `id` = \\? AND `release_id` = \\? LIMIT 1"
+	p := NewPage(Release{Id: 5}, "img.png", time.Now())
+	p.Id = 7
+	p.SortIndex = 2
+
+	// tests validation failed case
+	_, err = UpdatePage(db, Page{Id: 7, Name: "img.bmp"})
+	assert.Equal(t, ErrPageUnsupportedMimeType, err)
+
+	// success case
+	mock.ExpectExec(query).WithArgs(p.Name, p.CreatedAt, p.MimeType, p.SortIndex, PRoleContent, p.Id, p.ReleaseID).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// error case
+	expErr := errors.New("error")
+	mock.ExpectExec(query).WithArgs(p.Name, p.CreatedAt, p.MimeType, p.SortIndex, PRoleContent, p.Id, p.ReleaseID).WillReturnError(expErr)
+
+	// tests success case
 	p, err = UpdatePage(db, p)
-	assert.Equal(t, ErrOperationNotSupported, err)
+	assert.Equal(t, nil, err)
+
+	// tests error case
+	_, err = UpdatePage(db, p)
+	assert.Equal(t, expErr, err)
 
 	err = mock.ExpectationsWereMet()
 	assert.Equal(t, nil, err)
@@ -313,3 +463,25 @@ func TestDeletePage(t *testing.T) {
 	err = mock.ExpectationsWereMet()
 	assert.Equal(t, nil, err)
 }
+
+func TestCountPages(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	const query_count string = "SELECT COUNT\\(\\*\\) FROM `pages` WHERE `release_id` = \\?"
+	r := Release{Id: 3}
+	expErr := errors.New("error")
+	mock.ExpectQuery(query_count).WithArgs(r.Id).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(7))
+	mock.ExpectQuery(query_count).WithArgs(r.Id).WillReturnError(expErr)
+
+	count, err := CountPages(db, r)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint32(7), count)
+
+	_, err = CountPages(db, r)
+	assert.Equal(t, expErr, err)
+
+	err = mock.ExpectationsWereMet()
+	assert.Equal(t, nil, err)
+}
@@ -118,15 +118,51 @@ func FindProject(db database.DB, id uint32) (Project, error) {
 	return p, nil
 }
 
-// ListProjects attempts to obtain a list of all of the projects in the database.
-func ListProjects(db database.DB) ([]Project, error) {
+// ProjectFilter restricts which projects are returned by ListProjects and CountProjects. Empty fields do not filter.
+type ProjectFilter struct {
+	Status string
+}
+
+var projectSortColumns = map[string]string{
+	"id":        Pc_id,
+	"name":      Pc_name,
+	"shorthand": Pc_shorthand,
+	"status":    Pc_status,
+	"createdAt": Pc_created_at,
+}
+
+func (f ProjectFilter) where() (string, []interface{}, error) {
+	clause := " WHERE 1"
+	args := []interface{}{}
+	if f.Status != "" {
+		s := NewProjectStatus(f.Status)
+		if s == PStatusUnknown {
+			return "", nil, ErrInvalidProjectStatus
+		}
+		clause += " AND " + Pc_status + " = ?"
+		args = append(args, s)
+	}
+	return clause, args, nil
+}
+
+// ListProjects attempts to obtain a list of the projects in the database matching the filter.
+func ListProjects(db database.DB, filter ProjectFilter, opts ListOptions) ([]Project, error) {
 	projects := []Project{}
 
-	const query = "SELECT " + Pc_id + ", " + Pc_name + ", " +
+	where, args, err := filter.where()
+	if err != nil {
+		return projects, err
+	}
+	order, orderArgs, err := opts.orderAndLimit(projectSortColumns, "id", Pc_id)
+	if err != nil {
+		return projects, err
+	}
+
+	query := "SELECT " + Pc_id + ", " + Pc_name + ", " +
 		Pc_shorthand + ", " + Pc_description + ", " + Pc_status + ", " +
-		Pc_created_at + " FROM " + t_projects
+		Pc_created_at + " FROM " + t_projects + where + order
 
-	rows, err := db.Query(query)
+	rows, err := db.Query(query, append(args, orderArgs...)...)
 	if err != nil {
 		return []Project{}, err
 	}
@@ -146,6 +182,19 @@ func ListProjects(db database.DB) ([]Project, error) {
 	return projects, err
 }
 
+// CountProjects counts the projects in the database matching the filter.
+func CountProjects(db database.DB, filter ProjectFilter) (uint32, error) {
+	where, args, err := filter.where()
+	if err != nil {
+		return 0, err
+	}
+
+	var count uint32
+	query := "SELECT COUNT(*) FROM " + t_projects + where
+	err = db.QueryRow(query, args...).Scan(&count)
+	return count, err
+}
+
 // Validate checks that the "status" of the project is one of the accepted ProjectStatus values.
 func (p *Project) Validate() error {
 	if PStatusUnknown == NewProjectStatus(p.Status) {
@@ -122,29 +122,29 @@ func TestListProjects(t *testing.T) {
 	mock.ExpectQuery(query_select).WillReturnRows(rows4)
 
 	// tests the error case
-	_, err = ListProjects(db)
+	_, err = ListProjects(db, ProjectFilter{}, ListOptions{})
 	assert.Equal(t, expErr, err)
 
 	// tests the no results case
-	projects, err := ListProjects(db)
+	projects, err := ListProjects(db, ProjectFilter{}, ListOptions{})
 	assert.Equal(t, nil, err)
 	assert.Equal(t, 0, len(projects))
 
 	// tests the some results case
-	projects, err = ListProjects(db)
+	projects, err = ListProjects(db, ProjectFilter{}, ListOptions{})
 	assert.Equal(t, nil, err)
 	assert.Equal(t, 2, len(projects))
 	assert.Equal(t, p1, projects[0])
 	assert.Equal(t, p2, projects[1])
 
 	// tests some results with error case
-	projects, err = ListProjects(db)
+	projects, err = ListProjects(db, ProjectFilter{}, ListOptions{})
 	assert.Equal(t, expErr2, err)
 	assert.Equal(t, 1, len(projects))
 	assert.Equal(t, p1, projects[0])
 
 	// tests some results with scan error case
-	projects, err = ListProjects(db)
+	projects, err = ListProjects(db, ProjectFilter{}, ListOptions{})
 	assert.NotEqual(t, nil, err)
 	assert.Equal(t, 1, len(projects))
 	assert.Equal(t, p1, projects[0])
@@ -293,3 +293,52 @@ func TestDeleteProject(t *testing.T) {
 	err = mock.ExpectationsWereMet()
 	assert.Equal(t, nil, err)
 }
+
+func TestListProjectsFiltered(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	const query_select string = "SELECT .* FROM `projects` WHERE 1 AND `status` = \\? ORDER BY `created_at` DESC, `id` DESC LIMIT \\? OFFSET \\?"
+	cols := []string{"id", "name", "shorthand", "description", "status", "created_at"}
+	mock.ExpectQuery(query_select).WithArgs(PStatusActive, uint32(5), uint32(10)).WillReturnRows(sqlmock.NewRows(cols))
+
+	filter := ProjectFilter{Status: PStatusActiveStr}
+	opts := ListOptions{Limit: 5, Offset: 10, Sort: "createdAt", Desc: true}
+	projects, err := ListProjects(db, filter, opts)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(projects))
+
+	_, err = ListProjects(db, ProjectFilter{Status: "bogus"}, ListOptions{})
+	assert.Equal(t, ErrInvalidProjectStatus, err)
+
+	_, err = ListProjects(db, ProjectFilter{}, ListOptions{Sort: "bogus"})
+	assert.Equal(t, ErrInvalidSortField, err)
+
+	err = mock.ExpectationsWereMet()
+	assert.Equal(t, nil, err)
+}
+
+func TestCountProjects(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	const query_count string = "SELECT COUNT\\(\\*\\) FROM `projects` WHERE 1"
+	mock.ExpectQuery(query_count).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery(query_count + " AND `status` = \\?").WithArgs(PStatusDropped).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	count, err := CountProjects(db, ProjectFilter{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint32(3), count)
+
+	count, err = CountProjects(db, ProjectFilter{Status: PStatusDroppedStr})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint32(1), count)
+
+	_, err = CountProjects(db, ProjectFilter{Status: "bogus"})
+	assert.Equal(t, ErrInvalidProjectStatus, err)
+
+	err = mock.ExpectationsWereMet()
+	assert.Equal(t, nil, err)
+}
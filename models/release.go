@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"ims-release/database"
+	"regexp"
 	"time"
 )
 
@@ -11,25 +12,32 @@ import (
 // about which chapter of manga the release was created for, which version of the release of said chapter it is for, and
 // the status of the release of the chapter itself, which may not be final right away.
 type Release struct {
-	Id         uint32    `json:"id"`
-	Identifier string    `json:"identifier"`
-	Scanlator  string    `json:"scanlator"`
-	Version    uint32    `json:"version"`
-	Status     string    `json:"status"`
-	ReleasedOn time.Time `json:"releasedOn"`
-	ProjectID  uint32    `json:"-"`
+	Id            uint32     `json:"id"`
+	Identifier    string     `json:"identifier"`
+	Scanlator     string     `json:"scanlator"`
+	Version       uint32     `json:"version"`
+	Status        string     `json:"status"`
+	ReleasedOn    time.Time  `json:"releasedOn"`
+	PublishAt     *time.Time `json:"publishAt,omitempty"`
+	RetractReason string     `json:"retractReason,omitempty"`
+	ProjectID     uint32     `json:"-"`
 }
 
 type ReleaseStatus int
 
-// ReleaseStatus pseudo-enum values
+// ReleaseStatus pseudo-enum values. A scheduled release is published once its PublishAt time has come. A retracted
+// release was taken down after being published, and is hidden without losing its pages or release date.
 const (
-	RStatusUnknown     ReleaseStatus = 0
-	RStatusUnknownStr  string        = "unknown"
-	RStatusReleased    ReleaseStatus = 1
-	RStatusReleasedStr string        = "released"
-	RStatusDraft       ReleaseStatus = 2
-	RStatusDraftStr    string        = "draft"
+	RStatusUnknown      ReleaseStatus = 0
+	RStatusUnknownStr   string        = "unknown"
+	RStatusReleased     ReleaseStatus = 1
+	RStatusReleasedStr  string        = "released"
+	RStatusDraft        ReleaseStatus = 2
+	RStatusDraftStr     string        = "draft"
+	RStatusScheduled    ReleaseStatus = 3
+	RStatusScheduledStr string        = "scheduled"
+	RStatusRetracted    ReleaseStatus = 4
+	RStatusRetractedStr string        = "retracted"
 )
 
 func (s ReleaseStatus) String() string {
@@ -38,6 +46,10 @@ func (s ReleaseStatus) String() string {
 		return RStatusReleasedStr
 	case RStatusDraft:
 		return RStatusDraftStr
+	case RStatusScheduled:
+		return RStatusScheduledStr
+	case RStatusRetracted:
+		return RStatusRetractedStr
 	default:
 		return RStatusUnknownStr
 	}
@@ -49,6 +61,10 @@ func NewReleaseStatus(val string) ReleaseStatus {
 		return RStatusReleased
 	case RStatusDraftStr:
 		return RStatusDraft
+	case RStatusScheduledStr:
+		return RStatusScheduled
+	case RStatusRetractedStr:
+		return RStatusRetracted
 	default:
 		return RStatusUnknown
 	}
@@ -58,19 +74,24 @@ func NewReleaseStatus(val string) ReleaseStatus {
 var (
 	ErrInvalidReleaseStatus = errors.New("Invalid release status.")
 	ErrNoSuchRelease        = errors.New("Could not find release.")
+	ErrPublishAtMissing     = errors.New("Scheduled releases need a publish time.")
+	ErrReleaseNotDue        = errors.New("Release is no longer due to be published.")
 )
 
 // Database queries for operations on Releases.
 const (
-	t_releases     string = "`releases`"
-	Rc_id          string = "`id`"
-	Rc_identifier  string = "`identifier`"
-	Rc_version     string = "`version`"
-	Rc_status      string = "`status`"
-	Rc_released_on string = "`released_on`"
-	Rc_project_id  string = "`project_id`"
-
-	Rmax_len_identifier = 10
+	t_releases        string = "`releases`"
+	Rc_id             string = "`id`"
+	Rc_identifier     string = "`identifier`"
+	Rc_version        string = "`version`"
+	Rc_status         string = "`status`"
+	Rc_released_on    string = "`released_on`"
+	Rc_publish_at     string = "`publish_at`"
+	Rc_retract_reason string = "`retract_reason`"
+	Rc_project_id     string = "`project_id`"
+
+	Rmax_len_identifier     = 10
+	Rmax_len_retract_reason = 255
 )
 
 // NewRelease constructs a brand new Release instance, with a default state lacking information its (future) position in
@@ -83,6 +104,8 @@ func NewRelease(p Project, identifier string, version uint32, tm time.Time) Rele
 		version,
 		RStatusDraftStr,
 		tm,
+		nil,
+		"",
 		p.Id,
 	}
 }
@@ -93,11 +116,11 @@ func FindRelease(db database.DB, project Project, releaseId uint32) (Release, er
 	var s ReleaseStatus
 
 	const query = "SELECT " + Rc_identifier + ", " + Rc_version + ", " +
-		Rc_status + ", " + Rc_released_on +
+		Rc_status + ", " + Rc_released_on + ", " + Rc_publish_at + ", " + Rc_retract_reason +
 		" FROM " + t_releases + " WHERE " + Rc_id + " = ? AND " + Rc_project_id + " = ?"
 
 	row := db.QueryRow(query, releaseId, project.Id)
-	err := row.Scan(&r.Identifier, &r.Version, &s, &r.ReleasedOn)
+	err := row.Scan(&r.Identifier, &r.Version, &s, &r.ReleasedOn, &r.PublishAt, &r.RetractReason)
 
 	if err == database.ErrNoRows {
 		return Release{}, ErrNoSuchRelease
@@ -112,14 +135,75 @@ func FindRelease(db database.DB, project Project, releaseId uint32) (Release, er
 	return r, nil
 }
 
-// ListReleases attempts to obtain a list of all of the releases in the database.
-func ListReleases(db database.DB, project Project) ([]Release, error) {
+// ReleaseFilter restricts which releases are returned by ListReleases and CountReleases. Empty fields do not filter.
+// ExcludeRetracted leaves out retracted releases.
+type ReleaseFilter struct {
+	Status           string
+	ReleasedSince    time.Time
+	ReleasedBefore   time.Time
+	ExcludeRetracted bool
+}
+
+var releaseSortColumns = map[string]string{
+	"id":         Rc_id,
+	"identifier": Rc_identifier,
+	"version":    Rc_version,
+	"status":     Rc_status,
+	"releasedOn": Rc_released_on,
+}
+
+// conditions produces the filter's conditions, each preceded by AND, with columns qualified by prefix.
+func (f ReleaseFilter) conditions(prefix string) (string, []interface{}, error) {
+	clause := ""
+	args := []interface{}{}
+	if f.Status != "" {
+		s := NewReleaseStatus(f.Status)
+		if s == RStatusUnknown {
+			return "", nil, ErrInvalidReleaseStatus
+		}
+		clause += " AND " + prefix + Rc_status + " = ?"
+		args = append(args, s)
+	}
+	if f.ExcludeRetracted {
+		clause += " AND " + prefix + Rc_status + " != ?"
+		args = append(args, RStatusRetracted)
+	}
+	if !f.ReleasedSince.IsZero() {
+		clause += " AND " + prefix + Rc_released_on + " >= ?"
+		args = append(args, f.ReleasedSince)
+	}
+	if !f.ReleasedBefore.IsZero() {
+		clause += " AND " + prefix + Rc_released_on + " < ?"
+		args = append(args, f.ReleasedBefore)
+	}
+	return clause, args, nil
+}
+
+func (f ReleaseFilter) where(project Project) (string, []interface{}, error) {
+	clause, args, err := f.conditions("")
+	if err != nil {
+		return "", nil, err
+	}
+	return " WHERE " + Rc_project_id + " = ?" + clause, append([]interface{}{project.Id}, args...), nil
+}
+
+// ListReleases attempts to obtain a list of the releases of a project matching the filter.
+func ListReleases(db database.DB, project Project, filter ReleaseFilter, opts ListOptions) ([]Release, error) {
 	releases := []Release{}
 
-	const query = "SELECT " + Rc_id + ", " + Rc_identifier + ", " +
-		Rc_version + ", " + Rc_status + ", " + Rc_released_on +
-		" FROM " + t_releases + " WHERE " + Rc_project_id + " = ?"
-	rows, err := db.Query(query, project.Id)
+	where, args, err := filter.where(project)
+	if err != nil {
+		return releases, err
+	}
+	order, orderArgs, err := opts.orderAndLimit(releaseSortColumns, "id", Rc_id)
+	if err != nil {
+		return releases, err
+	}
+
+	query := "SELECT " + Rc_id + ", " + Rc_identifier + ", " +
+		Rc_version + ", " + Rc_status + ", " + Rc_released_on + ", " + Rc_publish_at + ", " + Rc_retract_reason +
+		" FROM " + t_releases + where + order
+	rows, err := db.Query(query, append(args, orderArgs...)...)
 	if err != nil {
 		return releases, err
 	}
@@ -128,7 +212,8 @@ func ListReleases(db database.DB, project Project) ([]Release, error) {
 		// @TODO make scanlator variable
 		release := Release{ProjectID: project.Id, Scanlator: "ims"}
 		var status ReleaseStatus
-		err = rows.Scan(&release.Id, &release.Identifier, &release.Version, &status, &release.ReleasedOn)
+		err = rows.Scan(&release.Id, &release.Identifier, &release.Version, &status, &release.ReleasedOn, &release.PublishAt,
+			&release.RetractReason)
 		if err != nil {
 			return releases, err
 		}
@@ -140,12 +225,98 @@ func ListReleases(db database.DB, project Project) ([]Release, error) {
 	return releases, err
 }
 
+// CountReleases counts the releases of a project matching the filter.
+func CountReleases(db database.DB, project Project, filter ReleaseFilter) (uint32, error) {
+	where, args, err := filter.where(project)
+	if err != nil {
+		return 0, err
+	}
+
+	var count uint32
+	query := "SELECT COUNT(*) FROM " + t_releases + where
+	err = db.QueryRow(query, args...).Scan(&count)
+	return count, err
+}
+
+// ProjectRelease is a release listed together with the project it belongs to and the name of its archive.
+type ProjectRelease struct {
+	Release
+	ProjectName      string `json:"projectName"`
+	ProjectShorthand string `json:"projectShorthand"`
+	ArchiveName      string `json:"archiveName"`
+}
+
+var projectReleaseSortColumns = map[string]string{
+	"id":         "r." + Rc_id,
+	"identifier": "r." + Rc_identifier,
+	"version":    "r." + Rc_version,
+	"status":     "r." + Rc_status,
+	"releasedOn": "r." + Rc_released_on,
+}
+
+// ListAllReleases attempts to obtain a list of the releases of all projects matching the filter.
+func ListAllReleases(db database.DB, filter ReleaseFilter, opts ListOptions) ([]ProjectRelease, error) {
+	releases := []ProjectRelease{}
+
+	where, args, err := filter.conditions("r.")
+	if err != nil {
+		return releases, err
+	}
+	order, orderArgs, err := opts.orderAndLimit(projectReleaseSortColumns, "id", "r."+Rc_id)
+	if err != nil {
+		return releases, err
+	}
+
+	query := "SELECT r." + Rc_id + ", r." + Rc_identifier + ", r." + Rc_version + ", r." + Rc_status +
+		", r." + Rc_released_on + ", r." + Rc_publish_at + ", r." + Rc_retract_reason + ", r." + Rc_project_id +
+		", p." + Pc_name + ", p." + Pc_shorthand +
+		" FROM " + t_releases + " r JOIN " + t_projects + " p ON p." + Pc_id + " = r." + Rc_project_id +
+		" WHERE 1" + where + order
+	rows, err := db.Query(query, append(args, orderArgs...)...)
+	if err != nil {
+		return releases, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		// @TODO make scanlator variable
+		pr := ProjectRelease{Release: Release{Scanlator: "ims"}}
+		var status ReleaseStatus
+		err = rows.Scan(&pr.Id, &pr.Identifier, &pr.Version, &status, &pr.ReleasedOn, &pr.PublishAt, &pr.RetractReason,
+			&pr.ProjectID, &pr.ProjectName, &pr.ProjectShorthand)
+		if err != nil {
+			return releases, err
+		}
+
+		pr.Status = status.String()
+		pr.ArchiveName = GenerateArchiveName(Project{Id: pr.ProjectID, Shorthand: pr.ProjectShorthand}, pr.Release)
+		releases = append(releases, pr)
+	}
+	err = rows.Err()
+	return releases, err
+}
+
+// CountAllReleases counts the releases of all projects matching the filter.
+func CountAllReleases(db database.DB, filter ReleaseFilter) (uint32, error) {
+	where, args, err := filter.conditions("")
+	if err != nil {
+		return 0, err
+	}
+
+	var count uint32
+	query := "SELECT COUNT(*) FROM " + t_releases + " WHERE 1" + where
+	err = db.QueryRow(query, args...).Scan(&count)
+	return count, err
+}
+
 // Validate checks that the "status" of the project is one of the accepted ReleaseStatus values.
 func (r *Release) Validate() error {
 	if NewReleaseStatus(r.Status) == RStatusUnknown {
 		return ErrInvalidReleaseStatus
 	}
-	if len(r.Identifier) > Rmax_len_identifier {
+	if NewReleaseStatus(r.Status) == RStatusScheduled && r.PublishAt == nil {
+		return ErrPublishAtMissing
+	}
+	if len(r.Identifier) > Rmax_len_identifier || len(r.RetractReason) > Rmax_len_retract_reason {
 		return ErrFieldTooLong
 	}
 	return nil
@@ -160,8 +331,9 @@ func SaveRelease(db database.DB, r Release) (Release, error) {
 
 	const query = "INSERT INTO " + t_releases + " (" +
 		Rc_identifier + ", " + Rc_version + ", " + Rc_status + ", " +
-		Rc_released_on + ", " + Rc_project_id + ") VALUES (?, ?, ?, ?, ?)"
-	res, err := db.Exec(query, r.Identifier, r.Version, NewReleaseStatus(r.Status), r.ReleasedOn, r.ProjectID)
+		Rc_released_on + ", " + Rc_publish_at + ", " + Rc_retract_reason + ", " + Rc_project_id + ") VALUES (?, ?, ?, ?, ?, ?, ?)"
+	res, err := db.Exec(query, r.Identifier, r.Version, NewReleaseStatus(r.Status), r.ReleasedOn, r.PublishAt,
+		r.RetractReason, r.ProjectID)
 	if err != nil {
 		return r, err
 	}
@@ -181,11 +353,66 @@ func UpdateRelease(db database.DB, r Release) (Release, error) {
 	}
 	const query = "UPDATE " + t_releases + " SET " +
 		Rc_identifier + " = ?, " + Rc_version + " = ?," + Rc_status + " = ?," +
-		Rc_released_on + " = ? WHERE " + Rc_id + " = ? AND " + Rc_project_id + " = ? LIMIT 1"
-	_, err := db.Exec(query, r.Identifier, r.Version, NewReleaseStatus(r.Status), r.ReleasedOn, r.Id, r.ProjectID)
+		Rc_released_on + " = ?, " + Rc_publish_at + " = ?, " + Rc_retract_reason + " = ? WHERE " + Rc_id + " = ? AND " +
+		Rc_project_id + " = ? LIMIT 1"
+	_, err := db.Exec(query, r.Identifier, r.Version, NewReleaseStatus(r.Status), r.ReleasedOn, r.PublishAt,
+		r.RetractReason, r.Id, r.ProjectID)
 	return r, err
 }
 
+// ListDueReleases attempts to obtain a list of the scheduled releases of all projects which are due to be published
+// at the given time, the longest overdue first.
+func ListDueReleases(db database.DB, tm time.Time) ([]Release, error) {
+	releases := []Release{}
+
+	const query = "SELECT " + Rc_id + ", " + Rc_identifier + ", " + Rc_version + ", " + Rc_released_on + ", " +
+		Rc_publish_at + ", " + Rc_project_id + " FROM " + t_releases + " WHERE " + Rc_status + " = ? AND " +
+		Rc_publish_at + " <= ? ORDER BY " + Rc_publish_at + " ASC, " + Rc_id + " ASC"
+	rows, err := db.Query(query, RStatusScheduled, tm)
+	if err != nil {
+		return releases, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		// @TODO make scanlator variable
+		release := Release{Status: RStatusScheduledStr, Scanlator: "ims"}
+		err = rows.Scan(&release.Id, &release.Identifier, &release.Version, &release.ReleasedOn, &release.PublishAt,
+			&release.ProjectID)
+		if err != nil {
+			return releases, err
+		}
+		releases = append(releases, release)
+	}
+	err = rows.Err()
+	return releases, err
+}
+
+// PublishScheduledRelease saves the status, release date and publish time of a scheduled release which the scheduler
+// has published or returned to draft. It fails with ErrReleaseNotDue if the release is no longer scheduled to be
+// published at tm, as when it was changed after ListDueReleases listed it.
+func PublishScheduledRelease(db database.DB, r Release, tm time.Time) (Release, error) {
+	validErr := r.Validate()
+	if validErr != nil {
+		return r, validErr
+	}
+	const query = "UPDATE " + t_releases + " SET " + Rc_status + " = ?, " + Rc_released_on + " = ?, " +
+		Rc_publish_at + " = ? WHERE " + Rc_id + " = ? AND " + Rc_project_id + " = ? AND " + Rc_status + " = ? AND " +
+		Rc_publish_at + " <= ? LIMIT 1"
+	res, err := db.Exec(query, NewReleaseStatus(r.Status), r.ReleasedOn, r.PublishAt, r.Id, r.ProjectID,
+		RStatusScheduled, tm)
+	if err != nil {
+		return r, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return r, err
+	}
+	if affected == 0 {
+		return r, ErrReleaseNotDue
+	}
+	return r, nil
+}
+
 // Delete removes the Release and all associated pages from the database.
 func DeleteRelease(db database.DB, r Release) (Release, error) {
 	const query = "DELETE FROM " + t_releases + " WHERE " + Rc_id + " = ?  AND " + Rc_project_id + " = ? LIMIT 1"
@@ -196,3 +423,29 @@ func DeleteRelease(db database.DB, r Release) (Release, error) {
 func GenerateArchiveName(p Project, r Release) string {
 	return fmt.Sprintf("%s - %s[%d][%s].zip", p.Shorthand, r.Identifier, r.Version, r.Scanlator)
 }
+
+// GenerateArchivePath produces the storage key under which a generated archive of a release is kept.
+func GenerateArchivePath(p Project, r Release, name string) string {
+	return fmt.Sprintf("archives/%d/%d/%s", p.Id, r.Id, name)
+}
+
+var (
+	identifierVolume = regexp.MustCompile(`(?i)v(?:ol)?\.?\s*(\d+(?:\.\d+)?)`)
+	identifierNumber = regexp.MustCompile(`(?i)c(?:h)?\.?\s*(\d+(?:\.\d+)?)`)
+	identifierPlain  = regexp.MustCompile(`^\d+(?:\.\d+)?$`)
+)
+
+// ParseReleaseIdentifier extracts the volume and chapter number from identifiers such as "v2c13", "ch12.5" or "7".
+// Parts which cannot be found are returned empty.
+func ParseReleaseIdentifier(identifier string) (volume string, number string) {
+	if identifierPlain.MatchString(identifier) {
+		return "", identifier
+	}
+	if m := identifierVolume.FindStringSubmatch(identifier); m != nil {
+		volume = m[1]
+	}
+	if m := identifierNumber.FindStringSubmatch(identifier); m != nil {
+		number = m[1]
+	}
+	return volume, number
+}
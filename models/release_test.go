@@ -12,13 +12,19 @@ import (
 func TestReleaseStatus(t *testing.T) {
 	sReleased := NewReleaseStatus("released")
 	sDraft := NewReleaseStatus("draft")
+	sScheduled := NewReleaseStatus("scheduled")
+	sRetracted := NewReleaseStatus("retracted")
 	sUnknown := NewReleaseStatus("somestring")
 	assert.Equal(t, ReleaseStatus(1), sReleased)
 	assert.Equal(t, ReleaseStatus(2), sDraft)
+	assert.Equal(t, ReleaseStatus(3), sScheduled)
+	assert.Equal(t, ReleaseStatus(4), sRetracted)
 	assert.Equal(t, ReleaseStatus(0), sUnknown)
 
 	assert.Equal(t, "released", sReleased.String())
 	assert.Equal(t, "draft", sDraft.String())
+	assert.Equal(t, "scheduled", sScheduled.String())
+	assert.Equal(t, "retracted", sRetracted.String())
 	assert.Equal(t, "unknown", sUnknown.String())
 
 	sUnknown = ReleaseStatus(5)
@@ -44,14 +50,14 @@ func TestFindRelease(t *testing.T) {
 
 	p := Project{Id: 7}
 	const id uint32 = 5
-	const query_select string = "SELECT (`[a-z_]+`, ){3}`[a-z_]+` FROM `releases` WHERE `id` = \\? AND `project_id` = \\?"
+	const query_select string = "SELECT (`[a-z_]+`, ){5}`[a-z_]+` FROM `releases` WHERE `id` = \\? AND `project_id` = \\?"
 
-	cols := []string{"identifier", "version", "status", "released_on"}
+	cols := []string{"identifier", "version", "status", "released_on", "publish_at", "retract_reason"}
 	rows := sqlmock.NewRows(cols)
 	rows2 := sqlmock.NewRows(cols)
 	tm := time.Now()
-	r1 := Release{Id: id, Identifier: "identifier", Version: 1, Status: RStatusReleasedStr, ReleasedOn: tm, ProjectID: p.Id, Scanlator: "ims"}
-	rows2.AddRow(r1.Identifier, r1.Version, NewReleaseStatus(r1.Status), r1.ReleasedOn)
+	r1 := Release{Id: id, Identifier: "identifier", Version: 1, Status: RStatusRetractedStr, ReleasedOn: tm, RetractReason: "takedown", ProjectID: p.Id, Scanlator: "ims"}
+	rows2.AddRow(r1.Identifier, r1.Version, NewReleaseStatus(r1.Status), r1.ReleasedOn, nil, r1.RetractReason)
 	// case of no rows
 	mock.ExpectQuery(query_select).WithArgs(id, p.Id).WillReturnRows(rows)
 
@@ -82,65 +88,68 @@ func TestListReleases(t *testing.T) {
 	assert.Equal(t, nil, err)
 	defer db.Close()
 
-	const query_select string = "SELECT (`[a-z_]+`, ){4}`[a-z_]+` FROM `releases`"
+	const query_select string = "SELECT (`[a-z_]+`, ){6}`[a-z_]+` FROM `releases`"
 	p := Project{Id: 9}
 
 	tm := time.Now()
 	r1 := Release{Id: 5, Identifier: "identifier", Version: 1, Status: RStatusReleasedStr, ReleasedOn: tm, ProjectID: p.Id, Scanlator: "ims"}
-	r2 := Release{Id: 9, Identifier: "identifier2", Version: 5, Status: RStatusDraftStr, ReleasedOn: tm, ProjectID: p.Id, Scanlator: "ims"}
+	publishAt := tm.Add(time.Hour)
+	r2 := Release{Id: 9, Identifier: "identifier2", Version: 5, Status: RStatusScheduledStr, ReleasedOn: tm, PublishAt: &publishAt, ProjectID: p.Id, Scanlator: "ims"}
 	// error case
 	expErr := errors.New("error")
 	mock.ExpectQuery(query_select).WithArgs(p.Id).WillReturnError(expErr)
 
 	// no results case
-	cols := []string{"id", "identifier", "version", "status", "released_on"}
+	cols := []string{"id", "identifier", "version", "status", "released_on", "publish_at", "retract_reason"}
 	rows := sqlmock.NewRows(cols)
 	mock.ExpectQuery(query_select).WithArgs(p.Id).WillReturnRows(rows)
 
 	// some results case
 	rows2 := sqlmock.NewRows(cols)
-	rows2.AddRow(r1.Id, r1.Identifier, r1.Version, NewReleaseStatus(r1.Status), r1.ReleasedOn)
-	rows2.AddRow(r2.Id, r2.Identifier, r2.Version, NewReleaseStatus(r2.Status), r2.ReleasedOn)
+	rows2.AddRow(r1.Id, r1.Identifier, r1.Version, NewReleaseStatus(r1.Status), r1.ReleasedOn, nil, "")
+	rows2.AddRow(r2.Id, r2.Identifier, r2.Version, NewReleaseStatus(r2.Status), r2.ReleasedOn, publishAt, "")
 	mock.ExpectQuery(query_select).WithArgs(p.Id).WillReturnRows(rows2)
 
 	// some results with error case
 	rows3 := sqlmock.NewRows(cols)
-	rows3.AddRow(r1.Id, r1.Identifier, r1.Version, NewReleaseStatus(r1.Status), r1.ReleasedOn)
-	rows3.AddRow(r2.Id, r2.Identifier, r2.Version, NewReleaseStatus(r2.Status), r2.ReleasedOn)
+	rows3.AddRow(r1.Id, r1.Identifier, r1.Version, NewReleaseStatus(r1.Status), r1.ReleasedOn, nil, "")
+	rows3.AddRow(r2.Id, r2.Identifier, r2.Version, NewReleaseStatus(r2.Status), r2.ReleasedOn, publishAt, "")
 	expErr2 := errors.New("row error")
 	rows3.RowError(1, expErr2)
 	mock.ExpectQuery(query_select).WithArgs(p.Id).WillReturnRows(rows3)
 
 	// some results with scan error case
 	rows4 := sqlmock.NewRows(cols)
-	rows4.AddRow(r1.Id, r1.Identifier, r1.Version, NewReleaseStatus(r1.Status), r1.ReleasedOn)
-	rows4.AddRow(r2.Id, r2.Identifier, r2.Version, NewReleaseStatus(r2.Status), "malformed time")
+	rows4.AddRow(r1.Id, r1.Identifier, r1.Version, NewReleaseStatus(r1.Status), r1.ReleasedOn, nil, "")
+	rows4.AddRow(r2.Id, r2.Identifier, r2.Version, NewReleaseStatus(r2.Status), "malformed time", publishAt, "")
 	mock.ExpectQuery(query_select).WithArgs(p.Id).WillReturnRows(rows4)
 
 	// tests the error case
-	_, err = ListReleases(db, p)
+	_, err = ListReleases(db, p, ReleaseFilter{}, ListOptions{})
 	assert.Equal(t, expErr, err)
 
 	// tests the no results case
-	releases, err := ListReleases(db, p)
+	releases, err := ListReleases(db, p, ReleaseFilter{}, ListOptions{})
 	assert.Equal(t, nil, err)
 	assert.Equal(t, 0, len(releases))
 
 	// tests the some results case
-	releases, err = ListReleases(db, p)
+	releases, err = ListReleases(db, p, ReleaseFilter{}, ListOptions{})
 	assert.Equal(t, nil, err)
 	assert.Equal(t, 2, len(releases))
 	assert.Equal(t, r1, releases[0])
-	assert.Equal(t, r2, releases[1])
+	assert.Equal(t, r2.Id, releases[1].Id)
+	assert.Equal(t, r2.Status, releases[1].Status)
+	assert.Equal(t, true, publishAt.Equal(*releases[1].PublishAt))
 
 	// tests some results with error case
-	releases, err = ListReleases(db, p)
+	releases, err = ListReleases(db, p, ReleaseFilter{}, ListOptions{})
 	assert.Equal(t, expErr2, err)
 	assert.Equal(t, 1, len(releases))
 	assert.Equal(t, r1, releases[0])
 
 	// tests some results with scan error case
-	releases, err = ListReleases(db, p)
+	releases, err = ListReleases(db, p, ReleaseFilter{}, ListOptions{})
 	assert.NotEqual(t, nil, err)
 	assert.Equal(t, 1, len(releases))
 	assert.Equal(t, r1, releases[0])
@@ -166,6 +175,20 @@ func TestValidateRelease(t *testing.T) {
 	r.Identifier = "a"
 	err = r.Validate()
 	assert.Equal(t, nil, err)
+
+	r.RetractReason = strings.Repeat("a", 256)
+	err = r.Validate()
+	assert.Equal(t, ErrFieldTooLong, err)
+	r.RetractReason = ""
+
+	r.Status = "scheduled"
+	err = r.Validate()
+	assert.Equal(t, ErrPublishAtMissing, err)
+
+	publishAt := time.Now()
+	r.PublishAt = &publishAt
+	err = r.Validate()
+	assert.Equal(t, nil, err)
 }
 
 func TestSaveRelease(t *testing.T) {
@@ -186,15 +209,15 @@ func TestSaveRelease(t *testing.T) {
 
 	// success case
 	r.Status = "draft"
-	mock.ExpectExec(query).WithArgs(r.Identifier, r.Version, NewReleaseStatus(r.Status), r.ReleasedOn, r.ProjectID).WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectExec(query).WithArgs(r.Identifier, r.Version, NewReleaseStatus(r.Status), r.ReleasedOn, r.PublishAt, r.RetractReason, r.ProjectID).WillReturnResult(sqlmock.NewResult(7, 1))
 
 	// error case
 	expErr := errors.New("error")
-	mock.ExpectExec(query).WithArgs(r.Identifier, r.Version, NewReleaseStatus(r.Status), r.ReleasedOn, r.ProjectID).WillReturnError(expErr)
+	mock.ExpectExec(query).WithArgs(r.Identifier, r.Version, NewReleaseStatus(r.Status), r.ReleasedOn, r.PublishAt, r.RetractReason, r.ProjectID).WillReturnError(expErr)
 
 	// error result case
 	expErr2 := errors.New("error2")
-	mock.ExpectExec(query).WithArgs(r.Identifier, r.Version, NewReleaseStatus(r.Status), r.ReleasedOn, r.ProjectID).WillReturnResult(sqlmock.NewErrorResult(expErr2))
+	mock.ExpectExec(query).WithArgs(r.Identifier, r.Version, NewReleaseStatus(r.Status), r.ReleasedOn, r.PublishAt, r.RetractReason, r.ProjectID).WillReturnResult(sqlmock.NewErrorResult(expErr2))
 
 	// tests success case
 	r, err = SaveRelease(db, r)
@@ -232,11 +255,11 @@ func TestUpdateRelease(t *testing.T) {
 
 	// success case
 	r.Status = "released"
-	mock.ExpectExec(query).WithArgs(r.Identifier, r.Version, NewReleaseStatus(r.Status), r.ReleasedOn, r.Id, r.ProjectID).WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectExec(query).WithArgs(r.Identifier, r.Version, NewReleaseStatus(r.Status), r.ReleasedOn, r.PublishAt, r.RetractReason, r.Id, r.ProjectID).WillReturnResult(sqlmock.NewResult(7, 1))
 
 	// error case
 	expErr := errors.New("error")
-	mock.ExpectExec(query).WithArgs(r.Identifier, r.Version, NewReleaseStatus(r.Status), r.ReleasedOn, r.Id, r.ProjectID).WillReturnError(expErr)
+	mock.ExpectExec(query).WithArgs(r.Identifier, r.Version, NewReleaseStatus(r.Status), r.ReleasedOn, r.PublishAt, r.RetractReason, r.Id, r.ProjectID).WillReturnError(expErr)
 
 	// tests success case
 	r, err = UpdateRelease(db, r)
@@ -250,6 +273,67 @@ func TestUpdateRelease(t *testing.T) {
 	assert.Equal(t, nil, err)
 }
 
+func TestListDueReleases(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	const query_select string = "SELECT (`[a-z_]+`, ){5}`[a-z_]+` FROM `releases` WHERE `status` = \\? AND `publish_at` <= \\? ORDER BY `publish_at` ASC, `id` ASC"
+	tm := time.Now()
+	publishAt := tm.Add(-time.Minute)
+	cols := []string{"id", "identifier", "version", "released_on", "publish_at", "project_id"}
+	rows := sqlmock.NewRows(cols).AddRow(3, "c12", 2, tm.Add(-time.Hour), publishAt, 9)
+	mock.ExpectQuery(query_select).WithArgs(RStatusScheduled, tm).WillReturnRows(rows)
+	expErr := errors.New("error")
+	mock.ExpectQuery(query_select).WithArgs(RStatusScheduled, tm).WillReturnError(expErr)
+
+	releases, err := ListDueReleases(db, tm)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(releases))
+	assert.Equal(t, uint32(3), releases[0].Id)
+	assert.Equal(t, uint32(9), releases[0].ProjectID)
+	assert.Equal(t, RStatusScheduledStr, releases[0].Status)
+	assert.Equal(t, true, publishAt.Equal(*releases[0].PublishAt))
+
+	_, err = ListDueReleases(db, tm)
+	assert.Equal(t, expErr, err)
+
+	err = mock.ExpectationsWereMet()
+	assert.Equal(t, nil, err)
+}
+
+func TestPublishScheduledRelease(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	const query string = "UPDATE `releases` SET `status` = \\?, `released_on` = \\?, `publish_at` = \\? WHERE `id` = \\? AND `project_id` = \\? AND `status` = \\? AND `publish_at` <= \\? LIMIT 1"
+	tm := time.Now()
+	r := Release{Id: 6, ProjectID: 5, Identifier: "Ch1", Version: 1, Status: "released", ReleasedOn: tm}
+	mock.ExpectExec(query).WithArgs(RStatusReleased, tm, r.PublishAt, r.Id, r.ProjectID, RStatusScheduled, tm).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(query).WithArgs(RStatusReleased, tm, r.PublishAt, r.Id, r.ProjectID, RStatusScheduled, tm).WillReturnResult(sqlmock.NewResult(0, 0))
+	expErr := errors.New("error")
+	mock.ExpectExec(query).WithArgs(RStatusReleased, tm, r.PublishAt, r.Id, r.ProjectID, RStatusScheduled, tm).WillReturnError(expErr)
+
+	_, err = PublishScheduledRelease(db, r, tm)
+	assert.Equal(t, nil, err)
+
+	// tests a release which was changed in the meantime
+	_, err = PublishScheduledRelease(db, r, tm)
+	assert.Equal(t, ErrReleaseNotDue, err)
+
+	_, err = PublishScheduledRelease(db, r, tm)
+	assert.Equal(t, expErr, err)
+
+	// tests validation failed case
+	r.Status = "invalid"
+	_, err = PublishScheduledRelease(db, r, tm)
+	assert.Equal(t, ErrInvalidReleaseStatus, err)
+
+	err = mock.ExpectationsWereMet()
+	assert.Equal(t, nil, err)
+}
+
 func TestDeleteRelease(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	assert.Equal(t, nil, err)
@@ -279,3 +363,131 @@ func TestGenerateArchiveName(t *testing.T) {
 	name := GenerateArchiveName(p, r)
 	assert.Equal(t, "short - v1[1][scans].zip", name)
 }
+
+func TestParseReleaseIdentifier(t *testing.T) {
+	cases := []struct{ identifier, volume, number string }{
+		{"7", "", "7"},
+		{"12.5", "", "12.5"},
+		{"c12", "", "12"},
+		{"ch12.5", "", "12.5"},
+		{"v2c13", "2", "13"},
+		{"Vol.3 Ch.20", "3", "20"},
+		{"v4", "4", ""},
+		{"oneshot", "", ""},
+	}
+	for _, c := range cases {
+		volume, number := ParseReleaseIdentifier(c.identifier)
+		assert.Equal(t, c.volume, volume)
+		assert.Equal(t, c.number, number)
+	}
+}
+
+func TestListReleasesFiltered(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	const query_select string = "SELECT .* FROM `releases` WHERE `project_id` = \\? AND `status` = \\? AND `released_on` >= \\? ORDER BY `released_on` DESC, `id` DESC LIMIT \\?"
+	p := Project{Id: 9}
+	since := time.Now()
+	cols := []string{"id", "identifier", "version", "status", "released_on", "publish_at", "retract_reason"}
+	mock.ExpectQuery(query_select).WithArgs(p.Id, RStatusReleased, since, uint32(20)).WillReturnRows(sqlmock.NewRows(cols))
+
+	filter := ReleaseFilter{Status: RStatusReleasedStr, ReleasedSince: since}
+	releases, err := ListReleases(db, p, filter, ListOptions{Limit: 20, Sort: "releasedOn", Desc: true})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(releases))
+
+	_, err = ListReleases(db, p, ReleaseFilter{Status: "bogus"}, ListOptions{})
+	assert.Equal(t, ErrInvalidReleaseStatus, err)
+
+	_, err = ListReleases(db, p, ReleaseFilter{}, ListOptions{Sort: "bogus"})
+	assert.Equal(t, ErrInvalidSortField, err)
+
+	err = mock.ExpectationsWereMet()
+	assert.Equal(t, nil, err)
+}
+
+func TestCountReleases(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	const query_count string = "SELECT COUNT\\(\\*\\) FROM `releases` WHERE `project_id` = \\?"
+	p := Project{Id: 9}
+	expErr := errors.New("error")
+	mock.ExpectQuery(query_count).WithArgs(p.Id).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(4))
+	mock.ExpectQuery(query_count).WithArgs(p.Id).WillReturnError(expErr)
+
+	count, err := CountReleases(db, p, ReleaseFilter{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint32(4), count)
+
+	_, err = CountReleases(db, p, ReleaseFilter{})
+	assert.Equal(t, expErr, err)
+
+	err = mock.ExpectationsWereMet()
+	assert.Equal(t, nil, err)
+}
+
+func TestListAllReleases(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	const query_select string = "SELECT .* FROM `releases` r JOIN `projects` p ON p.`id` = r.`project_id` WHERE 1 AND r.`status` = \\? AND r.`released_on` >= \\? AND r.`released_on` < \\? ORDER BY r.`released_on` DESC, r.`id` DESC LIMIT \\?"
+	since := time.Now()
+	before := since.Add(time.Hour)
+	cols := []string{"id", "identifier", "version", "status", "released_on", "publish_at", "retract_reason", "project_id", "name", "shorthand"}
+	rows := sqlmock.NewRows(cols).AddRow(3, "c12", 2, RStatusReleased, since, nil, "", 9, "Project", "proj")
+	mock.ExpectQuery(query_select).WithArgs(RStatusReleased, since, before, uint32(20)).WillReturnRows(rows)
+	expErr := errors.New("error")
+	mock.ExpectQuery("SELECT .* FROM `releases` r JOIN `projects` p .* ORDER BY r.`id` ASC").WillReturnError(expErr)
+
+	filter := ReleaseFilter{Status: RStatusReleasedStr, ReleasedSince: since, ReleasedBefore: before}
+	releases, err := ListAllReleases(db, filter, ListOptions{Limit: 20, Sort: "releasedOn", Desc: true})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(releases))
+	r := Release{Id: 3, Identifier: "c12", Scanlator: "ims", Version: 2, Status: RStatusReleasedStr, ReleasedOn: since, ProjectID: 9}
+	assert.Equal(t, ProjectRelease{Release: r, ProjectName: "Project", ProjectShorthand: "proj", ArchiveName: "proj - c12[2][ims].zip"}, releases[0])
+
+	_, err = ListAllReleases(db, ReleaseFilter{}, ListOptions{})
+	assert.Equal(t, expErr, err)
+
+	_, err = ListAllReleases(db, ReleaseFilter{Status: "bogus"}, ListOptions{})
+	assert.Equal(t, ErrInvalidReleaseStatus, err)
+
+	_, err = ListAllReleases(db, ReleaseFilter{}, ListOptions{Sort: "bogus"})
+	assert.Equal(t, ErrInvalidSortField, err)
+
+	err = mock.ExpectationsWereMet()
+	assert.Equal(t, nil, err)
+}
+
+func TestCountAllReleases(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	const query_count string = "SELECT COUNT\\(\\*\\) FROM `releases` WHERE 1 AND `status` = \\?"
+	expErr := errors.New("error")
+	mock.ExpectQuery(query_count).WithArgs(RStatusDraft).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(4))
+	mock.ExpectQuery(query_count).WithArgs(RStatusDraft).WillReturnError(expErr)
+
+	count, err := CountAllReleases(db, ReleaseFilter{Status: RStatusDraftStr})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint32(4), count)
+
+	_, err = CountAllReleases(db, ReleaseFilter{Status: RStatusDraftStr})
+	assert.Equal(t, expErr, err)
+
+	// retracted releases can be left out
+	const query_visible string = "SELECT COUNT\\(\\*\\) FROM `releases` WHERE 1 AND `status` != \\?"
+	mock.ExpectQuery(query_visible).WithArgs(RStatusRetracted).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	count, err = CountAllReleases(db, ReleaseFilter{ExcludeRetracted: true})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint32(3), count)
+
+	err = mock.ExpectationsWereMet()
+	assert.Equal(t, nil, err)
+}
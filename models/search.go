@@ -0,0 +1,208 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"ims-release/database"
+	"sort"
+	"strings"
+)
+
+// SearchResult is a single match returned by Search. Type tells whether the match is the project itself or one of
+// its releases, in which case Release is set. Results with a higher Score are better matches.
+type SearchResult struct {
+	Type    string   `json:"type"`
+	Score   float64  `json:"score"`
+	Project Project  `json:"project"`
+	Release *Release `json:"release,omitempty"`
+}
+
+// Types of search results
+const (
+	SearchTypeProject = "project"
+	SearchTypeRelease = "release"
+)
+
+// SearchMode is a type alias which will be used to create an enum of the ways text can be matched.
+type SearchMode uint32
+
+// SearchMode pseudo-enum values. Fulltext mode relies on the MySQL FULLTEXT index on project names and descriptions,
+// while like mode only uses standard SQL and works with any database.
+const (
+	SModeUnknown     SearchMode = 0
+	SModeUnknownStr  string     = "unknown"
+	SModeFulltext    SearchMode = 1
+	SModeFulltextStr string     = "fulltext"
+	SModeLike        SearchMode = 2
+	SModeLikeStr     string     = "like"
+)
+
+func (m SearchMode) String() string {
+	switch m {
+	case SModeFulltext:
+		return SModeFulltextStr
+	case SModeLike:
+		return SModeLikeStr
+	default:
+		return SModeUnknownStr
+	}
+}
+
+func NewSearchMode(val string) SearchMode {
+	switch val {
+	case SModeFulltextStr:
+		return SModeFulltext
+	case SModeLikeStr:
+		return SModeLike
+	default:
+		return SModeUnknown
+	}
+}
+
+// Errors pertaining to searches.
+var (
+	ErrInvalidSearchMode = errors.New("Invalid search mode.")
+)
+
+// likeEscape escapes the LIKE wildcards in s. The escape character is given explicitly in the queries below since
+// the default differs between databases.
+var likeEscape = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// likePatterns produces the lowercase exact, prefix and substring LIKE patterns for a query.
+func likePatterns(q string) (string, string, string) {
+	q = likeEscape.Replace(strings.ToLower(q))
+	return q, q + "%", "%" + q + "%"
+}
+
+// likeScore produces a score expression for a column, to be used with the arguments from likePatterns. Short fields
+// are compared as a whole, so exact matches score highest, followed by prefix and substring matches.
+func likeScore(column string) string {
+	return "CASE WHEN " + column + " LIKE ? ESCAPE '!' THEN 10" +
+		" WHEN " + column + " LIKE ? ESCAPE '!' THEN 5" +
+		" WHEN " + column + " LIKE ? ESCAPE '!' THEN 2 ELSE 0 END"
+}
+
+// Search looks for projects whose name, shorthand or description match the query, and for releases whose identifier
+// matches it. The results are ordered by descending score and at most limit are returned.
+func Search(db database.DB, mode SearchMode, q string, limit uint32) ([]SearchResult, error) {
+	var projects []SearchResult
+	var err error
+	switch mode {
+	case SModeFulltext:
+		projects, err = searchProjectsFulltext(db, q, limit)
+	case SModeLike:
+		projects, err = searchProjectsLike(db, q, limit)
+	default:
+		err = ErrInvalidSearchMode
+	}
+	if err != nil {
+		return []SearchResult{}, err
+	}
+
+	releases, err := searchReleases(db, q, limit)
+	if err != nil {
+		return []SearchResult{}, err
+	}
+
+	results := append(projects, releases...)
+	// projects come first, so they win ties with their own releases
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if uint32(len(results)) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+const searchProjectColumns = Pc_id + ", " + Pc_name + ", " + Pc_shorthand + ", " +
+	Pc_description + ", " + Pc_status + ", " + Pc_created_at
+
+func searchProjectsFulltext(db database.DB, q string, limit uint32) ([]SearchResult, error) {
+	// shorthand is binary, so it is cast to text for a case insensitive comparison
+	const shorthand = "LOWER(CAST(" + Pc_shorthand + " AS CHAR))"
+	const match = "MATCH(" + Pc_name + ", " + Pc_description + ") AGAINST (? IN NATURAL LANGUAGE MODE)"
+	query := "SELECT " + searchProjectColumns + ", " + match + " + " + likeScore(shorthand) + " AS `score`" +
+		" FROM " + t_projects + " WHERE " + match + " OR " + shorthand + " LIKE ? ESCAPE '!'" +
+		" ORDER BY `score` DESC, " + Pc_id + " LIMIT ?"
+
+	exact, prefix, contains := likePatterns(q)
+	return scanProjectResults(db.Query(query, q, exact, prefix, contains, q, contains, limit))
+}
+
+func searchProjectsLike(db database.DB, q string, limit uint32) ([]SearchResult, error) {
+	const name = "LOWER(" + Pc_name + ")"
+	// shorthand is binary, so it is cast to text for a case insensitive comparison
+	const shorthand = "LOWER(CAST(" + Pc_shorthand + " AS CHAR))"
+	const description = "LOWER(" + Pc_description + ")"
+	query := "SELECT " + searchProjectColumns + ", " + likeScore(name) + " + " + likeScore(shorthand) +
+		" + CASE WHEN " + description + " LIKE ? ESCAPE '!' THEN 1 ELSE 0 END AS `score`" +
+		" FROM " + t_projects + " WHERE " + name + " LIKE ? ESCAPE '!' OR " + shorthand + " LIKE ? ESCAPE '!'" +
+		" OR " + description + " LIKE ? ESCAPE '!'" +
+		" ORDER BY `score` DESC, " + Pc_id + " LIMIT ?"
+
+	exact, prefix, contains := likePatterns(q)
+	return scanProjectResults(db.Query(query, exact, prefix, contains, exact, prefix, contains, contains,
+		contains, contains, contains, limit))
+}
+
+func scanProjectResults(rows *sql.Rows, err error) ([]SearchResult, error) {
+	results := []SearchResult{}
+	if err != nil {
+		return results, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		r := SearchResult{Type: SearchTypeProject}
+		var s ProjectStatus
+		err = rows.Scan(&r.Project.Id, &r.Project.Name, &r.Project.Shorthand, &r.Project.Description, &s,
+			&r.Project.CreatedAt, &r.Score)
+		if err != nil {
+			return results, err
+		}
+		r.Project.Status = s.String()
+		results = append(results, r)
+	}
+	err = rows.Err()
+	return results, err
+}
+
+// searchReleases matches release identifiers. They are too short to be worth indexing for full-text search, so
+// the same query is used in every mode. Retracted releases are left out.
+func searchReleases(db database.DB, q string, limit uint32) ([]SearchResult, error) {
+	results := []SearchResult{}
+	// identifier is binary, so it is cast to text for a case insensitive comparison
+	const identifier = "LOWER(CAST(r." + Rc_identifier + " AS CHAR))"
+	query := "SELECT r." + Rc_id + ", r." + Rc_identifier + ", r." + Rc_version + ", r." + Rc_status +
+		", r." + Rc_released_on + ", p." + Pc_id + ", p." + Pc_name + ", p." + Pc_shorthand +
+		", p." + Pc_description + ", p." + Pc_status + ", p." + Pc_created_at + ", " + likeScore(identifier) +
+		" AS `score` FROM " + t_releases + " r JOIN " + t_projects + " p ON p." + Pc_id + " = r." + Rc_project_id +
+		" WHERE " + identifier + " LIKE ? ESCAPE '!' AND r." + Rc_status + " != ? ORDER BY `score` DESC, r." + Rc_id +
+		" LIMIT ?"
+
+	exact, prefix, contains := likePatterns(q)
+	rows, err := db.Query(query, exact, prefix, contains, contains, RStatusRetracted, limit)
+	if err != nil {
+		return results, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		// @TODO make scanlator variable
+		release := Release{Scanlator: "ims"}
+		r := SearchResult{Type: SearchTypeRelease, Release: &release}
+		var rs ReleaseStatus
+		var ps ProjectStatus
+		err = rows.Scan(&release.Id, &release.Identifier, &release.Version, &rs, &release.ReleasedOn,
+			&r.Project.Id, &r.Project.Name, &r.Project.Shorthand, &r.Project.Description, &ps,
+			&r.Project.CreatedAt, &r.Score)
+		if err != nil {
+			return results, err
+		}
+		release.Status = rs.String()
+		release.ProjectID = r.Project.Id
+		r.Project.Status = ps.String()
+		results = append(results, r)
+	}
+	err = rows.Err()
+	return results, err
+}
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"errors"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"ims-release/assert"
+	"testing"
+	"time"
+)
+
+func TestSearchMode(t *testing.T) {
+	assert.Equal(t, SModeFulltext, NewSearchMode("fulltext"))
+	assert.Equal(t, SModeLike, NewSearchMode("like"))
+	assert.Equal(t, SModeUnknown, NewSearchMode("somestring"))
+	assert.Equal(t, "fulltext", SModeFulltext.String())
+	assert.Equal(t, "like", SModeLike.String())
+	assert.Equal(t, "unknown", SearchMode(3).String())
+}
+
+func TestLikePatterns(t *testing.T) {
+	exact, prefix, contains := likePatterns("Robo_100%!")
+	assert.Equal(t, "robo!_100!%!!", exact)
+	assert.Equal(t, "robo!_100!%!!%", prefix)
+	assert.Equal(t, "%robo!_100!%!!%", contains)
+}
+
+func TestSearch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	const query_fulltext string = "SELECT .*MATCH\\(`name`, `description`\\) AGAINST \\(\\? IN NATURAL LANGUAGE MODE\\).* FROM `projects` WHERE MATCH.* LIMIT \\?"
+	const query_like string = "SELECT .* FROM `projects` WHERE LOWER\\(`name`\\) LIKE \\? ESCAPE '!' OR LOWER\\(CAST\\(`shorthand` AS CHAR\\)\\) LIKE \\? ESCAPE '!' .* LIMIT \\?"
+	const query_releases string = "SELECT .* FROM `releases` r JOIN `projects` p .* WHERE LOWER\\(CAST\\(r.`identifier` AS CHAR\\)\\) LIKE \\? ESCAPE '!' AND r.`status` != \\? .* LIMIT \\?"
+
+	tm := time.Now()
+	projectCols := []string{"id", "name", "shorthand", "description", "status", "created_at", "score"}
+	releaseCols := []string{"id", "identifier", "version", "status", "released_on",
+		"project_id", "name", "shorthand", "description", "status", "created_at", "score"}
+	p1 := Project{Id: 1, Name: "Robotics", Shorthand: "robo", Description: "desc", Status: PStatusActiveStr, CreatedAt: tm}
+	p2 := Project{Id: 2, Name: "Other", Shorthand: "other", Description: "about robots", Status: PStatusStalledStr, CreatedAt: tm}
+	r1 := Release{Id: 3, Identifier: "robo1", Scanlator: "ims", Version: 1, Status: RStatusReleasedStr, ReleasedOn: tm, ProjectID: 1}
+
+	// fulltext mode, results from both queries are merged by score
+	rows := sqlmock.NewRows(projectCols).
+		AddRow(p1.Id, p1.Name, p1.Shorthand, p1.Description, PStatusActive, p1.CreatedAt, 11.5).
+		AddRow(p2.Id, p2.Name, p2.Shorthand, p2.Description, PStatusStalled, p2.CreatedAt, 0.7)
+	mock.ExpectQuery(query_fulltext).WithArgs("Robo", "robo", "robo%", "%robo%", "Robo", "%robo%", 3).WillReturnRows(rows)
+	rows = sqlmock.NewRows(releaseCols).
+		AddRow(r1.Id, r1.Identifier, r1.Version, RStatusReleased, r1.ReleasedOn,
+			p1.Id, p1.Name, p1.Shorthand, p1.Description, PStatusActive, p1.CreatedAt, 5)
+	mock.ExpectQuery(query_releases).WithArgs("robo", "robo%", "%robo%", "%robo%", RStatusRetracted, 3).WillReturnRows(rows)
+
+	results, err := Search(db, SModeFulltext, "Robo", 3)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(results))
+	assert.Equal(t, SearchResult{Type: SearchTypeProject, Score: 11.5, Project: p1}, results[0])
+	assert.Equal(t, SearchTypeRelease, results[1].Type)
+	assert.Equal(t, float64(5), results[1].Score)
+	assert.Equal(t, p1, results[1].Project)
+	assert.Equal(t, r1, *results[1].Release)
+	assert.Equal(t, SearchResult{Type: SearchTypeProject, Score: 0.7, Project: p2}, results[2])
+
+	// like mode, results are cut down to the limit
+	rows = sqlmock.NewRows(projectCols).
+		AddRow(p1.Id, p1.Name, p1.Shorthand, p1.Description, PStatusActive, p1.CreatedAt, 10)
+	mock.ExpectQuery(query_like).WillReturnRows(rows)
+	rows = sqlmock.NewRows(releaseCols).
+		AddRow(r1.Id, r1.Identifier, r1.Version, RStatusReleased, r1.ReleasedOn,
+			p1.Id, p1.Name, p1.Shorthand, p1.Description, PStatusActive, p1.CreatedAt, 5)
+	mock.ExpectQuery(query_releases).WillReturnRows(rows)
+
+	results, err = Search(db, SModeLike, "robo", 1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, SearchTypeProject, results[0].Type)
+
+	// errors
+	expErr := errors.New("error")
+	mock.ExpectQuery(query_like).WillReturnError(expErr)
+	_, err = Search(db, SModeLike, "robo", 1)
+	assert.Equal(t, expErr, err)
+
+	mock.ExpectQuery(query_like).WillReturnRows(sqlmock.NewRows(projectCols))
+	mock.ExpectQuery(query_releases).WillReturnError(expErr)
+	_, err = Search(db, SModeLike, "robo", 1)
+	assert.Equal(t, expErr, err)
+
+	_, err = Search(db, SModeUnknown, "robo", 1)
+	assert.Equal(t, ErrInvalidSearchMode, err)
+
+	err = mock.ExpectationsWereMet()
+	assert.Equal(t, nil, err)
+}
@@ -0,0 +1,125 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"ims-release/database"
+	"time"
+)
+
+// Session is a logged in user's session. The session token itself is only handed to the client; the database
+// keeps a hash of it so that a leaked table cannot be used to impersonate anyone. The CSRF token must accompany
+// every state changing request authenticated with the session cookie.
+type Session struct {
+	Id        uint32    `json:"-"`
+	TokenHash string    `json:"-"`
+	CsrfToken string    `json:"csrfToken"`
+	UserID    uint32    `json:"-"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Database constants for sessions
+const (
+	t_sessions     string = "`sessions`"
+	Sc_id          string = "`id`"
+	Sc_token_hash  string = "`token_hash`"
+	Sc_csrf_token  string = "`csrf_token`"
+	Sc_user_id     string = "`user_id`"
+	Sc_created_at  string = "`created_at`"
+	Sc_expires_at  string = "`expires_at`"
+	sessionTokenSz        = 32
+)
+
+// Errors pertaining to operations on Sessions.
+var (
+	ErrNoSuchSession = errors.New("Could not find session.")
+)
+
+func randomToken() (string, error) {
+	b := make([]byte, sessionTokenSz)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// HashSessionToken produces the value stored in the database for a session token.
+func HashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewSession constructs a session for a user which expires after lifetime. The returned token is the secret
+// which identifies the session and is not recoverable from the Session itself.
+func NewSession(u User, tm time.Time, lifetime time.Duration) (Session, string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return Session{}, "", err
+	}
+	csrf, err := randomToken()
+	if err != nil {
+		return Session{}, "", err
+	}
+
+	return Session{
+		0,
+		HashSessionToken(token),
+		csrf,
+		u.Id,
+		tm,
+		tm.Add(lifetime),
+	}, token, nil
+}
+
+// FindSession attempts to lookup a session which has not expired at tm by its token.
+func FindSession(db database.DB, token string, tm time.Time) (Session, error) {
+	s := Session{TokenHash: HashSessionToken(token)}
+	const query = "SELECT " + Sc_id + ", " + Sc_csrf_token + ", " + Sc_user_id + ", " +
+		Sc_created_at + ", " + Sc_expires_at + " FROM " + t_sessions +
+		" WHERE " + Sc_token_hash + " = ? AND " + Sc_expires_at + " > ?"
+
+	row := db.QueryRow(query, s.TokenHash, tm)
+	err := row.Scan(&s.Id, &s.CsrfToken, &s.UserID, &s.CreatedAt, &s.ExpiresAt)
+	if err == database.ErrNoRows {
+		return Session{}, ErrNoSuchSession
+	} else if err != nil {
+		return Session{}, err
+	}
+	return s, nil
+}
+
+// SaveSession inserts the session into the database and updates its Id field.
+func SaveSession(db database.DB, s Session) (Session, error) {
+	const query = "INSERT INTO " + t_sessions + " (" +
+		Sc_token_hash + ", " + Sc_csrf_token + ", " + Sc_user_id + ", " +
+		Sc_created_at + ", " + Sc_expires_at + ") VALUES (?, ?, ?, ?, ?)"
+
+	res, err := db.Exec(query, s.TokenHash, s.CsrfToken, s.UserID, s.CreatedAt, s.ExpiresAt)
+	if err != nil {
+		return s, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return s, err
+	}
+	s.Id = uint32(id)
+	return s, nil
+}
+
+// DeleteSession removes the session from the database, logging the user out.
+func DeleteSession(db database.DB, s Session) (Session, error) {
+	const query = "DELETE FROM " + t_sessions + " WHERE " + Sc_id + " = ? LIMIT 1"
+	_, err := db.Exec(query, s.Id)
+	return s, err
+}
+
+// DeleteExpiredSessions removes all sessions which have expired at tm.
+func DeleteExpiredSessions(db database.DB, tm time.Time) error {
+	const query = "DELETE FROM " + t_sessions + " WHERE " + Sc_expires_at + " <= ?"
+	_, err := db.Exec(query, tm)
+	return err
+}
@@ -0,0 +1,111 @@
+package models
+
+import (
+	"errors"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"ims-release/assert"
+	"testing"
+	"time"
+)
+
+func TestNewSession(t *testing.T) {
+	tm := time.Now()
+	u := User{Id: 3}
+	s, token, err := NewSession(u, tm, time.Hour)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 64, len(token))
+	assert.Equal(t, HashSessionToken(token), s.TokenHash)
+	assert.NotEqual(t, token, s.TokenHash)
+	assert.Equal(t, 64, len(s.CsrfToken))
+	assert.Equal(t, u.Id, s.UserID)
+	assert.Equal(t, tm, s.CreatedAt)
+	assert.Equal(t, tm.Add(time.Hour), s.ExpiresAt)
+
+	s2, token2, err := NewSession(u, tm, time.Hour)
+	assert.Equal(t, nil, err)
+	assert.NotEqual(t, token, token2)
+	assert.NotEqual(t, s.CsrfToken, s2.CsrfToken)
+}
+
+func TestFindSession(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	const query_select string = "SELECT (`[a-z_]+`, ){4}`[a-z_]+` FROM `sessions` WHERE `token_hash` = \\? AND `expires_at` > \\?"
+	tm := time.Now()
+	hash := HashSessionToken("token")
+
+	cols := []string{"id", "csrf_token", "user_id", "created_at", "expires_at"}
+	rows := sqlmock.NewRows(cols)
+	rows2 := sqlmock.NewRows(cols)
+	s1 := Session{Id: 2, TokenHash: hash, CsrfToken: "csrf", UserID: 3, CreatedAt: tm, ExpiresAt: tm}
+	rows2.AddRow(s1.Id, s1.CsrfToken, s1.UserID, s1.CreatedAt, s1.ExpiresAt)
+	mock.ExpectQuery(query_select).WithArgs(hash, tm).WillReturnRows(rows)
+	mock.ExpectQuery(query_select).WithArgs(hash, tm).WillReturnRows(rows2)
+	expErr := errors.New("error")
+	mock.ExpectQuery(query_select).WithArgs(hash, tm).WillReturnError(expErr)
+
+	_, err = FindSession(db, "token", tm)
+	assert.Equal(t, ErrNoSuchSession, err)
+
+	s, err := FindSession(db, "token", tm)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, s1, s)
+
+	_, err = FindSession(db, "token", tm)
+	assert.Equal(t, expErr, err)
+
+	err = mock.ExpectationsWereMet()
+	assert.Equal(t, nil, err)
+}
+
+func TestSaveSession(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	const query string = "INSERT INTO `sessions`.*"
+	tm := time.Now()
+	s := Session{TokenHash: "hash", CsrfToken: "csrf", UserID: 3, CreatedAt: tm, ExpiresAt: tm}
+	mock.ExpectExec(query).WithArgs(s.TokenHash, s.CsrfToken, s.UserID, s.CreatedAt, s.ExpiresAt).WillReturnResult(sqlmock.NewResult(7, 1))
+	expErr := errors.New("error")
+	mock.ExpectExec(query).WithArgs(s.TokenHash, s.CsrfToken, s.UserID, s.CreatedAt, s.ExpiresAt).WillReturnError(expErr)
+
+	s, err = SaveSession(db, s)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint32(7), s.Id)
+
+	_, err = SaveSession(db, s)
+	assert.Equal(t, expErr, err)
+
+	err = mock.ExpectationsWereMet()
+	assert.Equal(t, nil, err)
+}
+
+func TestDeleteSession(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	const query string = "DELETE FROM `sessions` WHERE `id` = \\? LIMIT 1"
+	const query_expired string = "DELETE FROM `sessions` WHERE `expires_at` <= \\?"
+	expErr := errors.New("error")
+	s := Session{Id: 7}
+	tm := time.Now()
+	mock.ExpectExec(query).WithArgs(s.Id).WillReturnError(expErr)
+	mock.ExpectExec(query).WithArgs(s.Id).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(query_expired).WithArgs(tm).WillReturnResult(sqlmock.NewResult(0, 3))
+
+	_, err = DeleteSession(db, s)
+	assert.Equal(t, expErr, err)
+
+	_, err = DeleteSession(db, s)
+	assert.Equal(t, nil, err)
+
+	err = DeleteExpiredSessions(db, tm)
+	assert.Equal(t, nil, err)
+
+	err = mock.ExpectationsWereMet()
+	assert.Equal(t, nil, err)
+}
@@ -0,0 +1,227 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"ims-release/database"
+	"time"
+)
+
+// Upload is a resumable upload of a page or of an archive of pages into a release. The data is sent in chunks,
+// each of which is staged in storage until the upload is finished. Uploads which are not finished before they
+// expire are abandoned.
+type Upload struct {
+	Id        uint32    `json:"-"`
+	Token     string    `json:"id"`
+	ReleaseID uint32    `json:"releaseId"`
+	Name      string    `json:"name"`
+	Kind      string    `json:"kind"`
+	Size      uint64    `json:"size"`
+	Offset    uint64    `json:"offset"`
+	Chunks    uint32    `json:"-"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Database constants for uploads
+const (
+	t_uploads      string = "`uploads`"
+	ULc_id         string = "`id`"
+	ULc_token      string = "`token`"
+	ULc_release_id string = "`release_id`"
+	ULc_name       string = "`name`"
+	ULc_kind       string = "`kind`"
+	ULc_size       string = "`size`"
+	ULc_offset     string = "`offset`"
+	ULc_chunks     string = "`chunks`"
+	ULc_created_at string = "`created_at`"
+	ULc_expires_at string = "`expires_at`"
+	ULmax_len_name        = 255
+)
+
+// The kinds of data which can be uploaded.
+const (
+	UploadKindPage    = "page"
+	UploadKindArchive = "archive"
+)
+
+// Errors pertaining to operations on Uploads.
+var (
+	ErrNoSuchUpload      = errors.New("Could not find upload.")
+	ErrUploadNameEmpty   = errors.New("Upload name must not be empty.")
+	ErrUploadNameTooLong = errors.New("Upload name is too long.")
+	ErrUploadKindInvalid = errors.New("Upload kind must be page or archive.")
+	ErrUploadSizeInvalid = errors.New("Upload size must not be 0.")
+	ErrUploadOffsetStale = errors.New("Upload offset has changed.")
+)
+
+// NewUpload constructs an upload into a release which expires after lifetime, unless it makes progress.
+func NewUpload(r Release, name, kind string, size uint64, tm time.Time, lifetime time.Duration) (Upload, error) {
+	token, err := randomToken()
+	if err != nil {
+		return Upload{}, err
+	}
+
+	return Upload{
+		0,
+		token,
+		r.Id,
+		name,
+		kind,
+		size,
+		0,
+		0,
+		tm,
+		tm.Add(lifetime),
+	}, nil
+}
+
+// Validate ensures the upload can be stored.
+func (u *Upload) Validate() error {
+	if len(u.Name) == 0 {
+		return ErrUploadNameEmpty
+	}
+	if len(u.Name) > ULmax_len_name {
+		return ErrUploadNameTooLong
+	}
+	if u.Kind != UploadKindPage && u.Kind != UploadKindArchive {
+		return ErrUploadKindInvalid
+	}
+	if u.Size == 0 {
+		return ErrUploadSizeInvalid
+	}
+	return nil
+}
+
+// GenerateUploadChunkPath produces the storage key under which a chunk of an upload is staged. Chunks are numbered
+// in the order they were received.
+func GenerateUploadChunkPath(u Upload, chunk uint32) string {
+	return fmt.Sprintf("uploads/%s/%d", u.Token, chunk)
+}
+
+// FindUpload attempts to lookup an upload into a release which has not expired at tm by its token.
+func FindUpload(db database.DB, r Release, token string, tm time.Time) (Upload, error) {
+	u := Upload{Token: token, ReleaseID: r.Id}
+	const query = "SELECT " + ULc_id + ", " + ULc_name + ", " + ULc_kind + ", " + ULc_size + ", " + ULc_offset + ", " +
+		ULc_chunks + ", " + ULc_created_at + ", " + ULc_expires_at + " FROM " + t_uploads +
+		" WHERE " + ULc_token + " = ? AND " + ULc_release_id + " = ? AND " + ULc_expires_at + " > ?"
+
+	row := db.QueryRow(query, token, r.Id, tm)
+	err := row.Scan(&u.Id, &u.Name, &u.Kind, &u.Size, &u.Offset, &u.Chunks, &u.CreatedAt, &u.ExpiresAt)
+	if err == database.ErrNoRows {
+		return Upload{}, ErrNoSuchUpload
+	} else if err != nil {
+		return Upload{}, err
+	}
+	return u, nil
+}
+
+// ListExpiredUploads returns all uploads which have expired at tm, so that their chunks can be removed.
+func ListExpiredUploads(db database.DB, tm time.Time) ([]Upload, error) {
+	return listUploads(db, ULc_expires_at+" <= ?", tm)
+}
+
+// ListReleaseUploads returns all uploads into a release, expired or not, so that their chunks can be removed along
+// with the release.
+func ListReleaseUploads(db database.DB, r Release) ([]Upload, error) {
+	return listUploads(db, ULc_release_id+" = ?", r.Id)
+}
+
+// listUploads returns the uploads matching the condition of a WHERE clause.
+func listUploads(db database.DB, where string, args ...interface{}) ([]Upload, error) {
+	query := "SELECT " + ULc_id + ", " + ULc_token + ", " + ULc_release_id + ", " + ULc_name + ", " + ULc_kind + ", " +
+		ULc_size + ", " + ULc_offset + ", " + ULc_chunks + ", " + ULc_created_at + ", " + ULc_expires_at + " FROM " +
+		t_uploads + " WHERE " + where
+
+	uploads := []Upload{}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return uploads, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		u := Upload{}
+		err = rows.Scan(&u.Id, &u.Token, &u.ReleaseID, &u.Name, &u.Kind, &u.Size, &u.Offset, &u.Chunks,
+			&u.CreatedAt, &u.ExpiresAt)
+		if err != nil {
+			return uploads, err
+		}
+		uploads = append(uploads, u)
+	}
+	return uploads, rows.Err()
+}
+
+// SaveUpload inserts the upload into the database and updates its Id field.
+func SaveUpload(db database.DB, u Upload) (Upload, error) {
+	validErr := u.Validate()
+	if validErr != nil {
+		return u, validErr
+	}
+
+	const query = "INSERT INTO " + t_uploads + " (" +
+		ULc_token + ", " + ULc_release_id + ", " + ULc_name + ", " + ULc_kind + ", " + ULc_size + ", " +
+		ULc_created_at + ", " + ULc_expires_at + ") VALUES (?, ?, ?, ?, ?, ?, ?)"
+
+	res, err := db.Exec(query, u.Token, u.ReleaseID, u.Name, u.Kind, u.Size, u.CreatedAt, u.ExpiresAt)
+	if err != nil {
+		return u, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return u, err
+	}
+	u.Id = uint32(id)
+	return u, nil
+}
+
+// AdvanceUpload records a chunk of length bytes appended to the upload, extending its expiry. It fails with
+// ErrUploadOffsetStale if another chunk has been appended since the upload was looked up.
+func AdvanceUpload(db database.DB, u Upload, length uint64, expiresAt time.Time) (Upload, error) {
+	const query = "UPDATE " + t_uploads + " SET " + ULc_offset + " = ?, " + ULc_chunks + " = ?, " + ULc_expires_at +
+		" = ? WHERE " + ULc_id + " = ? AND " + ULc_offset + " = ? LIMIT 1"
+
+	res, err := db.Exec(query, u.Offset+length, u.Chunks+1, expiresAt, u.Id, u.Offset)
+	if err != nil {
+		return u, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return u, err
+	}
+	if affected == 0 {
+		return u, ErrUploadOffsetStale
+	}
+	u.Offset += length
+	u.Chunks++
+	u.ExpiresAt = expiresAt
+	return u, nil
+}
+
+// RewindUpload takes back the last chunk recorded with AdvanceUpload, which was length bytes long, for when its data
+// could not be stored. It fails with ErrUploadOffsetStale if another chunk has been appended since.
+func RewindUpload(db database.DB, u Upload, length uint64) (Upload, error) {
+	const query = "UPDATE " + t_uploads + " SET " + ULc_offset + " = ?, " + ULc_chunks + " = ? WHERE " + ULc_id +
+		" = ? AND " + ULc_offset + " = ? LIMIT 1"
+
+	res, err := db.Exec(query, u.Offset-length, u.Chunks-1, u.Id, u.Offset)
+	if err != nil {
+		return u, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return u, err
+	}
+	if affected == 0 {
+		return u, ErrUploadOffsetStale
+	}
+	u.Offset -= length
+	u.Chunks--
+	return u, nil
+}
+
+// DeleteUpload removes the upload from the database. Its chunks have to be removed from storage separately.
+func DeleteUpload(db database.DB, u Upload) (Upload, error) {
+	const query = "DELETE FROM " + t_uploads + " WHERE " + ULc_id + " = ? LIMIT 1"
+	_, err := db.Exec(query, u.Id)
+	return u, err
+}
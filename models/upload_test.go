@@ -0,0 +1,246 @@
+package models
+
+import (
+	"errors"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"ims-release/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewUpload(t *testing.T) {
+	tm := time.Now()
+	u, err := NewUpload(Release{Id: 4}, "p01.png", UploadKindPage, 100, tm, time.Hour)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 64, len(u.Token))
+	assert.Equal(t, uint32(4), u.ReleaseID)
+	assert.Equal(t, "p01.png", u.Name)
+	assert.Equal(t, UploadKindPage, u.Kind)
+	assert.Equal(t, uint64(100), u.Size)
+	assert.Equal(t, uint64(0), u.Offset)
+	assert.Equal(t, tm, u.CreatedAt)
+	assert.Equal(t, tm.Add(time.Hour), u.ExpiresAt)
+
+	u2, err := NewUpload(Release{Id: 4}, "p01.png", UploadKindPage, 100, tm, time.Hour)
+	assert.Equal(t, nil, err)
+	assert.NotEqual(t, u.Token, u2.Token)
+}
+
+func TestValidateUpload(t *testing.T) {
+	u := Upload{Name: "ch1.zip", Kind: UploadKindArchive, Size: 1}
+	assert.Equal(t, nil, u.Validate())
+
+	u.Name = ""
+	assert.Equal(t, ErrUploadNameEmpty, u.Validate())
+	u.Name = strings.Repeat("a", ULmax_len_name+1)
+	assert.Equal(t, ErrUploadNameTooLong, u.Validate())
+	u.Name = "ch1.zip"
+
+	u.Kind = "release"
+	assert.Equal(t, ErrUploadKindInvalid, u.Validate())
+	u.Kind = UploadKindArchive
+
+	u.Size = 0
+	assert.Equal(t, ErrUploadSizeInvalid, u.Validate())
+}
+
+func TestGenerateUploadChunkPath(t *testing.T) {
+	assert.Equal(t, "uploads/abc/3", GenerateUploadChunkPath(Upload{Token: "abc"}, 3))
+}
+
+func TestFindUpload(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	const query_select string = "SELECT (`[a-z_]+`, ){7}`[a-z_]+` FROM `uploads` WHERE `token` = \\? AND `release_id` = \\? AND `expires_at` > \\?"
+	tm := time.Now()
+	r := Release{Id: 4}
+
+	cols := []string{"id", "name", "kind", "size", "offset", "chunks", "created_at", "expires_at"}
+	rows := sqlmock.NewRows(cols)
+	rows2 := sqlmock.NewRows(cols)
+	u1 := Upload{Id: 2, Token: "token", ReleaseID: r.Id, Name: "p01.png", Kind: UploadKindPage, Size: 10, Offset: 5,
+		Chunks: 1, CreatedAt: tm, ExpiresAt: tm}
+	rows2.AddRow(u1.Id, u1.Name, u1.Kind, u1.Size, u1.Offset, u1.Chunks, u1.CreatedAt, u1.ExpiresAt)
+	mock.ExpectQuery(query_select).WithArgs("token", r.Id, tm).WillReturnRows(rows)
+	mock.ExpectQuery(query_select).WithArgs("token", r.Id, tm).WillReturnRows(rows2)
+	expErr := errors.New("error")
+	mock.ExpectQuery(query_select).WithArgs("token", r.Id, tm).WillReturnError(expErr)
+
+	_, err = FindUpload(db, r, "token", tm)
+	assert.Equal(t, ErrNoSuchUpload, err)
+
+	u, err := FindUpload(db, r, "token", tm)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, u1, u)
+
+	_, err = FindUpload(db, r, "token", tm)
+	assert.Equal(t, expErr, err)
+
+	err = mock.ExpectationsWereMet()
+	assert.Equal(t, nil, err)
+}
+
+func TestListExpiredUploads(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	const query_select string = "SELECT (`[a-z_]+`, ){9}`[a-z_]+` FROM `uploads` WHERE `expires_at` <= \\?"
+	tm := time.Now()
+
+	cols := []string{"id", "token", "release_id", "name", "kind", "size", "offset", "chunks", "created_at", "expires_at"}
+	rows := sqlmock.NewRows(cols)
+	u1 := Upload{Id: 2, Token: "a", ReleaseID: 4, Name: "p01.png", Kind: UploadKindPage, Size: 10, Offset: 5,
+		Chunks: 1, CreatedAt: tm, ExpiresAt: tm}
+	u2 := Upload{Id: 3, Token: "b", ReleaseID: 4, Name: "ch1.zip", Kind: UploadKindArchive, Size: 10, CreatedAt: tm,
+		ExpiresAt: tm}
+	for _, u := range []Upload{u1, u2} {
+		rows.AddRow(u.Id, u.Token, u.ReleaseID, u.Name, u.Kind, u.Size, u.Offset, u.Chunks, u.CreatedAt, u.ExpiresAt)
+	}
+	mock.ExpectQuery(query_select).WithArgs(tm).WillReturnRows(rows)
+	expErr := errors.New("error")
+	mock.ExpectQuery(query_select).WithArgs(tm).WillReturnError(expErr)
+
+	uploads, err := ListExpiredUploads(db, tm)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(uploads))
+	assert.Equal(t, u1, uploads[0])
+	assert.Equal(t, u2, uploads[1])
+
+	_, err = ListExpiredUploads(db, tm)
+	assert.Equal(t, expErr, err)
+
+	err = mock.ExpectationsWereMet()
+	assert.Equal(t, nil, err)
+}
+
+func TestListReleaseUploads(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	const query_select string = "SELECT (`[a-z_]+`, ){9}`[a-z_]+` FROM `uploads` WHERE `release_id` = \\?"
+	tm := time.Now()
+	r := Release{Id: 4}
+
+	cols := []string{"id", "token", "release_id", "name", "kind", "size", "offset", "chunks", "created_at", "expires_at"}
+	u := Upload{Id: 2, Token: "a", ReleaseID: 4, Name: "p01.png", Kind: UploadKindPage, Size: 10, Offset: 5,
+		Chunks: 1, CreatedAt: tm, ExpiresAt: tm}
+	rows := sqlmock.NewRows(cols)
+	rows.AddRow(u.Id, u.Token, u.ReleaseID, u.Name, u.Kind, u.Size, u.Offset, u.Chunks, u.CreatedAt, u.ExpiresAt)
+	mock.ExpectQuery(query_select).WithArgs(r.Id).WillReturnRows(rows)
+	expErr := errors.New("error")
+	mock.ExpectQuery(query_select).WithArgs(r.Id).WillReturnError(expErr)
+
+	uploads, err := ListReleaseUploads(db, r)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(uploads))
+	assert.Equal(t, u, uploads[0])
+
+	_, err = ListReleaseUploads(db, r)
+	assert.Equal(t, expErr, err)
+
+	err = mock.ExpectationsWereMet()
+	assert.Equal(t, nil, err)
+}
+func TestSaveUpload(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	const query string = "INSERT INTO `uploads`.*"
+	tm := time.Now()
+	u := Upload{Token: "token", ReleaseID: 4, Name: "p01.png", Kind: UploadKindPage, Size: 10, CreatedAt: tm, ExpiresAt: tm}
+	mock.ExpectExec(query).WithArgs(u.Token, u.ReleaseID, u.Name, u.Kind, u.Size, u.CreatedAt, u.ExpiresAt).WillReturnResult(sqlmock.NewResult(7, 1))
+	expErr := errors.New("error")
+	mock.ExpectExec(query).WithArgs(u.Token, u.ReleaseID, u.Name, u.Kind, u.Size, u.CreatedAt, u.ExpiresAt).WillReturnError(expErr)
+
+	u, err = SaveUpload(db, u)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint32(7), u.Id)
+
+	_, err = SaveUpload(db, u)
+	assert.Equal(t, expErr, err)
+
+	// tests validation failed case
+	_, err = SaveUpload(db, Upload{Name: "p01.png"})
+	assert.Equal(t, ErrUploadKindInvalid, err)
+
+	err = mock.ExpectationsWereMet()
+	assert.Equal(t, nil, err)
+}
+
+func TestAdvanceUpload(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	const query string = "UPDATE `uploads` SET `offset` = \\?, `chunks` = \\?, `expires_at` = \\? WHERE `id` = \\? AND `offset` = \\? LIMIT 1"
+	tm := time.Now()
+	u := Upload{Id: 2, Size: 10, Offset: 4, Chunks: 1}
+	mock.ExpectExec(query).WithArgs(uint64(7), uint32(2), tm, u.Id, uint64(4)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(query).WithArgs(uint64(7), uint32(2), tm, u.Id, uint64(4)).WillReturnResult(sqlmock.NewResult(0, 0))
+	expErr := errors.New("error")
+	mock.ExpectExec(query).WithArgs(uint64(7), uint32(2), tm, u.Id, uint64(4)).WillReturnError(expErr)
+
+	u2, err := AdvanceUpload(db, u, 3, tm)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(7), u2.Offset)
+	assert.Equal(t, uint32(2), u2.Chunks)
+	assert.Equal(t, tm, u2.ExpiresAt)
+
+	_, err = AdvanceUpload(db, u, 3, tm)
+	assert.Equal(t, ErrUploadOffsetStale, err)
+
+	_, err = AdvanceUpload(db, u, 3, tm)
+	assert.Equal(t, expErr, err)
+
+	err = mock.ExpectationsWereMet()
+	assert.Equal(t, nil, err)
+}
+
+func TestRewindUpload(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	const query string = "UPDATE `uploads` SET `offset` = \\?, `chunks` = \\? WHERE `id` = \\? AND `offset` = \\? LIMIT 1"
+	u := Upload{Id: 2, Size: 10, Offset: 7, Chunks: 2}
+	mock.ExpectExec(query).WithArgs(uint64(4), uint32(1), u.Id, uint64(7)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(query).WithArgs(uint64(4), uint32(1), u.Id, uint64(7)).WillReturnResult(sqlmock.NewResult(0, 0))
+	expErr := errors.New("error")
+	mock.ExpectExec(query).WithArgs(uint64(4), uint32(1), u.Id, uint64(7)).WillReturnError(expErr)
+
+	u2, err := RewindUpload(db, u, 3)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(4), u2.Offset)
+	assert.Equal(t, uint32(1), u2.Chunks)
+
+	_, err = RewindUpload(db, u, 3)
+	assert.Equal(t, ErrUploadOffsetStale, err)
+
+	_, err = RewindUpload(db, u, 3)
+	assert.Equal(t, expErr, err)
+
+	err = mock.ExpectationsWereMet()
+	assert.Equal(t, nil, err)
+}
+
+func TestDeleteUpload(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	const query string = "DELETE FROM `uploads` WHERE `id` = \\? LIMIT 1"
+	u := Upload{Id: 2}
+	mock.ExpectExec(query).WithArgs(u.Id).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	_, err = DeleteUpload(db, u)
+	assert.Equal(t, nil, err)
+
+	err = mock.ExpectationsWereMet()
+	assert.Equal(t, nil, err)
+}
@@ -0,0 +1,129 @@
+package models
+
+import (
+	"errors"
+	"ims-release/database"
+	"time"
+)
+
+// User is a staff member who has logged in through the identity provider. Subject is the stable identifier
+// assigned to the user by the identity provider.
+type User struct {
+	Id        uint32    `json:"id"`
+	Subject   string    `json:"-"`
+	Email     string    `json:"email"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Database constants for users
+const (
+	t_users       string = "`users`"
+	Uc_id         string = "`id`"
+	Uc_subject    string = "`subject`"
+	Uc_email      string = "`email`"
+	Uc_name       string = "`name`"
+	Uc_created_at string = "`created_at`"
+
+	Umax_len_subject = 255
+	Umax_len_email   = 65535
+	Umax_len_name    = 65535
+)
+
+// Errors pertaining to the data in a User or operations on Users.
+var (
+	ErrNoSuchUser       = errors.New("Could not find user.")
+	ErrUserSubjectEmpty = errors.New("User subject is empty.")
+)
+
+// NewUser constructs a brand new User instance, with a default state lacking information about its (future)
+// position in a database.
+func NewUser(subject, email, name string, tm time.Time) User {
+	return User{
+		0,
+		subject,
+		email,
+		name,
+		tm,
+	}
+}
+
+// FindUser attempts to lookup a user by ID.
+func FindUser(db database.DB, id uint32) (User, error) {
+	u := User{}
+	const query = "SELECT " + Uc_subject + ", " + Uc_email + ", " + Uc_name + ", " +
+		Uc_created_at + " FROM " + t_users + " WHERE " + Uc_id + " = ?"
+
+	row := db.QueryRow(query, id)
+	err := row.Scan(&u.Subject, &u.Email, &u.Name, &u.CreatedAt)
+	if err == database.ErrNoRows {
+		return User{}, ErrNoSuchUser
+	} else if err != nil {
+		return User{}, err
+	}
+	u.Id = id
+	return u, nil
+}
+
+// FindUserBySubject attempts to lookup a user by the subject assigned by the identity provider.
+func FindUserBySubject(db database.DB, subject string) (User, error) {
+	u := User{Subject: subject}
+	const query = "SELECT " + Uc_id + ", " + Uc_email + ", " + Uc_name + ", " +
+		Uc_created_at + " FROM " + t_users + " WHERE " + Uc_subject + " = ?"
+
+	row := db.QueryRow(query, subject)
+	err := row.Scan(&u.Id, &u.Email, &u.Name, &u.CreatedAt)
+	if err == database.ErrNoRows {
+		return User{}, ErrNoSuchUser
+	} else if err != nil {
+		return User{}, err
+	}
+	return u, nil
+}
+
+// Validate checks that the subject is present and that no field is too long.
+func (u *User) Validate() error {
+	if len(u.Subject) == 0 {
+		return ErrUserSubjectEmpty
+	}
+	if len(u.Subject) > Umax_len_subject || len(u.Email) > Umax_len_email || len(u.Name) > Umax_len_name {
+		return ErrFieldTooLong
+	}
+	return nil
+}
+
+// SaveUser inserts the user into the database and updates its Id field.
+func SaveUser(db database.DB, u User) (User, error) {
+	validErr := u.Validate()
+	if validErr != nil {
+		return u, validErr
+	}
+
+	const query = "INSERT INTO " + t_users + " (" +
+		Uc_subject + ", " + Uc_email + ", " + Uc_name + ", " + Uc_created_at + ") VALUES (?, ?, ?, ?)"
+
+	res, err := db.Exec(query, u.Subject, u.Email, u.Name, u.CreatedAt)
+	if err != nil {
+		return u, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return u, err
+	}
+	u.Id = uint32(id)
+	return u, nil
+}
+
+// UpdateUser refreshes the email and name of a user, which may change at the identity provider.
+func UpdateUser(db database.DB, u User) (User, error) {
+	validErr := u.Validate()
+	if validErr != nil {
+		return u, validErr
+	}
+
+	const query = "UPDATE " + t_users + " SET " +
+		Uc_email + " = ?, " + Uc_name + " = ? WHERE " + Uc_id + " = ? LIMIT 1"
+
+	_, err := db.Exec(query, u.Email, u.Name, u.Id)
+	return u, err
+}
@@ -0,0 +1,148 @@
+package models
+
+import (
+	"errors"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"ims-release/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewUser(t *testing.T) {
+	tm := time.Now()
+	u := NewUser("sub", "a@b.c", "name", tm)
+	assert.Equal(t, "sub", u.Subject)
+	assert.Equal(t, "a@b.c", u.Email)
+	assert.Equal(t, "name", u.Name)
+	assert.Equal(t, tm, u.CreatedAt)
+	assert.Equal(t, uint32(0), u.Id)
+}
+
+func TestFindUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	const id uint32 = 5
+	const query_select string = "SELECT (`[a-z_]+`, ){3}`[a-z_]+` FROM `users` WHERE `id` = \\?"
+
+	cols := []string{"subject", "email", "name", "created_at"}
+	rows := sqlmock.NewRows(cols)
+	rows2 := sqlmock.NewRows(cols)
+	tm := time.Now()
+	u1 := User{Id: id, Subject: "sub", Email: "a@b.c", Name: "name", CreatedAt: tm}
+	rows2.AddRow(u1.Subject, u1.Email, u1.Name, u1.CreatedAt)
+	mock.ExpectQuery(query_select).WithArgs(id).WillReturnRows(rows)
+	mock.ExpectQuery(query_select).WithArgs(id).WillReturnRows(rows2)
+	expErr := errors.New("error")
+	mock.ExpectQuery(query_select).WithArgs(id).WillReturnError(expErr)
+
+	_, err = FindUser(db, id)
+	assert.Equal(t, ErrNoSuchUser, err)
+
+	user, err := FindUser(db, id)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, u1, user)
+
+	_, err = FindUser(db, id)
+	assert.Equal(t, expErr, err)
+
+	err = mock.ExpectationsWereMet()
+	assert.Equal(t, nil, err)
+}
+
+func TestFindUserBySubject(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	const query_select string = "SELECT (`[a-z_]+`, ){3}`[a-z_]+` FROM `users` WHERE `subject` = \\?"
+
+	cols := []string{"id", "email", "name", "created_at"}
+	rows := sqlmock.NewRows(cols)
+	rows2 := sqlmock.NewRows(cols)
+	tm := time.Now()
+	u1 := User{Id: 3, Subject: "sub", Email: "a@b.c", Name: "name", CreatedAt: tm}
+	rows2.AddRow(u1.Id, u1.Email, u1.Name, u1.CreatedAt)
+	mock.ExpectQuery(query_select).WithArgs("sub").WillReturnRows(rows)
+	mock.ExpectQuery(query_select).WithArgs("sub").WillReturnRows(rows2)
+	expErr := errors.New("error")
+	mock.ExpectQuery(query_select).WithArgs("sub").WillReturnError(expErr)
+
+	_, err = FindUserBySubject(db, "sub")
+	assert.Equal(t, ErrNoSuchUser, err)
+
+	user, err := FindUserBySubject(db, "sub")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, u1, user)
+
+	_, err = FindUserBySubject(db, "sub")
+	assert.Equal(t, expErr, err)
+
+	err = mock.ExpectationsWereMet()
+	assert.Equal(t, nil, err)
+}
+
+func TestValidateUser(t *testing.T) {
+	u := User{}
+	err := u.Validate()
+	assert.Equal(t, ErrUserSubjectEmpty, err)
+
+	u.Subject = strings.Repeat("a", 256)
+	err = u.Validate()
+	assert.Equal(t, ErrFieldTooLong, err)
+
+	u.Subject = "sub"
+	err = u.Validate()
+	assert.Equal(t, nil, err)
+}
+
+func TestSaveUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	const query string = "INSERT INTO `users`.*"
+	u := User{}
+
+	// tests validation failed case
+	u, err = SaveUser(db, u)
+	assert.Equal(t, ErrUserSubjectEmpty, err)
+
+	u.Subject = "sub"
+	mock.ExpectExec(query).WithArgs(u.Subject, u.Email, u.Name, u.CreatedAt).WillReturnResult(sqlmock.NewResult(7, 1))
+	expErr := errors.New("error")
+	mock.ExpectExec(query).WithArgs(u.Subject, u.Email, u.Name, u.CreatedAt).WillReturnError(expErr)
+
+	u, err = SaveUser(db, u)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint32(7), u.Id)
+
+	u, err = SaveUser(db, u)
+	assert.Equal(t, expErr, err)
+
+	err = mock.ExpectationsWereMet()
+	assert.Equal(t, nil, err)
+}
+
+func TestUpdateUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	const query string = "UPDATE `users`.*WHERE `id` = \\? LIMIT 1"
+	u := User{Id: 4, Subject: "sub", Email: "new@b.c", Name: "new"}
+	mock.ExpectExec(query).WithArgs(u.Email, u.Name, u.Id).WillReturnResult(sqlmock.NewResult(0, 1))
+	expErr := errors.New("error")
+	mock.ExpectExec(query).WithArgs(u.Email, u.Name, u.Id).WillReturnError(expErr)
+
+	u, err = UpdateUser(db, u)
+	assert.Equal(t, nil, err)
+
+	u, err = UpdateUser(db, u)
+	assert.Equal(t, expErr, err)
+
+	err = mock.ExpectationsWereMet()
+	assert.Equal(t, nil, err)
+}
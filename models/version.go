@@ -0,0 +1,237 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"ims-release/database"
+	"time"
+)
+
+// ReleaseVersion is a snapshot of a release taken when a version of it is published. Snapshots are never changed,
+// so a version can still be downloaded after the release has moved on to a later one.
+type ReleaseVersion struct {
+	Id         uint32        `json:"-"`
+	ReleaseID  uint32        `json:"-"`
+	Identifier string        `json:"identifier"`
+	Version    uint32        `json:"version"`
+	ReleasedOn time.Time     `json:"releasedOn"`
+	Pages      []VersionPage `json:"pages,omitempty"`
+}
+
+// VersionPage is a page as it was when a release version was published. The hash is the hex encoded SHA-256 of the
+// page's image data, which is kept in storage under the hash.
+type VersionPage struct {
+	Name      string   `json:"name"`
+	MimeType  MimeType `json:"-"`
+	Role      string   `json:"role"`
+	SortIndex uint32   `json:"sortIndex"`
+	Hash      string   `json:"hash"`
+}
+
+// VersionPageChange describes how a page differs between two versions of a release. Pages are matched by name;
+// Before is missing for added pages and After for removed ones.
+type VersionPageChange struct {
+	Name   string       `json:"name"`
+	Change string       `json:"change"`
+	Before *VersionPage `json:"before,omitempty"`
+	After  *VersionPage `json:"after,omitempty"`
+}
+
+// The kinds of changes between two versions of a page.
+const (
+	VersionPageAdded   = "added"
+	VersionPageRemoved = "removed"
+	VersionPageChanged = "changed"
+)
+
+// Database constants for release versions
+const (
+	t_release_versions string = "`release_versions`"
+	RVc_id             string = "`id`"
+	RVc_release_id     string = "`release_id`"
+	RVc_identifier     string = "`identifier`"
+	RVc_version        string = "`version`"
+	RVc_released_on    string = "`released_on`"
+	t_version_pages    string = "`release_version_pages`"
+	VPc_id             string = "`id`"
+	VPc_version_id     string = "`version_id`"
+	VPc_name           string = "`name`"
+	VPc_mime_type      string = "`mime_type`"
+	VPc_role           string = "`role`"
+	VPc_sort_index     string = "`sort_index`"
+	VPc_hash           string = "`hash`"
+)
+
+// Errors pertaining to operations on ReleaseVersions.
+var (
+	ErrNoSuchReleaseVersion = errors.New("Could not find release version.")
+)
+
+// NewReleaseVersion constructs a snapshot of a release as it is now, with its pages in page order.
+func NewReleaseVersion(r Release, pages []VersionPage) ReleaseVersion {
+	return ReleaseVersion{
+		0,
+		r.Id,
+		r.Identifier,
+		r.Version,
+		r.ReleasedOn,
+		pages,
+	}
+}
+
+// Release returns the release as it was when the version was published, for generating archive names and archives.
+func (v ReleaseVersion) Release(r Release) Release {
+	r.Identifier = v.Identifier
+	r.Version = v.Version
+	r.ReleasedOn = v.ReleasedOn
+	return r
+}
+
+// Validate ensures the version can be stored.
+func (v *ReleaseVersion) Validate() error {
+	if len(v.Identifier) > Rmax_len_identifier {
+		return ErrFieldTooLong
+	}
+	for _, page := range v.Pages {
+		if len(page.Name) > PGmax_len_name {
+			return ErrFieldTooLong
+		}
+	}
+	return nil
+}
+
+// GenerateVersionPagePath produces the storage key under which the image data of a page of a release version is
+// kept. Pages with the same data share a key, so unchanged pages are only stored once across versions.
+func GenerateVersionPagePath(p Project, r Release, hash string) string {
+	return fmt.Sprintf("versions/%d/%d/%s", p.Id, r.Id, hash)
+}
+
+// ListReleaseVersions attempts to obtain the versions of a release, oldest first. Their pages are not loaded.
+func ListReleaseVersions(db database.DB, r Release) ([]ReleaseVersion, error) {
+	versions := []ReleaseVersion{}
+	const query = "SELECT " + RVc_id + ", " + RVc_identifier + ", " + RVc_version + ", " + RVc_released_on +
+		" FROM " + t_release_versions + " WHERE " + RVc_release_id + " = ? ORDER BY " + RVc_version + " ASC"
+
+	rows, err := db.Query(query, r.Id)
+	if err != nil {
+		return versions, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		v := ReleaseVersion{ReleaseID: r.Id}
+		err = rows.Scan(&v.Id, &v.Identifier, &v.Version, &v.ReleasedOn)
+		if err != nil {
+			return versions, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// FindReleaseVersion attempts to lookup a version of a release by its version number, along with its pages in page
+// order.
+func FindReleaseVersion(db database.DB, r Release, version uint32) (ReleaseVersion, error) {
+	v := ReleaseVersion{ReleaseID: r.Id, Version: version, Pages: []VersionPage{}}
+	const query = "SELECT " + RVc_id + ", " + RVc_identifier + ", " + RVc_released_on + " FROM " + t_release_versions +
+		" WHERE " + RVc_release_id + " = ? AND " + RVc_version + " = ?"
+
+	row := db.QueryRow(query, r.Id, version)
+	err := row.Scan(&v.Id, &v.Identifier, &v.ReleasedOn)
+	if err == database.ErrNoRows {
+		return ReleaseVersion{}, ErrNoSuchReleaseVersion
+	} else if err != nil {
+		return ReleaseVersion{}, err
+	}
+
+	const pagesQuery = "SELECT " + VPc_name + ", " + VPc_mime_type + ", " + VPc_role + ", " + VPc_sort_index + ", " +
+		VPc_hash + " FROM " + t_version_pages + " WHERE " + VPc_version_id + " = ? ORDER BY " + VPc_sort_index +
+		" ASC, " + VPc_id + " ASC"
+	rows, err := db.Query(pagesQuery, v.Id)
+	if err != nil {
+		return ReleaseVersion{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		page := VersionPage{}
+		var role PageRole
+		err = rows.Scan(&page.Name, &page.MimeType, &role, &page.SortIndex, &page.Hash)
+		if err != nil {
+			return ReleaseVersion{}, err
+		}
+		page.Role = role.String()
+		v.Pages = append(v.Pages, page)
+	}
+	if err = rows.Err(); err != nil {
+		return ReleaseVersion{}, err
+	}
+	return v, nil
+}
+
+// SaveReleaseVersion inserts the version and its pages into the database and updates its Id field. The version and
+// its pages are inserted in one transaction, so that no partial snapshot is left behind.
+func SaveReleaseVersion(db database.DB, v ReleaseVersion) (ReleaseVersion, error) {
+	validErr := v.Validate()
+	if validErr != nil {
+		return v, validErr
+	}
+
+	const query = "INSERT INTO " + t_release_versions + " (" + RVc_release_id + ", " + RVc_identifier + ", " +
+		RVc_version + ", " + RVc_released_on + ") VALUES (?, ?, ?, ?)"
+	const pageQuery = "INSERT INTO " + t_version_pages + " (" + VPc_version_id + ", " + VPc_name + ", " +
+		VPc_mime_type + ", " + VPc_role + ", " + VPc_sort_index + ", " + VPc_hash + ") VALUES (?, ?, ?, ?, ?, ?)"
+	var id int64
+	err := database.Transaction(db, func(tx database.DB) error {
+		res, err := tx.Exec(query, v.ReleaseID, v.Identifier, v.Version, v.ReleasedOn)
+		if err != nil {
+			return err
+		}
+		id, err = res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		for _, page := range v.Pages {
+			_, err = tx.Exec(pageQuery, uint32(id), page.Name, page.MimeType, NewPageRole(page.Role), page.SortIndex, page.Hash)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return v, err
+	}
+	v.Id = uint32(id)
+	return v, nil
+}
+
+// DiffReleaseVersions lists the pages which were added, removed or changed between two versions of a release. A page
+// has changed when its image data or its role differs. Removed pages come first, followed by the pages of the target
+// version in page order.
+func DiffReleaseVersions(base, target ReleaseVersion) []VersionPageChange {
+	before := map[string]VersionPage{}
+	for _, page := range base.Pages {
+		before[page.Name] = page
+	}
+	after := map[string]bool{}
+	for _, page := range target.Pages {
+		after[page.Name] = true
+	}
+
+	changes := []VersionPageChange{}
+	for i := range base.Pages {
+		page := base.Pages[i]
+		if !after[page.Name] {
+			changes = append(changes, VersionPageChange{page.Name, VersionPageRemoved, &page, nil})
+		}
+	}
+	for i := range target.Pages {
+		page := target.Pages[i]
+		old, ok := before[page.Name]
+		if !ok {
+			changes = append(changes, VersionPageChange{page.Name, VersionPageAdded, nil, &page})
+		} else if old.Hash != page.Hash || old.Role != page.Role {
+			changes = append(changes, VersionPageChange{page.Name, VersionPageChanged, &old, &page})
+		}
+	}
+	return changes
+}
@@ -0,0 +1,198 @@
+package models
+
+import (
+	"errors"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"ims-release/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewReleaseVersion(t *testing.T) {
+	tm := time.Now()
+	pages := []VersionPage{{Name: "p01.png", Role: PRoleCreditStr, Hash: "abc"}}
+	v := NewReleaseVersion(Release{Id: 4, Identifier: "c1", Version: 2, ReleasedOn: tm}, pages)
+	assert.Equal(t, uint32(0), v.Id)
+	assert.Equal(t, uint32(4), v.ReleaseID)
+	assert.Equal(t, "c1", v.Identifier)
+	assert.Equal(t, uint32(2), v.Version)
+	assert.Equal(t, tm, v.ReleasedOn)
+	assert.Equal(t, pages[0], v.Pages[0])
+
+	r := v.Release(Release{Id: 4, Identifier: "c2", Version: 3, Scanlator: "ims"})
+	assert.Equal(t, Release{Id: 4, Identifier: "c1", Version: 2, Scanlator: "ims", ReleasedOn: tm}, r)
+}
+
+func TestValidateReleaseVersion(t *testing.T) {
+	v := ReleaseVersion{Identifier: "c1", Pages: []VersionPage{{Name: "p01.png"}}}
+	assert.Equal(t, nil, v.Validate())
+
+	v.Identifier = strings.Repeat("a", Rmax_len_identifier+1)
+	assert.Equal(t, ErrFieldTooLong, v.Validate())
+	v.Identifier = "c1"
+
+	v.Pages[0].Name = strings.Repeat("a", PGmax_len_name+1)
+	assert.Equal(t, ErrFieldTooLong, v.Validate())
+}
+
+func TestGenerateVersionPagePath(t *testing.T) {
+	assert.Equal(t, "versions/2/4/abc", GenerateVersionPagePath(Project{Id: 2}, Release{Id: 4}, "abc"))
+}
+
+func TestListReleaseVersions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	const query_select string = "SELECT (`[a-z_]+`, ){3}`[a-z_]+` FROM `release_versions` WHERE `release_id` = \\? ORDER BY `version` ASC"
+	tm := time.Now()
+	r := Release{Id: 4}
+
+	cols := []string{"id", "identifier", "version", "released_on"}
+	rows := sqlmock.NewRows(cols)
+	rows.AddRow(1, "c1", 2, tm)
+	rows.AddRow(3, "c1.5", 3, tm)
+	mock.ExpectQuery(query_select).WithArgs(r.Id).WillReturnRows(rows)
+	expErr := errors.New("error")
+	mock.ExpectQuery(query_select).WithArgs(r.Id).WillReturnError(expErr)
+
+	versions, err := ListReleaseVersions(db, r)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(versions))
+	assert.Equal(t, uint32(1), versions[0].Id)
+	assert.Equal(t, uint32(4), versions[0].ReleaseID)
+	assert.Equal(t, uint32(2), versions[0].Version)
+	assert.Equal(t, "c1.5", versions[1].Identifier)
+	assert.Equal(t, tm, versions[1].ReleasedOn)
+
+	_, err = ListReleaseVersions(db, r)
+	assert.Equal(t, expErr, err)
+
+	err = mock.ExpectationsWereMet()
+	assert.Equal(t, nil, err)
+}
+
+func TestFindReleaseVersion(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	const query_select string = "SELECT (`[a-z_]+`, ){2}`[a-z_]+` FROM `release_versions` WHERE `release_id` = \\? AND `version` = \\?"
+	const query_pages string = "SELECT (`[a-z_]+`, ){4}`[a-z_]+` FROM `release_version_pages` WHERE `version_id` = \\? ORDER BY `sort_index` ASC, `id` ASC"
+	tm := time.Now()
+	r := Release{Id: 4}
+
+	cols := []string{"id", "identifier", "released_on"}
+	pageCols := []string{"name", "mime_type", "role", "sort_index", "hash"}
+	mock.ExpectQuery(query_select).WithArgs(r.Id, uint32(2)).WillReturnRows(sqlmock.NewRows(cols))
+	mock.ExpectQuery(query_select).WithArgs(r.Id, uint32(2)).WillReturnRows(sqlmock.NewRows(cols).AddRow(7, "c1", tm))
+	pageRows := sqlmock.NewRows(pageCols)
+	pageRows.AddRow("p01.png", MimeTypePng, PRoleCredit, 0, "abc")
+	pageRows.AddRow("p02.png", MimeTypeJpg, PRoleContent, 1, "def")
+	mock.ExpectQuery(query_pages).WithArgs(uint32(7)).WillReturnRows(pageRows)
+	expErr := errors.New("error")
+	mock.ExpectQuery(query_select).WithArgs(r.Id, uint32(2)).WillReturnRows(sqlmock.NewRows(cols).AddRow(7, "c1", tm))
+	mock.ExpectQuery(query_pages).WithArgs(uint32(7)).WillReturnError(expErr)
+	mock.ExpectQuery(query_select).WithArgs(r.Id, uint32(2)).WillReturnError(expErr)
+
+	_, err = FindReleaseVersion(db, r, 2)
+	assert.Equal(t, ErrNoSuchReleaseVersion, err)
+
+	v, err := FindReleaseVersion(db, r, 2)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint32(7), v.Id)
+	assert.Equal(t, uint32(2), v.Version)
+	assert.Equal(t, "c1", v.Identifier)
+	assert.Equal(t, tm, v.ReleasedOn)
+	assert.Equal(t, 2, len(v.Pages))
+	assert.Equal(t, VersionPage{"p01.png", MimeTypePng, PRoleCreditStr, 0, "abc"}, v.Pages[0])
+	assert.Equal(t, VersionPage{"p02.png", MimeTypeJpg, PRoleContentStr, 1, "def"}, v.Pages[1])
+
+	_, err = FindReleaseVersion(db, r, 2)
+	assert.Equal(t, expErr, err)
+
+	_, err = FindReleaseVersion(db, r, 2)
+	assert.Equal(t, expErr, err)
+
+	err = mock.ExpectationsWereMet()
+	assert.Equal(t, nil, err)
+}
+
+func TestSaveReleaseVersion(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	const query string = "INSERT INTO `release_versions`.*"
+	const query_page string = "INSERT INTO `release_version_pages`.*"
+	tm := time.Now()
+	v := ReleaseVersion{ReleaseID: 4, Identifier: "c1", Version: 2, ReleasedOn: tm, Pages: []VersionPage{
+		{"p01.png", MimeTypePng, PRoleCreditStr, 0, "abc"},
+		{"p02.png", MimeTypeJpg, PRoleContentStr, 1, "def"},
+	}}
+	mock.ExpectBegin()
+	mock.ExpectExec(query).WithArgs(v.ReleaseID, v.Identifier, v.Version, tm).WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectExec(query_page).WithArgs(uint32(7), "p01.png", MimeTypePng, PRoleCredit, uint32(0), "abc").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(query_page).WithArgs(uint32(7), "p02.png", MimeTypeJpg, PRoleContent, uint32(1), "def").WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+	expErr := errors.New("error")
+	mock.ExpectBegin()
+	mock.ExpectExec(query).WithArgs(v.ReleaseID, v.Identifier, v.Version, tm).WillReturnResult(sqlmock.NewResult(8, 1))
+	mock.ExpectExec(query_page).WithArgs(uint32(8), "p01.png", MimeTypePng, PRoleCredit, uint32(0), "abc").WillReturnError(expErr)
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectExec(query).WithArgs(v.ReleaseID, v.Identifier, v.Version, tm).WillReturnError(expErr)
+	mock.ExpectRollback()
+
+	saved, err := SaveReleaseVersion(db, v)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint32(7), saved.Id)
+
+	// test a failed page rolls back the version
+	saved, err = SaveReleaseVersion(db, v)
+	assert.Equal(t, expErr, err)
+	assert.Equal(t, uint32(0), saved.Id)
+
+	_, err = SaveReleaseVersion(db, v)
+	assert.Equal(t, expErr, err)
+
+	// tests validation failed case
+	_, err = SaveReleaseVersion(db, ReleaseVersion{Identifier: strings.Repeat("a", Rmax_len_identifier+1)})
+	assert.Equal(t, ErrFieldTooLong, err)
+
+	err = mock.ExpectationsWereMet()
+	assert.Equal(t, nil, err)
+}
+
+func TestDiffReleaseVersions(t *testing.T) {
+	changes := func(base, target ReleaseVersion) string {
+		names := []string{}
+		for _, c := range DiffReleaseVersions(base, target) {
+			names = append(names, c.Change+":"+c.Name)
+		}
+		return strings.Join(names, ",")
+	}
+	v1 := ReleaseVersion{Pages: []VersionPage{
+		{Name: "p01.png", Role: PRoleCreditStr, Hash: "a"},
+		{Name: "p02.png", Role: PRoleContentStr, Hash: "b"},
+		{Name: "p03.png", Role: PRoleContentStr, Hash: "c"},
+	}}
+	v2 := ReleaseVersion{Pages: []VersionPage{
+		{Name: "p01.png", Role: PRoleCreditStr, Hash: "a"},
+		{Name: "p03.png", Role: PRoleCoverStr, Hash: "c"},
+		{Name: "p02.png", Role: PRoleContentStr, Hash: "d"},
+		{Name: "p04.png", Role: PRoleContentStr, Hash: "e"},
+	}}
+
+	assert.Equal(t, "", changes(v1, v1))
+	assert.Equal(t, "changed:p03.png,changed:p02.png,added:p04.png", changes(v1, v2))
+	assert.Equal(t, "removed:p04.png,changed:p02.png,changed:p03.png", changes(v2, v1))
+	assert.Equal(t, "added:p01.png,added:p02.png,added:p03.png", changes(ReleaseVersion{}, v1))
+
+	diff := DiffReleaseVersions(v1, v2)
+	assert.Equal(t, "b", diff[1].Before.Hash)
+	assert.Equal(t, "d", diff[1].After.Hash)
+	assert.Equal(t, true, diff[2].Before == nil)
+	assert.Equal(t, "e", diff[2].After.Hash)
+}
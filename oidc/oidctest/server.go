@@ -0,0 +1,88 @@
+package oidctest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+)
+
+// Server is a minimal local identity provider for tests. Every authorization request is approved immediately
+// for Identity, and the code handed out is Code.
+type Server struct {
+	*httptest.Server
+	ClientId     string
+	ClientSecret string
+	Code         string
+	AccessToken  string
+	Identity     map[string]interface{}
+}
+
+// NewServer starts a fake identity provider which accepts the given client credentials.
+func NewServer(clientId, clientSecret string) *Server {
+	s := &Server{
+		ClientId:     clientId,
+		ClientSecret: clientSecret,
+		Code:         "fake-code",
+		AccessToken:  "fake-access-token",
+		Identity: map[string]interface{}{
+			"sub":            "fake-subject",
+			"email":          "staff@example.com",
+			"email_verified": true,
+			"name":           "Staff",
+		},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("/authorize", s.authorize)
+	mux.HandleFunc("/token", s.token)
+	mux.HandleFunc("/userinfo", s.userinfo)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 s.URL,
+		"authorization_endpoint": s.URL + "/authorize",
+		"token_endpoint":         s.URL + "/token",
+		"userinfo_endpoint":      s.URL + "/userinfo",
+	})
+}
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != s.ClientId || q.Get("response_type") != "code" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	v := url.Values{}
+	v.Set("code", s.Code)
+	v.Set("state", q.Get("state"))
+	http.Redirect(w, r, q.Get("redirect_uri")+"?"+v.Encode(), http.StatusFound)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != s.ClientId || secret != s.ClientSecret ||
+		r.PostFormValue("code") != s.Code || r.PostFormValue("grant_type") != "authorization_code" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": s.AccessToken,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+	})
+}
+
+func (s *Server) userinfo(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+s.AccessToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Identity)
+}
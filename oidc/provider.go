@@ -0,0 +1,184 @@
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors pertaining to communication with the identity provider.
+var (
+	ErrDiscovery     = errors.New("Could not fetch the identity provider configuration.")
+	ErrTokenExchange = errors.New("Could not exchange the authorization code for a token.")
+	ErrUserInfo      = errors.New("Could not fetch user information from the identity provider.")
+	ErrNoSubject     = errors.New("The identity provider did not return a subject.")
+)
+
+const discoveryPath = "/.well-known/openid-configuration"
+
+// Identity contains the claims about the authenticated user that the rest of the service cares about.
+type Identity struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+}
+
+// Provider implements the parts of the OpenID Connect authorization code flow needed to identify staff members.
+// The provider configuration is discovered lazily so the service can start while the identity provider is down.
+// Claims are taken from the userinfo endpoint, which is queried over a back channel with the access token.
+type Provider struct {
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	RedirectUrl  string
+	Client       *http.Client
+
+	mu   sync.Mutex
+	disc *discovery
+}
+
+// NewProvider constructs a Provider for the given issuer and client registration.
+func NewProvider(issuer, clientId, clientSecret, redirectUrl string) *Provider {
+	return &Provider{
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientId:     clientId,
+		ClientSecret: clientSecret,
+		RedirectUrl:  redirectUrl,
+		Client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *Provider) discover() (discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.disc != nil {
+		return *p.disc, nil
+	}
+
+	rsp, err := p.Client.Get(p.Issuer + discoveryPath)
+	if err != nil {
+		return discovery{}, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return discovery{}, ErrDiscovery
+	}
+
+	d := discovery{}
+	err = json.NewDecoder(rsp.Body).Decode(&d)
+	if err != nil {
+		return discovery{}, err
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != p.Issuer || d.AuthorizationEndpoint == "" ||
+		d.TokenEndpoint == "" || d.UserinfoEndpoint == "" {
+		return discovery{}, ErrDiscovery
+	}
+	p.disc = &d
+	return d, nil
+}
+
+// AuthCodeUrl produces the URL that the user agent should be redirected to in order to log in.
+func (p *Provider) AuthCodeUrl(state string) (string, error) {
+	d, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.ClientId)
+	v.Set("redirect_uri", p.RedirectUrl)
+	v.Set("scope", "openid email profile")
+	v.Set("state", state)
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + v.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the identity of the user who logged in.
+func (p *Provider) Exchange(code string) (Identity, error) {
+	d, err := p.discover()
+	if err != nil {
+		return Identity{}, err
+	}
+
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("code", code)
+	v.Set("redirect_uri", p.RedirectUrl)
+	req, err := http.NewRequest("POST", d.TokenEndpoint, strings.NewReader(v.Encode()))
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.ClientId), url.QueryEscape(p.ClientSecret))
+
+	rsp, err := p.Client.Do(req)
+	if err != nil {
+		return Identity{}, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return Identity{}, ErrTokenExchange
+	}
+	token := tokenResponse{}
+	err = json.NewDecoder(rsp.Body).Decode(&token)
+	if err != nil {
+		return Identity{}, err
+	}
+	if token.AccessToken == "" {
+		return Identity{}, ErrTokenExchange
+	}
+
+	return p.userInfo(d, token.AccessToken)
+}
+
+func (p *Provider) userInfo(d discovery, accessToken string) (Identity, error) {
+	req, err := http.NewRequest("GET", d.UserinfoEndpoint, nil)
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+	req.Header.Set("Accept", "application/json")
+
+	rsp, err := p.Client.Do(req)
+	if err != nil {
+		return Identity{}, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return Identity{}, ErrUserInfo
+	}
+
+	id := Identity{}
+	err = json.NewDecoder(rsp.Body).Decode(&id)
+	if err != nil {
+		return Identity{}, err
+	}
+	if id.Subject == "" {
+		return Identity{}, ErrNoSubject
+	}
+	return id, nil
+}
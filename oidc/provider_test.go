@@ -0,0 +1,71 @@
+package oidc
+
+import (
+	"ims-release/assert"
+	"ims-release/oidc/oidctest"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestAuthCodeUrl(t *testing.T) {
+	idp := oidctest.NewServer("client", "secret")
+	defer idp.Close()
+
+	p := NewProvider(idp.URL, "client", "secret", "http://localhost/auth/callback")
+	u, err := p.AuthCodeUrl("some-state")
+	assert.Equal(t, nil, err)
+
+	parsed, err := url.Parse(u)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "/authorize", parsed.Path)
+	assert.Equal(t, "client", parsed.Query().Get("client_id"))
+	assert.Equal(t, "code", parsed.Query().Get("response_type"))
+	assert.Equal(t, "some-state", parsed.Query().Get("state"))
+	assert.Equal(t, "http://localhost/auth/callback", parsed.Query().Get("redirect_uri"))
+
+	// discovery failure
+	p = NewProvider(idp.URL+"/missing", "client", "secret", "http://localhost/auth/callback")
+	_, err = p.AuthCodeUrl("some-state")
+	assert.Equal(t, ErrDiscovery, err)
+}
+
+func TestExchange(t *testing.T) {
+	idp := oidctest.NewServer("client", "secret")
+	defer idp.Close()
+
+	p := NewProvider(idp.URL, "client", "secret", "http://localhost/auth/callback")
+	id, err := p.Exchange(idp.Code)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "fake-subject", id.Subject)
+	assert.Equal(t, "staff@example.com", id.Email)
+	assert.Equal(t, true, id.EmailVerified)
+	assert.Equal(t, "Staff", id.Name)
+
+	// bad code
+	_, err = p.Exchange("bad-code")
+	assert.Equal(t, ErrTokenExchange, err)
+
+	// bad client secret
+	p = NewProvider(idp.URL, "client", "wrong", "http://localhost/auth/callback")
+	_, err = p.Exchange(idp.Code)
+	assert.Equal(t, ErrTokenExchange, err)
+
+	// missing subject
+	idp.Identity = map[string]interface{}{"email": "staff@example.com"}
+	p = NewProvider(idp.URL, "client", "secret", "http://localhost/auth/callback")
+	_, err = p.Exchange(idp.Code)
+	assert.Equal(t, ErrNoSubject, err)
+
+	// the login redirect round trip ends at the redirect uri with the code and state
+	u, _ := p.AuthCodeUrl("state")
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	rsp, err := client.Get(u)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, http.StatusFound, rsp.StatusCode)
+	loc, _ := url.Parse(rsp.Header.Get("Location"))
+	assert.Equal(t, idp.Code, loc.Query().Get("code"))
+	assert.Equal(t, "state", loc.Query().Get("state"))
+}
@@ -22,11 +22,21 @@ func (sp *File) Set(key string, data []byte) error {
 	if err != nil {
 		return err
 	}
-	f, err := os.Create(filePath)
+	// write to a temporary file first, so that readers never see partially written data
+	f, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
 	if err == nil {
-		_, err = f.Write(data)
+		err = os.Rename(f.Name(), filePath)
+	}
+	if err != nil {
+		os.Remove(f.Name())
 	}
-	defer f.Close()
 	return err
 }
 